@@ -0,0 +1,272 @@
+package archive_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net"
+	"testing"
+
+	"github.com/hjcian/grpc-notes/archive"
+	"github.com/hjcian/grpc-notes/client"
+	"github.com/hjcian/grpc-notes/pb"
+	"github.com/hjcian/grpc-notes/sample"
+	"github.com/hjcian/grpc-notes/serializer"
+	"github.com/hjcian/grpc-notes/service"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+func newStores(t *testing.T) archive.Stores {
+	return archive.Stores{
+		Laptops: service.NewInMemoryLaptopStore(),
+		Images:  service.NewDiskImageStore(t.TempDir()),
+		Ratings: service.NewInMemoryRatingStore(),
+	}
+}
+
+func startServer(t *testing.T, stores archive.Stores, opts ...archive.Option) *client.LaptopClient {
+	grpcServer := grpc.NewServer()
+	pb.RegisterArchiveServiceServer(grpcServer, archive.NewServer(stores, opts...))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithInsecure())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return client.NewLaptopClient(conn)
+}
+
+// fillStores saves laptops with ratings and images, one image larger than a chunk
+func fillStores(t *testing.T, stores archive.Stores) {
+	for i := 0; i < 5; i++ {
+		laptop := sample.NewLaptop()
+		require.NoError(t, stores.Laptops.Save(laptop))
+
+		_, err := stores.Ratings.Add(laptop.GetId(), 7)
+		require.NoError(t, err)
+		_, err = stores.Ratings.Add(laptop.GetId(), 9)
+		require.NoError(t, err)
+
+		data := bytes.Repeat([]byte{byte(i)}, 100+i*archive.ChunkSize/2)
+		imageID, err := stores.Images.Save(laptop.GetId(), ".jpg", *bytes.NewBuffer(data), service.ImageQuota{})
+		require.NoError(t, err)
+		require.NoError(t, stores.Images.SetPrimary(laptop.GetId(), imageID))
+	}
+}
+
+func requireSameCatalog(t *testing.T, expected, actual archive.Stores, withData bool) {
+	require.Equal(t, expected.Laptops.Count(), actual.Laptops.Count())
+	err := expected.Laptops.All(func(laptop *pb.Laptop) error {
+		other, err := actual.Laptops.Find(laptop.GetId())
+		require.NoError(t, err)
+		require.True(t, proto.Equal(laptop, other))
+		return nil
+	})
+	require.NoError(t, err)
+
+	require.Equal(t, expected.Ratings.All(), actual.Ratings.All())
+
+	expectedImages, expectedPrimary := expected.Images.All()
+	actualImages, actualPrimary := actual.Images.All()
+	require.Equal(t, expectedPrimary, actualPrimary)
+	require.Len(t, actualImages, len(expectedImages))
+	for imageID, image := range expectedImages {
+		other := actualImages[imageID]
+		require.Equal(t, image.LaptopID, other.LaptopID)
+		require.Equal(t, image.Type, other.Type)
+		require.Equal(t, image.Size, other.Size)
+		if !withData {
+			require.Empty(t, other.Path)
+			continue
+		}
+
+		data, err := ioutil.ReadFile(image.Path)
+		require.NoError(t, err)
+		otherData, err := ioutil.ReadFile(other.Path)
+		require.NoError(t, err)
+		require.Equal(t, data, otherData)
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	t.Parallel()
+
+	stores := newStores(t)
+	fillStores(t, stores)
+	source := startServer(t, stores)
+
+	testCases := []struct {
+		withData    bool
+		compression serializer.Compression
+	}{
+		{true, serializer.NoCompression},
+		{false, serializer.Gzip},
+		{true, serializer.Zstd},
+	}
+	for _, tc := range testCases {
+		withData := tc.withData
+		var buffer bytes.Buffer
+		summary, err := source.ExportCatalog(context.Background(), withData, &buffer, tc.compression)
+		require.NoError(t, err)
+		require.EqualValues(t, 5, summary.GetLaptops())
+		require.EqualValues(t, 5, summary.GetRatings())
+		require.EqualValues(t, 5, summary.GetImages())
+		require.EqualValues(t, 5, summary.GetPrimaryImages())
+		data := buffer.Bytes()
+
+		other := newStores(t)
+		target := startServer(t, other)
+		imported, err := target.ImportCatalog(context.Background(), bytes.NewReader(data))
+		require.NoError(t, err)
+		require.True(t, proto.Equal(summary, imported))
+		requireSameCatalog(t, stores, other, withData)
+
+		// importing again keeps the laptops, their ratings and the images
+		imported, err = target.ImportCatalog(context.Background(), bytes.NewReader(data))
+		require.NoError(t, err)
+		require.Zero(t, imported.GetLaptops())
+		require.EqualValues(t, 5, imported.GetSkippedLaptops())
+		require.Zero(t, imported.GetRatings())
+		require.EqualValues(t, 5, imported.GetSkippedRatings())
+		require.Zero(t, imported.GetImages())
+		requireSameCatalog(t, stores, other, withData)
+	}
+}
+
+// archiveOf writes the records in an archive
+func archiveOf(t *testing.T, records ...*pb.ArchiveRecord) *bytes.Buffer {
+	var buffer bytes.Buffer
+	writer, err := serializer.NewStreamWriter(&buffer, &pb.ArchiveRecord{}, serializer.NoCompression)
+	require.NoError(t, err)
+	for _, record := range records {
+		require.NoError(t, writer.Write(record))
+	}
+	require.NoError(t, writer.Close())
+	return &buffer
+}
+
+func TestImportSkippedRecords(t *testing.T) {
+	t.Parallel()
+
+	stores := newStores(t)
+	rated := sample.NewLaptop()
+	require.NoError(t, stores.Laptops.Save(rated))
+	_, err := stores.Ratings.Add(rated.GetId(), 7)
+	require.NoError(t, err)
+
+	valid := sample.NewLaptop()
+	invalid := sample.NewLaptop()
+	invalid.Cpu.NumberCores = 0
+	rating := func(laptopID string) *pb.ArchiveRecord {
+		return &pb.ArchiveRecord{Data: &pb.ArchiveRecord_Rating{Rating: &pb.LaptopRating{
+			LaptopId: laptopID,
+			Count:    2,
+			Sum:      18,
+		}}}
+	}
+
+	imported, err := startServer(t, stores).ImportCatalog(context.Background(), archiveOf(t,
+		&pb.ArchiveRecord{Data: &pb.ArchiveRecord_Header{Header: &pb.ArchiveHeader{Version: archive.Version}}},
+		&pb.ArchiveRecord{Data: &pb.ArchiveRecord_Laptop{Laptop: valid}},
+		&pb.ArchiveRecord{Data: &pb.ArchiveRecord_Laptop{Laptop: invalid}},
+		rating(valid.GetId()),
+		rating(invalid.GetId()),
+		rating(rated.GetId()),
+		rating(sample.NewLaptop().GetId()),
+		&pb.ArchiveRecord{Data: &pb.ArchiveRecord_Image{Image: &pb.ImageSaved{
+			ImageId:   "9b5a0c5e-8d8f-4a54-9d1b-1c0b8e1e2a3f",
+			LaptopId:  valid.GetId(),
+			ImageType: ".jpg",
+			Size:      100,
+		}}},
+	))
+	require.NoError(t, err)
+	require.EqualValues(t, 1, imported.GetLaptops())
+	require.EqualValues(t, 1, imported.GetInvalidLaptops())
+	require.EqualValues(t, 1, imported.GetRatings())
+	require.EqualValues(t, 3, imported.GetSkippedRatings())
+	require.EqualValues(t, 1, imported.GetImages())
+
+	laptop, err := stores.Laptops.Find(invalid.GetId())
+	require.NoError(t, err)
+	require.Nil(t, laptop)
+
+	// the rating of a laptop which is already rated is kept
+	require.Equal(t, map[string]service.Rating{
+		valid.GetId(): {Count: 2, Sum: 18},
+		rated.GetId(): {Count: 1, Sum: 7},
+	}, stores.Ratings.All())
+
+	// an image without data doesn't count in the usage of the laptop
+	usage, err := stores.Images.Usage(valid.GetId())
+	require.NoError(t, err)
+	require.Equal(t, &service.ImageUsage{}, usage)
+}
+
+func TestImportErrors(t *testing.T) {
+	t.Parallel()
+
+	stores := newStores(t)
+	fillStores(t, stores)
+	var buffer bytes.Buffer
+	_, err := startServer(t, stores).ExportCatalog(context.Background(), true, &buffer, serializer.Gzip)
+	require.NoError(t, err)
+
+	var records []*pb.ArchiveRecord
+	reader, err := serializer.NewStreamReader(&buffer, &pb.ArchiveRecord{})
+	require.NoError(t, err)
+	defer reader.Close()
+	for {
+		record := &pb.ArchiveRecord{}
+		if reader.Read(record) != nil {
+			break
+		}
+		records = append(records, record)
+	}
+
+	importInto := func(data *bytes.Buffer, opts ...archive.Option) error {
+		_, err := startServer(t, newStores(t), opts...).ImportCatalog(context.Background(), data)
+		return err
+	}
+
+	err = importInto(archiveOf(t, records[1:]...))
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// drop a chunk of an image having several ones
+	var chunk int
+	for i, record := range records {
+		if record.GetImageChunk() != nil && records[i+1].GetImageChunk() != nil {
+			chunk = i
+			break
+		}
+	}
+	err = importInto(archiveOf(t, append(records[:chunk:chunk], records[chunk+1:]...)...))
+	require.Equal(t, codes.InvalidArgument, status.Code(err), "missing chunk")
+
+	err = importInto(archiveOf(t, records...), archive.WithReadOnly())
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	// the import of a truncated archive fails
+	truncated := archiveOf(t, records...)
+	truncated.Truncate(truncated.Len() - 2)
+	err = importInto(truncated)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "unexpected EOF")
+
+	// so does the import of a stream of other messages
+	var laptops bytes.Buffer
+	writer, err := serializer.NewStreamWriter(&laptops, &pb.Laptop{}, serializer.NoCompression)
+	require.NoError(t, err)
+	require.NoError(t, writer.Write(sample.NewLaptop()))
+	require.NoError(t, writer.Close())
+	err = importInto(&laptops)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "techschool.pcbook.Laptop")
+}
@@ -0,0 +1,36 @@
+package archive
+
+import "go.uber.org/zap"
+
+type config struct {
+	readOnly bool
+	logger   *zap.Logger
+}
+
+// Option configures a Server
+type Option func(c *config)
+
+func newConfig(opts []Option) *config {
+	c := &config{
+		logger: zap.NewNop(),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// WithReadOnly makes the server refuse the imports, e.g. when its catalog is replicated
+// and the writes must go through the leader
+func WithReadOnly() Option {
+	return func(c *config) {
+		c.readOnly = true
+	}
+}
+
+// WithLogger sets the logger of the server
+func WithLogger(logger *zap.Logger) Option {
+	return func(c *config) {
+		c.logger = logger
+	}
+}
@@ -0,0 +1,365 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/google/uuid"
+	"github.com/hjcian/grpc-notes/logging"
+	"github.com/hjcian/grpc-notes/pb"
+	"github.com/hjcian/grpc-notes/service"
+	"github.com/hjcian/grpc-notes/validator"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Version is the version of the archives written by the server
+const Version = 1
+
+// ChunkSize is the size of the chunks of the image data in an archive
+const ChunkSize = 64 << 10
+
+// Stores are the stores of the catalog archived by the server
+type Stores struct {
+	Laptops *service.InMemoryLaptopStore
+	Images  *service.DiskImageStore
+	Ratings *service.InMemoryRatingStore
+}
+
+// Server exports the catalog of the stores to an archive, and imports archives into them
+type Server struct {
+	stores   Stores
+	readOnly bool
+	logger   *zap.Logger
+}
+
+// NewServer returns a new Server of the catalog in the stores
+func NewServer(stores Stores, opts ...Option) *Server {
+	config := newConfig(opts)
+	return &Server{
+		stores:   stores,
+		readOnly: config.readOnly,
+		logger:   config.logger,
+	}
+}
+
+// ExportCatalog streams the records of the catalog. The laptops are read at once, the writes
+// made during the export may or may not be in the archive.
+func (s *Server) ExportCatalog(req *pb.ExportCatalogRequest, stream pb.ArchiveService_ExportCatalogServer) error {
+	logger := logging.FromContext(stream.Context(), s.logger)
+
+	var laptops []*pb.Laptop
+	err := s.stores.Laptops.All(func(laptop *pb.Laptop) error {
+		laptops = append(laptops, laptop)
+		return nil
+	})
+	if err != nil {
+		return status.Errorf(codes.Internal, "cannot read laptops: %v", err)
+	}
+	ratings := s.stores.Ratings.All()
+	images, primary := s.stores.Images.All()
+
+	err = stream.Send(&pb.ArchiveRecord{Data: &pb.ArchiveRecord_Header{Header: &pb.ArchiveHeader{
+		Version:        Version,
+		CreatedAt:      timestamppb.Now(),
+		ImagesIncluded: req.GetIncludeImages(),
+	}}})
+	if err != nil {
+		return err
+	}
+
+	for _, laptop := range laptops {
+		err := stream.Send(&pb.ArchiveRecord{Data: &pb.ArchiveRecord_Laptop{Laptop: laptop}})
+		if err != nil {
+			return err
+		}
+	}
+
+	for laptopID, rating := range ratings {
+		err := stream.Send(&pb.ArchiveRecord{Data: &pb.ArchiveRecord_Rating{Rating: &pb.LaptopRating{
+			LaptopId: laptopID,
+			Count:    rating.Count,
+			Sum:      rating.Sum,
+		}}})
+		if err != nil {
+			return err
+		}
+	}
+
+	for imageID, image := range images {
+		err := stream.Send(&pb.ArchiveRecord{Data: &pb.ArchiveRecord_Image{Image: &pb.ImageSaved{
+			ImageId:   imageID,
+			LaptopId:  image.LaptopID,
+			ImageType: image.Type,
+			Size:      uint32(image.Size),
+		}}})
+		if err != nil {
+			return err
+		}
+
+		// the images of a follower have no data, only their metadata is exported
+		if req.GetIncludeImages() && image.Path != "" {
+			err = sendImageData(stream, imageID, image.Path)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for laptopID, imageID := range primary {
+		err := stream.Send(&pb.ArchiveRecord{Data: &pb.ArchiveRecord_PrimaryImage{PrimaryImage: &pb.PrimaryImageSet{
+			LaptopId: laptopID,
+			ImageId:  imageID,
+		}}})
+		if err != nil {
+			return err
+		}
+	}
+
+	logger.Info("exported catalog",
+		zap.Int("laptops", len(laptops)),
+		zap.Int("ratings", len(ratings)),
+		zap.Int("images", len(images)),
+		zap.Bool("images_included", req.GetIncludeImages()),
+	)
+	return nil
+}
+
+// sendImageData sends the data of the image file in chunks
+func sendImageData(stream pb.ArchiveService_ExportCatalogServer, imageID, imagePath string) error {
+	data, err := ioutil.ReadFile(imagePath)
+	if err != nil {
+		return status.Errorf(codes.Internal, "cannot read image %s: %v", imageID, err)
+	}
+
+	for len(data) > 0 {
+		chunk := data
+		if len(chunk) > ChunkSize {
+			chunk = chunk[:ChunkSize]
+		}
+		data = data[len(chunk):]
+
+		err := stream.Send(&pb.ArchiveRecord{Data: &pb.ArchiveRecord_ImageChunk{ImageChunk: &pb.ImageChunk{
+			ImageId: imageID,
+			Data:    chunk,
+		}}})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pendingImage is an image whose data chunks are being received
+type pendingImage struct {
+	id   string
+	info *service.ImageInfo
+	data bytes.Buffer
+}
+
+// ImportCatalog adds the records of an archive to the catalog. The records are applied as they
+// are received, those received before an error are kept.
+func (s *Server) ImportCatalog(stream pb.ArchiveService_ImportCatalogServer) error {
+	logger := logging.FromContext(stream.Context(), s.logger)
+
+	if s.readOnly {
+		return status.Error(codes.FailedPrecondition, "the catalog of this server can't be imported into")
+	}
+
+	res := &pb.ArchiveSummary{}
+	var pending *pendingImage
+	for i := 0; ; i++ {
+		record, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if i == 0 {
+			header := record.GetHeader()
+			if header == nil {
+				return status.Error(codes.InvalidArgument, "the archive doesn't start with a header")
+			}
+			if header.GetVersion() != Version {
+				return status.Errorf(codes.InvalidArgument, "unsupported archive version %d", header.GetVersion())
+			}
+			continue
+		}
+
+		if chunk := record.GetImageChunk(); chunk != nil {
+			if pending == nil || chunk.GetImageId() != pending.id {
+				return status.Errorf(codes.InvalidArgument, "record %d: unexpected chunk of image %s", i, chunk.GetImageId())
+			}
+			if pending.data.Len()+len(chunk.GetData()) > pending.info.Size {
+				return status.Errorf(codes.InvalidArgument, "record %d: image %s is larger than its size", i, pending.id)
+			}
+			pending.data.Write(chunk.GetData())
+			continue
+		}
+
+		err = s.importImage(pending, res)
+		if err != nil {
+			return err
+		}
+		pending, err = s.importRecord(stream.Context(), i, record, res)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := s.importImage(pending, res)
+	if err != nil {
+		return err
+	}
+
+	logger.Info("imported catalog",
+		zap.Uint32("laptops", res.GetLaptops()),
+		zap.Uint32("skipped_laptops", res.GetSkippedLaptops()),
+		zap.Uint32("invalid_laptops", res.GetInvalidLaptops()),
+		zap.Uint32("ratings", res.GetRatings()),
+		zap.Uint32("skipped_ratings", res.GetSkippedRatings()),
+		zap.Uint32("images", res.GetImages()),
+		zap.Uint32("primary_images", res.GetPrimaryImages()),
+	)
+	return stream.SendAndClose(res)
+}
+
+// importRecord applies a record other than a chunk, it returns the image whose chunks may follow.
+// The invalid laptops are skipped, so are the ratings of the unknown laptops or of the rated ones.
+func (s *Server) importRecord(
+	ctx context.Context,
+	i int,
+	record *pb.ArchiveRecord,
+	res *pb.ArchiveSummary,
+) (*pendingImage, error) {
+	logger := logging.FromContext(ctx, s.logger)
+
+	switch data := record.GetData().(type) {
+	case *pb.ArchiveRecord_Laptop:
+		laptop := data.Laptop
+		if _, err := uuid.Parse(laptop.GetId()); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "record %d: laptop id is not a valid UUID: %v", i, err)
+		}
+		if violations := validator.ValidateLaptop("laptop", laptop); len(violations) > 0 {
+			logger.Warn("skip invalid laptop",
+				zap.Int("record", i),
+				zap.String("laptop_id", laptop.GetId()),
+				zap.Stringer("violations", violations))
+			res.InvalidLaptops++
+			return nil, nil
+		}
+
+		err := s.stores.Laptops.Save(laptop)
+		if errors.Is(err, service.ErrAlreadyExists) {
+			res.SkippedLaptops++
+			return nil, nil
+		}
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "record %d: cannot save laptop: %v", i, err)
+		}
+		res.Laptops++
+
+	case *pb.ArchiveRecord_Rating:
+		rating := data.Rating
+		laptop, err := s.stores.Laptops.Find(rating.GetLaptopId())
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "record %d: cannot find laptop: %v", i, err)
+		}
+		if laptop == nil {
+			res.SkippedRatings++
+			return nil, nil
+		}
+
+		err = s.stores.Ratings.Restore(rating.GetLaptopId(), service.Rating{
+			Count: rating.GetCount(),
+			Sum:   rating.GetSum(),
+		})
+		if errors.Is(err, service.ErrAlreadyExists) {
+			res.SkippedRatings++
+			return nil, nil
+		}
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "record %d: cannot restore rating: %v", i, err)
+		}
+		res.Ratings++
+
+	case *pb.ArchiveRecord_Image:
+		image := data.Image
+		if _, err := uuid.Parse(image.GetImageId()); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "record %d: image id is not a valid UUID: %v", i, err)
+		}
+		if filepath.Base(image.GetImageType()) != image.GetImageType() {
+			return nil, status.Errorf(codes.InvalidArgument, "record %d: invalid image type %q", i, image.GetImageType())
+		}
+		if image.GetSize() > service.MaxImageSize {
+			return nil, status.Errorf(codes.InvalidArgument, "record %d: image is too large: %d > %d",
+				i, image.GetSize(), service.MaxImageSize)
+		}
+		return &pendingImage{
+			id: image.GetImageId(),
+			info: &service.ImageInfo{
+				LaptopID: image.GetLaptopId(),
+				Type:     image.GetImageType(),
+				Size:     int(image.GetSize()),
+			},
+		}, nil
+
+	case *pb.ArchiveRecord_PrimaryImage:
+		primary := data.PrimaryImage
+		err := s.stores.Images.SetPrimary(primary.GetLaptopId(), primary.GetImageId())
+		if errors.Is(err, service.ErrNotFound) {
+			return nil, status.Errorf(codes.InvalidArgument, "record %d: primary image %s of laptop %s not found",
+				i, primary.GetImageId(), primary.GetLaptopId())
+		}
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "record %d: cannot set primary image: %v", i, err)
+		}
+		res.PrimaryImages++
+
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "record %d: unexpected record of type %T", i, data)
+	}
+	return nil, nil
+}
+
+// importImage saves the image once all its chunks are received, or only its metadata if it has none.
+// The images which already exist are kept as they are.
+func (s *Server) importImage(image *pendingImage, res *pb.ArchiveSummary) error {
+	if image == nil {
+		return nil
+	}
+
+	if image.data.Len() == 0 {
+		found, err := s.stores.Images.Find(image.id)
+		if err != nil {
+			return status.Errorf(codes.Internal, "cannot find image %s: %v", image.id, err)
+		}
+		if found == nil {
+			s.stores.Images.AddInfo(image.id, image.info)
+			res.Images++
+		}
+		return nil
+	}
+
+	if image.data.Len() != image.info.Size {
+		return status.Errorf(codes.InvalidArgument, "image %s has %d bytes instead of %d",
+			image.id, image.data.Len(), image.info.Size)
+	}
+	err := s.stores.Images.Restore(image.id, image.info, image.data)
+	if errors.Is(err, service.ErrAlreadyExists) {
+		return nil
+	}
+	if err != nil {
+		return status.Errorf(codes.Internal, "cannot save image %s: %v", image.id, err)
+	}
+	res.Images++
+	return nil
+}
@@ -0,0 +1,103 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/hjcian/grpc-notes/pb"
+	"github.com/hjcian/grpc-notes/serializer"
+)
+
+// ExportCatalog writes the archive of the whole catalog to the writer as a stream of records
+// with the compression, with the data of the images if includeImages is true. It returns the summary
+// of the records written. The export is not retried since the writer is partially written.
+func (c *LaptopClient) ExportCatalog(
+	ctx context.Context,
+	includeImages bool,
+	writer io.Writer,
+	compression serializer.Compression,
+) (*pb.ArchiveSummary, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	stream, err := c.archive.ExportCatalog(ctx, &pb.ExportCatalogRequest{IncludeImages: includeImages})
+	if err != nil {
+		return nil, toError(err)
+	}
+
+	records, err := serializer.NewStreamWriter(writer, &pb.ArchiveRecord{}, compression)
+	if err != nil {
+		return nil, err
+	}
+	summary := &pb.ArchiveSummary{}
+	for {
+		record, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, toError(err)
+		}
+
+		err = records.Write(record)
+		if err != nil {
+			return nil, fmt.Errorf("cannot write record: %w", err)
+		}
+
+		switch record.GetData().(type) {
+		case *pb.ArchiveRecord_Laptop:
+			summary.Laptops++
+		case *pb.ArchiveRecord_Rating:
+			summary.Ratings++
+		case *pb.ArchiveRecord_Image:
+			summary.Images++
+		case *pb.ArchiveRecord_PrimaryImage:
+			summary.PrimaryImages++
+		}
+	}
+
+	err = records.Close()
+	if err != nil {
+		return nil, fmt.Errorf("cannot write records: %w", err)
+	}
+	return summary, nil
+}
+
+// ImportCatalog imports the archive read from the reader, as written by ExportCatalog with any compression.
+// The import is not retried since the reader is consumed.
+func (c *LaptopClient) ImportCatalog(ctx context.Context, reader io.Reader) (*pb.ArchiveSummary, error) {
+	records, err := serializer.NewStreamReader(reader, &pb.ArchiveRecord{})
+	if err != nil {
+		return nil, fmt.Errorf("cannot read archive: %w", err)
+	}
+	defer records.Close()
+
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	stream, err := c.archive.ImportCatalog(ctx)
+	if err != nil {
+		return nil, toError(err)
+	}
+
+	for err == nil {
+		record := &pb.ArchiveRecord{}
+		readErr := records.Read(record)
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return nil, fmt.Errorf("cannot read record: %w", readErr)
+		}
+
+		err = stream.Send(record)
+	}
+	// a failed send means the server has ended the stream, its status is received by CloseAndRecv
+
+	res, err := stream.CloseAndRecv()
+	if err != nil {
+		return nil, toError(err)
+	}
+	return res, nil
+}
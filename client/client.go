@@ -0,0 +1,327 @@
+package client
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hjcian/grpc-notes/pb"
+	"github.com/hjcian/grpc-notes/rpcerror"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Defaults of the options of LaptopClient
+const (
+	DefaultTimeout      = 10 * time.Second
+	DefaultMaxRetries   = 3
+	DefaultRetryBackoff = 100 * time.Millisecond
+)
+
+// LaptopClient is a typed client of LaptopService, and of ArchiveService for the admin commands.
+// The calls without a deadline get the default timeout, the transient errors of the
+// calls which are safe to repeat are retried, and the errors are returned as *Error.
+//
+// The UNAVAILABLE errors are retried by the channel with the retry policy of ServiceConfig,
+// so the connection should be created by Dial. LaptopClient retries the errors which
+// the policy can't handle: the rate limits with the delay told by the server, and the aborted
+// or unavailable calls of CreateAll in all-or-nothing mode.
+type LaptopClient struct {
+	service       pb.LaptopServiceClient
+	archive       pb.ArchiveServiceClient
+	timeout       time.Duration
+	maxRetries    int
+	retryBackoff  time.Duration
+	hedgeAttempts int
+	hedgeDelay    time.Duration
+}
+
+// Option configures a LaptopClient
+type Option func(c *LaptopClient)
+
+// WithTimeout sets the timeout of the calls whose context has no deadline, 0 means no timeout.
+// The timeout of the streams covers the whole stream.
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *LaptopClient) {
+		c.timeout = timeout
+	}
+}
+
+// WithRetries sets how many times a failed call is retried, and the delay before the first retry
+// which is doubled for each next retry. The delay suggested by the server is used if it's longer.
+func WithRetries(maxRetries int, backoff time.Duration) Option {
+	return func(c *LaptopClient) {
+		c.maxRetries = maxRetries
+		c.retryBackoff = backoff
+	}
+}
+
+// WithHedging makes Get send up to maxAttempts requests, a new one each time the previous
+// hasn't answered within the delay, and return the first response. It cuts the tail latency
+// of Get at the cost of more load on the server. grpc-go doesn't support the hedging policy
+// of the service config, so it's done by LaptopClient.
+func WithHedging(maxAttempts int, delay time.Duration) Option {
+	return func(c *LaptopClient) {
+		c.hedgeAttempts = maxAttempts
+		c.hedgeDelay = delay
+	}
+}
+
+// NewLaptopClient returns a new LaptopClient calling the server through the connection
+func NewLaptopClient(conn grpc.ClientConnInterface, opts ...Option) *LaptopClient {
+	c := &LaptopClient{
+		service:      pb.NewLaptopServiceClient(conn),
+		archive:      pb.NewArchiveServiceClient(conn),
+		timeout:      DefaultTimeout,
+		maxRetries:   DefaultMaxRetries,
+		retryBackoff: DefaultRetryBackoff,
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// withTimeout applies the timeout to the context if it has no deadline yet
+func (c *LaptopClient) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok || c.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, c.timeout)
+}
+
+// retryable returns true for the errors which may not happen again and aren't retried
+// by the channel, the rate limits are only retried if the server tells when
+func retryable(err error) bool {
+	switch status.Code(err) {
+	case codes.Aborted:
+		return true
+	case codes.ResourceExhausted:
+		_, ok := rpcerror.RetryDelay(err)
+		return ok
+	default:
+		return false
+	}
+}
+
+// retryableNotCommitted is retryable for the calls which have no effect when they fail,
+// but aren't in the retry policy of the channel
+func retryableNotCommitted(err error) bool {
+	return status.Code(err) == codes.Unavailable || retryable(err)
+}
+
+// retryDelay returns how long to wait before the next attempt, and false if there is none left
+func (c *LaptopClient) retryDelay(attempt int, err error, retryable func(error) bool) (time.Duration, bool) {
+	if attempt >= c.maxRetries || !retryable(err) {
+		return 0, false
+	}
+
+	delay := c.retryBackoff << uint(attempt)
+	if suggested, ok := rpcerror.RetryDelay(err); ok && suggested > delay {
+		delay = suggested
+	}
+	return delay, true
+}
+
+// wait waits for the delay, it returns false if the context is done before
+func wait(ctx context.Context, delay time.Duration) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// retry calls the function until it succeeds, fails with an error which isn't retryable,
+// or there is no retry left
+func (c *LaptopClient) retry(
+	ctx context.Context,
+	retryable func(error) bool,
+	call func(ctx context.Context) error,
+) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	for attempt := 0; ; attempt++ {
+		err := call(ctx)
+		if err == nil {
+			return nil
+		}
+
+		delay, ok := c.retryDelay(attempt, err, retryable)
+		if !ok || !wait(ctx, delay) {
+			return toError(err)
+		}
+	}
+}
+
+// hedge calls the function up to hedgeAttempts times concurrently, starting a new call
+// each time the previous hasn't returned within hedgeDelay. It returns the first success
+// or fatal error, the UNAVAILABLE errors only end it when no call is pending anymore.
+// The other calls are canceled, their results are dropped.
+func (c *LaptopClient) hedge(
+	ctx context.Context,
+	call func(ctx context.Context) (interface{}, error),
+) (interface{}, error) {
+	if c.hedgeAttempts <= 1 {
+		return call(ctx)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		res interface{}
+		err error
+	}
+	results := make(chan result, c.hedgeAttempts)
+	start := func() {
+		go func() {
+			res, err := call(ctx)
+			results <- result{res: res, err: err}
+		}()
+	}
+
+	start()
+	pending, started := 1, 1
+	timer := time.NewTimer(c.hedgeDelay)
+	defer timer.Stop()
+
+	for {
+		select {
+		case r := <-results:
+			pending--
+			if pending == 0 || status.Code(r.err) != codes.Unavailable {
+				return r.res, r.err
+			}
+		case <-timer.C:
+			if started < c.hedgeAttempts {
+				start()
+				pending++
+				started++
+				timer.Reset(c.hedgeDelay)
+			}
+		}
+	}
+}
+
+// Create creates the laptop and returns its ID. The request is sent with a new idempotency key,
+// so that it's retried without creating the laptop twice.
+func (c *LaptopClient) Create(ctx context.Context, laptop *pb.Laptop) (string, error) {
+	return c.CreateWithIdempotencyKey(ctx, laptop, uuid.New().String())
+}
+
+// CreateWithIdempotencyKey creates the laptop with the given idempotency key and returns its ID,
+// the caller can repeat it with the same key to retry beyond the retries of the client.
+func (c *LaptopClient) CreateWithIdempotencyKey(
+	ctx context.Context,
+	laptop *pb.Laptop,
+	idempotencyKey string,
+) (string, error) {
+	req := &pb.CreateLaptopRequest{
+		Laptop:         laptop,
+		IdempotencyKey: idempotencyKey,
+	}
+
+	var res *pb.CreateLaptopResponse
+	err := c.retry(ctx, retryable, func(ctx context.Context) error {
+		var err error
+		res, err = c.service.CreateLaptop(ctx, req)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return res.GetId(), nil
+}
+
+// CreateAll creates the laptops in a single stream, the result of each laptop is in the response.
+// In all-or-nothing mode, none is created if any fails, and the aborted calls are retried.
+func (c *LaptopClient) CreateAll(
+	ctx context.Context,
+	laptops []*pb.Laptop,
+	allOrNothing bool,
+) (*pb.CreateLaptopsResponse, error) {
+	call := func(ctx context.Context) (*pb.CreateLaptopsResponse, error) {
+		stream, err := c.service.CreateLaptops(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		err = stream.Send(&pb.CreateLaptopsRequest{
+			Data: &pb.CreateLaptopsRequest_Options{
+				Options: &pb.CreateLaptopsOptions{AllOrNothing: allOrNothing},
+			},
+		})
+		for _, laptop := range laptops {
+			if err != nil {
+				// the server has ended the stream, its status is received by CloseAndRecv
+				break
+			}
+			err = stream.Send(&pb.CreateLaptopsRequest{
+				Data: &pb.CreateLaptopsRequest_Laptop{Laptop: laptop},
+			})
+		}
+
+		return stream.CloseAndRecv()
+	}
+
+	if !allOrNothing {
+		// some laptops may have been created when it fails, it's not safe to retry
+		ctx, cancel := c.withTimeout(ctx)
+		defer cancel()
+
+		res, err := call(ctx)
+		return res, toError(err)
+	}
+
+	// nothing is created when it fails, so it's safe to retry even if it's unavailable
+	var res *pb.CreateLaptopsResponse
+	err := c.retry(ctx, retryableNotCommitted, func(ctx context.Context) error {
+		var err error
+		res, err = call(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Get returns the laptop with its primary image ID, see WithHedging
+func (c *LaptopClient) Get(ctx context.Context, laptopID string) (*pb.GetLaptopResponse, error) {
+	var res *pb.GetLaptopResponse
+	err := c.retry(ctx, retryable, func(ctx context.Context) error {
+		got, err := c.hedge(ctx, func(ctx context.Context) (interface{}, error) {
+			return c.service.GetLaptop(ctx, &pb.GetLaptopRequest{Id: laptopID})
+		})
+		if err == nil {
+			res = got.(*pb.GetLaptopResponse)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// SetPrimaryImage marks an uploaded image as the primary image of the laptop
+func (c *LaptopClient) SetPrimaryImage(ctx context.Context, laptopID, imageID string) error {
+	req := &pb.SetPrimaryImageRequest{
+		LaptopId: laptopID,
+		ImageId:  imageID,
+	}
+
+	return c.retry(ctx, retryable, func(ctx context.Context) error {
+		_, err := c.service.SetPrimaryImage(ctx, req)
+		return err
+	})
+}
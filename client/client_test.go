@@ -0,0 +1,337 @@
+package client_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hjcian/grpc-notes/client"
+	"github.com/hjcian/grpc-notes/pb"
+	"github.com/hjcian/grpc-notes/rpcerror"
+	"github.com/hjcian/grpc-notes/sample"
+	"github.com/hjcian/grpc-notes/service"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// flakyInterceptors fail the first calls with Unavailable, and delay the next one
+type flakyInterceptors struct {
+	failures int32
+	delay    time.Duration
+	calls    int32
+}
+
+func (f *flakyInterceptors) fail(ctx context.Context) error {
+	call := atomic.AddInt32(&f.calls, 1)
+	if call <= f.failures {
+		return status.Error(codes.Unavailable, "server is warming up")
+	}
+	if call == f.failures+1 && f.delay > 0 {
+		select {
+		case <-time.After(f.delay):
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		}
+	}
+	return nil
+}
+
+func (f *flakyInterceptors) unary(
+	ctx context.Context,
+	req interface{},
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (interface{}, error) {
+	if err := f.fail(ctx); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (f *flakyInterceptors) stream(
+	srv interface{},
+	stream grpc.ServerStream,
+	info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler,
+) error {
+	if err := f.fail(stream.Context()); err != nil {
+		return err
+	}
+	return handler(srv, stream)
+}
+
+// flakyLaptopStore fails to find the first laptops with ErrUnavailable
+type flakyLaptopStore struct {
+	*service.InMemoryLaptopStore
+	failures int32
+	calls    int32
+}
+
+func (store *flakyLaptopStore) Find(id string) (*pb.Laptop, error) {
+	if atomic.AddInt32(&store.calls, 1) <= store.failures {
+		return nil, service.ErrUnavailable
+	}
+	return store.InMemoryLaptopStore.Find(id)
+}
+
+func startTestClient(
+	t *testing.T,
+	flaky *flakyInterceptors,
+	laptopStore service.LaptopStore,
+	opts ...client.Option,
+) *client.LaptopClient {
+	grpcServer := grpc.NewServer(
+		grpc.UnaryInterceptor(flaky.unary),
+		grpc.StreamInterceptor(flaky.stream),
+	)
+	laptopServer := service.NewLaptopServer(
+		laptopStore,
+		service.NewDiskImageStore(t.TempDir()),
+		service.NewInMemoryRatingStore(),
+		service.WithIdempotencyStore(service.NewInMemoryIdempotencyStore(time.Minute)),
+	)
+	pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
+
+	listener, err := net.Listen("tcp", ":0")
+	require.NoError(t, err)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	conn, err := client.Dial(listener.Addr().String(), grpc.WithInsecure())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	opts = append([]client.Option{client.WithRetries(3, time.Millisecond)}, opts...)
+	return client.NewLaptopClient(conn, opts...)
+}
+
+func TestCreateAndGet(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	laptopClient := startTestClient(t, &flakyInterceptors{failures: 2}, laptopStore)
+
+	laptop := sample.NewLaptop()
+	laptop.Id = ""
+	id, err := laptopClient.Create(context.Background(), laptop)
+	require.NoError(t, err)
+	require.NotEmpty(t, id)
+	require.Equal(t, 1, laptopStore.Count())
+
+	res, err := laptopClient.Get(context.Background(), id)
+	require.NoError(t, err)
+	require.Equal(t, id, res.GetLaptop().GetId())
+
+	_, err = laptopClient.Get(context.Background(), "unknown")
+	require.True(t, errors.Is(err, client.ErrNotFound))
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	var clientErr *client.Error
+	require.True(t, errors.As(err, &clientErr))
+	require.NotNil(t, clientErr.Details.ResourceInfo)
+
+	_, err = laptopClient.Create(context.Background(), res.GetLaptop())
+	require.True(t, errors.Is(err, client.ErrAlreadyExists))
+}
+
+func TestRetries(t *testing.T) {
+	t.Parallel()
+
+	// the channel makes up to 4 attempts
+	flaky := &flakyInterceptors{failures: 3}
+	laptopClient := startTestClient(t, flaky, service.NewInMemoryLaptopStore())
+
+	_, err := laptopClient.Create(context.Background(), sample.NewLaptop())
+	require.NoError(t, err)
+	require.Equal(t, int32(4), atomic.LoadInt32(&flaky.calls))
+
+	flaky = &flakyInterceptors{failures: 4}
+	laptopClient = startTestClient(t, flaky, service.NewInMemoryLaptopStore())
+
+	_, err = laptopClient.Create(context.Background(), sample.NewLaptop())
+	require.True(t, errors.Is(err, client.ErrUnavailable))
+	require.Equal(t, int32(4), atomic.LoadInt32(&flaky.calls))
+
+	// CreateLaptops isn't retried by the channel, only by the client in all-or-nothing mode
+	flaky = &flakyInterceptors{failures: 2}
+	laptopClient = startTestClient(t, flaky, service.NewInMemoryLaptopStore())
+
+	_, err = laptopClient.CreateAll(context.Background(), []*pb.Laptop{sample.NewLaptop()}, false)
+	require.True(t, errors.Is(err, client.ErrUnavailable))
+	res, err := laptopClient.CreateAll(context.Background(), []*pb.Laptop{sample.NewLaptop()}, true)
+	require.NoError(t, err)
+	require.Equal(t, uint32(1), res.GetCreatedCount())
+}
+
+func TestRetriesUnavailableStore(t *testing.T) {
+	t.Parallel()
+
+	laptop := sample.NewLaptop()
+	laptopStore := &flakyLaptopStore{
+		InMemoryLaptopStore: service.NewInMemoryLaptopStore(),
+		failures:            2,
+	}
+	require.NoError(t, laptopStore.Save(laptop))
+	laptopClient := startTestClient(t, &flakyInterceptors{}, laptopStore)
+
+	res, err := laptopClient.Get(context.Background(), laptop.GetId())
+	require.NoError(t, err)
+	require.Equal(t, laptop.GetId(), res.GetLaptop().GetId())
+	require.Equal(t, int32(3), atomic.LoadInt32(&laptopStore.calls))
+}
+
+func TestHedging(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	laptop := sample.NewLaptop()
+	require.NoError(t, laptopStore.Save(laptop))
+
+	// the first call is stuck, the second one answers
+	flaky := &flakyInterceptors{delay: 10 * time.Second}
+	laptopClient := startTestClient(t, flaky, laptopStore, client.WithHedging(3, 20*time.Millisecond))
+
+	start := time.Now()
+	res, err := laptopClient.Get(context.Background(), laptop.GetId())
+	require.NoError(t, err)
+	require.Equal(t, laptop.GetId(), res.GetLaptop().GetId())
+	require.Less(t, int64(time.Since(start)), int64(time.Second))
+	require.Equal(t, int32(2), atomic.LoadInt32(&flaky.calls))
+
+	// the fatal errors aren't hedged
+	flaky = &flakyInterceptors{}
+	laptopClient = startTestClient(t, flaky, laptopStore, client.WithHedging(3, 20*time.Millisecond))
+
+	_, err = laptopClient.Get(context.Background(), "unknown")
+	require.True(t, errors.Is(err, client.ErrNotFound))
+	require.Equal(t, int32(1), atomic.LoadInt32(&flaky.calls))
+}
+
+func TestCreateAll(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	laptopClient := startTestClient(t, &flakyInterceptors{failures: 1}, laptopStore)
+
+	laptops := []*pb.Laptop{sample.NewLaptop(), sample.NewLaptop(), sample.NewLaptop()}
+	res, err := laptopClient.CreateAll(context.Background(), laptops, true)
+	require.NoError(t, err)
+	require.Equal(t, uint32(3), res.GetCreatedCount())
+	require.Equal(t, 3, laptopStore.Count())
+
+	res, err = laptopClient.CreateAll(context.Background(), []*pb.Laptop{laptops[0], sample.NewLaptop()}, false)
+	require.NoError(t, err)
+	require.Equal(t, uint32(1), res.GetCreatedCount())
+	require.Equal(t, uint32(1), res.GetFailedCount())
+}
+
+func TestSearch(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	laptopClient := startTestClient(t, &flakyInterceptors{failures: 1}, laptopStore)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, laptopStore.Save(sample.NewLaptop()))
+	}
+
+	it, err := laptopClient.Search(context.Background(), &pb.Filter{MaxPriceUsd: 10000})
+	require.NoError(t, err)
+	defer it.Close()
+
+	found := 0
+	for it.Next() {
+		require.NotEmpty(t, it.Laptop().GetId())
+		found++
+	}
+	require.NoError(t, it.Err())
+	require.Equal(t, 3, found)
+	require.False(t, it.Next())
+
+	it, err = laptopClient.Search(context.Background(), &pb.Filter{MaxPriceUsd: -1})
+	require.NoError(t, err)
+	require.False(t, it.Next())
+	require.True(t, errors.Is(it.Err(), client.ErrInvalidArgument))
+}
+
+func TestUploadImageFromReader(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	laptopClient := startTestClient(t, &flakyInterceptors{failures: 0}, laptopStore)
+	laptop := sample.NewLaptop()
+	require.NoError(t, laptopStore.Save(laptop))
+
+	image := bytes.Repeat([]byte{1}, 2500)
+	var progress []int
+	res, err := laptopClient.UploadImageFromReader(
+		context.Background(), laptop.GetId(), ".jpg", bytes.NewReader(image),
+		func(sent int) { progress = append(progress, sent) },
+	)
+	require.NoError(t, err)
+	require.Equal(t, uint32(len(image)), res.GetSize())
+	require.Equal(t, []int{1024, 2048, 2500}, progress)
+
+	require.NoError(t, laptopClient.SetPrimaryImage(context.Background(), laptop.GetId(), res.GetId()))
+	got, err := laptopClient.Get(context.Background(), laptop.GetId())
+	require.NoError(t, err)
+	require.Equal(t, res.GetId(), got.GetPrimaryImageId())
+
+	// the server rejects the image before all the chunks are sent
+	large := bytes.NewReader(make([]byte, service.MaxImageSize+imageOverflow))
+	_, err = laptopClient.UploadImageFromReader(context.Background(), laptop.GetId(), ".jpg", large, nil)
+	require.True(t, errors.Is(err, client.ErrInvalidArgument))
+	require.NotNil(t, rpcerror.FromError(err).QuotaFailure)
+
+	_, err = laptopClient.UploadImageFromReader(context.Background(), "unknown", ".jpg", bytes.NewReader(image), nil)
+	require.True(t, errors.Is(err, client.ErrInvalidArgument))
+}
+
+const imageOverflow = 4096
+
+func TestRateSession(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	laptopClient := startTestClient(t, &flakyInterceptors{failures: 0}, laptopStore)
+	laptop := sample.NewLaptop()
+	require.NoError(t, laptopStore.Save(laptop))
+
+	session, err := laptopClient.NewRateSession(context.Background())
+	require.NoError(t, err)
+
+	res, err := session.Rate(laptop.GetId(), 8)
+	require.NoError(t, err)
+	require.Equal(t, uint32(1), res.GetRatedCount())
+
+	res, err = session.Rate(laptop.GetId(), 10)
+	require.NoError(t, err)
+	require.Equal(t, uint32(2), res.GetRatedCount())
+	require.Equal(t, 9.0, res.GetAverageScore())
+	require.NoError(t, session.Close())
+
+	session, err = laptopClient.NewRateSession(context.Background())
+	require.NoError(t, err)
+	_, err = session.Rate("unknown", 8)
+	require.True(t, errors.Is(err, client.ErrNotFound))
+	_, err = session.Rate(laptop.GetId(), 8)
+	require.True(t, errors.Is(err, client.ErrNotFound))
+	require.Error(t, session.Close())
+}
+
+func TestTimeout(t *testing.T) {
+	t.Parallel()
+
+	flaky := &flakyInterceptors{delay: 10 * time.Second}
+	laptopClient := startTestClient(t, flaky, service.NewInMemoryLaptopStore(), client.WithTimeout(50*time.Millisecond))
+
+	start := time.Now()
+	_, err := laptopClient.Get(context.Background(), "any")
+	require.Equal(t, codes.DeadlineExceeded, status.Code(err))
+	require.Less(t, int64(time.Since(start)), int64(time.Second))
+}
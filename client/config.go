@@ -0,0 +1,40 @@
+package client
+
+import (
+	"google.golang.org/grpc"
+	// registers the client side health checking used by healthCheckConfig
+	_ "google.golang.org/grpc/health"
+)
+
+// ServiceConfig is the default service config of the connections to LaptopService.
+// The RPCs are balanced round robin across the servers whose health status of LaptopService
+// is SERVING, the servers without health service are considered healthy.
+// The RPCs which are safe to repeat are retried by the channel when they fail with UNAVAILABLE,
+// e.g. while the server restarts. CreateLaptop is safe since LaptopClient always sends an
+// idempotency key, CreateLaptops isn't since some laptops may be saved before it fails.
+const ServiceConfig = `{
+	"loadBalancingConfig": [{"round_robin": {}}],
+	"healthCheckConfig": {"serviceName": "techschool.pcbook.LaptopService"},
+	"methodConfig": [{
+		"name": [
+			{"service": "techschool.pcbook.LaptopService", "method": "CreateLaptop"},
+			{"service": "techschool.pcbook.LaptopService", "method": "SearchLaptop"},
+			{"service": "techschool.pcbook.LaptopService", "method": "GetLaptop"},
+			{"service": "techschool.pcbook.LaptopService", "method": "SetPrimaryImage"}
+		],
+		"retryPolicy": {
+			"maxAttempts": 4,
+			"initialBackoff": "0.1s",
+			"maxBackoff": "1s",
+			"backoffMultiplier": 2,
+			"retryableStatusCodes": ["UNAVAILABLE"]
+		}
+	}]
+}`
+
+// Dial connects to the servers of the target with the default service config, see Target.
+// The options can override the service config with grpc.WithDefaultServiceConfig.
+func Dial(target string, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	opts = append([]grpc.DialOption{grpc.WithDefaultServiceConfig(ServiceConfig)}, opts...)
+	return grpc.Dial(target, opts...)
+}
@@ -0,0 +1,76 @@
+package client
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hjcian/grpc-notes/rpcerror"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Error is returned by LaptopClient when an RPC fails, it can be compared with
+// the sentinel errors below with errors.Is, e.g. errors.Is(err, client.ErrNotFound)
+type Error struct {
+	Code    codes.Code
+	Message string
+	Details *rpcerror.Details
+
+	status *status.Status
+}
+
+// Sentinel errors matching the Error of the same code
+var (
+	ErrInvalidArgument   = &Error{Code: codes.InvalidArgument}
+	ErrNotFound          = &Error{Code: codes.NotFound}
+	ErrAlreadyExists     = &Error{Code: codes.AlreadyExists}
+	ErrResourceExhausted = &Error{Code: codes.ResourceExhausted}
+	ErrUnavailable       = &Error{Code: codes.Unavailable}
+)
+
+func (e *Error) Error() string {
+	if details := e.Details.String(); len(details) > 0 {
+		return fmt.Sprintf("%s: %s (%s)", e.Code, e.Message, details)
+	}
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// Is returns true if the target is an Error of the same code
+func (e *Error) Is(target error) bool {
+	other, ok := target.(*Error)
+	return ok && other.Code == e.Code
+}
+
+// GRPCStatus returns the status of the error with its details,
+// so that the status and rpcerror packages work on it
+func (e *Error) GRPCStatus() *status.Status {
+	if e.status == nil {
+		return status.New(e.Code, e.Message)
+	}
+	return e.status
+}
+
+// toError converts the error of an RPC to an Error, nil stays nil
+func toError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*Error); ok {
+		return err
+	}
+
+	switch err {
+	case context.Canceled:
+		err = status.Error(codes.Canceled, err.Error())
+	case context.DeadlineExceeded:
+		err = status.Error(codes.DeadlineExceeded, err.Error())
+	}
+
+	st := status.Convert(err)
+	return &Error{
+		Code:    st.Code(),
+		Message: st.Message(),
+		Details: rpcerror.FromError(err),
+		status:  st,
+	}
+}
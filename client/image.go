@@ -0,0 +1,89 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/hjcian/grpc-notes/pb"
+)
+
+// imageChunkSize is the size of the chunks of the uploaded images
+const imageChunkSize = 1024
+
+// UploadImageFromReader uploads an image of the laptop read from the reader. The image type is
+// the file extension, e.g. ".jpg". The progress callback, if not nil, is called with the number of
+// bytes sent so far after each chunk. The upload is not retried since the reader is consumed.
+func (c *LaptopClient) UploadImageFromReader(
+	ctx context.Context,
+	laptopID string,
+	imageType string,
+	reader io.Reader,
+	progress func(sent int),
+) (*pb.UploadImageResponse, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	stream, err := c.service.UploadImage(ctx)
+	if err != nil {
+		return nil, toError(err)
+	}
+
+	err = stream.Send(&pb.UploadImageRequest{
+		Data: &pb.UploadImageRequest_Info{
+			Info: &pb.ImageInfo{
+				LaptopId:  laptopID,
+				ImageType: imageType,
+			},
+		},
+	})
+
+	buffer := make([]byte, imageChunkSize)
+	sent := 0
+	for err == nil {
+		n, readErr := reader.Read(buffer)
+		if n > 0 {
+			err = stream.Send(&pb.UploadImageRequest{
+				Data: &pb.UploadImageRequest_ChunkData{ChunkData: buffer[:n]},
+			})
+			if err == nil {
+				sent += n
+				if progress != nil {
+					progress(sent)
+				}
+			}
+		}
+
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return nil, fmt.Errorf("cannot read image: %w", readErr)
+		}
+	}
+	// a failed send means the server has ended the stream, its status is received by CloseAndRecv
+
+	res, err := stream.CloseAndRecv()
+	if err != nil {
+		return nil, toError(err)
+	}
+	return res, nil
+}
+
+// UploadImageFile uploads the image file of the laptop, see UploadImageFromReader
+func (c *LaptopClient) UploadImageFile(
+	ctx context.Context,
+	laptopID string,
+	imagePath string,
+	progress func(sent int),
+) (*pb.UploadImageResponse, error) {
+	file, err := os.Open(imagePath)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open image file: %w", err)
+	}
+	defer file.Close()
+
+	return c.UploadImageFromReader(ctx, laptopID, filepath.Ext(imagePath), file, progress)
+}
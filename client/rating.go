@@ -0,0 +1,82 @@
+package client
+
+import (
+	"context"
+	"io"
+
+	"github.com/hjcian/grpc-notes/pb"
+)
+
+// RateSession rates laptops on a single RateLaptop stream, one rating at a time.
+// It's not safe for concurrent use.
+type RateSession struct {
+	stream pb.LaptopService_RateLaptopClient
+	cancel context.CancelFunc
+	err    error
+}
+
+// NewRateSession opens a rating stream, the timeout of the client covers the whole session.
+// The session must be closed.
+func (c *LaptopClient) NewRateSession(ctx context.Context) (*RateSession, error) {
+	ctx, cancel := c.withTimeout(ctx)
+
+	stream, err := c.service.RateLaptop(ctx)
+	if err != nil {
+		cancel()
+		return nil, toError(err)
+	}
+
+	return &RateSession{stream: stream, cancel: cancel}, nil
+}
+
+// Rate rates the laptop and returns its updated rating. The session can't be used anymore
+// after an error since the server ends the stream.
+func (s *RateSession) Rate(laptopID string, score float64) (*pb.RateLaptopResponse, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	err := s.stream.Send(&pb.RateLaptopRequest{
+		LaptopId: laptopID,
+		Score:    score,
+	})
+	if err != nil && err != io.EOF {
+		return nil, s.fail(err)
+	}
+	// on io.EOF the server has ended the stream, its status is received by Recv
+
+	res, err := s.stream.Recv()
+	if err == io.EOF {
+		return nil, s.fail(io.ErrUnexpectedEOF)
+	}
+	if err != nil {
+		return nil, s.fail(err)
+	}
+	return res, nil
+}
+
+func (s *RateSession) fail(err error) error {
+	s.err = toError(err)
+	s.cancel()
+	return s.err
+}
+
+// Close ends the session, it returns the error which ended the session, if any
+func (s *RateSession) Close() error {
+	defer s.cancel()
+
+	if s.err != nil {
+		return s.err
+	}
+
+	err := s.stream.CloseSend()
+	if err != nil {
+		return toError(err)
+	}
+
+	_, err = s.stream.Recv()
+	if err != io.EOF {
+		return toError(err)
+	}
+	return nil
+}
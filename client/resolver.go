@@ -0,0 +1,62 @@
+package client
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/grpc/resolver"
+)
+
+// StaticScheme is the scheme of the targets listing the server addresses,
+// e.g. "static:///host1:8080,host2:8080"
+const StaticScheme = "static"
+
+func init() {
+	resolver.Register(staticBuilder{})
+}
+
+// Target returns the target to dial for the server addresses. A single address is returned as is,
+// so that it can also be a target of another scheme, e.g. "dns:///laptops.internal:8080".
+func Target(addresses ...string) string {
+	if len(addresses) == 1 {
+		return addresses[0]
+	}
+	return fmt.Sprintf("%s:///%s", StaticScheme, strings.Join(addresses, ","))
+}
+
+// staticBuilder resolves the static targets to their fixed list of addresses
+type staticBuilder struct{}
+
+func (staticBuilder) Build(
+	target resolver.Target,
+	cc resolver.ClientConn,
+	opts resolver.BuildOptions,
+) (resolver.Resolver, error) {
+	var addresses []resolver.Address
+	for _, address := range strings.Split(target.Endpoint, ",") {
+		address = strings.TrimSpace(address)
+		if len(address) > 0 {
+			addresses = append(addresses, resolver.Address{Addr: address})
+		}
+	}
+	if len(addresses) == 0 {
+		return nil, fmt.Errorf("no server address in target %q", target.Endpoint)
+	}
+
+	err := cc.UpdateState(resolver.State{Addresses: addresses})
+	if err != nil {
+		return nil, err
+	}
+	return staticResolver{}, nil
+}
+
+func (staticBuilder) Scheme() string {
+	return StaticScheme
+}
+
+// staticResolver has nothing to resolve again since the addresses never change
+type staticResolver struct{}
+
+func (staticResolver) ResolveNow(resolver.ResolveNowOptions) {}
+
+func (staticResolver) Close() {}
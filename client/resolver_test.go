@@ -0,0 +1,128 @@
+package client_test
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hjcian/grpc-notes/client"
+	"github.com/hjcian/grpc-notes/pb"
+	"github.com/hjcian/grpc-notes/sample"
+	"github.com/hjcian/grpc-notes/service"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// replica is an in-process server counting the LaptopService calls it receives
+type replica struct {
+	address string
+	server  *grpc.Server
+	health  *health.Server
+	calls   int32
+}
+
+func startReplica(t *testing.T, laptopStore service.LaptopStore) *replica {
+	r := &replica{health: health.NewServer()}
+
+	r.server = grpc.NewServer(grpc.UnaryInterceptor(func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		atomic.AddInt32(&r.calls, 1)
+		return handler(ctx, req)
+	}))
+	laptopServer := service.NewLaptopServer(laptopStore, nil, service.NewInMemoryRatingStore())
+	pb.RegisterLaptopServiceServer(r.server, laptopServer)
+	healthpb.RegisterHealthServer(r.server, r.health)
+	r.health.SetServingStatus(service.LaptopServiceName, healthpb.HealthCheckResponse_SERVING)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go r.server.Serve(listener)
+	t.Cleanup(r.server.Stop)
+
+	r.address = listener.Addr().String()
+	return r
+}
+
+// callReplicas gets the laptop n times and returns the number of calls received by each replica
+func callReplicas(t *testing.T, laptopClient *client.LaptopClient, laptopID string, n int, replicas []*replica) []int {
+	for _, r := range replicas {
+		atomic.StoreInt32(&r.calls, 0)
+	}
+
+	for i := 0; i < n; i++ {
+		_, err := laptopClient.Get(context.Background(), laptopID)
+		require.NoError(t, err)
+	}
+
+	calls := make([]int, len(replicas))
+	for i, r := range replicas {
+		calls[i] = int(atomic.LoadInt32(&r.calls))
+	}
+	return calls
+}
+
+func TestLoadBalancing(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	laptop := sample.NewLaptop()
+	require.NoError(t, laptopStore.Save(laptop))
+
+	replicas := []*replica{
+		startReplica(t, laptopStore),
+		startReplica(t, laptopStore),
+		startReplica(t, laptopStore),
+	}
+
+	target := client.Target(replicas[0].address, replicas[1].address, replicas[2].address)
+	conn, err := client.Dial(target, grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	laptopClient := client.NewLaptopClient(conn)
+
+	// round robin across all the replicas once they are connected
+	require.Eventually(t, func() bool {
+		calls := callReplicas(t, laptopClient, laptop.GetId(), 30, replicas)
+		return calls[0] == 10 && calls[1] == 10 && calls[2] == 10
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// an unhealthy replica gets no more calls
+	replicas[0].health.SetServingStatus(service.LaptopServiceName, healthpb.HealthCheckResponse_NOT_SERVING)
+	require.Eventually(t, func() bool {
+		calls := callReplicas(t, laptopClient, laptop.GetId(), 10, replicas)
+		return calls[0] == 0 && calls[1] == 5 && calls[2] == 5
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// the calls fail over to the last replica when another one is down
+	replicas[1].server.Stop()
+	require.Eventually(t, func() bool {
+		calls := callReplicas(t, laptopClient, laptop.GetId(), 10, replicas)
+		return calls[0] == 0 && calls[2] == 10
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// the replica is back when it's healthy again
+	replicas[0].health.SetServingStatus(service.LaptopServiceName, healthpb.HealthCheckResponse_SERVING)
+	require.Eventually(t, func() bool {
+		calls := callReplicas(t, laptopClient, laptop.GetId(), 10, replicas)
+		return calls[0] == 5 && calls[2] == 5
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestTarget(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(t, "localhost:8080", client.Target("localhost:8080"))
+	require.Equal(t, "dns:///laptops:8080", client.Target("dns:///laptops:8080"))
+	require.Equal(t, "static:///host1:8080,host2:8080", client.Target("host1:8080", "host2:8080"))
+
+	_, err := client.Dial("static:///", grpc.WithInsecure())
+	require.Error(t, err)
+}
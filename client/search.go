@@ -0,0 +1,131 @@
+package client
+
+import (
+	"context"
+	"io"
+
+	"github.com/hjcian/grpc-notes/pb"
+)
+
+// SearchIterator iterates over the laptops found by Search:
+//
+//	it, err := client.Search(ctx, filter)
+//	...
+//	defer it.Close()
+//	for it.Next() {
+//		laptop := it.Laptop()
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+type SearchIterator struct {
+	client *LaptopClient
+	ctx    context.Context
+	cancel context.CancelFunc
+	req    *pb.SearchLaptopRequest
+
+	stream   pb.LaptopService_SearchLaptopClient
+	current  *pb.SearchLaptopResponse
+	received bool
+	attempt  int
+	err      error
+}
+
+// Search starts searching for the laptops matching the filter. The search is retried
+// if it fails before any laptop is received.
+func (c *LaptopClient) Search(ctx context.Context, filter *pb.Filter) (*SearchIterator, error) {
+	return c.SearchLaptop(ctx, &pb.SearchLaptopRequest{Filter: filter})
+}
+
+// SearchLaptop is Search with the currency and the sort of the request
+func (c *LaptopClient) SearchLaptop(ctx context.Context, req *pb.SearchLaptopRequest) (*SearchIterator, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	it := &SearchIterator{
+		client: c,
+		ctx:    ctx,
+		cancel: cancel,
+		req:    req,
+	}
+
+	if err := it.open(); err != nil {
+		cancel()
+		return nil, toError(err)
+	}
+	return it, nil
+}
+
+func (it *SearchIterator) open() error {
+	stream, err := it.client.service.SearchLaptop(it.ctx, it.req)
+	if err != nil {
+		return err
+	}
+	it.stream = stream
+	return nil
+}
+
+// Next receives the next laptop, it returns false when there is no more laptop or an error occurs
+func (it *SearchIterator) Next() bool {
+	if it.err != nil || it.stream == nil {
+		return false
+	}
+
+	for {
+		res, err := it.stream.Recv()
+		if err == nil {
+			it.current = res
+			it.received = true
+			return true
+		}
+
+		if err == io.EOF {
+			it.Close()
+			return false
+		}
+
+		if !it.received {
+			delay, ok := it.client.retryDelay(it.attempt, err, retryable)
+			if ok && wait(it.ctx, delay) {
+				it.attempt++
+				if err = it.open(); err == nil {
+					continue
+				}
+			}
+		}
+
+		it.err = toError(err)
+		it.Close()
+		return false
+	}
+}
+
+// Laptop returns the laptop received by the last call to Next
+func (it *SearchIterator) Laptop() *pb.Laptop {
+	return it.current.GetLaptop()
+}
+
+// PrimaryImageID returns the primary image ID of the laptop received by the last call to Next
+func (it *SearchIterator) PrimaryImageID() string {
+	return it.current.GetPrimaryImageId()
+}
+
+// Price returns the price of the laptop received by the last call to Next in the currency
+// of the search, nil if no currency nor sort is requested
+func (it *SearchIterator) Price() *pb.Money {
+	return it.current.GetPrice()
+}
+
+// Response returns the response received by the last call to Next
+func (it *SearchIterator) Response() *pb.SearchLaptopResponse {
+	return it.current
+}
+
+// Err returns the error which stopped the iteration, if any
+func (it *SearchIterator) Err() error {
+	return it.err
+}
+
+// Close stops the search, it must be called if the iteration is stopped early
+func (it *SearchIterator) Close() {
+	it.cancel()
+	it.stream = nil
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/hjcian/grpc-notes/client"
+	"github.com/hjcian/grpc-notes/money"
+	"github.com/hjcian/grpc-notes/pb"
+	"github.com/hjcian/grpc-notes/sample"
+	"github.com/hjcian/grpc-notes/serializer"
+	"github.com/hjcian/grpc-notes/units"
+)
+
+func newFlagSet(name, args, description string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: client [flags] %s [flags] %s\n\n%s\n\n", name, args, description)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+func runCreate(ctx context.Context, laptopClient *client.LaptopClient, out *printer, args []string) error {
+	flags := newFlagSet("create", "", "Create the laptops of a JSON, YAML or CSV file, or n sample laptops.")
+	file := flags.String("file", "", "the file of the laptops, its format is told by its extension: .json, .yaml, .yml or .csv")
+	n := flags.Int("n", 1, "the number of sample laptops to create if no file is given")
+	allOrNothing := flags.Bool("all-or-nothing", false, "create none of the laptops if any fails")
+	idempotencyKey := flags.String("idempotency-key", "", "the key making the retries of the creation safe")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		return usageErrorf("unexpected arguments %v", flags.Args())
+	}
+	if *n < 1 {
+		return usageErrorf("n must be positive")
+	}
+
+	if len(*file) > 0 {
+		laptops, err := serializer.ReadLaptopsFromFile(*file)
+		if err != nil {
+			return err
+		}
+		switch len(laptops) {
+		case 0:
+			return fmt.Errorf("no laptop in %s", *file)
+		case 1:
+			return createLaptop(ctx, laptopClient, out, laptops[0], *idempotencyKey)
+		default:
+			return createLaptops(ctx, laptopClient, out, laptops, *allOrNothing)
+		}
+	}
+
+	if *n == 1 {
+		return createLaptop(ctx, laptopClient, out, sample.NewLaptop(), *idempotencyKey)
+	}
+
+	laptops := make([]*pb.Laptop, *n)
+	for i := range laptops {
+		laptops[i] = sample.NewLaptop()
+	}
+	return createLaptops(ctx, laptopClient, out, laptops, *allOrNothing)
+}
+
+var createColumns = []string{"INDEX", "ID", "STATUS", "REASON"}
+
+func createLaptop(
+	ctx context.Context,
+	laptopClient *client.LaptopClient,
+	out *printer,
+	laptop *pb.Laptop,
+	idempotencyKey string,
+) error {
+	var id string
+	var err error
+	if len(idempotencyKey) > 0 {
+		id, err = laptopClient.CreateWithIdempotencyKey(ctx, laptop, idempotencyKey)
+	} else {
+		id, err = laptopClient.Create(ctx, laptop)
+	}
+	if err != nil {
+		return rpcFailed("create laptop", err)
+	}
+
+	res := &pb.CreateLaptopResponse{Id: id}
+	return out.Print(res, createColumns, 0, res.GetId(), pb.CreateLaptopResult_CREATED, "")
+}
+
+func createLaptops(
+	ctx context.Context,
+	laptopClient *client.LaptopClient,
+	out *printer,
+	laptops []*pb.Laptop,
+	allOrNothing bool,
+) error {
+	res, err := laptopClient.CreateAll(ctx, laptops, allOrNothing)
+	if err != nil {
+		return rpcFailed("create laptops", err)
+	}
+
+	for _, result := range res.GetResults() {
+		err := out.Print(result, createColumns,
+			result.GetIndex(), result.GetId(), result.GetStatus(), result.GetReason())
+		if err != nil {
+			return err
+		}
+	}
+
+	if res.GetFailedCount() > 0 {
+		return fmt.Errorf("created %d laptops, %d failed", res.GetCreatedCount(), res.GetFailedCount())
+	}
+	return nil
+}
+
+var searchSorts = map[string]pb.SearchLaptopRequest_Sort{
+	"none":   pb.SearchLaptopRequest_NONE,
+	"price":  pb.SearchLaptopRequest_PRICE_ASCENDING,
+	"-price": pb.SearchLaptopRequest_PRICE_DESCENDING,
+}
+
+func runSearch(ctx context.Context, laptopClient *client.LaptopClient, out *printer, args []string) error {
+	flags := newFlagSet("search", "", "Search for the laptops matching the filter.")
+	maxPrice := flags.Float64("max-price", 0, "the max price in the currency, 0 means any price")
+	currency := flags.String("currency", "", "the currency of the max price and of the printed prices, e.g. EUR, USD by default")
+	sortBy := flags.String("sort", "none", "sort the laptops by price: none, price or -price for the highest price first")
+	minCores := flags.Uint("min-cores", 0, "the min number of CPU cores")
+	minGhz := flags.Float64("min-ghz", 0, "the min frequency of the CPU in GHz")
+	minRAM := flags.String("min-ram", "", "the min size of the RAM, e.g. 16GiB or 1.5TB, a number alone is in min-ram-unit")
+	minRAMUnit := flags.String("min-ram-unit", "GIGABYTE", "the unit of a min-ram without unit, e.g. MEGABYTE or GIGABYTE")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		return usageErrorf("unexpected arguments %v", flags.Args())
+	}
+
+	unit, ok := pb.Memory_Unit_value[strings.ToUpper(*minRAMUnit)]
+	if !ok {
+		return usageErrorf("unknown memory unit %q", *minRAMUnit)
+	}
+	ram := &pb.Memory{Unit: pb.Memory_Unit(unit)}
+	if len(*minRAM) > 0 {
+		var err error
+		if ram.Value, err = strconv.ParseUint(*minRAM, 10, 64); err != nil {
+			if ram, err = units.ParseMemory(*minRAM); err != nil {
+				return usageErrorf("invalid -min-ram: %v", err)
+			}
+		}
+	}
+
+	sort, ok := searchSorts[*sortBy]
+	if !ok {
+		return usageErrorf("unknown sort %q, expect none, price or -price", *sortBy)
+	}
+
+	filter := &pb.Filter{
+		MinCpuCores: uint32(*minCores),
+		MinCpuGhz:   *minGhz,
+		MinRam:      ram,
+	}
+	switch {
+	case *maxPrice == 0:
+		filter.MaxPriceUsd = math.MaxFloat64
+	case len(*currency) > 0:
+		price, err := money.FromFloat(strings.ToUpper(*currency), *maxPrice)
+		if err != nil {
+			return usageErrorf("invalid -max-price: %v", err)
+		}
+		filter.MaxPrice = price
+	default:
+		filter.MaxPriceUsd = *maxPrice
+	}
+
+	it, err := laptopClient.SearchLaptop(ctx, &pb.SearchLaptopRequest{
+		Filter:       filter,
+		CurrencyCode: strings.ToUpper(*currency),
+		Sort:         sort,
+	})
+	if err != nil {
+		return rpcFailed("search laptop", err)
+	}
+	defer it.Close()
+
+	for it.Next() {
+		err := out.PrintLaptop(it.Response(), it.Laptop(), it.Price(), it.PrimaryImageID())
+		if err != nil {
+			return err
+		}
+	}
+	if err := it.Err(); err != nil {
+		return rpcFailed("search laptop", err)
+	}
+	return nil
+}
+
+func runGet(ctx context.Context, laptopClient *client.LaptopClient, out *printer, args []string) error {
+	flags := newFlagSet("get", "<laptop id>", "Get a laptop by ID.")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return usageErrorf("expect exactly one laptop ID")
+	}
+
+	res, err := laptopClient.Get(ctx, flags.Arg(0))
+	if err != nil {
+		return rpcFailed("get laptop", err)
+	}
+
+	return out.PrintLaptop(res, res.GetLaptop(), nil, res.GetPrimaryImageId())
+}
+
+func runUploadImage(ctx context.Context, laptopClient *client.LaptopClient, out *printer, args []string) error {
+	flags := newFlagSet("upload-image", "<image file>", "Upload an image of a laptop.")
+	laptopID := flags.String("laptop", "", "the ID of the laptop")
+	primary := flags.Bool("primary", false, "set the image as the primary image of the laptop")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return usageErrorf("expect exactly one image file")
+	}
+	if len(*laptopID) == 0 {
+		return usageErrorf("laptop ID is required")
+	}
+
+	res, err := laptopClient.UploadImageFile(ctx, *laptopID, flags.Arg(0), nil)
+	if err != nil {
+		return rpcFailed("upload image", err)
+	}
+
+	if *primary {
+		err := laptopClient.SetPrimaryImage(ctx, *laptopID, res.GetId())
+		if err != nil {
+			return rpcFailed("set primary image", err)
+		}
+	}
+
+	return out.Print(res, []string{"IMAGE ID", "SIZE", "PRIMARY"}, res.GetId(), res.GetSize(), *primary)
+}
+
+func runRate(ctx context.Context, laptopClient *client.LaptopClient, out *printer, args []string) error {
+	flags := newFlagSet("rate", "<laptop id>=<score>...", "Rate laptops with scores from 1 to 10.")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return usageErrorf("expect at least one <laptop id>=<score>")
+	}
+
+	var laptopIDs []string
+	var scores []float64
+	for _, arg := range flags.Args() {
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) != 2 {
+			return usageErrorf("invalid rating %q, expect <laptop id>=<score>", arg)
+		}
+		score, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil {
+			return usageErrorf("invalid score in %q", arg)
+		}
+		laptopIDs = append(laptopIDs, parts[0])
+		scores = append(scores, score)
+	}
+
+	return rateLaptop(ctx, laptopClient, out, laptopIDs, scores)
+}
+
+func rateLaptop(
+	ctx context.Context,
+	laptopClient *client.LaptopClient,
+	out *printer,
+	laptopIDs []string,
+	scores []float64,
+) error {
+	session, err := laptopClient.NewRateSession(ctx)
+	if err != nil {
+		return rpcFailed("rate laptop", err)
+	}
+
+	for i, laptopID := range laptopIDs {
+		res, err := session.Rate(laptopID, scores[i])
+		if err != nil {
+			session.Close()
+			return rpcFailed("rate laptop", err)
+		}
+
+		err = out.Print(res, []string{"LAPTOP ID", "RATED COUNT", "AVERAGE SCORE"},
+			res.GetLaptopId(), res.GetRatedCount(), fmt.Sprintf("%.2f", res.GetAverageScore()))
+		if err != nil {
+			session.Close()
+			return err
+		}
+	}
+
+	if err := session.Close(); err != nil {
+		return rpcFailed("rate laptop", err)
+	}
+	return nil
+}
+
+var summaryColumns = []string{
+	"LAPTOPS", "SKIPPED LAPTOPS", "INVALID LAPTOPS", "RATINGS", "SKIPPED RATINGS", "IMAGES", "PRIMARY IMAGES",
+}
+
+func printSummary(out *printer, summary *pb.ArchiveSummary) error {
+	return out.Print(summary, summaryColumns,
+		summary.GetLaptops(),
+		summary.GetSkippedLaptops(),
+		summary.GetInvalidLaptops(),
+		summary.GetRatings(),
+		summary.GetSkippedRatings(),
+		summary.GetImages(),
+		summary.GetPrimaryImages(),
+	)
+}
+
+func runExport(ctx context.Context, laptopClient *client.LaptopClient, out *printer, args []string) error {
+	flags := newFlagSet("export", "<archive file>", "Export the whole catalog to an archive file.")
+	images := flags.Bool("images", false, "include the data of the images, not only their metadata")
+	compressionName := flags.String("compression", "gzip", "the compression of the archive: none, gzip or zstd")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return usageErrorf("expect exactly one archive file")
+	}
+	compression, err := serializer.ParseCompression(*compressionName)
+	if err != nil {
+		return usageErrorf("%v", err)
+	}
+
+	file, err := os.Create(flags.Arg(0))
+	if err != nil {
+		return fmt.Errorf("cannot create archive file: %w", err)
+	}
+
+	summary, err := laptopClient.ExportCatalog(ctx, *images, file, compression)
+	if closeErr := file.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("cannot write archive file: %w", closeErr)
+	}
+	if err != nil {
+		// don't leave an incomplete archive behind
+		os.Remove(flags.Arg(0))
+		return rpcFailed("export catalog", err)
+	}
+
+	return printSummary(out, summary)
+}
+
+func runImport(ctx context.Context, laptopClient *client.LaptopClient, out *printer, args []string) error {
+	flags := newFlagSet("import", "<archive file>",
+		"Import an archive file into the catalog, the existing laptops are kept.")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return usageErrorf("expect exactly one archive file")
+	}
+
+	file, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return fmt.Errorf("cannot open archive file: %w", err)
+	}
+	defer file.Close()
+
+	summary, err := laptopClient.ImportCatalog(ctx, file)
+	if err != nil {
+		return rpcFailed("import catalog", err)
+	}
+
+	return printSummary(out, summary)
+}
@@ -1,284 +1,179 @@
 package main
 
 import (
-	"bufio"
 	"context"
+	"errors"
 	"flag"
 	"fmt"
-	"io"
-	"log"
 	"os"
-	"path/filepath"
 	"strings"
 	"time"
 
-	"github.com/hjcian/grpc-notes/pb"
-	"github.com/hjcian/grpc-notes/sample"
+	"github.com/hjcian/grpc-notes/client"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
 )
 
-func createLaptop(client pb.LaptopServiceClient, laptop *pb.Laptop) {
-	req := &pb.CreateLaptopRequest{
-		Laptop: laptop,
-	}
-
-	// set timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	res, err := client.CreateLaptop(ctx, req)
-	if err != nil {
-		st, ok := status.FromError(err)
-		if ok && st.Code() == codes.AlreadyExists {
-			// not a big deal
-			log.Print("laptop already exists")
-		} else if ok {
-			log.Fatalf("error: %s | %s", st.Code(), err)
-		} else {
-			log.Fatal("cannot create laptop: ", err)
-		}
-		return
-	}
+// Exit codes of the client, the gRPC errors exit with 10 + their status code,
+// e.g. 15 for NOT_FOUND
+const (
+	exitOK      = 0
+	exitFailure = 1
+	exitUsage   = 2
+	exitRPC     = 10
+)
 
-	log.Printf("created laptop with id: %s", res.Id)
+const usage = `usage: client [flags] <command> [command flags]
+
+commands:
+  create        create the laptops of a JSON, YAML or CSV file, or sample laptops
+  search        search for laptops with a filter
+  get           get a laptop by ID
+  upload-image  upload an image of a laptop
+  rate          rate laptops
+  export        export the whole catalog to an archive file
+  import        import an archive file into the catalog
+
+flags:
+`
+
+// command runs a subcommand with its arguments
+type command func(ctx context.Context, laptopClient *client.LaptopClient, out *printer, args []string) error
+
+var commands = map[string]command{
+	"create":       runCreate,
+	"search":       runSearch,
+	"get":          runGet,
+	"upload-image": runUploadImage,
+	"rate":         runRate,
+	"export":       runExport,
+	"import":       runImport,
 }
 
-func createLaptopN(laptopClient pb.LaptopServiceClient, n int) {
-	for i := 0; i < n; i++ {
-		createLaptop(laptopClient, sample.NewLaptop())
-	}
+// usageError is returned for invalid command line arguments
+type usageError struct {
+	message string
 }
 
-func searchLaptop(laptopClient pb.LaptopServiceClient, filter *pb.Filter) {
-	log.Print("search filter:", filter)
-
-	ctx, cancel := context.WithTimeout(
-		context.Background(),
-		5*time.Second,
-	)
-	defer cancel()
+func (e *usageError) Error() string {
+	return e.message
+}
 
-	req := &pb.SearchLaptopRequest{Filter: filter}
+func usageErrorf(format string, args ...interface{}) error {
+	return &usageError{message: fmt.Sprintf(format, args...)}
+}
 
-	stream, err := laptopClient.SearchLaptop(ctx, req)
-	if err != nil {
-		log.Fatal("cannot search laptop:", err)
+// parseFlags parses the flags, the errors are usage errors except for flag.ErrHelp
+func parseFlags(flags *flag.FlagSet, args []string) error {
+	err := flags.Parse(args)
+	if err != nil && err != flag.ErrHelp {
+		return &usageError{message: err.Error()}
 	}
+	return err
+}
 
-	for {
-		res, err := stream.Recv()
-		if err == io.EOF {
-			return
-		}
-
-		if err != nil {
-			log.Fatal("cannot receive response: ", err)
-		}
+// rpcFailure is returned when an RPC fails, it keeps the typed error of the client
+type rpcFailure struct {
+	op  string
+	err *client.Error
+}
 
-		laptop := res.GetLaptop()
-		log.Print("- found: ", laptop.GetId())
-		log.Print("  + brand: ", laptop.GetBrand())
-		log.Print("  + name: ", laptop.GetName())
-		log.Print("  + cpu cores: ", laptop.GetCpu().GetNumberCores())
-		log.Print("  + cpu min ghz: ", laptop.GetCpu().GetMinGhz())
-		log.Print("  + ram: ", laptop.GetRam())
-		log.Print("  + price: ", laptop.GetPriceUsd())
-	}
+func (e *rpcFailure) Error() string {
+	return fmt.Sprintf("cannot %s: %s", e.op, e.err.Message)
 }
 
-func testCreateLaptop(client pb.LaptopServiceClient) {
-	createLaptopN(client, 10)
+func (e *rpcFailure) Unwrap() error {
+	return e.err
 }
 
-func testSearchLaptop(client pb.LaptopServiceClient) {
-	filter := &pb.Filter{
-		MaxPriceUsd: 3000,
-		MinCpuCores: 4,
-		MinCpuGhz:   2.5,
-		MinRam: &pb.Memory{
-			Value: 8,
-			Unit:  pb.Memory_GIGABYTE,
-		},
+// rpcFailed wraps the errors of the RPCs, the other errors, e.g. of reading a file, are returned as is
+func rpcFailed(op string, err error) error {
+	var clientErr *client.Error
+	if !errors.As(err, &clientErr) {
+		return err
 	}
-	searchLaptop(client, filter)
+	return &rpcFailure{op: op, err: clientErr}
 }
 
-func uploadImage(client pb.LaptopServiceClient, laptopID string, imagePath string) {
-	file, err := os.Open(imagePath)
-	if err != nil {
-		log.Fatal("cannot open image file: ", err)
+func exitCode(err error) int {
+	var usageErr *usageError
+	var rpcErr *rpcFailure
+
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.As(err, &usageErr):
+		return exitUsage
+	case errors.As(err, &rpcErr):
+		return exitRPC + int(rpcErr.err.Code)
+	default:
+		return exitFailure
 	}
-	defer file.Close()
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+// report prints the error and its details to stderr
+func report(err error) {
+	fmt.Fprintln(os.Stderr, "error:", err)
 
-	stream, err := client.UploadImage(ctx)
-	if err != nil {
-		log.Fatal("cannot upload image: ", err)
+	var rpcErr *rpcFailure
+	if errors.As(err, &rpcErr) {
+		fmt.Fprintf(os.Stderr, "code: %s\n", rpcErr.err.Code)
+		if details := rpcErr.err.Details.String(); len(details) > 0 {
+			fmt.Fprintln(os.Stderr, "details:", details)
+		}
 	}
+}
 
-	req := &pb.UploadImageRequest{
-		Data: &pb.UploadImageRequest_Info{
-			Info: &pb.ImageInfo{
-				LaptopId:  laptopID,
-				ImageType: filepath.Ext(imagePath),
-			},
-		},
+func run(args []string) error {
+	flags := flag.NewFlagSet("client", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprint(flags.Output(), usage)
+		flags.PrintDefaults()
 	}
+	serverAddr := flags.String("address", "localhost:8080",
+		"the server address, the comma separated addresses of the replicas, or a target like dns:///host:port")
+	output := flags.String("output", "table", "the output format: json or table")
+	timeout := flags.Duration("timeout", 30*time.Second, "the timeout of the command")
 
-	err = stream.Send(req)
-	if err != nil {
-		log.Fatal("cannot send image info to server: ", err, stream.RecvMsg(nil))
+	if err := parseFlags(flags, args); err != nil {
+		return err
 	}
-
-	reader := bufio.NewReader(file)
-	buffer := make([]byte, 1024)
-
-	for {
-		n, err := reader.Read(buffer)
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			log.Fatal("cannot read chunk to buffer: ", err)
-		}
-
-		req := &pb.UploadImageRequest{
-			Data: &pb.UploadImageRequest_ChunkData{
-				ChunkData: buffer[:n],
-			},
-		}
-
-		err = stream.Send(req)
-		if err != nil {
-			log.Fatal("cannot send chunk to server: ", err, stream.RecvMsg(nil))
-		}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return usageErrorf("missing command")
 	}
 
-	res, err := stream.CloseAndRecv()
-	if err != nil {
-		log.Fatal("cannot receive response: ", err)
+	name := flags.Arg(0)
+	cmd, ok := commands[name]
+	if !ok {
+		flags.Usage()
+		return usageErrorf("unknown command %q", name)
 	}
 
-	log.Printf("image uploaded with id: %s, size: %d", res.GetId(), res.GetSize())
-
-}
-
-func testUploadImage(client pb.LaptopServiceClient) {
-	laptop := sample.NewLaptop()
-	createLaptop(client, laptop)
-	uploadImage(client, laptop.GetId(), "tmp/laptop.jpeg")
-}
-
-func rateLaptop(
-	client pb.LaptopServiceClient,
-	laptopIDs []string,
-	scores []float64,
-) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	stream, err := client.RateLaptop(ctx)
+	out, err := newPrinter(*output, os.Stdout)
 	if err != nil {
-		return fmt.Errorf("cannot rate laptop: %v", err)
+		return err
 	}
+	defer out.Flush()
 
-	waitResponse := make(chan error)
-	// go routine to receive responses
-	go func() {
-		for {
-			res, err := stream.Recv()
-			if err == io.EOF {
-				log.Print("no more responses")
-				waitResponse <- nil
-				return
-			}
-			if err != nil {
-				waitResponse <- fmt.Errorf("cannot receive stream response: %v", err)
-				return
-			}
-
-			log.Print("received response: ", res)
-		}
-	}()
-
-	// send requests
-	for i, laptopID := range laptopIDs {
-		req := &pb.RateLaptopRequest{
-			LaptopId: laptopID,
-			Score:    scores[i],
-		}
-
-		err := stream.Send(req)
-		if err != nil {
-			return fmt.Errorf(
-				"cannot send stream request: %v - %v",
-				err,
-				stream.RecvMsg(nil),
-			)
-		}
-		log.Print("sent request: ", req)
-	}
-	err = stream.CloseSend()
+	conn, err := client.Dial(client.Target(strings.Split(*serverAddr, ",")...), grpc.WithInsecure())
 	if err != nil {
-		return fmt.Errorf("cannot close send: %v", err)
+		return fmt.Errorf("cannot dial server %s: %w", *serverAddr, err)
 	}
+	defer conn.Close()
 
-	// block here to wait the waitResponse is filled by error or nil
-	err = <-waitResponse
-	return err
-}
-
-func testRateLaptop(client pb.LaptopServiceClient) {
-	n := 3
-	laptopIDs := make([]string, n)
-
-	for i := 0; i < n; i++ {
-		laptop := sample.NewLaptop()
-		laptopIDs[i] = laptop.GetId()
-		createLaptop(client, laptop)
-	}
-
-	scores := make([]float64, n)
-	for {
-		fmt.Print("rate laptop (y/n)? ")
-		var answer string
-		_, err := fmt.Scan(&answer)
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		if strings.ToLower(answer) != "y" {
-			break
-		}
-
-		for i := 0; i < n; i++ {
-			scores[i] = sample.RandomLaptopScore()
-		}
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
 
-		err = rateLaptop(client, laptopIDs, scores)
-		if err != nil {
-			log.Fatal(err)
-		}
-	}
+	return cmd(ctx, client.NewLaptopClient(conn), out, flags.Args()[1:])
 }
 
 func main() {
-	serverAddr := flag.String("address", "", "the server address")
-	flag.Parse()
-	log.Printf("dial address: %s", *serverAddr)
-
-	conn, err := grpc.Dial(*serverAddr, grpc.WithInsecure())
+	err := run(os.Args[1:])
+	if err == flag.ErrHelp {
+		err = nil
+	}
 	if err != nil {
-		log.Fatalf("cannot dial server %s: %s", *serverAddr, err)
+		report(err)
 	}
-
-	client := pb.NewLaptopServiceClient(conn)
-	// testCreateLaptop(client)
-	// testSearchLaptop(client)
-	// testUploadImage(client)
-	testRateLaptop(client)
+	os.Exit(exitCode(err))
 }
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/hjcian/grpc-notes/money"
+	"github.com/hjcian/grpc-notes/pb"
+	"github.com/hjcian/grpc-notes/serializer"
+	"github.com/hjcian/grpc-notes/units"
+	"google.golang.org/protobuf/proto"
+)
+
+// printer writes the responses as JSON, one message per line, or as a table
+type printer struct {
+	json   bool
+	out    io.Writer
+	table  *tabwriter.Writer
+	header bool
+}
+
+func newPrinter(format string, out io.Writer) (*printer, error) {
+	switch format {
+	case "json":
+		return &printer{json: true, out: out}, nil
+	case "table":
+		return &printer{out: out, table: tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)}, nil
+	default:
+		return nil, usageErrorf("unknown output format %q, expect json or table", format)
+	}
+}
+
+// Print writes the message as JSON, or the row under the columns as a table
+func (p *printer) Print(message proto.Message, columns []string, row ...interface{}) error {
+	if p.json {
+		data, err := serializer.MarshalJSON(message)
+		if err != nil {
+			return fmt.Errorf("cannot marshal response: %w", err)
+		}
+		_, err = fmt.Fprintf(p.out, "%s\n", data)
+		return err
+	}
+
+	if !p.header {
+		p.header = true
+		fmt.Fprintln(p.table, strings.Join(columns, "\t"))
+	}
+
+	cells := make([]string, len(row))
+	for i, cell := range row {
+		cells[i] = fmt.Sprint(cell)
+	}
+	_, err := fmt.Fprintln(p.table, strings.Join(cells, "\t"))
+	return err
+}
+
+// Flush writes the table
+func (p *printer) Flush() error {
+	if p.table == nil {
+		return nil
+	}
+	return p.table.Flush()
+}
+
+var laptopColumns = []string{"ID", "BRAND", "NAME", "CPU CORES", "CPU GHZ", "RAM", "WEIGHT", "PRICE", "PRIMARY IMAGE"}
+
+// PrintLaptop prints the laptop at the price, or at its own price if price is nil
+func (p *printer) PrintLaptop(message proto.Message, laptop *pb.Laptop, price *pb.Money, primaryImageID string) error {
+	if price == nil {
+		var err error
+		if price, err = money.LaptopPrice(laptop); err != nil {
+			return err
+		}
+	}
+	var ram, weight string
+	if laptop.GetRam() != nil {
+		ram = units.FormatMemory(laptop.GetRam())
+	}
+	if w, ok := units.LaptopWeight(laptop); ok {
+		weight = fmt.Sprintf("%.2f %s", w.Value, w.Unit)
+	}
+	return p.Print(message, laptopColumns,
+		laptop.GetId(),
+		laptop.GetBrand(),
+		laptop.GetName(),
+		laptop.GetCpu().GetNumberCores(),
+		fmt.Sprintf("%.2f", laptop.GetCpu().GetMinGhz()),
+		ram,
+		weight,
+		fmt.Sprintf("%.2f %s", money.Float(price), price.GetCurrencyCode()),
+		primaryImageID,
+	)
+}
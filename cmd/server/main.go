@@ -1,39 +1,454 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/hjcian/grpc-notes/archive"
+	"github.com/hjcian/grpc-notes/gateway"
+	"github.com/hjcian/grpc-notes/logging"
+	"github.com/hjcian/grpc-notes/metrics"
+	"github.com/hjcian/grpc-notes/money"
 	"github.com/hjcian/grpc-notes/pb"
+	"github.com/hjcian/grpc-notes/raft"
+	"github.com/hjcian/grpc-notes/ratelimit"
+	"github.com/hjcian/grpc-notes/replication"
+	"github.com/hjcian/grpc-notes/sharding"
+	"github.com/hjcian/grpc-notes/tracing"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
 
 	"github.com/hjcian/grpc-notes/service"
 )
 
 func main() {
 	port := flag.Int("port", 0, "ther server port")
+	maxImageCount := flag.Int("max-images", 0, "max number of images per laptop, 0 means no limit")
+	maxImageTotalSize := flag.Int("max-image-bytes", 0, "max total image bytes per laptop, 0 means no limit")
+	maxSortedResults := flag.Int("max-sorted-results", service.DefaultMaxSortedResults, "max number of laptops found by a search sorted by price, 0 means no limit")
+	idempotencyWindow := flag.Duration("idempotency-window", 10*time.Minute, "how long the idempotency keys of create-laptop requests are remembered")
+	healthInterval := flag.Duration("health-interval", 10*time.Second, "how often the dependencies are health checked")
+	enableReflection := flag.Bool("reflection", false, "enable gRPC server reflection")
+	metricsPort := flag.Int("metrics-port", 0, "the port serving the prometheus /metrics over HTTP, 0 means disabled")
+	rateLimit := flag.String("rate-limit", "0", "the default rate limit of each client per LaptopService method, as requests per second[:burst], 0 means no limit")
+	methodRateLimits := flag.String("method-rate-limits", "", "comma separated rate limits of the methods, e.g. SearchLaptop=1:5,RateLaptop=10:20")
+	maxStreams := flag.Int("max-streams", 0, "max number of concurrent LaptopService streams of each client, 0 means no limit")
+	httpPort := flag.Int("http-port", 0, "the port serving the REST/JSON gateway, 0 means disabled")
+	enableGRPCWeb := flag.Bool("grpc-web", false, "serve gRPC-Web on the gateway port too")
+	corsOrigins := flag.String("cors-origins", "", "comma separated origins allowed to call gRPC-Web, * allows any origin")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 30*time.Second, "how long the pending RPCs can run after a shutdown signal")
+	drainDelay := flag.Duration("drain-delay", 2*time.Second, "how long the server keeps serving after reporting NOT_SERVING, so that the load balancers stop sending new requests")
+	logLevel := flag.String("log-level", "info", "the log verbosity: debug, info, warn or error")
+	logJSON := flag.Bool("log-json", false, "write the logs in JSON instead of the human readable format")
+	enableTracing := flag.Bool("tracing", false, "trace the RPCs and write the spans to the debug logs")
+	role := flag.String("role", "standalone", "the replication role of the server: standalone, leader, follower or raft")
+	leaderAddr := flag.String("leader", "", "the address of the leader followed by a follower")
+	raftID := flag.String("raft-id", "", "the address of the raft node advertised to its peers, localhost:port by default")
+	raftPeers := flag.String("raft-peers", "", "the comma separated addresses of the other raft nodes")
+	raftDir := flag.String("raft-dir", "raft", "the folder of the raft log and snapshot")
+	laptopShards := flag.String("laptop-shards", "", "the comma separated addresses of the LaptopService nodes sharing the laptops, \"local\" is the in-memory store of this server")
+	exchangeRates := flag.String("exchange-rates", "", "the JSON file of the exchange rates to search by price in any currency, e.g. {\"base\": \"USD\", \"rates\": {\"EUR\": 0.92}}, only USD is known by default")
 	flag.Parse()
-	log.Printf("start server on port %d", *port)
 
-	grpcServer := grpc.NewServer()
+	logger, err := logging.NewLogger(*logLevel, *logJSON)
+	if err != nil {
+		log.Fatalf("cannot create logger: %s", err)
+	}
+	defer logger.Sync()
+	logger.Info("start server", zap.Int("port", *port))
+
+	laptopStore := service.NewInMemoryLaptopStore(service.WithStoreLogger(logger))
+	imageStore := service.NewDiskImageStore("img", service.WithStoreLogger(logger))
+	ratingStore := service.NewInMemoryRatingStore(service.WithStoreLogger(logger))
+
+	err = imageStore.RemovePartialImages()
+	if err != nil {
+		logger.Warn("cannot remove partial images", zap.Error(err))
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+	)
+
+	serverMetrics, err := metrics.NewServerMetrics(registry)
+	if err != nil {
+		logger.Fatal("cannot register server metrics", zap.Error(err))
+	}
+
+	err = metrics.RegisterStoreGauges(registry, laptopStore, imageStore, ratingStore)
+	if err != nil {
+		logger.Fatal("cannot register store metrics", zap.Error(err))
+	}
+
+	stores := replication.Stores{Laptops: laptopStore, Images: imageStore, Ratings: ratingStore}
+	leader, follower, err := newReplicationNode(*role, *leaderAddr, stores, logger)
+	if err != nil {
+		logger.Fatal("cannot set up replication", zap.Error(err))
+	}
+
+	idempotencyStore := service.NewInMemoryIdempotencyStore(*idempotencyWindow)
+	var raftNode *raft.Node
+	catalog := raft.NewCatalog(laptopStore, ratingStore, idempotencyStore)
+	if *role == "raft" {
+		raftNode, err = newRaftNode(*raftID, *raftPeers, *raftDir, *port, catalog, logger)
+		if err != nil {
+			logger.Fatal("cannot set up raft", zap.Error(err))
+		}
+	}
+
+	limiter, err := newLimiter(*rateLimit, *methodRateLimits, *maxStreams)
+	if err != nil {
+		logger.Fatal("cannot create rate limiter", zap.Error(err))
+	}
+
+	unaryInterceptors := []grpc.UnaryServerInterceptor{
+		serverMetrics.UnaryServerInterceptor(),
+		logging.UnaryServerInterceptor(logger),
+		limiter.UnaryServerInterceptor(),
+	}
+	// the long-lived streams are canceled when the server is drained, GracefulStop would wait for them
+	drainer := service.NewDrainer(
+		"/techschool.pcbook.ReplicationService/Follow",
+		"/grpc.health.v1.Health/Watch",
+	)
+	streamInterceptors := []grpc.StreamServerInterceptor{
+		serverMetrics.StreamServerInterceptor(),
+		logging.StreamServerInterceptor(logger),
+		limiter.StreamServerInterceptor(),
+	}
+	if follower != nil {
+		unaryInterceptors = append(unaryInterceptors, replication.UnaryServerInterceptor(*leaderAddr))
+		streamInterceptors = append(streamInterceptors, replication.StreamServerInterceptor(*leaderAddr))
+	}
+	if *enableTracing {
+		tracer := tracing.NewTracer(tracing.NewLogExporter(logger))
+		unaryInterceptors = append(unaryInterceptors, tracing.UnaryServerInterceptor(tracer))
+		streamInterceptors = append(streamInterceptors, tracing.StreamServerInterceptor(tracer))
+	}
+	streamInterceptors = append(streamInterceptors, drainer.StreamServerInterceptor())
+
+	serverOptions := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(unaryInterceptors...),
+		grpc.ChainStreamInterceptor(streamInterceptors...),
+	}
+	if raftNode != nil {
+		// receive the raft messages sent by the GRPCTransport of the peers
+		serverOptions = append(serverOptions, grpc.MaxRecvMsgSize(raft.GRPCMessageSize))
+	}
+	grpcServer := grpc.NewServer(serverOptions...)
+	var serverLaptopStore service.LaptopStore = laptopStore
+	var serverImageStore service.ImageStore = imageStore
+	var serverRatingStore service.RatingStore = ratingStore
+	var serverIdempotencyStore service.IdempotencyStore = idempotencyStore
+	if leader != nil {
+		// record the writes in the log of the leader
+		serverLaptopStore = leader.LaptopStore()
+		serverImageStore = leader.ImageStore()
+		serverRatingStore = leader.RatingStore()
+		pb.RegisterReplicationServiceServer(grpcServer, leader)
+	}
+	if len(*laptopShards) > 0 {
+		if *role != "standalone" {
+			logger.Fatal("the laptops can only be sharded by a standalone server", zap.String("role", *role))
+		}
+		serverLaptopStore, err = newShardedLaptopStore(*laptopShards, laptopStore)
+		if err != nil {
+			logger.Fatal("cannot set up laptop shards", zap.Error(err))
+		}
+		logger.Info("shard the laptops", zap.String("shards", *laptopShards))
+	}
+	if raftNode != nil {
+		// commit the laptops, their idempotency keys and the ratings through the raft log, the images stay local
+		serverLaptopStore = catalog.LaptopStore(raftNode)
+		serverRatingStore = catalog.RatingStore(raftNode)
+		serverIdempotencyStore = catalog.IdempotencyStore()
+		pb.RegisterRaftServiceServer(grpcServer, raftNode)
+	}
+
+	serverOpts := []service.ServerOption{
+		service.WithImageQuota(*maxImageCount, *maxImageTotalSize),
+		service.WithMaxSortedResults(*maxSortedResults),
+		service.WithIdempotencyStore(serverIdempotencyStore),
+		service.WithLogger(logger),
+	}
+	if len(*exchangeRates) > 0 {
+		rates, err := money.LoadStaticRates(*exchangeRates)
+		if err != nil {
+			logger.Fatal("cannot load exchange rates", zap.Error(err))
+		}
+		serverOpts = append(serverOpts, service.WithExchangeRates(rates))
+		logger.Info("load exchange rates", zap.String("file", *exchangeRates))
+	}
+
 	lpServer := service.NewLaptopServer(
-		service.NewInMemoryLaptopStore(),
-		service.NewDiskImageStore("img"),
-		service.NewInMemoryRatingStore(),
+		serverLaptopStore,
+		serverImageStore,
+		serverRatingStore,
+		serverOpts...,
 	)
 	pb.RegisterLaptopServiceServer(grpcServer, lpServer)
 
+	// the archives are only imported by a standalone server, the writes of the other roles go through
+	// the leader or the raft log. A sharded server only exports its local shard.
+	archiveOpts := []archive.Option{archive.WithLogger(logger)}
+	if *role != "standalone" || len(*laptopShards) > 0 {
+		archiveOpts = append(archiveOpts, archive.WithReadOnly())
+	}
+	archiveStores := archive.Stores{Laptops: laptopStore, Images: imageStore, Ratings: ratingStore}
+	pb.RegisterArchiveServiceServer(grpcServer, archive.NewServer(archiveStores, archiveOpts...))
+
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
+
+	healthMonitor := service.NewHealthMonitor(healthServer, logger)
+	healthMonitor.Add(service.LaptopStoreHealthName, laptopStore)
+	healthMonitor.Add(service.ImageStoreHealthName, imageStore)
+	healthMonitor.Add(service.RatingStoreHealthName, ratingStore)
+	if follower != nil {
+		healthMonitor.Add(replication.FollowerHealthName, follower)
+	}
+	if raftNode != nil {
+		healthMonitor.Add(raft.NodeHealthName, raftNode)
+	}
+	healthCtx, stopHealthMonitor := context.WithCancel(context.Background())
+	go healthMonitor.Run(healthCtx, *healthInterval)
+
+	followerCtx, stopFollower := context.WithCancel(context.Background())
+	if follower != nil {
+		go func() {
+			err := follower.Run(followerCtx)
+			if err != nil {
+				logger.Fatal("cannot follow the leader", zap.String("leader", *leaderAddr), zap.Error(err))
+			}
+		}()
+	}
+
+	raftCtx, stopRaft := context.WithCancel(context.Background())
+	if raftNode != nil {
+		go func() {
+			err := raftNode.Run(raftCtx)
+			if err != nil {
+				logger.Fatal("cannot run raft node", zap.Error(err))
+			}
+		}()
+	}
+
+	if *enableReflection {
+		reflection.Register(grpcServer)
+	}
+
 	addr := fmt.Sprintf("0.0.0.0:%d", *port)
 	listener, err := net.Listen("tcp", addr)
 	if err != nil {
-		log.Fatalf("cannot listen on addr %s: %s", addr, err)
+		logger.Fatal("cannot listen", zap.String("addr", addr), zap.Error(err))
+	}
+
+	if *metricsPort > 0 {
+		go serveMetrics(*metricsPort, registry, logger)
 	}
 
-	err = grpcServer.Serve(listener)
+	go func() {
+		err := grpcServer.Serve(listener)
+		if err != nil {
+			logger.Fatal("cannot start gRPC server", zap.Error(err))
+		}
+	}()
+
+	var httpServer *http.Server
+	if *httpPort > 0 {
+		httpServer = newGatewayServer(*httpPort, listener.Addr().String(), logger)
+		if *enableGRPCWeb {
+			origins := strings.Split(*corsOrigins, ",")
+			httpServer.Handler = gateway.NewGRPCWebHandler(grpcServer, origins, httpServer.Handler)
+			logger.Info("serve gRPC-Web", zap.Strings("cors_origins", origins))
+		}
+		go func() {
+			err := httpServer.ListenAndServe()
+			if err != nil && err != http.ErrServerClosed {
+				logger.Fatal("cannot start gateway", zap.Error(err))
+			}
+		}()
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-signals
+	logger.Info("receive signal, draining the server",
+		zap.Stringer("signal", sig),
+		zap.Duration("timeout", *shutdownTimeout))
+
+	// report NOT_SERVING so that the load balancer stops sending new requests
+	stopHealthMonitor()
+	healthServer.Shutdown()
+	time.Sleep(*drainDelay)
+	drainer.Drain()
+
+	if httpServer != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+		err = httpServer.Shutdown(ctx)
+		cancel()
+		if err != nil {
+			logger.Warn("cannot shut down gateway", zap.Error(err))
+		}
+	}
+
+	if !service.StopGracefully(grpcServer, *shutdownTimeout) {
+		logger.Warn("shutdown timeout exceeded, pending RPCs are canceled")
+	}
+	stopFollower()
+	stopRaft()
+
+	err = imageStore.Close()
 	if err != nil {
-		log.Fatalf("cannot start gRPC server: %s", err)
+		logger.Warn("cannot close image store", zap.Error(err))
 	}
 
+	logger.Info("server stopped")
+}
+
+// newReplicationNode returns the leader or the follower of the replication role,
+// both are nil for a standalone server
+func newReplicationNode(
+	role string,
+	leaderAddr string,
+	stores replication.Stores,
+	logger *zap.Logger,
+) (*replication.Leader, *replication.Follower, error) {
+	switch role {
+	case "standalone", "raft":
+		return nil, nil, nil
+	case "leader":
+		logger.Info("replicate the catalog to the followers")
+		return replication.NewLeader(stores, replication.WithLogger(logger)), nil, nil
+	case "follower":
+		if len(leaderAddr) == 0 {
+			return nil, nil, fmt.Errorf("the leader address is required for a follower")
+		}
+		conn, err := grpc.Dial(leaderAddr, grpc.WithInsecure())
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot dial leader %s: %w", leaderAddr, err)
+		}
+		logger.Info("follow the leader", zap.String("leader", leaderAddr))
+		return nil, replication.NewFollower(conn, stores, replication.WithLogger(logger)), nil
+	default:
+		return nil, nil, fmt.Errorf("unknown replication role %q", role)
+	}
+}
+
+// newRaftNode returns the raft node of the catalog, it's identified by its address
+func newRaftNode(
+	id string,
+	peers string,
+	folder string,
+	port int,
+	catalog *raft.Catalog,
+	logger *zap.Logger,
+) (*raft.Node, error) {
+	if len(id) == 0 {
+		id = fmt.Sprintf("localhost:%d", port)
+	}
+	if len(peers) == 0 {
+		return nil, fmt.Errorf("the raft peers are required")
+	}
+
+	storage, err := raft.NewFileStorage(folder)
+	if err != nil {
+		return nil, err
+	}
+	transport := raft.NewGRPCTransport(grpc.WithInsecure())
+
+	logger.Info("join the raft cluster", zap.String("raft_id", id), zap.String("peers", peers))
+	return raft.NewNode(
+		id,
+		strings.Split(peers, ","),
+		catalog,
+		storage,
+		transport,
+		raft.WithLogger(logger),
+	)
+}
+
+// newShardedLaptopStore returns the store spreading the laptops over the shards,
+// the local shard is the in-memory store
+func newShardedLaptopStore(addresses string, local *service.InMemoryLaptopStore) (*sharding.LaptopStore, error) {
+	shards := make(map[string]service.LaptopStore)
+	for _, address := range strings.Split(addresses, ",") {
+		if address == "local" {
+			shards[address] = local
+			continue
+		}
+
+		conn, err := grpc.Dial(address, grpc.WithInsecure())
+		if err != nil {
+			return nil, fmt.Errorf("cannot dial shard %s: %w", address, err)
+		}
+		shards[address] = sharding.NewRemoteLaptopStore(conn)
+	}
+	return sharding.NewLaptopStore(shards), nil
+}
+
+func newLimiter(defaultLimit string, methodLimits string, maxStreams int) (*ratelimit.Limiter, error) {
+	limit, err := ratelimit.ParseLimit(defaultLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	limits, err := ratelimit.ParseMethodLimits(methodLimits)
+	if err != nil {
+		return nil, err
+	}
+
+	// the health checks and the streams between the servers aren't limited
+	return ratelimit.NewLimiter(
+		ratelimit.WithServices("techschool.pcbook.LaptopService"),
+		ratelimit.WithDefaultLimit(limit),
+		ratelimit.WithMethodLimits(limits),
+		ratelimit.WithMaxStreams(maxStreams),
+	), nil
+}
+
+// newGatewayServer returns an HTTP server translating the REST/JSON requests
+// to the gRPC server listening on grpcAddr
+func newGatewayServer(port int, grpcAddr string, logger *zap.Logger) *http.Server {
+	conn, err := grpc.Dial(grpcAddr, grpc.WithInsecure())
+	if err != nil {
+		logger.Fatal("cannot dial gRPC server", zap.String("addr", grpcAddr), zap.Error(err))
+	}
+
+	addr := fmt.Sprintf("0.0.0.0:%d", port)
+	logger.Info("serve gateway", zap.String("addr", addr))
+
+	return &http.Server{
+		Addr:    addr,
+		Handler: gateway.NewGateway(pb.NewLaptopServiceClient(conn)),
+	}
+}
+
+func serveMetrics(port int, registry *prometheus.Registry, logger *zap.Logger) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+
+	addr := fmt.Sprintf("0.0.0.0:%d", port)
+	logger.Info("serve metrics", zap.String("addr", addr), zap.String("path", "/metrics"))
+
+	err := http.ListenAndServe(addr, mux)
+	if err != nil {
+		logger.Fatal("cannot serve metrics", zap.Error(err))
+	}
 }
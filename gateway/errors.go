@@ -0,0 +1,66 @@
+package gateway
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/hjcian/grpc-notes/rpcerror"
+	"github.com/hjcian/grpc-notes/serializer"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// HTTPStatusFromCode returns the HTTP status matching the gRPC status code
+func HTTPStatusFromCode(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		return 499 // client closed request
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// writeError writes the gRPC error as a JSON google.rpc.Status, including its details,
+// with the matching HTTP status
+func writeError(w http.ResponseWriter, err error) {
+	writeErrorWithStatus(w, HTTPStatusFromCode(status.Code(err)), err)
+}
+
+func writeErrorWithStatus(w http.ResponseWriter, httpStatus int, err error) {
+	if delay, ok := rpcerror.RetryDelay(err); ok {
+		seconds := int(math.Ceil(delay.Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	}
+
+	data, marshalErr := serializer.MarshalJSON(status.Convert(err).Proto())
+	if marshalErr != nil {
+		http.Error(w, status.Convert(err).Message(), httpStatus)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpStatus)
+	w.Write(data)
+	w.Write([]byte("\n"))
+}
@@ -0,0 +1,472 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/hjcian/grpc-notes/logging"
+	"github.com/hjcian/grpc-notes/money"
+	"github.com/hjcian/grpc-notes/pb"
+	"github.com/hjcian/grpc-notes/ratelimit"
+	"github.com/hjcian/grpc-notes/rpcerror"
+	"github.com/hjcian/grpc-notes/serializer"
+	"github.com/hjcian/grpc-notes/service"
+	"github.com/hjcian/grpc-notes/tracing"
+	"github.com/hjcian/grpc-notes/units"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// maxRequestSize is the limit of the JSON request bodies
+const maxRequestSize = 1 << 20
+
+// forwardedHeaders are the HTTP headers passed to the gRPC server as metadata
+var forwardedHeaders = []string{
+	logging.RequestIDHeader,
+	tracing.TraceParentHeader,
+	service.IdempotencyKeyHeader,
+}
+
+// Gateway is an HTTP/JSON front end translating the requests to LaptopService RPCs:
+//
+//	POST /v1/laptops                 CreateLaptop, the body is a JSON CreateLaptopRequest
+//	GET  /v1/laptops/search          SearchLaptop, the query parameters are the fields of the request
+//	GET  /v1/laptops/{id}            GetLaptop
+//	POST /v1/laptops/{id}/images     UploadImage, the body is a multipart form with an "image" file
+//
+// The search results are streamed as newline delimited JSON, or as server-sent events
+// if the client accepts text/event-stream.
+type Gateway struct {
+	client pb.LaptopServiceClient
+	mux    *http.ServeMux
+}
+
+// NewGateway returns a new Gateway calling the laptop service through client
+func NewGateway(client pb.LaptopServiceClient) *Gateway {
+	gateway := &Gateway{
+		client: client,
+		mux:    http.NewServeMux(),
+	}
+
+	gateway.mux.HandleFunc("/v1/laptops", gateway.handleLaptops)
+	gateway.mux.HandleFunc("/v1/laptops/search", gateway.handleSearch)
+	gateway.mux.HandleFunc("/v1/laptops/", gateway.handleLaptop)
+
+	return gateway
+}
+
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.mux.ServeHTTP(w, r)
+}
+
+// outgoingContext returns the request context with the forwarded headers and the client address
+// as metadata, so that the clients of the gateway don't share the rate limits of its address
+func outgoingContext(r *http.Request) context.Context {
+	ctx := r.Context()
+	for _, key := range forwardedHeaders {
+		if value := r.Header.Get(key); len(value) > 0 {
+			ctx = metadata.AppendToOutgoingContext(ctx, key, value)
+		}
+	}
+	return metadata.AppendToOutgoingContext(ctx, ratelimit.ClientAddressHeader, clientAddress(r))
+}
+
+// clientAddress returns the host of the address of the HTTP client
+func clientAddress(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// writeResponseHeader copies the request ID returned by the gRPC server to the HTTP response
+func writeResponseHeader(w http.ResponseWriter, header metadata.MD) {
+	if values := header.Get(logging.RequestIDHeader); len(values) > 0 {
+		w.Header().Set(logging.RequestIDHeader, values[0])
+	}
+}
+
+func writeMessage(w http.ResponseWriter, httpStatus int, message proto.Message) {
+	data, err := serializer.MarshalJSON(message)
+	if err != nil {
+		writeError(w, status.Errorf(codes.Internal, "cannot marshal response: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpStatus)
+	w.Write(data)
+	w.Write([]byte("\n"))
+}
+
+func methodNotAllowed(w http.ResponseWriter, r *http.Request, allowed string) bool {
+	if r.Method == allowed {
+		return false
+	}
+
+	w.Header().Set("Allow", allowed)
+	writeErrorWithStatus(w, http.StatusMethodNotAllowed, status.Errorf(
+		codes.Unimplemented, "method %s is not allowed on %s", r.Method, r.URL.Path,
+	))
+	return true
+}
+
+// handleLaptops creates a laptop
+func (g *Gateway) handleLaptops(w http.ResponseWriter, r *http.Request) {
+	if methodNotAllowed(w, r, http.MethodPost) {
+		return
+	}
+
+	data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestSize))
+	if err != nil {
+		writeError(w, status.Errorf(codes.InvalidArgument, "cannot read request body: %v", err))
+		return
+	}
+
+	req := &pb.CreateLaptopRequest{}
+	err = serializer.UnmarshalJSON(data, req)
+	if err != nil {
+		writeError(w, status.Errorf(codes.InvalidArgument, "cannot parse request body: %v", err))
+		return
+	}
+
+	var header metadata.MD
+	res, err := g.client.CreateLaptop(outgoingContext(r), req, grpc.Header(&header))
+	writeResponseHeader(w, header)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeMessage(w, http.StatusCreated, res)
+}
+
+// ParseFilter converts the query parameters to a filter, the parameters are named after
+// the fields of the filter, e.g. max_price_usd=2000&min_ram.value=8&min_ram.unit=GIGABYTE.
+// The min RAM may also be given as a size, e.g. min_ram=8GiB, and the max price is a money,
+// e.g. max_price=1500 EUR. Without max_price_usd, the legacy max price doesn't limit the laptops.
+func ParseFilter(query map[string][]string) (*pb.Filter, error) {
+	filter := &pb.Filter{MaxPriceUsd: math.MaxFloat64}
+	var err error
+
+	get := func(key string) string {
+		if values := query[key]; len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+	invalid := func(field string, err error) error {
+		return rpcerror.New(
+			codes.InvalidArgument,
+			fmt.Sprintf("invalid query parameter %s: %v", field, err),
+			rpcerror.BadRequest(field, err.Error()),
+		)
+	}
+
+	if value := get("max_price_usd"); len(value) > 0 {
+		if filter.MaxPriceUsd, err = strconv.ParseFloat(value, 64); err != nil {
+			return nil, invalid("max_price_usd", err)
+		}
+	}
+	if value := get("max_price"); len(value) > 0 {
+		if filter.MaxPrice, err = money.Parse(value); err != nil {
+			return nil, invalid("max_price", err)
+		}
+	}
+	if value := get("min_cpu_cores"); len(value) > 0 {
+		cores, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return nil, invalid("min_cpu_cores", err)
+		}
+		filter.MinCpuCores = uint32(cores)
+	}
+	if value := get("min_cpu_ghz"); len(value) > 0 {
+		if filter.MinCpuGhz, err = strconv.ParseFloat(value, 64); err != nil {
+			return nil, invalid("min_cpu_ghz", err)
+		}
+	}
+
+	if value := get("min_ram"); len(value) > 0 {
+		if filter.MinRam, err = units.ParseMemory(value); err != nil {
+			return nil, invalid("min_ram", err)
+		}
+	}
+
+	ramValue, ramUnit := get("min_ram.value"), get("min_ram.unit")
+	if len(ramValue) > 0 || len(ramUnit) > 0 {
+		if filter.MinRam != nil {
+			return nil, invalid("min_ram", fmt.Errorf("cannot be given with min_ram.value or min_ram.unit"))
+		}
+		filter.MinRam = &pb.Memory{}
+		if len(ramValue) > 0 {
+			if filter.MinRam.Value, err = strconv.ParseUint(ramValue, 10, 64); err != nil {
+				return nil, invalid("min_ram.value", err)
+			}
+		}
+		if len(ramUnit) > 0 {
+			unit, ok := pb.Memory_Unit_value[strings.ToUpper(ramUnit)]
+			if !ok {
+				return nil, invalid("min_ram.unit", fmt.Errorf("unknown unit %q", ramUnit))
+			}
+			filter.MinRam.Unit = pb.Memory_Unit(unit)
+		}
+	}
+
+	return filter, nil
+}
+
+// ParseSearchRequest converts the query parameters to a search request, they are the parameters
+// of the filter, the currency_code and the sort, e.g. max_price=1500 EUR&currency_code=EUR&sort=PRICE_ASCENDING
+func ParseSearchRequest(query map[string][]string) (*pb.SearchLaptopRequest, error) {
+	filter, err := ParseFilter(query)
+	if err != nil {
+		return nil, err
+	}
+	req := &pb.SearchLaptopRequest{Filter: filter}
+
+	if values := query["currency_code"]; len(values) > 0 {
+		req.CurrencyCode = strings.ToUpper(values[0])
+	}
+	if values := query["sort"]; len(values) > 0 && len(values[0]) > 0 {
+		sort, ok := pb.SearchLaptopRequest_Sort_value[strings.ToUpper(values[0])]
+		if !ok {
+			return nil, rpcerror.New(
+				codes.InvalidArgument,
+				fmt.Sprintf("invalid query parameter sort: unknown sort %q", values[0]),
+				rpcerror.BadRequest("sort", "must be NONE, PRICE_ASCENDING or PRICE_DESCENDING"),
+			)
+		}
+		req.Sort = pb.SearchLaptopRequest_Sort(sort)
+	}
+
+	return req, nil
+}
+
+// searchWriter writes the search results as NDJSON or server-sent events
+type searchWriter struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	sse     bool
+}
+
+func (sw *searchWriter) writeHeader() {
+	if sw.sse {
+		sw.w.Header().Set("Content-Type", "text/event-stream")
+		sw.w.Header().Set("Cache-Control", "no-cache")
+	} else {
+		sw.w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	sw.w.WriteHeader(http.StatusOK)
+}
+
+func (sw *searchWriter) write(event string, message proto.Message) error {
+	data, err := serializer.MarshalJSON(message)
+	if err != nil {
+		return err
+	}
+
+	if sw.sse {
+		_, err = fmt.Fprintf(sw.w, "event: %s\ndata: %s\n\n", event, data)
+	} else if event == "error" {
+		_, err = fmt.Fprintf(sw.w, "{\"error\":%s}\n", data)
+	} else {
+		_, err = fmt.Fprintf(sw.w, "%s\n", data)
+	}
+	if err != nil {
+		return err
+	}
+
+	if sw.flusher != nil {
+		sw.flusher.Flush()
+	}
+	return nil
+}
+
+// handleSearch streams the laptops matching the search request
+func (g *Gateway) handleSearch(w http.ResponseWriter, r *http.Request) {
+	if methodNotAllowed(w, r, http.MethodGet) {
+		return
+	}
+
+	req, err := ParseSearchRequest(r.URL.Query())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	stream, err := g.client.SearchLaptop(outgoingContext(r), req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// wait for the first result so that an invalid request gets a proper HTTP status
+	res, err := stream.Recv()
+	if header, headerErr := stream.Header(); headerErr == nil {
+		writeResponseHeader(w, header)
+	}
+	if err != nil && err != io.EOF {
+		writeError(w, err)
+		return
+	}
+
+	flusher, _ := w.(http.Flusher)
+	sw := &searchWriter{
+		w:       w,
+		flusher: flusher,
+		sse:     strings.Contains(r.Header.Get("Accept"), "text/event-stream"),
+	}
+	sw.writeHeader()
+
+	for err == nil {
+		if writeErr := sw.write("laptop", res); writeErr != nil {
+			return
+		}
+		res, err = stream.Recv()
+	}
+	if err != io.EOF {
+		sw.write("error", status.Convert(err).Proto())
+	}
+}
+
+// imageChunkSize is the size of the chunks sent to UploadImage
+const imageChunkSize = 1024
+
+// handleLaptop gets a laptop, or uploads an image of a laptop
+func (g *Gateway) handleLaptop(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/v1/laptops/"), "/")
+	switch {
+	case len(parts) == 1 && len(parts[0]) > 0:
+		g.handleGet(w, r, parts[0])
+	case len(parts) == 2 && len(parts[0]) > 0 && parts[1] == "images":
+		g.handleImages(w, r, parts[0])
+	default:
+		writeError(w, status.Errorf(codes.NotFound, "path %s is not found", r.URL.Path))
+	}
+}
+
+// handleGet gets a laptop by ID
+func (g *Gateway) handleGet(w http.ResponseWriter, r *http.Request, laptopID string) {
+	if methodNotAllowed(w, r, http.MethodGet) {
+		return
+	}
+
+	var header metadata.MD
+	res, err := g.client.GetLaptop(outgoingContext(r), &pb.GetLaptopRequest{Id: laptopID}, grpc.Header(&header))
+	writeResponseHeader(w, header)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeMessage(w, http.StatusOK, res)
+}
+
+// handleImages uploads an image of a laptop from a multipart form
+func (g *Gateway) handleImages(w http.ResponseWriter, r *http.Request, laptopID string) {
+	if methodNotAllowed(w, r, http.MethodPost) {
+		return
+	}
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		writeError(w, status.Errorf(codes.InvalidArgument, "cannot read multipart form: %v", err))
+		return
+	}
+
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			writeError(w, rpcerror.New(
+				codes.InvalidArgument,
+				"multipart form has no image",
+				rpcerror.BadRequest("image", "image file is required"),
+			))
+			return
+		}
+		if err != nil {
+			writeError(w, status.Errorf(codes.InvalidArgument, "cannot read multipart form: %v", err))
+			return
+		}
+
+		if part.FormName() == "image" {
+			g.uploadImage(w, r, laptopID, part.FileName(), part)
+			return
+		}
+	}
+}
+
+func (g *Gateway) uploadImage(
+	w http.ResponseWriter,
+	r *http.Request,
+	laptopID string,
+	filename string,
+	image io.Reader,
+) {
+	imageType := ""
+	if i := strings.LastIndex(filename, "."); i >= 0 {
+		imageType = filename[i:]
+	}
+	if len(imageType) == 0 {
+		writeError(w, rpcerror.New(
+			codes.InvalidArgument,
+			fmt.Sprintf("cannot tell the image type of file %q", filename),
+			rpcerror.BadRequest("image", "file name must have an extension"),
+		))
+		return
+	}
+
+	stream, err := g.client.UploadImage(outgoingContext(r))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	err = stream.Send(&pb.UploadImageRequest{
+		Data: &pb.UploadImageRequest_Info{
+			Info: &pb.ImageInfo{
+				LaptopId:  laptopID,
+				ImageType: imageType,
+			},
+		},
+	})
+
+	buffer := make([]byte, imageChunkSize)
+	for err == nil {
+		n, readErr := image.Read(buffer)
+		if n > 0 {
+			err = stream.Send(&pb.UploadImageRequest{
+				Data: &pb.UploadImageRequest_ChunkData{ChunkData: buffer[:n]},
+			})
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			writeError(w, status.Errorf(codes.InvalidArgument, "cannot read image: %v", readErr))
+			return
+		}
+	}
+	// a failed send means the server has returned, its error is received by CloseAndRecv
+
+	res, err := stream.CloseAndRecv()
+	if header, headerErr := stream.Header(); headerErr == nil {
+		writeResponseHeader(w, header)
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeMessage(w, http.StatusCreated, res)
+}
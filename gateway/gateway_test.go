@@ -0,0 +1,352 @@
+package gateway_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hjcian/grpc-notes/gateway"
+	"github.com/hjcian/grpc-notes/pb"
+	"github.com/hjcian/grpc-notes/ratelimit"
+	"github.com/hjcian/grpc-notes/sample"
+	"github.com/hjcian/grpc-notes/serializer"
+	"github.com/hjcian/grpc-notes/service"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+func startTestGateway(t *testing.T) *httptest.Server {
+	grpcServer := grpc.NewServer()
+	laptopServer := service.NewLaptopServer(
+		service.NewInMemoryLaptopStore(),
+		service.NewDiskImageStore(t.TempDir()),
+		service.NewInMemoryRatingStore(),
+	)
+	pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
+
+	listener, err := net.Listen("tcp", ":0")
+	require.NoError(t, err)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithInsecure())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	server := httptest.NewServer(gateway.NewGateway(pb.NewLaptopServiceClient(conn)))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func createLaptop(t *testing.T, server *httptest.Server, laptop *pb.Laptop) *http.Response {
+	body, err := serializer.MarshalJSON(&pb.CreateLaptopRequest{Laptop: laptop})
+	require.NoError(t, err)
+
+	res, err := http.Post(server.URL+"/v1/laptops", "application/json", bytes.NewReader(body))
+	require.NoError(t, err)
+	return res
+}
+
+type errorBody struct {
+	Code    int
+	Message string
+	Details []map[string]interface{}
+}
+
+func readError(t *testing.T, res *http.Response) errorBody {
+	defer res.Body.Close()
+	require.Equal(t, "application/json", res.Header.Get("Content-Type"))
+
+	var body errorBody
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&body))
+	return body
+}
+
+func TestGatewayCreateLaptop(t *testing.T) {
+	t.Parallel()
+
+	server := startTestGateway(t)
+
+	laptop := sample.NewLaptop()
+	res := createLaptop(t, server, laptop)
+	require.Equal(t, http.StatusCreated, res.StatusCode)
+
+	created := &pb.CreateLaptopResponse{}
+	data, err := ioutil.ReadAll(res.Body)
+	require.NoError(t, err)
+	require.NoError(t, serializer.UnmarshalJSON(data, created))
+	require.Equal(t, laptop.Id, created.Id)
+
+	res = createLaptop(t, server, laptop)
+	require.Equal(t, http.StatusConflict, res.StatusCode)
+	body := readError(t, res)
+	require.Equal(t, 6, body.Code) // ALREADY_EXISTS
+	require.Len(t, body.Details, 1)
+	require.Equal(t, "type.googleapis.com/google.rpc.ResourceInfo", body.Details[0]["@type"])
+
+	laptop = sample.NewLaptop()
+	laptop.Cpu.NumberCores = 0
+	res = createLaptop(t, server, laptop)
+	require.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	res, err = http.Get(server.URL + "/v1/laptops/" + created.Id)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	found := &pb.GetLaptopResponse{}
+	data, err = ioutil.ReadAll(res.Body)
+	require.NoError(t, err)
+	require.NoError(t, serializer.UnmarshalJSON(data, found))
+	require.Equal(t, created.Id, found.Laptop.Id)
+
+	res, err = http.Get(server.URL + "/v1/laptops/unknown")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNotFound, res.StatusCode)
+
+	res, err = http.Post(server.URL+"/v1/laptops", "application/json", strings.NewReader("{"))
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	res, err = http.Get(server.URL + "/v1/laptops")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusMethodNotAllowed, res.StatusCode)
+	require.Equal(t, http.MethodPost, res.Header.Get("Allow"))
+}
+
+func TestGatewayForwardsClientAddress(t *testing.T) {
+	t.Parallel()
+
+	identities := make(chan string, 1)
+	grpcServer := grpc.NewServer(grpc.UnaryInterceptor(func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		identities <- ratelimit.PeerIdentity(ctx)
+		return handler(ctx, req)
+	}))
+	laptopStore := service.NewInMemoryLaptopStore()
+	pb.RegisterLaptopServiceServer(grpcServer, service.NewLaptopServer(laptopStore, nil, nil))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go grpcServer.Serve(listener)
+	defer grpcServer.Stop()
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	laptop := sample.NewLaptop()
+	require.NoError(t, laptopStore.Save(laptop))
+
+	// the clients of the gateway are identified by their own address rather than the gateway's one
+	req := httptest.NewRequest(http.MethodGet, "/v1/laptops/"+laptop.GetId(), nil)
+	req.RemoteAddr = "203.0.113.7:40000"
+	req.Header.Set(ratelimit.ClientAddressHeader, "10.0.0.1")
+	res := httptest.NewRecorder()
+	gateway.NewGateway(pb.NewLaptopServiceClient(conn)).ServeHTTP(res, req)
+	require.Equal(t, http.StatusOK, res.Code)
+	require.Equal(t, "203.0.113.7", <-identities)
+}
+
+func TestParseFilter(t *testing.T) {
+	t.Parallel()
+
+	filter, err := gateway.ParseFilter(map[string][]string{
+		"max_price_usd": {"2000"},
+		"min_cpu_cores": {"4"},
+		"min_cpu_ghz":   {"2.5"},
+		"min_ram.value": {"8"},
+		"min_ram.unit":  {"gigabyte"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, 2000.0, filter.MaxPriceUsd)
+	require.Equal(t, uint32(4), filter.MinCpuCores)
+	require.Equal(t, 2.5, filter.MinCpuGhz)
+	require.Equal(t, uint64(8), filter.MinRam.Value)
+	require.Equal(t, pb.Memory_GIGABYTE, filter.MinRam.Unit)
+
+	filter, err = gateway.ParseFilter(map[string][]string{"min_ram": {"1.5TB"}})
+	require.NoError(t, err)
+	require.Equal(t, uint64(1536), filter.MinRam.Value)
+	require.Equal(t, pb.Memory_GIGABYTE, filter.MinRam.Unit)
+
+	for key, value := range map[string]string{
+		"max_price_usd": "cheap",
+		"min_cpu_cores": "-1",
+		"min_ram.unit":  "PETABYTE",
+		"min_ram":       "8 PiB",
+	} {
+		_, err := gateway.ParseFilter(map[string][]string{key: {value}})
+		require.Error(t, err, key)
+	}
+
+	_, err = gateway.ParseFilter(map[string][]string{"min_ram": {"8GiB"}, "min_ram.value": {"8"}})
+	require.Error(t, err)
+}
+
+func TestParseSearchRequest(t *testing.T) {
+	t.Parallel()
+
+	req, err := gateway.ParseSearchRequest(map[string][]string{
+		"max_price":     {"1500.50 EUR"},
+		"currency_code": {"gbp"},
+		"sort":          {"price_descending"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, &pb.Money{CurrencyCode: "EUR", Units: 1500, Nanos: 500000000}, req.Filter.MaxPrice)
+	require.Equal(t, "GBP", req.CurrencyCode)
+	require.Equal(t, pb.SearchLaptopRequest_PRICE_DESCENDING, req.Sort)
+
+	req, err = gateway.ParseSearchRequest(map[string][]string{})
+	require.NoError(t, err)
+	require.Equal(t, pb.SearchLaptopRequest_NONE, req.Sort)
+
+	for key, value := range map[string]string{
+		"max_price": "1500",
+		"sort":      "cheapest",
+	} {
+		_, err := gateway.ParseSearchRequest(map[string][]string{key: {value}})
+		require.Error(t, err, key)
+	}
+}
+
+func TestGatewaySearchLaptop(t *testing.T) {
+	t.Parallel()
+
+	server := startTestGateway(t)
+
+	for i := 0; i < 3; i++ {
+		laptop := sample.NewLaptop()
+		laptop.PriceUsd = 1000
+		res := createLaptop(t, server, laptop)
+		require.Equal(t, http.StatusCreated, res.StatusCode)
+	}
+
+	url := server.URL + "/v1/laptops/search?max_price_usd=1500"
+
+	// NDJSON
+	res, err := http.Get(url)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Equal(t, "application/x-ndjson", res.Header.Get("Content-Type"))
+
+	lines := 0
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		found := &pb.SearchLaptopResponse{}
+		require.NoError(t, serializer.UnmarshalJSON(scanner.Bytes(), found))
+		require.Equal(t, 1000.0, found.Laptop.PriceUsd)
+		require.True(t, strings.Contains(scanner.Text(), `"price_usd"`))
+		lines++
+	}
+	require.Equal(t, 3, lines)
+
+	// server-sent events
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	require.NoError(t, err)
+	req.Header.Set("Accept", "text/event-stream")
+	res, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+
+	data, err := ioutil.ReadAll(res.Body)
+	require.NoError(t, err)
+	require.Equal(t, 3, strings.Count(string(data), "event: laptop\ndata: {"))
+
+	// no max price means no limit
+	expensive := sample.NewLaptop()
+	expensive.PriceUsd = 3000
+	res = createLaptop(t, server, expensive)
+	require.Equal(t, http.StatusCreated, res.StatusCode)
+
+	res, err = http.Get(server.URL + "/v1/laptops/search")
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	lines = 0
+	scanner = bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		lines++
+	}
+	require.Equal(t, 4, lines)
+
+	// invalid filter
+	res, err = http.Get(server.URL + "/v1/laptops/search?max_price_usd=-1")
+	require.NoError(t, err)
+	require.Equal(t, http.StatusBadRequest, res.StatusCode)
+	body := readError(t, res)
+	require.Equal(t, "type.googleapis.com/google.rpc.BadRequest", body.Details[0]["@type"])
+}
+
+func uploadImage(t *testing.T, server *httptest.Server, laptopID, filename string, image []byte) *http.Response {
+	body := &bytes.Buffer{}
+	form := multipart.NewWriter(body)
+	part, err := form.CreateFormFile("image", filename)
+	require.NoError(t, err)
+	_, err = part.Write(image)
+	require.NoError(t, err)
+	require.NoError(t, form.Close())
+
+	res, err := http.Post(server.URL+"/v1/laptops/"+laptopID+"/images", form.FormDataContentType(), body)
+	require.NoError(t, err)
+	return res
+}
+
+func TestGatewayUploadImage(t *testing.T) {
+	t.Parallel()
+
+	server := startTestGateway(t)
+
+	laptop := sample.NewLaptop()
+	res := createLaptop(t, server, laptop)
+	require.Equal(t, http.StatusCreated, res.StatusCode)
+
+	image := bytes.Repeat([]byte{1}, 2500)
+	res = uploadImage(t, server, laptop.Id, "laptop.jpg", image)
+	require.Equal(t, http.StatusCreated, res.StatusCode)
+
+	uploaded := &pb.UploadImageResponse{}
+	data, err := ioutil.ReadAll(res.Body)
+	require.NoError(t, err)
+	require.NoError(t, serializer.UnmarshalJSON(data, uploaded))
+	require.NotEmpty(t, uploaded.Id)
+	require.Equal(t, uint32(len(image)), uploaded.Size)
+
+	res = uploadImage(t, server, "unknown", "laptop.jpg", image)
+	require.Equal(t, http.StatusBadRequest, res.StatusCode)
+	body := readError(t, res)
+	require.Equal(t, "type.googleapis.com/google.rpc.ResourceInfo", body.Details[0]["@type"])
+
+	res = uploadImage(t, server, laptop.Id, "laptop", image)
+	require.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	res, err = http.Post(server.URL+"/v1/laptops/"+laptop.Id+"/photos", "image/jpeg", nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNotFound, res.StatusCode)
+}
+
+func TestHTTPStatusFromCode(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(t, http.StatusOK, gateway.HTTPStatusFromCode(codes.OK))
+	require.Equal(t, http.StatusBadRequest, gateway.HTTPStatusFromCode(codes.InvalidArgument))
+	require.Equal(t, http.StatusNotFound, gateway.HTTPStatusFromCode(codes.NotFound))
+	require.Equal(t, http.StatusConflict, gateway.HTTPStatusFromCode(codes.AlreadyExists))
+	require.Equal(t, http.StatusTooManyRequests, gateway.HTTPStatusFromCode(codes.ResourceExhausted))
+	require.Equal(t, http.StatusInternalServerError, gateway.HTTPStatusFromCode(codes.DataLoss))
+}
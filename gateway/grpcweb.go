@@ -0,0 +1,44 @@
+package gateway
+
+import (
+	"net/http"
+
+	"github.com/hjcian/grpc-notes/ratelimit"
+	"github.com/improbable-eng/grpc-web/go/grpcweb"
+	"google.golang.org/grpc"
+)
+
+// grpcWebRequestHeaders are the headers sent by the gRPC-Web clients
+var grpcWebRequestHeaders = []string{"content-type", "x-grpc-web", "x-user-agent", "grpc-timeout"}
+
+// NewGRPCWebHandler returns an HTTP handler serving the gRPC-Web requests, and their CORS
+// preflight requests, with the gRPC server, the other requests are passed to next.
+// The response headers, e.g. the request ID, are exposed to the browsers.
+// Only the browsers on the allowed origins can call the server, "*" allows any origin.
+func NewGRPCWebHandler(server *grpc.Server, allowedOrigins []string, next http.Handler) http.Handler {
+	origins := make(map[string]bool, len(allowedOrigins))
+	for _, origin := range allowedOrigins {
+		if len(origin) > 0 {
+			origins[origin] = true
+		}
+	}
+
+	wrapped := grpcweb.WrapServer(
+		server,
+		grpcweb.WithOriginFunc(func(origin string) bool {
+			return origins["*"] || origins[origin]
+		}),
+		grpcweb.WithAllowedRequestHeaders(append(grpcWebRequestHeaders, forwardedHeaders...)),
+		grpcweb.WithCorsForRegisteredEndpointsOnly(true),
+	)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if wrapped.IsGrpcWebRequest(r) || wrapped.IsAcceptableGrpcCorsRequest(r) {
+			// the browsers can't choose the address they are identified by
+			r.Header.Set(ratelimit.ClientAddressHeader, clientAddress(r))
+			wrapped.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
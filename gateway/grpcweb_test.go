@@ -0,0 +1,136 @@
+package gateway_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hjcian/grpc-notes/gateway"
+	"github.com/hjcian/grpc-notes/pb"
+	"github.com/hjcian/grpc-notes/sample"
+	"github.com/hjcian/grpc-notes/service"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+)
+
+const searchLaptopPath = "/techschool.pcbook.LaptopService/SearchLaptop"
+
+func startTestGRPCWeb(t *testing.T, origins ...string) (*httptest.Server, service.LaptopStore) {
+	laptopStore := service.NewInMemoryLaptopStore()
+	grpcServer := grpc.NewServer()
+	laptopServer := service.NewLaptopServer(
+		laptopStore,
+		service.NewDiskImageStore(t.TempDir()),
+		service.NewInMemoryRatingStore(),
+	)
+	pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	server := httptest.NewServer(gateway.NewGRPCWebHandler(grpcServer, origins, next))
+	t.Cleanup(server.Close)
+
+	return server, laptopStore
+}
+
+// grpcWebFrame encodes a message as a gRPC-Web data frame
+func grpcWebFrame(t *testing.T, message proto.Message) []byte {
+	data, err := proto.Marshal(message)
+	require.NoError(t, err)
+
+	frame := make([]byte, 5, 5+len(data))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(data)))
+	return append(frame, data...)
+}
+
+// readGRPCWebFrames decodes the data frames and the trailers of a gRPC-Web response
+func readGRPCWebFrames(t *testing.T, body io.Reader) ([][]byte, string) {
+	var messages [][]byte
+	for {
+		header := make([]byte, 5)
+		_, err := io.ReadFull(body, header)
+		require.NoError(t, err)
+
+		data := make([]byte, binary.BigEndian.Uint32(header[1:]))
+		_, err = io.ReadFull(body, data)
+		require.NoError(t, err)
+
+		if header[0]&0x80 != 0 {
+			return messages, string(data)
+		}
+		messages = append(messages, data)
+	}
+}
+
+func TestGRPCWebSearchLaptop(t *testing.T) {
+	t.Parallel()
+
+	server, laptopStore := startTestGRPCWeb(t, "https://admin.example.com")
+	for i := 0; i < 3; i++ {
+		laptop := sample.NewLaptop()
+		laptop.PriceUsd = 1000
+		require.NoError(t, laptopStore.Save(laptop))
+	}
+
+	body := grpcWebFrame(t, &pb.SearchLaptopRequest{Filter: &pb.Filter{MaxPriceUsd: 1500}})
+	req, err := http.NewRequest(http.MethodPost, server.URL+searchLaptopPath, bytes.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/grpc-web+proto")
+	req.Header.Set("Origin", "https://admin.example.com")
+
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Equal(t, "https://admin.example.com", res.Header.Get("Access-Control-Allow-Origin"))
+
+	messages, trailers := readGRPCWebFrames(t, res.Body)
+	require.Len(t, messages, 3)
+	for _, data := range messages {
+		found := &pb.SearchLaptopResponse{}
+		require.NoError(t, proto.Unmarshal(data, found))
+		require.Equal(t, 1000.0, found.Laptop.PriceUsd)
+	}
+	require.Contains(t, trailers, "grpc-status: 0")
+}
+
+func TestGRPCWebCORS(t *testing.T) {
+	t.Parallel()
+
+	server, _ := startTestGRPCWeb(t, "https://admin.example.com")
+
+	preflight := func(origin string) *http.Response {
+		req, err := http.NewRequest(http.MethodOptions, server.URL+searchLaptopPath, nil)
+		require.NoError(t, err)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		req.Header.Set("Access-Control-Request-Headers", "content-type,x-grpc-web,x-request-id")
+
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		res.Body.Close()
+		return res
+	}
+
+	res := preflight("https://admin.example.com")
+	require.Equal(t, "https://admin.example.com", res.Header.Get("Access-Control-Allow-Origin"))
+	require.Contains(t, strings.ToLower(res.Header.Get("Access-Control-Allow-Headers")), "x-request-id")
+
+	res = preflight("https://evil.example.com")
+	require.Empty(t, res.Header.Get("Access-Control-Allow-Origin"))
+
+	// the other requests are passed to the next handler
+	res, err := http.Get(server.URL + "/v1/laptops/search")
+	require.NoError(t, err)
+	_, err = ioutil.ReadAll(res.Body)
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusTeapot, res.StatusCode)
+}
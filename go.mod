@@ -3,11 +3,19 @@ module github.com/hjcian/grpc-notes
 go 1.15
 
 require (
+	github.com/desertbit/timer v1.0.1 // indirect
 	github.com/golang/protobuf v1.4.3
 	github.com/google/uuid v1.1.2
+	github.com/improbable-eng/grpc-web v0.13.0
 	github.com/jinzhu/copier v0.1.0
-	github.com/stretchr/testify v1.6.1
+	github.com/klauspost/compress v1.13.6
+	github.com/prometheus/client_golang v1.9.0
+	github.com/rs/cors v1.11.1 // indirect
+	github.com/stretchr/testify v1.7.0
 	gitlab.com/techschool/pcbook v0.0.0-20200530140618-343f6ae8e43a
-	google.golang.org/grpc v1.29.1
+	go.uber.org/zap v1.16.0
+	google.golang.org/genproto v0.0.0-20200528191852-705c0b31589b
+	google.golang.org/grpc v1.42.0
 	google.golang.org/protobuf v1.25.0
+	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c
 )
@@ -0,0 +1,195 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// RequestIDHeader is the metadata key carrying the request ID,
+// it's generated by the server if the client doesn't send one
+const RequestIDHeader = "x-request-id"
+
+// NewLogger returns a logger writing to stderr at the given level ("debug", "info", "warn" or "error"),
+// in JSON if json is true, otherwise in a human readable format
+func NewLogger(level string, json bool) (*zap.Logger, error) {
+	var zapLevel zapcore.Level
+	if err := zapLevel.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q: %w", level, err)
+	}
+
+	config := zap.NewProductionConfig()
+	if !json {
+		config = zap.NewDevelopmentConfig()
+	}
+	config.Level = zap.NewAtomicLevelAt(zapLevel)
+
+	return config.Build()
+}
+
+type loggerKey struct{}
+
+// NewContext returns a copy of the context carrying the logger
+func NewContext(ctx context.Context, logger *zap.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// FromContext returns the logger carried by the context, or fallback if there is none
+func FromContext(ctx context.Context, fallback *zap.Logger) *zap.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*zap.Logger); ok {
+		return logger
+	}
+	return fallback
+}
+
+// requestID returns the request ID sent by the client, or a new one
+func requestID(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(RequestIDHeader); len(values) > 0 && len(values[0]) > 0 {
+		return values[0]
+	}
+	return uuid.New().String()
+}
+
+// newRequestContext attaches a logger with the request ID and method to the context
+func newRequestContext(ctx context.Context, logger *zap.Logger, fullMethod string) (context.Context, string, *zap.Logger) {
+	id := requestID(ctx)
+	requestLogger := logger.With(
+		zap.String("request_id", id),
+		zap.String("method", fullMethod),
+	)
+	return NewContext(ctx, requestLogger), id, requestLogger
+}
+
+// setUnaryRequestID sends the request ID back to the client in the response header,
+// or in the trailer of an UNAVAILABLE error. The client retries an UNAVAILABLE error
+// only if the response has no header.
+func setUnaryRequestID(ctx context.Context, id string, err error) {
+	md := metadata.Pairs(RequestIDHeader, id)
+	if status.Code(err) == codes.Unavailable {
+		_ = grpc.SetTrailer(ctx, md)
+		return
+	}
+	_ = grpc.SetHeader(ctx, md)
+}
+
+func logCompletion(logger *zap.Logger, start time.Time, err error) {
+	code := status.Code(err)
+	fields := []zap.Field{
+		zap.String("code", code.String()),
+		zap.Duration("duration", time.Since(start)),
+	}
+	if err != nil {
+		fields = append(fields, zap.Error(err))
+	}
+
+	switch code {
+	case codes.OK:
+		logger.Info("request completed", fields...)
+	case codes.Canceled:
+		// the clients cancel the long running streams when they are done with them,
+		// e.g. the health checks of the balanced connections
+		logger.Info("request canceled", fields...)
+	case codes.Internal, codes.Unknown, codes.DataLoss, codes.Unimplemented:
+		logger.Error("request failed", fields...)
+	default:
+		logger.Warn("request failed", fields...)
+	}
+}
+
+// UnaryServerInterceptor attaches a request logger to the context of the unary RPCs
+// and logs their completion
+func UnaryServerInterceptor(logger *zap.Logger) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		start := time.Now()
+		ctx, id, requestLogger := newRequestContext(ctx, logger, info.FullMethod)
+
+		res, err := handler(ctx, req)
+		setUnaryRequestID(ctx, id, err)
+
+		logCompletion(requestLogger, start, err)
+		return res, err
+	}
+}
+
+// StreamServerInterceptor attaches a request logger to the context of the streaming RPCs
+// and logs their completion
+func StreamServerInterceptor(logger *zap.Logger) grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		stream grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		start := time.Now()
+		ctx, id, requestLogger := newRequestContext(stream.Context(), logger, info.FullMethod)
+
+		requestStream := &requestStream{ServerStream: stream, ctx: ctx, id: id}
+		err := handler(srv, requestStream)
+		requestStream.finish(err)
+
+		logCompletion(requestLogger, start, err)
+		return err
+	}
+}
+
+// requestStream is a server stream with the request context. The request ID is only added
+// to the header when the handler sends it, so that a stream failing before is trailers-only.
+type requestStream struct {
+	grpc.ServerStream
+	ctx context.Context
+	id  string
+
+	mutex     sync.Mutex
+	headerSet bool
+}
+
+func (s *requestStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *requestStream) SendHeader(md metadata.MD) error {
+	s.setHeader()
+	return s.ServerStream.SendHeader(md)
+}
+
+func (s *requestStream) SendMsg(m interface{}) error {
+	s.setHeader()
+	return s.ServerStream.SendMsg(m)
+}
+
+// setHeader adds the request ID to the header before it's sent
+func (s *requestStream) setHeader() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !s.headerSet {
+		s.headerSet = true
+		_ = s.ServerStream.SetHeader(metadata.Pairs(RequestIDHeader, s.id))
+	}
+}
+
+// finish sends the request ID of an UNAVAILABLE error in the trailer, the client retries
+// the stream only if the response has no header. The request ID of the other streams
+// which sent nothing is sent in the header.
+func (s *requestStream) finish(err error) {
+	if status.Code(err) == codes.Unavailable {
+		s.SetTrailer(metadata.Pairs(RequestIDHeader, s.id))
+		return
+	}
+	s.setHeader()
+}
@@ -0,0 +1,286 @@
+package logging_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hjcian/grpc-notes/logging"
+	"github.com/hjcian/grpc-notes/pb"
+	"github.com/hjcian/grpc-notes/sample"
+	"github.com/hjcian/grpc-notes/service"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func startTestServer(t *testing.T, logger *zap.Logger) pb.LaptopServiceClient {
+	grpcServer := grpc.NewServer(
+		grpc.UnaryInterceptor(logging.UnaryServerInterceptor(logger)),
+		grpc.StreamInterceptor(logging.StreamServerInterceptor(logger)),
+	)
+	laptopServer := service.NewLaptopServer(
+		service.NewInMemoryLaptopStore(service.WithStoreLogger(logger)),
+		service.NewDiskImageStore(t.TempDir()),
+		service.NewInMemoryRatingStore(),
+		service.WithLogger(logger),
+	)
+	pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
+
+	listener, err := net.Listen("tcp", ":0")
+	require.NoError(t, err)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithInsecure())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return pb.NewLaptopServiceClient(conn)
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	t.Parallel()
+
+	core, logs := observer.New(zapcore.InfoLevel)
+	client := startTestServer(t, zap.New(core))
+
+	// the request ID sent by the client is propagated
+	ctx := metadata.AppendToOutgoingContext(context.Background(), logging.RequestIDHeader, "my-request")
+	var header metadata.MD
+	_, err := client.CreateLaptop(ctx, &pb.CreateLaptopRequest{Laptop: sample.NewLaptop()}, grpc.Header(&header))
+	require.NoError(t, err)
+	require.Equal(t, []string{"my-request"}, header.Get(logging.RequestIDHeader))
+
+	entries := logs.FilterField(zap.String("request_id", "my-request")).All()
+	require.NotEmpty(t, entries)
+	for _, entry := range entries {
+		require.Equal(t, "/techschool.pcbook.LaptopService/CreateLaptop", entry.ContextMap()["method"])
+	}
+	completed := logs.FilterMessage("request completed").All()
+	require.Len(t, completed, 1)
+	require.Equal(t, "OK", completed[0].ContextMap()["code"])
+
+	// a new request ID is generated otherwise
+	header = nil
+	_, err = client.CreateLaptop(context.Background(), &pb.CreateLaptopRequest{Laptop: nil}, grpc.Header(&header))
+	require.Error(t, err)
+	require.Len(t, header.Get(logging.RequestIDHeader), 1)
+	require.NotEmpty(t, header.Get(logging.RequestIDHeader)[0])
+	require.NotEqual(t, "my-request", header.Get(logging.RequestIDHeader)[0])
+
+	failed := logs.FilterMessage("request failed").All()
+	require.Len(t, failed, 1)
+	require.Equal(t, zapcore.WarnLevel, failed[0].Level)
+	require.Equal(t, "InvalidArgument", failed[0].ContextMap()["code"])
+	require.Equal(t, header.Get(logging.RequestIDHeader)[0], failed[0].ContextMap()["request_id"])
+}
+
+func TestStreamServerInterceptor(t *testing.T) {
+	t.Parallel()
+
+	core, logs := observer.New(zapcore.DebugLevel)
+	client := startTestServer(t, zap.New(core))
+
+	_, err := client.CreateLaptop(context.Background(), &pb.CreateLaptopRequest{Laptop: sample.NewLaptop()})
+	require.NoError(t, err)
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), logging.RequestIDHeader, "my-search")
+	stream, err := client.SearchLaptop(ctx, &pb.SearchLaptopRequest{
+		Filter: &pb.Filter{MaxPriceUsd: 10000},
+	})
+	require.NoError(t, err)
+	for {
+		_, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+	}
+	header, err := stream.Header()
+	require.NoError(t, err)
+	require.Equal(t, []string{"my-search"}, header.Get(logging.RequestIDHeader))
+
+	// the logs of the store carry the request ID too
+	searched := logs.FilterMessage("search is done").All()
+	require.Len(t, searched, 1)
+	require.Equal(t, "my-search", searched[0].ContextMap()["request_id"])
+}
+
+func TestNewLogger(t *testing.T) {
+	t.Parallel()
+
+	logger, err := logging.NewLogger("warn", true)
+	require.NoError(t, err)
+	require.False(t, logger.Core().Enabled(zapcore.InfoLevel))
+	require.True(t, logger.Core().Enabled(zapcore.WarnLevel))
+
+	_, err = logging.NewLogger("verbose", false)
+	require.Error(t, err)
+}
+
+func TestCanceledStream(t *testing.T) {
+	t.Parallel()
+
+	core, logs := observer.New(zapcore.InfoLevel)
+	client := startTestServer(t, zap.New(core))
+
+	laptop, err := client.CreateLaptop(context.Background(), &pb.CreateLaptopRequest{Laptop: sample.NewLaptop()})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := client.RateLaptop(ctx)
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&pb.RateLaptopRequest{LaptopId: laptop.GetId(), Score: 8}))
+	_, err = stream.Recv()
+	require.NoError(t, err)
+	cancel()
+
+	// canceling a stream is up to the client, it's not a failure of the server
+	require.Eventually(t, func() bool {
+		return logs.FilterMessage("request canceled").Len() == 1
+	}, time.Second, 10*time.Millisecond)
+	canceled := logs.FilterMessage("request canceled").All()[0]
+	require.Equal(t, zapcore.InfoLevel, canceled.Level)
+	require.Equal(t, "Canceled", canceled.ContextMap()["code"])
+	require.Zero(t, logs.FilterMessage("request failed").Len())
+}
+
+func TestUnavailableIsRetried(t *testing.T) {
+	t.Parallel()
+
+	var calls int32
+	failOnce := func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			return nil, status.Error(codes.Unavailable, "try again")
+		}
+		return handler(ctx, req)
+	}
+
+	core, logs := observer.New(zapcore.InfoLevel)
+	logger := zap.New(core)
+	grpcServer := grpc.NewServer(grpc.ChainUnaryInterceptor(logging.UnaryServerInterceptor(logger), failOnce))
+	laptopServer := service.NewLaptopServer(
+		service.NewInMemoryLaptopStore(),
+		service.NewDiskImageStore(t.TempDir()),
+		service.NewInMemoryRatingStore(),
+	)
+	pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
+
+	listener, err := net.Listen("tcp", ":0")
+	require.NoError(t, err)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	conn, err := grpc.Dial(
+		listener.Addr().String(),
+		grpc.WithInsecure(),
+		grpc.WithDefaultServiceConfig(`{"methodConfig": [{
+			"name": [{"service": "techschool.pcbook.LaptopService"}],
+			"retryPolicy": {
+				"maxAttempts": 2,
+				"initialBackoff": "0.01s",
+				"maxBackoff": "0.01s",
+				"backoffMultiplier": 1,
+				"retryableStatusCodes": ["UNAVAILABLE"]
+			}
+		}]}`),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	// the UNAVAILABLE response has no header, so that the client retries it
+	var header metadata.MD
+	_, err = pb.NewLaptopServiceClient(conn).CreateLaptop(
+		context.Background(),
+		&pb.CreateLaptopRequest{Laptop: sample.NewLaptop()},
+		grpc.Header(&header),
+	)
+	require.NoError(t, err)
+	require.Equal(t, int32(2), atomic.LoadInt32(&calls))
+	require.Len(t, header.Get(logging.RequestIDHeader), 1)
+
+	failed := logs.FilterMessage("request failed").All()
+	require.Len(t, failed, 1)
+	require.Equal(t, "Unavailable", failed[0].ContextMap()["code"])
+}
+
+func TestUnavailableStreamIsRetried(t *testing.T) {
+	t.Parallel()
+
+	var failures, calls int32
+	failing := func(
+		srv interface{},
+		stream grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		if atomic.AddInt32(&calls, 1) <= atomic.LoadInt32(&failures) {
+			return status.Error(codes.Unavailable, "try again")
+		}
+		return handler(srv, stream)
+	}
+
+	grpcServer := grpc.NewServer(grpc.ChainStreamInterceptor(logging.StreamServerInterceptor(zap.NewNop()), failing))
+	laptopStore := service.NewInMemoryLaptopStore()
+	require.NoError(t, laptopStore.Save(sample.NewLaptop()))
+	pb.RegisterLaptopServiceServer(grpcServer, service.NewLaptopServer(laptopStore, nil, nil))
+
+	listener, err := net.Listen("tcp", ":0")
+	require.NoError(t, err)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	conn, err := grpc.Dial(
+		listener.Addr().String(),
+		grpc.WithInsecure(),
+		grpc.WithDefaultServiceConfig(`{"methodConfig": [{
+			"name": [{"service": "techschool.pcbook.LaptopService"}],
+			"retryPolicy": {
+				"maxAttempts": 2,
+				"initialBackoff": "0.01s",
+				"maxBackoff": "0.01s",
+				"backoffMultiplier": 1,
+				"retryableStatusCodes": ["UNAVAILABLE"]
+			}
+		}]}`),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	client := pb.NewLaptopServiceClient(conn)
+	req := &pb.SearchLaptopRequest{Filter: &pb.Filter{MaxPriceUsd: 10000}}
+
+	// the UNAVAILABLE response has no header, so that the client retries it
+	atomic.StoreInt32(&failures, 1)
+	stream, err := client.SearchLaptop(context.Background(), req)
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.NoError(t, err)
+	header, err := stream.Header()
+	require.NoError(t, err)
+	require.Len(t, header.Get(logging.RequestIDHeader), 1)
+	require.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	// the request ID of the last failure is in the trailer
+	atomic.StoreInt32(&calls, 0)
+	atomic.StoreInt32(&failures, 2)
+	stream, err = client.SearchLaptop(context.Background(), req)
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.Equal(t, codes.Unavailable, status.Code(err))
+	require.Equal(t, int32(2), atomic.LoadInt32(&calls))
+	require.Len(t, stream.Trailer().Get(logging.RequestIDHeader), 1)
+}
@@ -0,0 +1,201 @@
+package metrics
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+const namespace = "pcbook"
+
+// ServerMetrics collects the metrics of the RPCs handled by a gRPC server
+type ServerMetrics struct {
+	started       *prometheus.CounterVec
+	handled       *prometheus.CounterVec
+	latency       *prometheus.HistogramVec
+	msgsReceived  *prometheus.CounterVec
+	msgsSent      *prometheus.CounterVec
+	bytesReceived *prometheus.CounterVec
+}
+
+// NewServerMetrics returns a new ServerMetrics and registers its collectors
+func NewServerMetrics(registerer prometheus.Registerer) (*ServerMetrics, error) {
+	m := &ServerMetrics{
+		started: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "grpc_server_started_total",
+			Help:      "Total number of RPCs started on the server.",
+		}, []string{"grpc_service", "grpc_method"}),
+		handled: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "grpc_server_handled_total",
+			Help:      "Total number of RPCs completed on the server, by status code.",
+		}, []string{"grpc_service", "grpc_method", "grpc_code"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "grpc_server_handling_seconds",
+			Help:      "Latency of the RPCs handled by the server.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"grpc_service", "grpc_method"}),
+		msgsReceived: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "grpc_server_msg_received_total",
+			Help:      "Total number of stream messages received from the clients.",
+		}, []string{"grpc_service", "grpc_method"}),
+		msgsSent: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "grpc_server_msg_sent_total",
+			Help:      "Total number of stream messages sent to the clients.",
+		}, []string{"grpc_service", "grpc_method"}),
+		bytesReceived: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "grpc_server_msg_received_bytes_total",
+			Help:      "Total bytes of the stream messages received from the clients, e.g. the uploaded images.",
+		}, []string{"grpc_service", "grpc_method"}),
+	}
+
+	for _, collector := range []prometheus.Collector{
+		m.started, m.handled, m.latency, m.msgsReceived, m.msgsSent, m.bytesReceived,
+	} {
+		if err := registerer.Register(collector); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+// splitMethod splits "/techschool.pcbook.LaptopService/CreateLaptop" into the service and the method names
+func splitMethod(fullMethod string) (string, string) {
+	fullMethod = strings.TrimPrefix(fullMethod, "/")
+	if i := strings.Index(fullMethod, "/"); i >= 0 {
+		return fullMethod[:i], fullMethod[i+1:]
+	}
+	return "unknown", fullMethod
+}
+
+// UnaryServerInterceptor counts and times the unary RPCs
+func (m *ServerMetrics) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		service, method := splitMethod(info.FullMethod)
+		m.started.WithLabelValues(service, method).Inc()
+		start := time.Now()
+
+		res, err := handler(ctx, req)
+
+		m.handled.WithLabelValues(service, method, status.Code(err).String()).Inc()
+		m.latency.WithLabelValues(service, method).Observe(time.Since(start).Seconds())
+		return res, err
+	}
+}
+
+// StreamServerInterceptor counts and times the streaming RPCs and their messages
+func (m *ServerMetrics) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		stream grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		service, method := splitMethod(info.FullMethod)
+		m.started.WithLabelValues(service, method).Inc()
+		start := time.Now()
+
+		err := handler(srv, &monitoredStream{
+			ServerStream:  stream,
+			received:      m.msgsReceived.WithLabelValues(service, method),
+			sent:          m.msgsSent.WithLabelValues(service, method),
+			receivedBytes: m.bytesReceived.WithLabelValues(service, method),
+		})
+
+		m.handled.WithLabelValues(service, method, status.Code(err).String()).Inc()
+		m.latency.WithLabelValues(service, method).Observe(time.Since(start).Seconds())
+		return err
+	}
+}
+
+// monitoredStream counts the messages received and sent on a server stream
+type monitoredStream struct {
+	grpc.ServerStream
+	received      prometheus.Counter
+	sent          prometheus.Counter
+	receivedBytes prometheus.Counter
+}
+
+func (s *monitoredStream) SendMsg(m interface{}) error {
+	err := s.ServerStream.SendMsg(m)
+	if err == nil {
+		s.sent.Inc()
+	}
+	return err
+}
+
+func (s *monitoredStream) RecvMsg(m interface{}) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil {
+		s.received.Inc()
+		if message, ok := m.(proto.Message); ok {
+			s.receivedBytes.Add(float64(proto.Size(message)))
+		}
+	}
+	return err
+}
+
+// Counter is implemented by the stores which can count their records
+type Counter interface {
+	Count() int
+}
+
+// SizeCounter is implemented by the stores which can also tell how many bytes they use
+type SizeCounter interface {
+	Counter
+	Size() int64
+}
+
+// RegisterStoreGauges registers the gauges reporting the content of the stores
+func RegisterStoreGauges(
+	registerer prometheus.Registerer,
+	laptopStore Counter,
+	imageStore SizeCounter,
+	ratingStore Counter,
+) error {
+	gauges := []prometheus.Collector{
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "laptops_stored",
+			Help:      "Number of laptops in the laptop store.",
+		}, func() float64 { return float64(laptopStore.Count()) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "images_stored",
+			Help:      "Number of images in the image store.",
+		}, func() float64 { return float64(imageStore.Count()) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "image_bytes_stored",
+			Help:      "Total bytes of the images on disk.",
+		}, func() float64 { return float64(imageStore.Size()) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "ratings_stored",
+			Help:      "Number of ratings in the rating store.",
+		}, func() float64 { return float64(ratingStore.Count()) }),
+	}
+
+	for _, gauge := range gauges {
+		if err := registerer.Register(gauge); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/hjcian/grpc-notes/pb"
+	"github.com/hjcian/grpc-notes/sample"
+	"github.com/hjcian/grpc-notes/service"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+)
+
+const laptopService = "techschool.pcbook.LaptopService"
+
+func TestServerMetrics(t *testing.T) {
+	t.Parallel()
+
+	registry := prometheus.NewRegistry()
+	m, err := NewServerMetrics(registry)
+	require.NoError(t, err)
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	imageStore := service.NewDiskImageStore(t.TempDir())
+	ratingStore := service.NewInMemoryRatingStore()
+	err = RegisterStoreGauges(registry, laptopStore, imageStore, ratingStore)
+	require.NoError(t, err)
+
+	grpcServer := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(m.UnaryServerInterceptor()),
+		grpc.ChainStreamInterceptor(m.StreamServerInterceptor()),
+	)
+	pb.RegisterLaptopServiceServer(grpcServer, service.NewLaptopServer(laptopStore, imageStore, ratingStore))
+
+	listener, err := net.Listen("tcp", ":0")
+	require.NoError(t, err)
+	go grpcServer.Serve(listener)
+	defer grpcServer.Stop()
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	client := pb.NewLaptopServiceClient(conn)
+
+	laptop := sample.NewLaptop()
+	for i := 0; i < 2; i++ {
+		_, err = client.CreateLaptop(context.Background(), &pb.CreateLaptopRequest{Laptop: laptop})
+	}
+	require.Error(t, err)
+
+	stream, err := client.SearchLaptop(context.Background(), &pb.SearchLaptopRequest{
+		Filter: &pb.Filter{MaxPriceUsd: 10000},
+	})
+	require.NoError(t, err)
+	for {
+		_, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+	}
+
+	upload, err := client.UploadImage(context.Background())
+	require.NoError(t, err)
+	err = upload.Send(&pb.UploadImageRequest{
+		Data: &pb.UploadImageRequest_Info{
+			Info: &pb.ImageInfo{LaptopId: laptop.GetId(), ImageType: ".jpg"},
+		},
+	})
+	require.NoError(t, err)
+	err = upload.Send(&pb.UploadImageRequest{
+		Data: &pb.UploadImageRequest_ChunkData{ChunkData: make([]byte, 1000)},
+	})
+	require.NoError(t, err)
+	_, err = upload.CloseAndRecv()
+	require.NoError(t, err)
+
+	require.Equal(t, 2.0, testutil.ToFloat64(m.started.WithLabelValues(laptopService, "CreateLaptop")))
+	require.Equal(t, 1.0, testutil.ToFloat64(m.handled.WithLabelValues(laptopService, "CreateLaptop", "OK")))
+	require.Equal(t, 1.0, testutil.ToFloat64(m.handled.WithLabelValues(laptopService, "CreateLaptop", "AlreadyExists")))
+	require.Equal(t, 1.0, testutil.ToFloat64(m.msgsSent.WithLabelValues(laptopService, "SearchLaptop")))
+	require.Equal(t, 2.0, testutil.ToFloat64(m.msgsReceived.WithLabelValues(laptopService, "UploadImage")))
+	require.Greater(t, testutil.ToFloat64(m.bytesReceived.WithLabelValues(laptopService, "UploadImage")), 1000.0)
+	require.Equal(t, 3, testutil.CollectAndCount(m.latency))
+
+	count, err := testutil.GatherAndCount(registry, "pcbook_laptops_stored", "pcbook_images_stored", "pcbook_image_bytes_stored")
+	require.NoError(t, err)
+	require.Equal(t, 3, count)
+
+	families, err := registry.Gather()
+	require.NoError(t, err)
+	for _, family := range families {
+		switch family.GetName() {
+		case "pcbook_laptops_stored", "pcbook_images_stored":
+			require.Equal(t, 1.0, family.GetMetric()[0].GetGauge().GetValue())
+		case "pcbook_image_bytes_stored":
+			require.Equal(t, 1000.0, family.GetMetric()[0].GetGauge().GetValue())
+		case "pcbook_ratings_stored":
+			require.Equal(t, 0.0, family.GetMetric()[0].GetGauge().GetValue())
+		}
+	}
+}
+
+func TestSplitMethod(t *testing.T) {
+	t.Parallel()
+
+	service, method := splitMethod("/techschool.pcbook.LaptopService/CreateLaptop")
+	require.Equal(t, laptopService, service)
+	require.Equal(t, "CreateLaptop", method)
+
+	service, method = splitMethod("CreateLaptop")
+	require.Equal(t, "unknown", service)
+	require.Equal(t, "CreateLaptop", method)
+}
@@ -0,0 +1,166 @@
+package money
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+
+	"github.com/hjcian/grpc-notes/pb"
+)
+
+// USD is the currency of the legacy prices, such as the price_usd of the laptops
+const USD = "USD"
+
+const nanosPerUnit = 1000000000
+
+// ErrOverflow is returned when an amount doesn't fit in the units of a money
+var ErrOverflow = errors.New("amount overflows the units of money")
+
+// ValidCurrencyCode tells if the code looks like an ISO 4217 code: 3 upper case letters
+func ValidCurrencyCode(code string) bool {
+	if len(code) != 3 {
+		return false
+	}
+	for _, c := range code {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// Validate checks the currency code of the money, and that its nanos are in range and of the sign of its units
+func Validate(money *pb.Money) error {
+	if !ValidCurrencyCode(money.GetCurrencyCode()) {
+		return fmt.Errorf("invalid currency code %q, expect 3 upper case letters such as USD", money.GetCurrencyCode())
+	}
+	units, nanos := money.GetUnits(), money.GetNanos()
+	if nanos <= -nanosPerUnit || nanos >= nanosPerUnit {
+		return fmt.Errorf("nanos %d out of range", nanos)
+	}
+	if (units > 0 && nanos < 0) || (units < 0 && nanos > 0) {
+		return fmt.Errorf("nanos %d and units %d are of different signs", nanos, units)
+	}
+	return nil
+}
+
+// LaptopPrice returns the price of the laptop, its legacy price in USD if it has no price
+func LaptopPrice(laptop *pb.Laptop) (*pb.Money, error) {
+	if laptop.GetPrice() != nil {
+		return laptop.GetPrice(), nil
+	}
+	return FromFloat(USD, laptop.GetPriceUsd())
+}
+
+// Rat returns the amount of the money
+func Rat(money *pb.Money) *big.Rat {
+	amount := new(big.Rat).SetFrac64(int64(money.GetNanos()), nanosPerUnit)
+	return amount.Add(amount, new(big.Rat).SetInt64(money.GetUnits()))
+}
+
+// FromRat returns the amount in the currency, rounded half away from zero to the nearest nano unit
+func FromRat(currency string, amount *big.Rat) (*pb.Money, error) {
+	nanos := new(big.Rat).Mul(amount, new(big.Rat).SetInt64(nanosPerUnit))
+	// round by adding half a nano unit before the division truncates toward zero
+	half := big.NewRat(1, 2)
+	if nanos.Sign() < 0 {
+		half.Neg(half)
+	}
+	nanos.Add(nanos, half)
+	total := new(big.Int).Quo(nanos.Num(), nanos.Denom())
+
+	units, rest := new(big.Int).QuoRem(total, big.NewInt(nanosPerUnit), new(big.Int))
+	if !units.IsInt64() {
+		return nil, fmt.Errorf("%s %s: %w", amount.FloatString(9), currency, ErrOverflow)
+	}
+	return &pb.Money{
+		CurrencyCode: currency,
+		Units:        units.Int64(),
+		Nanos:        int32(rest.Int64()),
+	}, nil
+}
+
+// FromFloat returns the amount in the currency, rounded to the nearest nano unit.
+// The amount must be finite.
+func FromFloat(currency string, amount float64) (*pb.Money, error) {
+	if math.IsInf(amount, 0) || math.IsNaN(amount) {
+		return nil, fmt.Errorf("amount %g %s is not a finite number", amount, currency)
+	}
+	return FromRat(currency, new(big.Rat).SetFloat64(amount))
+}
+
+// Float returns the amount of the money as a float, it may be rounded
+func Float(money *pb.Money) float64 {
+	amount, _ := Rat(money).Float64()
+	return amount
+}
+
+// Compare compares the amounts of the monies, which must be of the same currency.
+// It returns -1 if a is less than b, 0 if they are equal and +1 if a is greater.
+func Compare(a, b *pb.Money) (int, error) {
+	if a.GetCurrencyCode() != b.GetCurrencyCode() {
+		return 0, fmt.Errorf("cannot compare %s to %s", a.GetCurrencyCode(), b.GetCurrencyCode())
+	}
+	return Rat(a).Cmp(Rat(b)), nil
+}
+
+// Format formats the money as its exact amount with at least 2 decimals, and its currency code,
+// e.g. "1999.90 EUR"
+func Format(money *pb.Money) string {
+	units, nanos := money.GetUnits(), money.GetNanos()
+	sign := ""
+	if units < 0 || nanos < 0 {
+		sign = "-"
+	}
+	decimals := strings.TrimRight(fmt.Sprintf("%09d", abs(int64(nanos))), "0")
+	for len(decimals) < 2 {
+		decimals += "0"
+	}
+	return fmt.Sprintf("%s%s.%s %s", sign, new(big.Int).Abs(big.NewInt(units)), decimals, money.GetCurrencyCode())
+}
+
+func abs(x int64) int64 {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// Parse parses a money formatted as its amount and its currency code, e.g. "1999.90 EUR"
+func Parse(value string) (*pb.Money, error) {
+	fields := strings.Fields(value)
+	if len(fields) != 2 {
+		return nil, fmt.Errorf("invalid money %q, expect <amount> <currency code> such as 1999.90 EUR", value)
+	}
+	return ParseAmount(fields[0], fields[1])
+}
+
+// ParseAmount parses a decimal amount of the currency, e.g. "1999.90", it must have at most 9 decimals
+func ParseAmount(amount string, currency string) (*pb.Money, error) {
+	if !ValidCurrencyCode(currency) {
+		return nil, fmt.Errorf("invalid currency code %q, expect 3 upper case letters such as USD", currency)
+	}
+
+	digits := strings.TrimPrefix(amount, "-")
+	whole, decimals := digits, ""
+	if i := strings.IndexByte(digits, '.'); i >= 0 {
+		whole, decimals = digits[:i], digits[i+1:]
+		if len(decimals) == 0 {
+			return nil, fmt.Errorf("invalid amount %q", amount)
+		}
+	}
+	if len(whole) == 0 || strings.IndexFunc(whole+decimals, func(r rune) bool { return r < '0' || r > '9' }) >= 0 {
+		return nil, fmt.Errorf("invalid amount %q", amount)
+	}
+	if len(decimals) > 9 {
+		return nil, fmt.Errorf("invalid amount %q: more than 9 decimals", amount)
+	}
+
+	rat, ok := new(big.Rat).SetString(amount)
+	if !ok {
+		return nil, fmt.Errorf("invalid amount %q", amount)
+	}
+	return FromRat(currency, rat)
+}
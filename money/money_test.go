@@ -0,0 +1,141 @@
+package money
+
+import (
+	"errors"
+	"math"
+	"math/big"
+	"testing"
+
+	"github.com/hjcian/grpc-notes/pb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	t.Parallel()
+
+	for _, money := range []*pb.Money{
+		{CurrencyCode: "USD"},
+		{CurrencyCode: "EUR", Units: 1999, Nanos: 990000000},
+		{CurrencyCode: "TWD", Units: -1, Nanos: -999999999},
+		{CurrencyCode: "GBP", Nanos: -500000000},
+	} {
+		require.NoError(t, Validate(money), money)
+	}
+
+	for _, money := range []*pb.Money{
+		nil,
+		{CurrencyCode: "usd", Units: 1},
+		{CurrencyCode: "EURO", Units: 1},
+		{CurrencyCode: "EUR", Units: 1, Nanos: 1000000000},
+		{CurrencyCode: "EUR", Units: 1, Nanos: -1},
+		{CurrencyCode: "EUR", Units: -1, Nanos: 1},
+	} {
+		require.Error(t, Validate(money), money)
+	}
+}
+
+func TestFromRat(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		amount *big.Rat
+		money  *pb.Money
+	}{
+		{big.NewRat(3, 2), &pb.Money{CurrencyCode: "EUR", Units: 1, Nanos: 500000000}},
+		{big.NewRat(-3, 2), &pb.Money{CurrencyCode: "EUR", Units: -1, Nanos: -500000000}},
+		{big.NewRat(-1, 4), &pb.Money{CurrencyCode: "EUR", Nanos: -250000000}},
+		// rounded half away from zero
+		{big.NewRat(2, 3), &pb.Money{CurrencyCode: "EUR", Nanos: 666666667}},
+		{big.NewRat(-2, 3), &pb.Money{CurrencyCode: "EUR", Nanos: -666666667}},
+		{big.NewRat(1, 2000000000), &pb.Money{CurrencyCode: "EUR", Nanos: 1}},
+		{big.NewRat(1, 2000000001), &pb.Money{CurrencyCode: "EUR"}},
+		{big.NewRat(1999999999999, 1000000000000), &pb.Money{CurrencyCode: "EUR", Units: 2}},
+	}
+	for _, tc := range testCases {
+		money, err := FromRat("EUR", tc.amount)
+		require.NoError(t, err, tc.amount)
+		require.Equal(t, tc.money, money, tc.amount)
+		require.NoError(t, Validate(money))
+	}
+
+	tooMuch := new(big.Rat).SetInt(new(big.Int).Lsh(big.NewInt(1), 63))
+	_, err := FromRat("EUR", tooMuch)
+	require.True(t, errors.Is(err, ErrOverflow), err)
+}
+
+func TestFromFloat(t *testing.T) {
+	t.Parallel()
+
+	money, err := FromFloat(USD, 1999.99)
+	require.NoError(t, err)
+	require.Equal(t, &pb.Money{CurrencyCode: USD, Units: 1999, Nanos: 990000000}, money)
+	require.Equal(t, 1999.99, Float(money))
+
+	for _, amount := range []float64{math.NaN(), math.Inf(1), math.MaxFloat64} {
+		_, err := FromFloat(USD, amount)
+		require.Error(t, err, amount)
+	}
+}
+
+func TestLaptopPrice(t *testing.T) {
+	t.Parallel()
+
+	price, err := LaptopPrice(&pb.Laptop{PriceUsd: 1500.5})
+	require.NoError(t, err)
+	require.Equal(t, &pb.Money{CurrencyCode: USD, Units: 1500, Nanos: 500000000}, price)
+
+	eur := &pb.Money{CurrencyCode: "EUR", Units: 1400}
+	price, err = LaptopPrice(&pb.Laptop{PriceUsd: 1500.5, Price: eur})
+	require.NoError(t, err)
+	require.Equal(t, eur, price)
+}
+
+func TestCompare(t *testing.T) {
+	t.Parallel()
+
+	cmp, err := Compare(&pb.Money{CurrencyCode: "EUR", Units: 1, Nanos: 1}, &pb.Money{CurrencyCode: "EUR", Units: 1})
+	require.NoError(t, err)
+	require.Equal(t, 1, cmp)
+
+	cmp, err = Compare(&pb.Money{CurrencyCode: "EUR", Nanos: -1}, &pb.Money{CurrencyCode: "EUR"})
+	require.NoError(t, err)
+	require.Equal(t, -1, cmp)
+
+	_, err = Compare(&pb.Money{CurrencyCode: "EUR"}, &pb.Money{CurrencyCode: USD})
+	require.Error(t, err)
+}
+
+func TestFormatParse(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		money *pb.Money
+		text  string
+	}{
+		{&pb.Money{CurrencyCode: "EUR", Units: 1999, Nanos: 900000000}, "1999.90 EUR"},
+		{&pb.Money{CurrencyCode: "TWD", Units: 31500}, "31500.00 TWD"},
+		{&pb.Money{CurrencyCode: "USD", Nanos: 1}, "0.000000001 USD"},
+		{&pb.Money{CurrencyCode: "GBP", Units: -2, Nanos: -500000000}, "-2.50 GBP"},
+		{&pb.Money{CurrencyCode: "GBP", Nanos: -10000000}, "-0.01 GBP"},
+		{&pb.Money{CurrencyCode: "USD", Units: math.MinInt64}, "-9223372036854775808.00 USD"},
+	}
+	for _, tc := range testCases {
+		require.Equal(t, tc.text, Format(tc.money))
+
+		money, err := Parse(tc.text)
+		require.NoError(t, err, tc.text)
+		require.Equal(t, tc.money, money, tc.text)
+	}
+
+	money, err := Parse("1500 EUR")
+	require.NoError(t, err)
+	require.Equal(t, &pb.Money{CurrencyCode: "EUR", Units: 1500}, money)
+
+	for _, text := range []string{
+		"", "1500", "EUR 1500", "1500 eur", "1500 EURO", "1,500 EUR", "1e3 EUR", "1/2 EUR",
+		".5 EUR", "1. EUR", "--1 EUR", "1.0000000001 EUR", "9223372036854775808 EUR",
+	} {
+		_, err := Parse(text)
+		require.Error(t, err, text)
+	}
+}
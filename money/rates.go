@@ -0,0 +1,129 @@
+package money
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/hjcian/grpc-notes/pb"
+)
+
+// ErrUnknownCurrency is returned for a currency which has no exchange rate
+var ErrUnknownCurrency = errors.New("unknown currency")
+
+// RateProvider provides the exchange rates between the currencies
+type RateProvider interface {
+	// Rate returns the amount in the currency to of one unit of the currency from,
+	// or an error wrapping ErrUnknownCurrency. The rate of a known currency to itself is 1.
+	// The rate may be modified by the caller.
+	Rate(ctx context.Context, from, to string) (*big.Rat, error)
+}
+
+// USDOnly is the RateProvider which only knows USD, the currency of the legacy prices
+var USDOnly RateProvider = &StaticRates{
+	base:  USD,
+	rates: map[string]*big.Rat{USD: big.NewRat(1, 1)},
+}
+
+// Convert returns the money in the currency, rounded to the nearest nano unit.
+// A money which is already in the currency is returned as it is, the rates aren't used.
+func Convert(ctx context.Context, rates RateProvider, money *pb.Money, currency string) (*pb.Money, error) {
+	if money.GetCurrencyCode() == currency {
+		return money, nil
+	}
+
+	rate, err := rates.Rate(ctx, money.GetCurrencyCode(), currency)
+	if err != nil {
+		return nil, err
+	}
+	return FromRat(currency, rate.Mul(rate, Rat(money)))
+}
+
+// StaticRates are fixed exchange rates, given as the amount of each currency
+// worth one unit of a base currency
+type StaticRates struct {
+	base  string
+	rates map[string]*big.Rat
+}
+
+// NewStaticRates returns the rates of the currencies to the base currency,
+// the rate of the base currency is 1
+func NewStaticRates(base string, rates map[string]*big.Rat) (*StaticRates, error) {
+	if !ValidCurrencyCode(base) {
+		return nil, fmt.Errorf("invalid base currency code %q", base)
+	}
+
+	r := &StaticRates{
+		base:  base,
+		rates: map[string]*big.Rat{base: big.NewRat(1, 1)},
+	}
+	for currency, rate := range rates {
+		if !ValidCurrencyCode(currency) {
+			return nil, fmt.Errorf("invalid currency code %q", currency)
+		}
+		if rate.Sign() <= 0 {
+			return nil, fmt.Errorf("rate of %s must be positive, got %s", currency, rate.FloatString(6))
+		}
+		if currency == base && rate.Cmp(r.rates[base]) != 0 {
+			return nil, fmt.Errorf("rate of the base currency %s must be 1", base)
+		}
+		r.rates[currency] = new(big.Rat).Set(rate)
+	}
+	return r, nil
+}
+
+// staticRatesFile is the JSON of a rates file, the rates are read as exact decimals
+type staticRatesFile struct {
+	Base  string                 `json:"base"`
+	Rates map[string]json.Number `json:"rates"`
+}
+
+// LoadStaticRates reads the rates of a JSON file such as:
+//
+//	{"base": "USD", "rates": {"EUR": 0.92, "GBP": 0.79, "TWD": 31.5}}
+func LoadStaticRates(filename string) (*StaticRates, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open rates file: %w", err)
+	}
+	defer file.Close()
+
+	var data staticRatesFile
+	decoder := json.NewDecoder(file)
+	decoder.UseNumber()
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&data); err != nil {
+		return nil, fmt.Errorf("cannot decode rates file %s: %w", filename, err)
+	}
+
+	rates := make(map[string]*big.Rat, len(data.Rates))
+	for currency, number := range data.Rates {
+		rate, ok := new(big.Rat).SetString(number.String())
+		if !ok {
+			return nil, fmt.Errorf("invalid rate %s of %s in %s", number, currency, filename)
+		}
+		rates[currency] = rate
+	}
+
+	r, err := NewStaticRates(data.Base, rates)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rates file %s: %w", filename, err)
+	}
+	return r, nil
+}
+
+// Rate returns the rate from a currency to another through the base currency
+func (r *StaticRates) Rate(ctx context.Context, from, to string) (*big.Rat, error) {
+	fromRate, ok := r.rates[from]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownCurrency, from)
+	}
+	toRate, ok := r.rates[to]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownCurrency, to)
+	}
+	return new(big.Rat).Quo(toRate, fromRate), nil
+}
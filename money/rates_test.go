@@ -0,0 +1,98 @@
+package money
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"testing"
+
+	"github.com/hjcian/grpc-notes/pb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStaticRates(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	rates, err := LoadStaticRates("testdata/rates.json")
+	require.NoError(t, err)
+
+	rate, err := rates.Rate(ctx, "USD", "EUR")
+	require.NoError(t, err)
+	require.Equal(t, big.NewRat(92, 100), rate)
+
+	rate, err = rates.Rate(ctx, "GBP", "TWD")
+	require.NoError(t, err)
+	require.Equal(t, big.NewRat(3150, 79), rate)
+
+	rate, err = rates.Rate(ctx, "TWD", "TWD")
+	require.NoError(t, err)
+	require.Equal(t, big.NewRat(1, 1), rate)
+
+	// the rates can be modified by the caller
+	rate.SetInt64(2)
+	rate, err = rates.Rate(ctx, "TWD", "TWD")
+	require.NoError(t, err)
+	require.Equal(t, big.NewRat(1, 1), rate)
+
+	for _, pair := range [][2]string{{"JPY", "USD"}, {"USD", "JPY"}, {"JPY", "JPY"}} {
+		_, err := rates.Rate(ctx, pair[0], pair[1])
+		require.True(t, errors.Is(err, ErrUnknownCurrency), err)
+	}
+
+	_, err = USDOnly.Rate(ctx, USD, USD)
+	require.NoError(t, err)
+	_, err = USDOnly.Rate(ctx, "EUR", "EUR")
+	require.True(t, errors.Is(err, ErrUnknownCurrency), err)
+}
+
+func TestConvert(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	rates, err := LoadStaticRates("testdata/rates.json")
+	require.NoError(t, err)
+
+	money, err := Convert(ctx, rates, &pb.Money{CurrencyCode: "TWD", Units: 1000}, "EUR")
+	require.NoError(t, err)
+	require.Equal(t, "29.206349206 EUR", Format(money))
+
+	money, err = Convert(ctx, rates, &pb.Money{CurrencyCode: "EUR", Units: 920}, USD)
+	require.NoError(t, err)
+	require.Equal(t, &pb.Money{CurrencyCode: USD, Units: 1000}, money)
+
+	// no rate is needed for the same currency
+	jpy := &pb.Money{CurrencyCode: "JPY", Units: 1000}
+	money, err = Convert(ctx, rates, jpy, "JPY")
+	require.NoError(t, err)
+	require.Equal(t, jpy, money)
+
+	_, err = Convert(ctx, rates, jpy, USD)
+	require.True(t, errors.Is(err, ErrUnknownCurrency), err)
+}
+
+func TestLoadStaticRatesErrors(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	for i, data := range []string{
+		``,
+		`{"base": "USD", "rates": {"EUR": 0}}`,
+		`{"base": "USD", "rates": {"EUR": -0.92}}`,
+		`{"base": "USD", "rates": {"eur": 0.92}}`,
+		`{"base": "USD", "rates": {"USD": 2}}`,
+		`{"base": "dollar", "rates": {}}`,
+		`{"base": "USD", "rates": {}, "date": "today"}`,
+	} {
+		filename := filepath.Join(dir, "rates.json")
+		require.NoError(t, ioutil.WriteFile(filename, []byte(data), 0644))
+
+		_, err := LoadStaticRates(filename)
+		require.Error(t, err, i)
+	}
+
+	_, err := LoadStaticRates(filepath.Join(dir, "missing.json"))
+	require.Error(t, err)
+}
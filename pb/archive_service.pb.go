@@ -0,0 +1,844 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.23.0
+// 	protoc        v3.13.0
+// source: archive_service.proto
+
+package pb
+
+import (
+	context "context"
+	proto "github.com/golang/protobuf/proto"
+	timestamp "github.com/golang/protobuf/ptypes/timestamp"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// This is a compile-time assertion that a sufficiently up-to-date version
+// of the legacy proto package is being used.
+const _ = proto.ProtoPackageIsVersion4
+
+// the first record of an archive
+type ArchiveHeader struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Version   uint32               `protobuf:"varint,1,opt,name=version,proto3" json:"version,omitempty"`
+	CreatedAt *timestamp.Timestamp `protobuf:"bytes,2,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
+	// whether the images are followed by their data
+	ImagesIncluded bool `protobuf:"varint,3,opt,name=images_included,json=imagesIncluded,proto3" json:"images_included,omitempty"`
+}
+
+func (x *ArchiveHeader) Reset() {
+	*x = ArchiveHeader{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_archive_service_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ArchiveHeader) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ArchiveHeader) ProtoMessage() {}
+
+func (x *ArchiveHeader) ProtoReflect() protoreflect.Message {
+	mi := &file_archive_service_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ArchiveHeader.ProtoReflect.Descriptor instead.
+func (*ArchiveHeader) Descriptor() ([]byte, []int) {
+	return file_archive_service_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *ArchiveHeader) GetVersion() uint32 {
+	if x != nil {
+		return x.Version
+	}
+	return 0
+}
+
+func (x *ArchiveHeader) GetCreatedAt() *timestamp.Timestamp {
+	if x != nil {
+		return x.CreatedAt
+	}
+	return nil
+}
+
+func (x *ArchiveHeader) GetImagesIncluded() bool {
+	if x != nil {
+		return x.ImagesIncluded
+	}
+	return false
+}
+
+// a chunk of the data of the image of the last ImageSaved record
+type ImageChunk struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	ImageId string `protobuf:"bytes,1,opt,name=image_id,json=imageId,proto3" json:"image_id,omitempty"`
+	Data    []byte `protobuf:"bytes,2,opt,name=data,proto3" json:"data,omitempty"`
+}
+
+func (x *ImageChunk) Reset() {
+	*x = ImageChunk{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_archive_service_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ImageChunk) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ImageChunk) ProtoMessage() {}
+
+func (x *ImageChunk) ProtoReflect() protoreflect.Message {
+	mi := &file_archive_service_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ImageChunk.ProtoReflect.Descriptor instead.
+func (*ImageChunk) Descriptor() ([]byte, []int) {
+	return file_archive_service_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *ImageChunk) GetImageId() string {
+	if x != nil {
+		return x.ImageId
+	}
+	return ""
+}
+
+func (x *ImageChunk) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+// an archive of the catalog is a stream of records: the header, the laptops, the ratings,
+// the images each one followed by the chunks of its data if they are included, and the primary images
+type ArchiveRecord struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// Types that are assignable to Data:
+	//	*ArchiveRecord_Header
+	//	*ArchiveRecord_Laptop
+	//	*ArchiveRecord_Rating
+	//	*ArchiveRecord_Image
+	//	*ArchiveRecord_ImageChunk
+	//	*ArchiveRecord_PrimaryImage
+	Data isArchiveRecord_Data `protobuf_oneof:"data"`
+}
+
+func (x *ArchiveRecord) Reset() {
+	*x = ArchiveRecord{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_archive_service_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ArchiveRecord) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ArchiveRecord) ProtoMessage() {}
+
+func (x *ArchiveRecord) ProtoReflect() protoreflect.Message {
+	mi := &file_archive_service_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ArchiveRecord.ProtoReflect.Descriptor instead.
+func (*ArchiveRecord) Descriptor() ([]byte, []int) {
+	return file_archive_service_proto_rawDescGZIP(), []int{2}
+}
+
+func (m *ArchiveRecord) GetData() isArchiveRecord_Data {
+	if m != nil {
+		return m.Data
+	}
+	return nil
+}
+
+func (x *ArchiveRecord) GetHeader() *ArchiveHeader {
+	if x, ok := x.GetData().(*ArchiveRecord_Header); ok {
+		return x.Header
+	}
+	return nil
+}
+
+func (x *ArchiveRecord) GetLaptop() *Laptop {
+	if x, ok := x.GetData().(*ArchiveRecord_Laptop); ok {
+		return x.Laptop
+	}
+	return nil
+}
+
+func (x *ArchiveRecord) GetRating() *LaptopRating {
+	if x, ok := x.GetData().(*ArchiveRecord_Rating); ok {
+		return x.Rating
+	}
+	return nil
+}
+
+func (x *ArchiveRecord) GetImage() *ImageSaved {
+	if x, ok := x.GetData().(*ArchiveRecord_Image); ok {
+		return x.Image
+	}
+	return nil
+}
+
+func (x *ArchiveRecord) GetImageChunk() *ImageChunk {
+	if x, ok := x.GetData().(*ArchiveRecord_ImageChunk); ok {
+		return x.ImageChunk
+	}
+	return nil
+}
+
+func (x *ArchiveRecord) GetPrimaryImage() *PrimaryImageSet {
+	if x, ok := x.GetData().(*ArchiveRecord_PrimaryImage); ok {
+		return x.PrimaryImage
+	}
+	return nil
+}
+
+type isArchiveRecord_Data interface {
+	isArchiveRecord_Data()
+}
+
+type ArchiveRecord_Header struct {
+	Header *ArchiveHeader `protobuf:"bytes,1,opt,name=header,proto3,oneof"`
+}
+
+type ArchiveRecord_Laptop struct {
+	Laptop *Laptop `protobuf:"bytes,2,opt,name=laptop,proto3,oneof"`
+}
+
+type ArchiveRecord_Rating struct {
+	Rating *LaptopRating `protobuf:"bytes,3,opt,name=rating,proto3,oneof"`
+}
+
+type ArchiveRecord_Image struct {
+	Image *ImageSaved `protobuf:"bytes,4,opt,name=image,proto3,oneof"`
+}
+
+type ArchiveRecord_ImageChunk struct {
+	ImageChunk *ImageChunk `protobuf:"bytes,5,opt,name=image_chunk,json=imageChunk,proto3,oneof"`
+}
+
+type ArchiveRecord_PrimaryImage struct {
+	PrimaryImage *PrimaryImageSet `protobuf:"bytes,6,opt,name=primary_image,json=primaryImage,proto3,oneof"`
+}
+
+func (*ArchiveRecord_Header) isArchiveRecord_Data() {}
+
+func (*ArchiveRecord_Laptop) isArchiveRecord_Data() {}
+
+func (*ArchiveRecord_Rating) isArchiveRecord_Data() {}
+
+func (*ArchiveRecord_Image) isArchiveRecord_Data() {}
+
+func (*ArchiveRecord_ImageChunk) isArchiveRecord_Data() {}
+
+func (*ArchiveRecord_PrimaryImage) isArchiveRecord_Data() {}
+
+type ExportCatalogRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	IncludeImages bool `protobuf:"varint,1,opt,name=include_images,json=includeImages,proto3" json:"include_images,omitempty"`
+}
+
+func (x *ExportCatalogRequest) Reset() {
+	*x = ExportCatalogRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_archive_service_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ExportCatalogRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ExportCatalogRequest) ProtoMessage() {}
+
+func (x *ExportCatalogRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_archive_service_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ExportCatalogRequest.ProtoReflect.Descriptor instead.
+func (*ExportCatalogRequest) Descriptor() ([]byte, []int) {
+	return file_archive_service_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *ExportCatalogRequest) GetIncludeImages() bool {
+	if x != nil {
+		return x.IncludeImages
+	}
+	return false
+}
+
+// the number of records of each type of an archive
+type ArchiveSummary struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Laptops uint32 `protobuf:"varint,1,opt,name=laptops,proto3" json:"laptops,omitempty"`
+	// the laptops which already exist when the archive is imported, they are kept as they are
+	SkippedLaptops uint32 `protobuf:"varint,2,opt,name=skipped_laptops,json=skippedLaptops,proto3" json:"skipped_laptops,omitempty"`
+	Ratings        uint32 `protobuf:"varint,3,opt,name=ratings,proto3" json:"ratings,omitempty"`
+	Images         uint32 `protobuf:"varint,4,opt,name=images,proto3" json:"images,omitempty"`
+	PrimaryImages  uint32 `protobuf:"varint,5,opt,name=primary_images,json=primaryImages,proto3" json:"primary_images,omitempty"`
+	// the laptops which aren't valid, they aren't imported
+	InvalidLaptops uint32 `protobuf:"varint,6,opt,name=invalid_laptops,json=invalidLaptops,proto3" json:"invalid_laptops,omitempty"`
+	// the ratings of unknown laptops, or of laptops which are already rated, they aren't imported
+	SkippedRatings uint32 `protobuf:"varint,7,opt,name=skipped_ratings,json=skippedRatings,proto3" json:"skipped_ratings,omitempty"`
+}
+
+func (x *ArchiveSummary) Reset() {
+	*x = ArchiveSummary{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_archive_service_proto_msgTypes[4]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ArchiveSummary) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ArchiveSummary) ProtoMessage() {}
+
+func (x *ArchiveSummary) ProtoReflect() protoreflect.Message {
+	mi := &file_archive_service_proto_msgTypes[4]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ArchiveSummary.ProtoReflect.Descriptor instead.
+func (*ArchiveSummary) Descriptor() ([]byte, []int) {
+	return file_archive_service_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *ArchiveSummary) GetLaptops() uint32 {
+	if x != nil {
+		return x.Laptops
+	}
+	return 0
+}
+
+func (x *ArchiveSummary) GetSkippedLaptops() uint32 {
+	if x != nil {
+		return x.SkippedLaptops
+	}
+	return 0
+}
+
+func (x *ArchiveSummary) GetRatings() uint32 {
+	if x != nil {
+		return x.Ratings
+	}
+	return 0
+}
+
+func (x *ArchiveSummary) GetImages() uint32 {
+	if x != nil {
+		return x.Images
+	}
+	return 0
+}
+
+func (x *ArchiveSummary) GetPrimaryImages() uint32 {
+	if x != nil {
+		return x.PrimaryImages
+	}
+	return 0
+}
+
+func (x *ArchiveSummary) GetInvalidLaptops() uint32 {
+	if x != nil {
+		return x.InvalidLaptops
+	}
+	return 0
+}
+
+func (x *ArchiveSummary) GetSkippedRatings() uint32 {
+	if x != nil {
+		return x.SkippedRatings
+	}
+	return 0
+}
+
+var File_archive_service_proto protoreflect.FileDescriptor
+
+var file_archive_service_proto_rawDesc = []byte{
+	0x0a, 0x15, 0x61, 0x72, 0x63, 0x68, 0x69, 0x76, 0x65, 0x5f, 0x73, 0x65, 0x72, 0x76, 0x69, 0x63,
+	0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x11, 0x74, 0x65, 0x63, 0x68, 0x73, 0x63, 0x68,
+	0x6f, 0x6f, 0x6c, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x1a, 0x14, 0x6c, 0x61, 0x70, 0x74,
+	0x6f, 0x70, 0x5f, 0x6d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f,
+	0x1a, 0x19, 0x72, 0x65, 0x70, 0x6c, 0x69, 0x63, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x5f, 0x73, 0x65,
+	0x72, 0x76, 0x69, 0x63, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x1a, 0x1f, 0x67, 0x6f, 0x6f,
+	0x67, 0x6c, 0x65, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2f, 0x74, 0x69, 0x6d,
+	0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x22, 0x8d, 0x01, 0x0a,
+	0x0d, 0x41, 0x72, 0x63, 0x68, 0x69, 0x76, 0x65, 0x48, 0x65, 0x61, 0x64, 0x65, 0x72, 0x12, 0x18,
+	0x0a, 0x07, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0d, 0x52,
+	0x07, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x39, 0x0a, 0x0a, 0x63, 0x72, 0x65, 0x61,
+	0x74, 0x65, 0x64, 0x5f, 0x61, 0x74, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x67,
+	0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x54,
+	0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x52, 0x09, 0x63, 0x72, 0x65, 0x61, 0x74, 0x65,
+	0x64, 0x41, 0x74, 0x12, 0x27, 0x0a, 0x0f, 0x69, 0x6d, 0x61, 0x67, 0x65, 0x73, 0x5f, 0x69, 0x6e,
+	0x63, 0x6c, 0x75, 0x64, 0x65, 0x64, 0x18, 0x03, 0x20, 0x01, 0x28, 0x08, 0x52, 0x0e, 0x69, 0x6d,
+	0x61, 0x67, 0x65, 0x73, 0x49, 0x6e, 0x63, 0x6c, 0x75, 0x64, 0x65, 0x64, 0x22, 0x3b, 0x0a, 0x0a,
+	0x49, 0x6d, 0x61, 0x67, 0x65, 0x43, 0x68, 0x75, 0x6e, 0x6b, 0x12, 0x19, 0x0a, 0x08, 0x69, 0x6d,
+	0x61, 0x67, 0x65, 0x5f, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x69, 0x6d,
+	0x61, 0x67, 0x65, 0x49, 0x64, 0x12, 0x12, 0x0a, 0x04, 0x64, 0x61, 0x74, 0x61, 0x18, 0x02, 0x20,
+	0x01, 0x28, 0x0c, 0x52, 0x04, 0x64, 0x61, 0x74, 0x61, 0x22, 0x87, 0x03, 0x0a, 0x0d, 0x41, 0x72,
+	0x63, 0x68, 0x69, 0x76, 0x65, 0x52, 0x65, 0x63, 0x6f, 0x72, 0x64, 0x12, 0x3a, 0x0a, 0x06, 0x68,
+	0x65, 0x61, 0x64, 0x65, 0x72, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x20, 0x2e, 0x74, 0x65,
+	0x63, 0x68, 0x73, 0x63, 0x68, 0x6f, 0x6f, 0x6c, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e,
+	0x41, 0x72, 0x63, 0x68, 0x69, 0x76, 0x65, 0x48, 0x65, 0x61, 0x64, 0x65, 0x72, 0x48, 0x00, 0x52,
+	0x06, 0x68, 0x65, 0x61, 0x64, 0x65, 0x72, 0x12, 0x33, 0x0a, 0x06, 0x6c, 0x61, 0x70, 0x74, 0x6f,
+	0x70, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x19, 0x2e, 0x74, 0x65, 0x63, 0x68, 0x73, 0x63,
+	0x68, 0x6f, 0x6f, 0x6c, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x4c, 0x61, 0x70, 0x74,
+	0x6f, 0x70, 0x48, 0x00, 0x52, 0x06, 0x6c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x12, 0x39, 0x0a, 0x06,
+	0x72, 0x61, 0x74, 0x69, 0x6e, 0x67, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1f, 0x2e, 0x74,
+	0x65, 0x63, 0x68, 0x73, 0x63, 0x68, 0x6f, 0x6f, 0x6c, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b,
+	0x2e, 0x4c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x52, 0x61, 0x74, 0x69, 0x6e, 0x67, 0x48, 0x00, 0x52,
+	0x06, 0x72, 0x61, 0x74, 0x69, 0x6e, 0x67, 0x12, 0x35, 0x0a, 0x05, 0x69, 0x6d, 0x61, 0x67, 0x65,
+	0x18, 0x04, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1d, 0x2e, 0x74, 0x65, 0x63, 0x68, 0x73, 0x63, 0x68,
+	0x6f, 0x6f, 0x6c, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x49, 0x6d, 0x61, 0x67, 0x65,
+	0x53, 0x61, 0x76, 0x65, 0x64, 0x48, 0x00, 0x52, 0x05, 0x69, 0x6d, 0x61, 0x67, 0x65, 0x12, 0x40,
+	0x0a, 0x0b, 0x69, 0x6d, 0x61, 0x67, 0x65, 0x5f, 0x63, 0x68, 0x75, 0x6e, 0x6b, 0x18, 0x05, 0x20,
+	0x01, 0x28, 0x0b, 0x32, 0x1d, 0x2e, 0x74, 0x65, 0x63, 0x68, 0x73, 0x63, 0x68, 0x6f, 0x6f, 0x6c,
+	0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x49, 0x6d, 0x61, 0x67, 0x65, 0x43, 0x68, 0x75,
+	0x6e, 0x6b, 0x48, 0x00, 0x52, 0x0a, 0x69, 0x6d, 0x61, 0x67, 0x65, 0x43, 0x68, 0x75, 0x6e, 0x6b,
+	0x12, 0x49, 0x0a, 0x0d, 0x70, 0x72, 0x69, 0x6d, 0x61, 0x72, 0x79, 0x5f, 0x69, 0x6d, 0x61, 0x67,
+	0x65, 0x18, 0x06, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x22, 0x2e, 0x74, 0x65, 0x63, 0x68, 0x73, 0x63,
+	0x68, 0x6f, 0x6f, 0x6c, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x50, 0x72, 0x69, 0x6d,
+	0x61, 0x72, 0x79, 0x49, 0x6d, 0x61, 0x67, 0x65, 0x53, 0x65, 0x74, 0x48, 0x00, 0x52, 0x0c, 0x70,
+	0x72, 0x69, 0x6d, 0x61, 0x72, 0x79, 0x49, 0x6d, 0x61, 0x67, 0x65, 0x42, 0x06, 0x0a, 0x04, 0x64,
+	0x61, 0x74, 0x61, 0x22, 0x3d, 0x0a, 0x14, 0x45, 0x78, 0x70, 0x6f, 0x72, 0x74, 0x43, 0x61, 0x74,
+	0x61, 0x6c, 0x6f, 0x67, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x25, 0x0a, 0x0e, 0x69,
+	0x6e, 0x63, 0x6c, 0x75, 0x64, 0x65, 0x5f, 0x69, 0x6d, 0x61, 0x67, 0x65, 0x73, 0x18, 0x01, 0x20,
+	0x01, 0x28, 0x08, 0x52, 0x0d, 0x69, 0x6e, 0x63, 0x6c, 0x75, 0x64, 0x65, 0x49, 0x6d, 0x61, 0x67,
+	0x65, 0x73, 0x22, 0xfe, 0x01, 0x0a, 0x0e, 0x41, 0x72, 0x63, 0x68, 0x69, 0x76, 0x65, 0x53, 0x75,
+	0x6d, 0x6d, 0x61, 0x72, 0x79, 0x12, 0x18, 0x0a, 0x07, 0x6c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x73,
+	0x18, 0x01, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x07, 0x6c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x73, 0x12,
+	0x27, 0x0a, 0x0f, 0x73, 0x6b, 0x69, 0x70, 0x70, 0x65, 0x64, 0x5f, 0x6c, 0x61, 0x70, 0x74, 0x6f,
+	0x70, 0x73, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x0e, 0x73, 0x6b, 0x69, 0x70, 0x70, 0x65,
+	0x64, 0x4c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x73, 0x12, 0x18, 0x0a, 0x07, 0x72, 0x61, 0x74, 0x69,
+	0x6e, 0x67, 0x73, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x07, 0x72, 0x61, 0x74, 0x69, 0x6e,
+	0x67, 0x73, 0x12, 0x16, 0x0a, 0x06, 0x69, 0x6d, 0x61, 0x67, 0x65, 0x73, 0x18, 0x04, 0x20, 0x01,
+	0x28, 0x0d, 0x52, 0x06, 0x69, 0x6d, 0x61, 0x67, 0x65, 0x73, 0x12, 0x25, 0x0a, 0x0e, 0x70, 0x72,
+	0x69, 0x6d, 0x61, 0x72, 0x79, 0x5f, 0x69, 0x6d, 0x61, 0x67, 0x65, 0x73, 0x18, 0x05, 0x20, 0x01,
+	0x28, 0x0d, 0x52, 0x0d, 0x70, 0x72, 0x69, 0x6d, 0x61, 0x72, 0x79, 0x49, 0x6d, 0x61, 0x67, 0x65,
+	0x73, 0x12, 0x27, 0x0a, 0x0f, 0x69, 0x6e, 0x76, 0x61, 0x6c, 0x69, 0x64, 0x5f, 0x6c, 0x61, 0x70,
+	0x74, 0x6f, 0x70, 0x73, 0x18, 0x06, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x0e, 0x69, 0x6e, 0x76, 0x61,
+	0x6c, 0x69, 0x64, 0x4c, 0x61, 0x70, 0x74, 0x6f, 0x70, 0x73, 0x12, 0x27, 0x0a, 0x0f, 0x73, 0x6b,
+	0x69, 0x70, 0x70, 0x65, 0x64, 0x5f, 0x72, 0x61, 0x74, 0x69, 0x6e, 0x67, 0x73, 0x18, 0x07, 0x20,
+	0x01, 0x28, 0x0d, 0x52, 0x0e, 0x73, 0x6b, 0x69, 0x70, 0x70, 0x65, 0x64, 0x52, 0x61, 0x74, 0x69,
+	0x6e, 0x67, 0x73, 0x32, 0xca, 0x01, 0x0a, 0x0e, 0x41, 0x72, 0x63, 0x68, 0x69, 0x76, 0x65, 0x53,
+	0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x12, 0x5e, 0x0a, 0x0d, 0x45, 0x78, 0x70, 0x6f, 0x72, 0x74,
+	0x43, 0x61, 0x74, 0x61, 0x6c, 0x6f, 0x67, 0x12, 0x27, 0x2e, 0x74, 0x65, 0x63, 0x68, 0x73, 0x63,
+	0x68, 0x6f, 0x6f, 0x6c, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x45, 0x78, 0x70, 0x6f,
+	0x72, 0x74, 0x43, 0x61, 0x74, 0x61, 0x6c, 0x6f, 0x67, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74,
+	0x1a, 0x20, 0x2e, 0x74, 0x65, 0x63, 0x68, 0x73, 0x63, 0x68, 0x6f, 0x6f, 0x6c, 0x2e, 0x70, 0x63,
+	0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x41, 0x72, 0x63, 0x68, 0x69, 0x76, 0x65, 0x52, 0x65, 0x63, 0x6f,
+	0x72, 0x64, 0x22, 0x00, 0x30, 0x01, 0x12, 0x58, 0x0a, 0x0d, 0x49, 0x6d, 0x70, 0x6f, 0x72, 0x74,
+	0x43, 0x61, 0x74, 0x61, 0x6c, 0x6f, 0x67, 0x12, 0x20, 0x2e, 0x74, 0x65, 0x63, 0x68, 0x73, 0x63,
+	0x68, 0x6f, 0x6f, 0x6c, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x41, 0x72, 0x63, 0x68,
+	0x69, 0x76, 0x65, 0x52, 0x65, 0x63, 0x6f, 0x72, 0x64, 0x1a, 0x21, 0x2e, 0x74, 0x65, 0x63, 0x68,
+	0x73, 0x63, 0x68, 0x6f, 0x6f, 0x6c, 0x2e, 0x70, 0x63, 0x62, 0x6f, 0x6f, 0x6b, 0x2e, 0x41, 0x72,
+	0x63, 0x68, 0x69, 0x76, 0x65, 0x53, 0x75, 0x6d, 0x6d, 0x61, 0x72, 0x79, 0x22, 0x00, 0x28, 0x01,
+	0x42, 0x06, 0x5a, 0x04, 0x2e, 0x3b, 0x70, 0x62, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_archive_service_proto_rawDescOnce sync.Once
+	file_archive_service_proto_rawDescData = file_archive_service_proto_rawDesc
+)
+
+func file_archive_service_proto_rawDescGZIP() []byte {
+	file_archive_service_proto_rawDescOnce.Do(func() {
+		file_archive_service_proto_rawDescData = protoimpl.X.CompressGZIP(file_archive_service_proto_rawDescData)
+	})
+	return file_archive_service_proto_rawDescData
+}
+
+var file_archive_service_proto_msgTypes = make([]protoimpl.MessageInfo, 5)
+var file_archive_service_proto_goTypes = []interface{}{
+	(*ArchiveHeader)(nil),        // 0: techschool.pcbook.ArchiveHeader
+	(*ImageChunk)(nil),           // 1: techschool.pcbook.ImageChunk
+	(*ArchiveRecord)(nil),        // 2: techschool.pcbook.ArchiveRecord
+	(*ExportCatalogRequest)(nil), // 3: techschool.pcbook.ExportCatalogRequest
+	(*ArchiveSummary)(nil),       // 4: techschool.pcbook.ArchiveSummary
+	(*timestamp.Timestamp)(nil),  // 5: google.protobuf.Timestamp
+	(*Laptop)(nil),               // 6: techschool.pcbook.Laptop
+	(*LaptopRating)(nil),         // 7: techschool.pcbook.LaptopRating
+	(*ImageSaved)(nil),           // 8: techschool.pcbook.ImageSaved
+	(*PrimaryImageSet)(nil),      // 9: techschool.pcbook.PrimaryImageSet
+}
+var file_archive_service_proto_depIdxs = []int32{
+	5, // 0: techschool.pcbook.ArchiveHeader.created_at:type_name -> google.protobuf.Timestamp
+	0, // 1: techschool.pcbook.ArchiveRecord.header:type_name -> techschool.pcbook.ArchiveHeader
+	6, // 2: techschool.pcbook.ArchiveRecord.laptop:type_name -> techschool.pcbook.Laptop
+	7, // 3: techschool.pcbook.ArchiveRecord.rating:type_name -> techschool.pcbook.LaptopRating
+	8, // 4: techschool.pcbook.ArchiveRecord.image:type_name -> techschool.pcbook.ImageSaved
+	1, // 5: techschool.pcbook.ArchiveRecord.image_chunk:type_name -> techschool.pcbook.ImageChunk
+	9, // 6: techschool.pcbook.ArchiveRecord.primary_image:type_name -> techschool.pcbook.PrimaryImageSet
+	3, // 7: techschool.pcbook.ArchiveService.ExportCatalog:input_type -> techschool.pcbook.ExportCatalogRequest
+	2, // 8: techschool.pcbook.ArchiveService.ImportCatalog:input_type -> techschool.pcbook.ArchiveRecord
+	2, // 9: techschool.pcbook.ArchiveService.ExportCatalog:output_type -> techschool.pcbook.ArchiveRecord
+	4, // 10: techschool.pcbook.ArchiveService.ImportCatalog:output_type -> techschool.pcbook.ArchiveSummary
+	9, // [9:11] is the sub-list for method output_type
+	7, // [7:9] is the sub-list for method input_type
+	7, // [7:7] is the sub-list for extension type_name
+	7, // [7:7] is the sub-list for extension extendee
+	0, // [0:7] is the sub-list for field type_name
+}
+
+func init() { file_archive_service_proto_init() }
+func file_archive_service_proto_init() {
+	if File_archive_service_proto != nil {
+		return
+	}
+	file_laptop_message_proto_init()
+	file_replication_service_proto_init()
+	if !protoimpl.UnsafeEnabled {
+		file_archive_service_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ArchiveHeader); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_archive_service_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ImageChunk); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_archive_service_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ArchiveRecord); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_archive_service_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ExportCatalogRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_archive_service_proto_msgTypes[4].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ArchiveSummary); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	file_archive_service_proto_msgTypes[2].OneofWrappers = []interface{}{
+		(*ArchiveRecord_Header)(nil),
+		(*ArchiveRecord_Laptop)(nil),
+		(*ArchiveRecord_Rating)(nil),
+		(*ArchiveRecord_Image)(nil),
+		(*ArchiveRecord_ImageChunk)(nil),
+		(*ArchiveRecord_PrimaryImage)(nil),
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_archive_service_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   5,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_archive_service_proto_goTypes,
+		DependencyIndexes: file_archive_service_proto_depIdxs,
+		MessageInfos:      file_archive_service_proto_msgTypes,
+	}.Build()
+	File_archive_service_proto = out.File
+	file_archive_service_proto_rawDesc = nil
+	file_archive_service_proto_goTypes = nil
+	file_archive_service_proto_depIdxs = nil
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConnInterface
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion6
+
+// ArchiveServiceClient is the client API for ArchiveService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
+type ArchiveServiceClient interface {
+	// streams the records of an archive of the whole catalog
+	ExportCatalog(ctx context.Context, in *ExportCatalogRequest, opts ...grpc.CallOption) (ArchiveService_ExportCatalogClient, error)
+	// adds the records of an archive to the catalog, the primary images replace the existing ones
+	// but the ratings of the laptops which are already rated are kept
+	ImportCatalog(ctx context.Context, opts ...grpc.CallOption) (ArchiveService_ImportCatalogClient, error)
+}
+
+type archiveServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewArchiveServiceClient(cc grpc.ClientConnInterface) ArchiveServiceClient {
+	return &archiveServiceClient{cc}
+}
+
+func (c *archiveServiceClient) ExportCatalog(ctx context.Context, in *ExportCatalogRequest, opts ...grpc.CallOption) (ArchiveService_ExportCatalogClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_ArchiveService_serviceDesc.Streams[0], "/techschool.pcbook.ArchiveService/ExportCatalog", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &archiveServiceExportCatalogClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type ArchiveService_ExportCatalogClient interface {
+	Recv() (*ArchiveRecord, error)
+	grpc.ClientStream
+}
+
+type archiveServiceExportCatalogClient struct {
+	grpc.ClientStream
+}
+
+func (x *archiveServiceExportCatalogClient) Recv() (*ArchiveRecord, error) {
+	m := new(ArchiveRecord)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *archiveServiceClient) ImportCatalog(ctx context.Context, opts ...grpc.CallOption) (ArchiveService_ImportCatalogClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_ArchiveService_serviceDesc.Streams[1], "/techschool.pcbook.ArchiveService/ImportCatalog", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &archiveServiceImportCatalogClient{stream}
+	return x, nil
+}
+
+type ArchiveService_ImportCatalogClient interface {
+	Send(*ArchiveRecord) error
+	CloseAndRecv() (*ArchiveSummary, error)
+	grpc.ClientStream
+}
+
+type archiveServiceImportCatalogClient struct {
+	grpc.ClientStream
+}
+
+func (x *archiveServiceImportCatalogClient) Send(m *ArchiveRecord) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *archiveServiceImportCatalogClient) CloseAndRecv() (*ArchiveSummary, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(ArchiveSummary)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// ArchiveServiceServer is the server API for ArchiveService service.
+type ArchiveServiceServer interface {
+	// streams the records of an archive of the whole catalog
+	ExportCatalog(*ExportCatalogRequest, ArchiveService_ExportCatalogServer) error
+	// adds the records of an archive to the catalog, the primary images replace the existing ones
+	// but the ratings of the laptops which are already rated are kept
+	ImportCatalog(ArchiveService_ImportCatalogServer) error
+}
+
+// UnimplementedArchiveServiceServer can be embedded to have forward compatible implementations.
+type UnimplementedArchiveServiceServer struct {
+}
+
+func (*UnimplementedArchiveServiceServer) ExportCatalog(*ExportCatalogRequest, ArchiveService_ExportCatalogServer) error {
+	return status.Errorf(codes.Unimplemented, "method ExportCatalog not implemented")
+}
+func (*UnimplementedArchiveServiceServer) ImportCatalog(ArchiveService_ImportCatalogServer) error {
+	return status.Errorf(codes.Unimplemented, "method ImportCatalog not implemented")
+}
+
+func RegisterArchiveServiceServer(s *grpc.Server, srv ArchiveServiceServer) {
+	s.RegisterService(&_ArchiveService_serviceDesc, srv)
+}
+
+func _ArchiveService_ExportCatalog_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ExportCatalogRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ArchiveServiceServer).ExportCatalog(m, &archiveServiceExportCatalogServer{stream})
+}
+
+type ArchiveService_ExportCatalogServer interface {
+	Send(*ArchiveRecord) error
+	grpc.ServerStream
+}
+
+type archiveServiceExportCatalogServer struct {
+	grpc.ServerStream
+}
+
+func (x *archiveServiceExportCatalogServer) Send(m *ArchiveRecord) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _ArchiveService_ImportCatalog_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(ArchiveServiceServer).ImportCatalog(&archiveServiceImportCatalogServer{stream})
+}
+
+type ArchiveService_ImportCatalogServer interface {
+	SendAndClose(*ArchiveSummary) error
+	Recv() (*ArchiveRecord, error)
+	grpc.ServerStream
+}
+
+type archiveServiceImportCatalogServer struct {
+	grpc.ServerStream
+}
+
+func (x *archiveServiceImportCatalogServer) SendAndClose(m *ArchiveSummary) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *archiveServiceImportCatalogServer) Recv() (*ArchiveRecord, error) {
+	m := new(ArchiveRecord)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+var _ArchiveService_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "techschool.pcbook.ArchiveService",
+	HandlerType: (*ArchiveServiceServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "ExportCatalog",
+			Handler:       _ArchiveService_ExportCatalog_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "ImportCatalog",
+			Handler:       _ArchiveService_ImportCatalog_Handler,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "archive_service.proto",
+}
@@ -0,0 +1,250 @@
+package raft
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hjcian/grpc-notes/pb"
+	"github.com/hjcian/grpc-notes/service"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Catalog is the state machine of the laptops, their ratings and the idempotency keys which created them,
+// the images aren't replicated. Its commands are the changes of the replication package.
+type Catalog struct {
+	laptops     *service.InMemoryLaptopStore
+	ratings     *service.InMemoryRatingStore
+	idempotency *service.InMemoryIdempotencyStore
+
+	mutex sync.Mutex
+	// pendingKeys are the idempotency keys of the laptops which aren't proposed yet, by laptop ID
+	pendingKeys map[string]string
+}
+
+// NewCatalog returns a new Catalog of the stores, which must only be written by the Catalog.
+// The idempotency store is nil if the keys aren't remembered.
+func NewCatalog(
+	laptops *service.InMemoryLaptopStore,
+	ratings *service.InMemoryRatingStore,
+	idempotency *service.InMemoryIdempotencyStore,
+) *Catalog {
+	return &Catalog{
+		laptops:     laptops,
+		ratings:     ratings,
+		idempotency: idempotency,
+		pendingKeys: make(map[string]string),
+	}
+}
+
+// Apply applies a change
+func (c *Catalog) Apply(command []byte) (interface{}, error) {
+	change := &pb.Change{}
+	err := proto.Unmarshal(command, change)
+	if err != nil {
+		return nil, fmt.Errorf("cannot unmarshal change: %w", err)
+	}
+
+	switch data := change.GetData().(type) {
+	case *pb.Change_LaptopsCreated:
+		laptops := data.LaptopsCreated.GetLaptops()
+		if len(laptops) == 1 {
+			// keep the errors of Save, which aren't wrapped with the laptop ID
+			err = c.laptops.Save(laptops[0])
+		} else {
+			err = c.laptops.SaveAll(laptops)
+		}
+		if err != nil {
+			return nil, err
+		}
+		// every node remembers the keys, so that the retries after a failover find the laptops
+		if c.idempotency != nil {
+			for _, key := range data.LaptopsCreated.GetIdempotencyKeys() {
+				c.idempotency.Store(key.GetKey(), key.GetLaptopId())
+			}
+		}
+		return nil, nil
+	case *pb.Change_RatingAdded:
+		return c.ratings.Add(data.RatingAdded.GetLaptopId(), data.RatingAdded.GetScore())
+	default:
+		return nil, fmt.Errorf("unexpected change %T", data)
+	}
+}
+
+// Snapshot returns the laptops, the ratings and the idempotency keys
+func (c *Catalog) Snapshot() ([]byte, error) {
+	snapshot := &pb.Snapshot{}
+	err := c.laptops.All(func(laptop *pb.Laptop) error {
+		snapshot.Laptops = append(snapshot.Laptops, laptop)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for laptopID, rating := range c.ratings.All() {
+		snapshot.Ratings = append(snapshot.Ratings, &pb.LaptopRating{
+			LaptopId: laptopID,
+			Count:    rating.Count,
+			Sum:      rating.Sum,
+		})
+	}
+
+	if c.idempotency != nil {
+		for key, record := range c.idempotency.All() {
+			snapshot.IdempotencyKeys = append(snapshot.IdempotencyKeys, &pb.IdempotencyKey{
+				Key:       key,
+				LaptopId:  record.LaptopID,
+				ExpiresAt: timestamppb.New(record.ExpiresAt),
+			})
+		}
+	}
+	return proto.Marshal(snapshot)
+}
+
+// Restore merges the snapshot into the stores. The catalog only grows, so a snapshot
+// contains everything the stores have applied before it.
+func (c *Catalog) Restore(data []byte) error {
+	snapshot := &pb.Snapshot{}
+	err := proto.Unmarshal(data, snapshot)
+	if err != nil {
+		return fmt.Errorf("cannot unmarshal snapshot: %w", err)
+	}
+
+	for _, laptop := range snapshot.GetLaptops() {
+		err := c.laptops.Save(laptop)
+		if err != nil && !errors.Is(err, service.ErrAlreadyExists) {
+			return fmt.Errorf("cannot save laptop %s: %w", laptop.GetId(), err)
+		}
+	}
+	for _, rating := range snapshot.GetRatings() {
+		c.ratings.Set(rating.GetLaptopId(), service.Rating{
+			Count: rating.GetCount(),
+			Sum:   rating.GetSum(),
+		})
+	}
+	if c.idempotency != nil {
+		for _, key := range snapshot.GetIdempotencyKeys() {
+			c.idempotency.Set(key.GetKey(), service.IdempotencyRecord{
+				LaptopID:  key.GetLaptopId(),
+				ExpiresAt: key.GetExpiresAt().AsTime(),
+			})
+		}
+	}
+	return nil
+}
+
+// LaptopStore returns the laptop store committing the saved laptops through the node,
+// the reads are served by the local store
+func (c *Catalog) LaptopStore(node *Node) service.LaptopStore {
+	return &catalogLaptopStore{InMemoryLaptopStore: c.laptops, catalog: c, node: node}
+}
+
+// RatingStore returns the rating store committing the ratings through the node
+func (c *Catalog) RatingStore(node *Node) service.RatingStore {
+	return &catalogRatingStore{InMemoryRatingStore: c.ratings, node: node}
+}
+
+// IdempotencyStore returns the idempotency store whose keys are committed with their laptops
+// by the laptop store of the Catalog, the Catalog must have an idempotency store
+func (c *Catalog) IdempotencyStore() service.IdempotencyStore {
+	return &catalogIdempotencyStore{InMemoryIdempotencyStore: c.idempotency, catalog: c}
+}
+
+// takePendingKeys returns the idempotency keys of the laptops, which are no longer pending
+func (c *Catalog) takePendingKeys(laptops []*pb.Laptop) []*pb.IdempotencyKey {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var keys []*pb.IdempotencyKey
+	for _, laptop := range laptops {
+		if key, ok := c.pendingKeys[laptop.GetId()]; ok {
+			keys = append(keys, &pb.IdempotencyKey{Key: key, LaptopId: laptop.GetId()})
+			delete(c.pendingKeys, laptop.GetId())
+		}
+	}
+	return keys
+}
+
+// propose commits the change and returns the result of its application
+func propose(node *Node, change *pb.Change) (interface{}, error) {
+	command, err := proto.Marshal(change)
+	if err != nil {
+		return nil, fmt.Errorf("cannot marshal change: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), node.config.proposeTimeout)
+	defer cancel()
+
+	res, err := node.Propose(ctx, command)
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("%w: change not committed in %s", service.ErrUnavailable, node.config.proposeTimeout)
+	}
+	return res, err
+}
+
+type catalogLaptopStore struct {
+	*service.InMemoryLaptopStore
+	catalog *Catalog
+	node    *Node
+}
+
+func (s *catalogLaptopStore) Save(laptop *pb.Laptop) error {
+	return s.SaveAll([]*pb.Laptop{laptop})
+}
+
+func (s *catalogLaptopStore) SaveAll(laptops []*pb.Laptop) error {
+	_, err := propose(s.node, &pb.Change{Data: &pb.Change_LaptopsCreated{
+		LaptopsCreated: &pb.LaptopsCreated{
+			Laptops:         laptops,
+			IdempotencyKeys: s.catalog.takePendingKeys(laptops),
+		},
+	}})
+	return err
+}
+
+type catalogRatingStore struct {
+	*service.InMemoryRatingStore
+	node *Node
+}
+
+func (s *catalogRatingStore) Add(laptopID string, score float64) (*service.Rating, error) {
+	res, err := propose(s.node, &pb.Change{Data: &pb.Change_RatingAdded{
+		RatingAdded: &pb.RatingAdded{LaptopId: laptopID, Score: score},
+	}})
+	if err != nil {
+		return nil, err
+	}
+	return res.(*service.Rating), nil
+}
+
+// catalogIdempotencyStore remembers the keys locally until they're committed with their laptops
+type catalogIdempotencyStore struct {
+	*service.InMemoryIdempotencyStore
+	catalog *Catalog
+}
+
+func (s *catalogIdempotencyStore) LoadOrStore(key, laptopID string) (string, bool, error) {
+	id, loaded, err := s.InMemoryIdempotencyStore.LoadOrStore(key, laptopID)
+	if err != nil || loaded {
+		return id, loaded, err
+	}
+
+	s.catalog.mutex.Lock()
+	defer s.catalog.mutex.Unlock()
+
+	s.catalog.pendingKeys[laptopID] = key
+	return id, false, nil
+}
+
+func (s *catalogIdempotencyStore) Forget(key, laptopID string) error {
+	s.catalog.mutex.Lock()
+	if s.catalog.pendingKeys[laptopID] == key {
+		delete(s.catalog.pendingKeys, laptopID)
+	}
+	s.catalog.mutex.Unlock()
+
+	return s.InMemoryIdempotencyStore.Forget(key, laptopID)
+}
@@ -0,0 +1,151 @@
+package raft_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hjcian/grpc-notes/pb"
+	"github.com/hjcian/grpc-notes/raft"
+)
+
+var errUnreachable = errors.New("node is unreachable")
+
+var errTooLarge = errors.New("message is larger than the gRPC transport accepts")
+
+// network connects the nodes in-process, the links between the nodes can be cut to partition them
+type network struct {
+	mutex sync.Mutex
+	nodes map[string]*raft.Node
+	// cut has both directions of the cut links
+	cut map[[2]string]bool
+}
+
+func newNetwork() *network {
+	return &network{
+		nodes: make(map[string]*raft.Node),
+		cut:   make(map[[2]string]bool),
+	}
+}
+
+// attach connects the node, replacing the previous node of the same ID
+func (n *network) attach(node *raft.Node) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	n.nodes[node.ID()] = node
+}
+
+// detach disconnects the node, as if it has crashed
+func (n *network) detach(id string) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	delete(n.nodes, id)
+}
+
+// partition cuts the links between the groups of nodes, the links within a group are kept
+func (n *network) partition(groups ...[]string) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	for i, group := range groups {
+		for _, other := range groups[i+1:] {
+			for _, from := range group {
+				for _, to := range other {
+					n.cut[[2]string{from, to}] = true
+					n.cut[[2]string{to, from}] = true
+				}
+			}
+		}
+	}
+}
+
+// heal restores all the links
+func (n *network) heal() {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	n.cut = make(map[[2]string]bool)
+}
+
+// node returns the node reachable from another node
+func (n *network) node(from, to string) (*raft.Node, error) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	node, ok := n.nodes[to]
+	if !ok || n.cut[[2]string{from, to}] {
+		return nil, errUnreachable
+	}
+	return node, nil
+}
+
+// send calls the RPC on the peer with a copy of the request, the response is lost if the link is cut meanwhile.
+// The request is rejected if it's larger than the messages of the GRPCTransport.
+func (n *network) send(from, to string, req proto.Message, call func(node *raft.Node, req proto.Message) (proto.Message, error)) (proto.Message, error) {
+	if proto.Size(req) > raft.GRPCMessageSize {
+		return nil, errTooLarge
+	}
+	node, err := n.node(from, to)
+	if err != nil {
+		return nil, err
+	}
+	res, err := call(node, proto.Clone(req))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := n.node(to, from); err != nil {
+		return nil, err
+	}
+	return proto.Clone(res), nil
+}
+
+// transport is the Transport of a node to the network
+type transport struct {
+	network *network
+	from    string
+}
+
+func (t *transport) RequestVote(
+	ctx context.Context,
+	peer string,
+	req *pb.RequestVoteRequest,
+) (*pb.RequestVoteResponse, error) {
+	res, err := t.network.send(t.from, peer, req, func(node *raft.Node, req proto.Message) (proto.Message, error) {
+		return node.RequestVote(ctx, req.(*pb.RequestVoteRequest))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res.(*pb.RequestVoteResponse), nil
+}
+
+func (t *transport) AppendEntries(
+	ctx context.Context,
+	peer string,
+	req *pb.AppendEntriesRequest,
+) (*pb.AppendEntriesResponse, error) {
+	res, err := t.network.send(t.from, peer, req, func(node *raft.Node, req proto.Message) (proto.Message, error) {
+		return node.AppendEntries(ctx, req.(*pb.AppendEntriesRequest))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res.(*pb.AppendEntriesResponse), nil
+}
+
+func (t *transport) InstallSnapshot(
+	ctx context.Context,
+	peer string,
+	req *pb.InstallSnapshotRequest,
+) (*pb.InstallSnapshotResponse, error) {
+	res, err := t.network.send(t.from, peer, req, func(node *raft.Node, req proto.Message) (proto.Message, error) {
+		return node.InstallSnapshot(ctx, req.(*pb.InstallSnapshotRequest))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res.(*pb.InstallSnapshotResponse), nil
+}
@@ -0,0 +1,857 @@
+package raft
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hjcian/grpc-notes/pb"
+	"github.com/hjcian/grpc-notes/service"
+	"go.uber.org/zap"
+)
+
+// StateMachine is the state replicated by the log, it applies the committed commands in the order of the log
+type StateMachine interface {
+	// Apply applies the command and returns its result, it must be deterministic
+	Apply(command []byte) (interface{}, error)
+	// Snapshot returns the state after the last applied command
+	Snapshot() ([]byte, error)
+	// Restore replaces the state by the snapshot
+	Restore(snapshot []byte) error
+}
+
+// ErrNotLeader is returned by Node.Propose on a node which isn't the leader,
+// it's unavailable so that the clients retry on another node
+var ErrNotLeader = fmt.Errorf("not the raft leader: %w", service.ErrUnavailable)
+
+// ErrLeadershipLost is returned by Node.Propose when the proposed entry is replaced by the entry of a new leader
+var ErrLeadershipLost = fmt.Errorf("raft leadership lost before the entry is committed: %w", service.ErrUnavailable)
+
+// ErrStopped is returned by a node which isn't running
+var ErrStopped = fmt.Errorf("raft node is stopped: %w", service.ErrUnavailable)
+
+// NodeHealthName is the name of the node reported by the health server
+const NodeHealthName = "raft"
+
+// ErrNoLeader is returned by Node.CheckHealth when the node doesn't know the leader
+var ErrNoLeader = errors.New("no known raft leader")
+
+type role int
+
+const (
+	follower role = iota
+	candidate
+	leader
+)
+
+func (r role) String() string {
+	switch r {
+	case follower:
+		return "follower"
+	case candidate:
+		return "candidate"
+	default:
+		return "leader"
+	}
+}
+
+type result struct {
+	value interface{}
+	err   error
+}
+
+// waiter is waiting for the result of the entry proposed in term
+type waiter struct {
+	term uint64
+	done chan result
+}
+
+// Node is a member of a raft cluster. The leader appends the proposed commands to its log,
+// they are applied to the state machine of every node once a quorum has stored them.
+type Node struct {
+	mutex     sync.Mutex
+	id        string
+	peers     []string
+	sm        StateMachine
+	storage   Storage
+	transport Transport
+	config    *config
+	random    *rand.Rand
+
+	role     role
+	term     uint64
+	votedFor string
+	leaderID string
+	// log[0] is the last entry of the snapshot, or the zero entry before any snapshot
+	log         []*pb.RaftEntry
+	snapshot    *pb.RaftSnapshot
+	commitIndex uint64
+	lastApplied uint64
+	// pendingSnapshot is installed by the leader, it's restored by the applier
+	pendingSnapshot *pb.RaftSnapshot
+	// receivedSnapshot has the chunks of the snapshot received so far from the leader
+	receivedSnapshot *pb.RaftSnapshot
+	waiters          map[uint64]waiter
+	applyCond        *sync.Cond
+	stopped          bool
+
+	electionDeadline time.Time
+
+	// the replication state of the leader
+	leaderSince time.Time
+	nextIndex   map[string]uint64
+	matchIndex  map[string]uint64
+	lastContact map[string]time.Time
+	triggers    map[string]chan struct{}
+}
+
+// NewNode returns a new Node of the cluster of its peers, their IDs are the addresses of the GRPCTransport.
+// The node restores the state and the snapshot saved in the storage.
+func NewNode(
+	id string,
+	peers []string,
+	sm StateMachine,
+	storage Storage,
+	transport Transport,
+	opts ...Option,
+) (*Node, error) {
+	n := &Node{
+		id:        id,
+		peers:     peers,
+		sm:        sm,
+		storage:   storage,
+		transport: transport,
+		config:    newConfig(opts),
+		random:    rand.New(rand.NewSource(time.Now().UnixNano())),
+		log:       []*pb.RaftEntry{{}},
+		waiters:   make(map[uint64]waiter),
+		stopped:   true,
+	}
+	n.applyCond = sync.NewCond(&n.mutex)
+
+	state, entries, snapshot, err := storage.Load()
+	if err != nil {
+		return nil, fmt.Errorf("cannot load raft state: %w", err)
+	}
+	if snapshot != nil {
+		err = sm.Restore(snapshot.GetData())
+		if err != nil {
+			return nil, fmt.Errorf("cannot restore snapshot %d: %w", snapshot.GetIndex(), err)
+		}
+		n.snapshot = snapshot
+		n.log[0] = &pb.RaftEntry{Index: snapshot.GetIndex(), Term: snapshot.GetTerm()}
+		n.commitIndex = snapshot.GetIndex()
+		n.lastApplied = snapshot.GetIndex()
+	}
+	n.term = state.GetTerm()
+	n.votedFor = state.GetVotedFor()
+	for _, entry := range entries {
+		// the entries may be saved before the snapshot compacting them
+		if entry.GetIndex() == n.lastIndex()+1 {
+			n.log = append(n.log, entry)
+		}
+	}
+	return n, nil
+}
+
+// ID returns the ID of the node
+func (n *Node) ID() string {
+	return n.id
+}
+
+// Leader returns the ID of the leader known by the node, empty if there is none
+func (n *Node) Leader() string {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	return n.leaderID
+}
+
+// IsLeader tells if the node is the leader
+func (n *Node) IsLeader() bool {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	return n.role == leader
+}
+
+// Term returns the current term of the node
+func (n *Node) Term() uint64 {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	return n.term
+}
+
+// AppliedIndex returns the index of the last entry applied to the state machine
+func (n *Node) AppliedIndex() uint64 {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	return n.lastApplied
+}
+
+// CheckHealth fails if the node doesn't know the leader, the writes would fail
+func (n *Node) CheckHealth() error {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if n.stopped {
+		return ErrStopped
+	}
+	if len(n.leaderID) == 0 {
+		return ErrNoLeader
+	}
+	return nil
+}
+
+// Run runs the node until the context is done, it fails if the state machine can't be snapshotted or restored
+func (n *Node) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	n.mutex.Lock()
+	n.stopped = false
+	n.resetElectionDeadline()
+	n.mutex.Unlock()
+	defer n.stop()
+
+	applied := make(chan error, 1)
+	go func() {
+		applied <- n.runApplier()
+	}()
+
+	ticker := time.NewTicker(n.config.electionTimeout / 10)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			n.stop()
+			return <-applied
+		case err := <-applied:
+			return err
+		case <-ticker.C:
+			n.tick(ctx)
+		}
+	}
+}
+
+// stop fails the pending proposals and stops the applier
+func (n *Node) stop() {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if n.stopped {
+		return
+	}
+	n.stopped = true
+	n.role = follower
+	n.leaderID = ""
+	n.triggers = nil
+	for index, waiter := range n.waiters {
+		waiter.done <- result{err: ErrStopped}
+		delete(n.waiters, index)
+	}
+	n.applyCond.Broadcast()
+}
+
+// Propose appends the command to the log of the leader and returns the result of its application
+func (n *Node) Propose(ctx context.Context, command []byte) (interface{}, error) {
+	n.mutex.Lock()
+	if n.stopped {
+		n.mutex.Unlock()
+		return nil, ErrStopped
+	}
+	if n.role != leader {
+		err := n.notLeaderError()
+		n.mutex.Unlock()
+		return nil, err
+	}
+
+	entries := n.split(command)
+	err := n.append(entries)
+	if err != nil {
+		n.mutex.Unlock()
+		return nil, err
+	}
+
+	// the result is the one of the last entry, which completes the command
+	entry := entries[len(entries)-1]
+	done := make(chan result, 1)
+	n.waiters[entry.Index] = waiter{term: entry.Term, done: done}
+	n.advanceCommit()
+	n.triggerReplication()
+	n.mutex.Unlock()
+
+	select {
+	case res := <-done:
+		return res.value, res.err
+	case <-ctx.Done():
+		n.mutex.Lock()
+		delete(n.waiters, entry.Index)
+		n.mutex.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// split returns the entries of the command after the last index,
+// each of them has at most the max message size of the command
+func (n *Node) split(command []byte) []*pb.RaftEntry {
+	var entries []*pb.RaftEntry
+	for {
+		entry := &pb.RaftEntry{
+			Index:   n.lastIndex() + uint64(len(entries)) + 1,
+			Term:    n.term,
+			Command: command,
+		}
+		entries = append(entries, entry)
+		if len(command) <= n.config.maxMessageSize {
+			return entries
+		}
+		entry.Command = command[:n.config.maxMessageSize]
+		entry.Partial = true
+		command = command[n.config.maxMessageSize:]
+	}
+}
+
+func (n *Node) notLeaderError() error {
+	if len(n.leaderID) == 0 {
+		return ErrNotLeader
+	}
+	return fmt.Errorf("%w, the leader is %s", ErrNotLeader, n.leaderID)
+}
+
+func (n *Node) snapshotIndex() uint64 {
+	return n.log[0].GetIndex()
+}
+
+func (n *Node) lastIndex() uint64 {
+	return n.log[len(n.log)-1].GetIndex()
+}
+
+func (n *Node) lastTerm() uint64 {
+	return n.log[len(n.log)-1].GetTerm()
+}
+
+// termAt returns the term of the entry at an index between the snapshot and the last index
+func (n *Node) termAt(index uint64) uint64 {
+	return n.log[index-n.snapshotIndex()].GetTerm()
+}
+
+// entries returns a copy of the entries from the first index to the last one included
+func (n *Node) entries(first, last uint64) []*pb.RaftEntry {
+	offset := n.snapshotIndex()
+	return append([]*pb.RaftEntry(nil), n.log[first-offset:last-offset+1]...)
+}
+
+// entriesToSend returns the entries from the first index which fit in the max message size,
+// with at least the first one
+func (n *Node) entriesToSend(first uint64) []*pb.RaftEntry {
+	last, size := first, proto.Size(n.log[first-n.snapshotIndex()])
+	for last < n.lastIndex() {
+		size += proto.Size(n.log[last+1-n.snapshotIndex()])
+		if size > n.config.maxMessageSize {
+			break
+		}
+		last++
+	}
+	return n.entries(first, last)
+}
+
+func (n *Node) quorum() int {
+	return (len(n.peers)+1)/2 + 1
+}
+
+// persist saves the term and the vote, before the node answers an RPC or starts an election
+func (n *Node) persist() error {
+	err := n.storage.SaveState(&pb.RaftState{
+		Term:     n.term,
+		VotedFor: n.votedFor,
+	})
+	if err != nil {
+		return fmt.Errorf("cannot save raft state: %w", err)
+	}
+	return nil
+}
+
+// append saves the entries after the last index and appends them to the log,
+// before the node answers an RPC or proposes an entry
+func (n *Node) append(entries []*pb.RaftEntry) error {
+	err := n.storage.AppendEntries(entries)
+	if err != nil {
+		return fmt.Errorf("cannot save raft entries: %w", err)
+	}
+	n.log = append(n.log, entries...)
+	return nil
+}
+
+func (n *Node) resetElectionDeadline() {
+	timeout := n.config.electionTimeout + time.Duration(n.random.Int63n(int64(n.config.electionTimeout)))
+	n.electionDeadline = time.Now().Add(timeout)
+}
+
+// becomeFollower follows the leader of the term, it's unknown until the leader sends its entries
+func (n *Node) becomeFollower(term uint64) error {
+	if n.role == leader {
+		n.logger().Info("step down", zap.Uint64("term", term))
+	}
+	n.role = follower
+	n.triggers = nil
+	n.resetElectionDeadline()
+
+	if term > n.term {
+		n.term = term
+		n.votedFor = ""
+		n.leaderID = ""
+		return n.persist()
+	}
+	return nil
+}
+
+// tick starts an election when the leader is silent, or steps down a leader which can't reach a quorum
+func (n *Node) tick(ctx context.Context) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	now := time.Now()
+	if n.role != leader {
+		if now.After(n.electionDeadline) {
+			n.startElection(ctx)
+		}
+		return
+	}
+
+	if now.Sub(n.leaderSince) < n.config.electionTimeout {
+		return
+	}
+	reached := 1
+	for _, peer := range n.peers {
+		if now.Sub(n.lastContact[peer]) < n.config.electionTimeout {
+			reached++
+		}
+	}
+	if reached < n.quorum() {
+		n.logger().Warn("leader can't reach a quorum", zap.Int("reached", reached))
+		n.leaderID = ""
+		err := n.becomeFollower(n.term)
+		if err != nil {
+			n.logger().Error("cannot step down", zap.Error(err))
+		}
+	}
+}
+
+func (n *Node) startElection(ctx context.Context) {
+	n.role = candidate
+	n.term++
+	n.votedFor = n.id
+	n.leaderID = ""
+	n.resetElectionDeadline()
+	err := n.persist()
+	if err != nil {
+		n.logger().Error("cannot start election", zap.Error(err))
+		return
+	}
+	n.logger().Info("start election", zap.Uint64("term", n.term))
+
+	req := &pb.RequestVoteRequest{
+		Term:         n.term,
+		CandidateId:  n.id,
+		LastLogIndex: n.lastIndex(),
+		LastLogTerm:  n.lastTerm(),
+	}
+	votes := 1
+	if votes >= n.quorum() {
+		n.becomeLeader(ctx)
+		return
+	}
+
+	for _, peer := range n.peers {
+		go func(peer string) {
+			rpcCtx, cancel := context.WithTimeout(ctx, n.config.electionTimeout)
+			defer cancel()
+			res, err := n.transport.RequestVote(rpcCtx, peer, req)
+			if err != nil {
+				return
+			}
+
+			n.mutex.Lock()
+			defer n.mutex.Unlock()
+
+			if res.GetTerm() > n.term {
+				err := n.becomeFollower(res.GetTerm())
+				if err != nil {
+					n.logger().Error("cannot follow newer term", zap.Error(err))
+				}
+				return
+			}
+			if n.role != candidate || n.term != req.GetTerm() || !res.GetVoteGranted() {
+				return
+			}
+			votes++
+			if votes >= n.quorum() {
+				n.becomeLeader(ctx)
+			}
+		}(peer)
+	}
+}
+
+// becomeLeader appends a no-op entry, which commits the entries of the previous terms,
+// and starts replicating the log to the peers
+func (n *Node) becomeLeader(ctx context.Context) {
+	n.role = leader
+	n.leaderID = n.id
+	n.leaderSince = time.Now()
+	n.nextIndex = make(map[string]uint64)
+	n.matchIndex = make(map[string]uint64)
+	n.lastContact = make(map[string]time.Time)
+	n.triggers = make(map[string]chan struct{})
+
+	err := n.append([]*pb.RaftEntry{{Index: n.lastIndex() + 1, Term: n.term}})
+	if err != nil {
+		n.logger().Error("cannot append no-op entry", zap.Error(err))
+		n.role = follower
+		n.triggers = nil
+		return
+	}
+	n.logger().Info("became leader", zap.Uint64("term", n.term), zap.Uint64("last_index", n.lastIndex()))
+
+	for _, peer := range n.peers {
+		n.nextIndex[peer] = n.lastIndex()
+		trigger := make(chan struct{}, 1)
+		n.triggers[peer] = trigger
+		go n.replicate(ctx, n.term, peer, trigger)
+	}
+	n.advanceCommit()
+}
+
+func (n *Node) triggerReplication() {
+	for _, trigger := range n.triggers {
+		select {
+		case trigger <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// replicate sends the entries to the peer on every heartbeat or new entry, until the node loses the leadership
+func (n *Node) replicate(ctx context.Context, term uint64, peer string, trigger chan struct{}) {
+	ticker := time.NewTicker(n.config.heartbeatInterval)
+	defer ticker.Stop()
+
+	for n.sendEntries(ctx, term, peer) {
+		select {
+		case <-ctx.Done():
+			return
+		case <-trigger:
+		case <-ticker.C:
+		}
+	}
+}
+
+// sendEntries sends the entries or the snapshot the peer is missing,
+// it returns false if the node is no longer the leader of the term
+func (n *Node) sendEntries(ctx context.Context, term uint64, peer string) bool {
+	for {
+		n.mutex.Lock()
+		if n.role != leader || n.term != term {
+			n.mutex.Unlock()
+			return false
+		}
+
+		if n.nextIndex[peer] <= n.snapshotIndex() {
+			snapshot := n.snapshot
+			n.mutex.Unlock()
+
+			leading, sent := n.sendSnapshot(ctx, term, peer, snapshot)
+			if !leading {
+				return false
+			}
+			if !sent {
+				return true
+			}
+			continue
+		}
+
+		prevIndex := n.nextIndex[peer] - 1
+		req := &pb.AppendEntriesRequest{
+			Term:         term,
+			LeaderId:     n.id,
+			PrevLogIndex: prevIndex,
+			PrevLogTerm:  n.termAt(prevIndex),
+			LeaderCommit: n.commitIndex,
+		}
+		if prevIndex < n.lastIndex() {
+			req.Entries = n.entriesToSend(prevIndex + 1)
+		}
+		n.mutex.Unlock()
+
+		rpcCtx, cancel := context.WithTimeout(ctx, n.config.electionTimeout)
+		res, err := n.transport.AppendEntries(rpcCtx, peer, req)
+		cancel()
+		if err != nil {
+			return true
+		}
+
+		n.mutex.Lock()
+		if !n.checkResponseTerm(res.GetTerm(), term) {
+			n.mutex.Unlock()
+			return false
+		}
+		n.lastContact[peer] = time.Now()
+
+		if !res.GetSuccess() {
+			next := res.GetConflictIndex()
+			if next == 0 || next > prevIndex {
+				next = prevIndex
+			}
+			if next < 1 {
+				next = 1
+			}
+			n.nextIndex[peer] = next
+			n.mutex.Unlock()
+			continue
+		}
+
+		n.matched(peer, prevIndex+uint64(len(req.GetEntries())))
+		caughtUp := n.nextIndex[peer] > n.lastIndex()
+		n.mutex.Unlock()
+		if caughtUp {
+			return true
+		}
+	}
+}
+
+// sendSnapshot sends the snapshot to the peer in chunks of the max message size,
+// it returns whether the node is still the leader of the term and whether the peer has installed the snapshot
+func (n *Node) sendSnapshot(ctx context.Context, term uint64, peer string, snapshot *pb.RaftSnapshot) (bool, bool) {
+	data := snapshot.GetData()
+	for offset := 0; ; offset += n.config.maxMessageSize {
+		end := offset + n.config.maxMessageSize
+		if end > len(data) {
+			end = len(data)
+		}
+		req := &pb.InstallSnapshotRequest{
+			Term:     term,
+			LeaderId: n.id,
+			Snapshot: &pb.RaftSnapshot{
+				Index: snapshot.GetIndex(),
+				Term:  snapshot.GetTerm(),
+				Data:  data[offset:end],
+			},
+			Offset: uint64(offset),
+			Done:   end == len(data),
+		}
+
+		rpcCtx, cancel := context.WithTimeout(ctx, n.config.electionTimeout)
+		res, err := n.transport.InstallSnapshot(rpcCtx, peer, req)
+		cancel()
+		if err != nil {
+			return true, false
+		}
+
+		n.mutex.Lock()
+		if !n.checkResponseTerm(res.GetTerm(), term) {
+			n.mutex.Unlock()
+			return false, false
+		}
+		n.lastContact[peer] = time.Now()
+		if req.GetDone() {
+			n.matched(peer, snapshot.GetIndex())
+		}
+		n.mutex.Unlock()
+		if req.GetDone() {
+			return true, true
+		}
+	}
+}
+
+// checkResponseTerm follows a newer term, it returns false if the node is no longer the leader of the term
+func (n *Node) checkResponseTerm(responseTerm, term uint64) bool {
+	if responseTerm > n.term {
+		err := n.becomeFollower(responseTerm)
+		if err != nil {
+			n.logger().Error("cannot follow newer term", zap.Error(err))
+		}
+		return false
+	}
+	return n.role == leader && n.term == term
+}
+
+// matched records the peer has stored the log up to the index
+func (n *Node) matched(peer string, index uint64) {
+	if index > n.matchIndex[peer] {
+		n.matchIndex[peer] = index
+		n.advanceCommit()
+	}
+	n.nextIndex[peer] = n.matchIndex[peer] + 1
+}
+
+// advanceCommit commits the entries of the current term stored by a quorum, with the entries before them
+func (n *Node) advanceCommit() {
+	for index := n.lastIndex(); index > n.commitIndex && n.termAt(index) == n.term; index-- {
+		stored := 1
+		for _, peer := range n.peers {
+			if n.matchIndex[peer] >= index {
+				stored++
+			}
+		}
+		if stored >= n.quorum() {
+			n.commitIndex = index
+			n.applyCond.Broadcast()
+			return
+		}
+	}
+}
+
+// truncate removes the entries from the index, their proposals have lost the leadership
+func (n *Node) truncate(index uint64) error {
+	err := n.storage.TruncateEntries(index)
+	if err != nil {
+		return fmt.Errorf("cannot truncate raft entries: %w", err)
+	}
+	n.log = n.log[:index-n.snapshotIndex()]
+	for waiting, waiter := range n.waiters {
+		if waiting >= index {
+			waiter.done <- result{err: ErrLeadershipLost}
+			delete(n.waiters, waiting)
+		}
+	}
+	return nil
+}
+
+// runApplier applies the committed entries and restores the installed snapshots, until the node stops
+func (n *Node) runApplier() error {
+	// the partial entries of the next command, they are dropped
+	// if the leader has lost the leadership before appending the last one
+	var partial []*pb.RaftEntry
+	for {
+		n.mutex.Lock()
+		for !n.stopped && n.pendingSnapshot == nil && n.lastApplied >= n.commitIndex {
+			n.applyCond.Wait()
+		}
+		if n.stopped {
+			n.mutex.Unlock()
+			return nil
+		}
+
+		if snapshot := n.pendingSnapshot; snapshot != nil {
+			n.pendingSnapshot = nil
+			n.mutex.Unlock()
+
+			err := n.sm.Restore(snapshot.GetData())
+			if err != nil {
+				return fmt.Errorf("cannot restore snapshot %d: %w", snapshot.GetIndex(), err)
+			}
+
+			n.mutex.Lock()
+			n.lastApplied = snapshot.GetIndex()
+			n.mutex.Unlock()
+			partial = nil
+			n.logger().Info("restored snapshot", zap.Uint64("index", snapshot.GetIndex()))
+			continue
+		}
+
+		entries := n.entries(n.lastApplied+1, n.commitIndex)
+		n.mutex.Unlock()
+
+		for _, entry := range entries {
+			// the entries of a command are appended together, an entry of another term
+			// follows the partial entries of a command which was never completed
+			if len(partial) > 0 && partial[0].GetTerm() != entry.GetTerm() {
+				partial = nil
+			}
+			if entry.GetPartial() {
+				partial = append(partial, entry)
+				n.mutex.Lock()
+				n.lastApplied = entry.GetIndex()
+				n.mutex.Unlock()
+				continue
+			}
+
+			command := entry.GetCommand()
+			if len(partial) > 0 {
+				command = nil
+				for _, part := range append(partial, entry) {
+					command = append(command, part.GetCommand()...)
+				}
+				partial = nil
+			}
+
+			var res result
+			if len(command) > 0 {
+				res.value, res.err = n.sm.Apply(command)
+			}
+
+			n.mutex.Lock()
+			n.lastApplied = entry.GetIndex()
+			if waiter, ok := n.waiters[entry.GetIndex()]; ok {
+				delete(n.waiters, entry.GetIndex())
+				if waiter.term != entry.GetTerm() {
+					res = result{err: ErrLeadershipLost}
+				}
+				waiter.done <- res
+			}
+			n.mutex.Unlock()
+		}
+
+		// the snapshot can't keep the partial entries
+		if len(partial) > 0 {
+			continue
+		}
+		err := n.compact()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// compact replaces the applied entries by a snapshot once there are enough of them
+func (n *Node) compact() error {
+	n.mutex.Lock()
+	applied := n.lastApplied
+	due := applied-n.snapshotIndex() >= n.config.snapshotThreshold
+	n.mutex.Unlock()
+	if !due {
+		return nil
+	}
+
+	// only the applier changes the state machine, it's the state at the applied index
+	data, err := n.sm.Snapshot()
+	if err != nil {
+		return fmt.Errorf("cannot take snapshot: %w", err)
+	}
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if applied <= n.snapshotIndex() {
+		// a newer snapshot was installed meanwhile
+		return nil
+	}
+	snapshot := &pb.RaftSnapshot{
+		Index: applied,
+		Term:  n.termAt(applied),
+		Data:  data,
+	}
+	err = n.storage.SaveSnapshot(snapshot)
+	if err != nil {
+		return fmt.Errorf("cannot save snapshot: %w", err)
+	}
+
+	n.snapshot = snapshot
+	n.log = append(
+		[]*pb.RaftEntry{{Index: snapshot.Index, Term: snapshot.Term}},
+		n.log[applied-n.snapshotIndex()+1:]...,
+	)
+	n.logger().Info("compacted log", zap.Uint64("snapshot_index", snapshot.Index))
+	return nil
+}
+
+func (n *Node) logger() *zap.Logger {
+	return n.config.logger.With(zap.String("raft_id", n.id))
+}
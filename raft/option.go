@@ -0,0 +1,88 @@
+package raft
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Defaults of the options of Node
+const (
+	DefaultElectionTimeout   = time.Second
+	DefaultHeartbeatInterval = 100 * time.Millisecond
+	DefaultSnapshotThreshold = 10000
+	DefaultProposeTimeout    = 5 * time.Second
+	DefaultMaxMessageSize    = 1 << 20
+)
+
+type config struct {
+	electionTimeout   time.Duration
+	heartbeatInterval time.Duration
+	snapshotThreshold uint64
+	proposeTimeout    time.Duration
+	maxMessageSize    int
+	logger            *zap.Logger
+}
+
+// Option configures a Node
+type Option func(c *config)
+
+func newConfig(opts []Option) *config {
+	c := &config{
+		electionTimeout:   DefaultElectionTimeout,
+		heartbeatInterval: DefaultHeartbeatInterval,
+		snapshotThreshold: DefaultSnapshotThreshold,
+		proposeTimeout:    DefaultProposeTimeout,
+		maxMessageSize:    DefaultMaxMessageSize,
+		logger:            zap.NewNop(),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// WithElectionTimeout sets the minimum time a follower waits for the leader before starting an election,
+// the timeout of each election is randomized between timeout and twice the timeout
+func WithElectionTimeout(timeout time.Duration) Option {
+	return func(c *config) {
+		c.electionTimeout = timeout
+	}
+}
+
+// WithHeartbeatInterval sets how often the leader sends its entries or heartbeats to the followers,
+// it must be well below the election timeout
+func WithHeartbeatInterval(interval time.Duration) Option {
+	return func(c *config) {
+		c.heartbeatInterval = interval
+	}
+}
+
+// WithSnapshotThreshold sets how many applied entries the log keeps before they are compacted into a snapshot
+func WithSnapshotThreshold(threshold uint64) Option {
+	return func(c *config) {
+		c.snapshotThreshold = threshold
+	}
+}
+
+// WithProposeTimeout sets how long the stores of the Catalog wait for their writes to be applied
+func WithProposeTimeout(timeout time.Duration) Option {
+	return func(c *config) {
+		c.proposeTimeout = timeout
+	}
+}
+
+// WithMaxMessageSize sets how many bytes of entries or of snapshot the leader sends at most in one RPC,
+// the larger commands are split into several entries. It must be well below GRPCMessageSize.
+func WithMaxMessageSize(size int) Option {
+	return func(c *config) {
+		c.maxMessageSize = size
+	}
+}
+
+// WithLogger sets the logger of the node
+func WithLogger(logger *zap.Logger) Option {
+	return func(c *config) {
+		c.logger = logger
+	}
+}
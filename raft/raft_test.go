@@ -0,0 +1,533 @@
+package raft_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hjcian/grpc-notes/pb"
+	"github.com/hjcian/grpc-notes/raft"
+	"github.com/hjcian/grpc-notes/sample"
+	"github.com/hjcian/grpc-notes/service"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+)
+
+var testOptions = []raft.Option{
+	raft.WithElectionTimeout(100 * time.Millisecond),
+	raft.WithHeartbeatInterval(20 * time.Millisecond),
+	raft.WithProposeTimeout(500 * time.Millisecond),
+}
+
+type testNode struct {
+	node        *raft.Node
+	catalog     *raft.Catalog
+	laptops     *service.InMemoryLaptopStore
+	ratings     *service.InMemoryRatingStore
+	idempotency *service.InMemoryIdempotencyStore
+	storage     raft.Storage
+	stop        func()
+}
+
+func (n *testNode) laptopStore() service.LaptopStore {
+	return n.catalog.LaptopStore(n.node)
+}
+
+func (n *testNode) ratingStore() service.RatingStore {
+	return n.catalog.RatingStore(n.node)
+}
+
+// laptopServer returns the laptop server of the node, which remembers the idempotency keys
+func (n *testNode) laptopServer() *service.LaptopServer {
+	return service.NewLaptopServer(
+		n.laptopStore(),
+		nil,
+		n.ratingStore(),
+		service.WithIdempotencyStore(n.catalog.IdempotencyStore()),
+	)
+}
+
+// cluster runs the nodes on the in-process network
+type cluster struct {
+	t       *testing.T
+	network *network
+	ids     []string
+	opts    []raft.Option
+	nodes   map[string]*testNode
+}
+
+func newCluster(t *testing.T, size int, opts ...raft.Option) *cluster {
+	c := &cluster{
+		t:       t,
+		network: newNetwork(),
+		opts:    append(append([]raft.Option(nil), testOptions...), opts...),
+		nodes:   make(map[string]*testNode),
+	}
+	for i := 0; i < size; i++ {
+		c.ids = append(c.ids, fmt.Sprintf("node-%d", i))
+	}
+	for _, id := range c.ids {
+		c.start(id, raft.NewMemoryStorage())
+	}
+	return c
+}
+
+func (c *cluster) peers(id string) []string {
+	var peers []string
+	for _, peer := range c.ids {
+		if peer != id {
+			peers = append(peers, peer)
+		}
+	}
+	return peers
+}
+
+// start runs the node with empty stores, it restores the state saved in the storage
+func (c *cluster) start(id string, storage raft.Storage) *testNode {
+	laptops := service.NewInMemoryLaptopStore()
+	ratings := service.NewInMemoryRatingStore()
+	idempotency := service.NewInMemoryIdempotencyStore(time.Hour)
+	catalog := raft.NewCatalog(laptops, ratings, idempotency)
+	node, err := raft.NewNode(
+		id,
+		c.peers(id),
+		catalog,
+		storage,
+		&transport{network: c.network, from: id},
+		c.opts...,
+	)
+	require.NoError(c.t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- node.Run(ctx)
+	}()
+	var stopped bool
+	stop := func() {
+		if !stopped {
+			stopped = true
+			c.network.detach(id)
+			cancel()
+			require.NoError(c.t, <-done)
+		}
+	}
+	c.t.Cleanup(stop)
+
+	c.network.attach(node)
+	testNode := &testNode{
+		node:        node,
+		catalog:     catalog,
+		laptops:     laptops,
+		ratings:     ratings,
+		idempotency: idempotency,
+		storage:     storage,
+		stop:        stop,
+	}
+	c.nodes[id] = testNode
+	return testNode
+}
+
+// restart crashes the node and starts it again from its storage
+func (c *cluster) restart(id string) *testNode {
+	c.nodes[id].stop()
+	return c.start(id, c.nodes[id].storage)
+}
+
+// leader waits for a single leader among the nodes
+func (c *cluster) leader(ids ...string) *testNode {
+	if len(ids) == 0 {
+		ids = c.ids
+	}
+
+	var found *testNode
+	require.Eventually(c.t, func() bool {
+		found = nil
+		for _, id := range ids {
+			if c.nodes[id].node.IsLeader() {
+				if found != nil {
+					return false
+				}
+				found = c.nodes[id]
+			}
+		}
+		if found == nil {
+			return false
+		}
+		// the followers know the leader
+		for _, id := range ids {
+			if c.nodes[id].node.Leader() != found.node.ID() {
+				return false
+			}
+		}
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+	return found
+}
+
+// requireLaptops waits for the nodes to have the laptops
+func (c *cluster) requireLaptops(count int, ids ...string) {
+	if len(ids) == 0 {
+		ids = c.ids
+	}
+	require.Eventually(c.t, func() bool {
+		for _, id := range ids {
+			if c.nodes[id].laptops.Count() != count {
+				return false
+			}
+		}
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestReplicatedCatalog(t *testing.T) {
+	t.Parallel()
+
+	c := newCluster(t, 3)
+	leader := c.leader()
+
+	laptop := sample.NewLaptop()
+	require.NoError(t, leader.laptopStore().Save(laptop))
+	require.NoError(t, leader.laptopStore().SaveAll([]*pb.Laptop{sample.NewLaptop(), sample.NewLaptop()}))
+	err := leader.laptopStore().Save(laptop)
+	require.True(t, errors.Is(err, service.ErrAlreadyExists))
+
+	_, err = leader.ratingStore().Add(laptop.GetId(), 8)
+	require.NoError(t, err)
+	rating, err := leader.ratingStore().Add(laptop.GetId(), 10)
+	require.NoError(t, err)
+	require.Equal(t, uint32(2), rating.Count)
+	require.Equal(t, 18.0, rating.Sum)
+
+	c.requireLaptops(3)
+	require.Eventually(t, func() bool {
+		for _, id := range c.ids {
+			if len(c.nodes[id].ratings.All()) != 1 {
+				return false
+			}
+		}
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// the followers reject the writes, so that the clients retry on another node
+	for _, id := range c.peers(leader.node.ID()) {
+		follower := c.nodes[id]
+		err := follower.laptopStore().Save(sample.NewLaptop())
+		require.True(t, errors.Is(err, raft.ErrNotLeader))
+		require.True(t, errors.Is(err, service.ErrUnavailable))
+		require.Contains(t, err.Error(), leader.node.ID())
+		require.NoError(t, follower.node.CheckHealth())
+	}
+	c.requireLaptops(3)
+}
+
+func TestLeaderPartitioned(t *testing.T) {
+	t.Parallel()
+
+	c := newCluster(t, 5)
+	oldLeader := c.leader()
+	require.NoError(t, oldLeader.laptopStore().Save(sample.NewLaptop()))
+	c.requireLaptops(1)
+
+	// the leader is isolated with a follower, the majority elects a new leader
+	minority := []string{oldLeader.node.ID(), c.peers(oldLeader.node.ID())[0]}
+	majority := c.peers(oldLeader.node.ID())[1:]
+	c.network.partition(minority, majority)
+
+	newLeader := c.leader(majority...)
+	require.Greater(t, newLeader.node.Term(), oldLeader.node.Term())
+
+	// the minority can't commit, while the majority does
+	err := oldLeader.laptopStore().Save(sample.NewLaptop())
+	require.True(t, errors.Is(err, service.ErrUnavailable))
+	require.NoError(t, newLeader.laptopStore().Save(sample.NewLaptop()))
+	c.requireLaptops(2, majority...)
+	require.Eventually(t, func() bool {
+		return !oldLeader.node.IsLeader()
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// the minority drops its uncommitted entry and catches up once the partition heals
+	c.network.heal()
+	c.leader()
+	c.requireLaptops(2)
+}
+
+func TestAcknowledgedWritesSurviveLeaderCrash(t *testing.T) {
+	t.Parallel()
+
+	c := newCluster(t, 3)
+	leader := c.leader()
+	var laptopIDs []string
+	for i := 0; i < 5; i++ {
+		laptop := sample.NewLaptop()
+		require.NoError(t, leader.laptopStore().Save(laptop))
+		laptopIDs = append(laptopIDs, laptop.GetId())
+	}
+
+	// every acknowledged write is stored by a quorum, so the new leader has it
+	leader.stop()
+	newLeader := c.leader(c.peers(leader.node.ID())...)
+	c.requireLaptops(5, c.peers(leader.node.ID())...)
+	for _, laptopID := range laptopIDs {
+		found, err := newLeader.laptops.Find(laptopID)
+		require.NoError(t, err)
+		require.NotNil(t, found)
+	}
+	require.NoError(t, newLeader.laptopStore().Save(sample.NewLaptop()))
+
+	// the crashed node restarts from its storage and catches up
+	c.restart(leader.node.ID())
+	c.leader()
+	c.requireLaptops(6)
+}
+
+func TestIdempotencyKeySurvivesFailover(t *testing.T) {
+	t.Parallel()
+
+	c := newCluster(t, 3, raft.WithSnapshotThreshold(3))
+	leader := c.leader()
+	ctx := context.Background()
+
+	laptop := sample.NewLaptop()
+	laptop.Id = ""
+	req := &pb.CreateLaptopRequest{Laptop: laptop, IdempotencyKey: "key"}
+	res, err := leader.laptopServer().CreateLaptop(ctx, proto.Clone(req).(*pb.CreateLaptopRequest))
+	require.NoError(t, err)
+	c.requireLaptops(1)
+
+	// the retry on the new leader returns the laptop created by the old leader
+	leader.stop()
+	newLeader := c.leader(c.peers(leader.node.ID())...)
+	retried, err := newLeader.laptopServer().CreateLaptop(ctx, proto.Clone(req).(*pb.CreateLaptopRequest))
+	require.NoError(t, err)
+	require.Equal(t, res.GetId(), retried.GetId())
+	require.Equal(t, 1, newLeader.laptops.Count())
+
+	// a node restored from a snapshot remembers the key
+	for i := 0; i < 5; i++ {
+		require.NoError(t, newLeader.laptopStore().Save(sample.NewLaptop()))
+	}
+	restarted := c.start(leader.node.ID(), raft.NewMemoryStorage())
+	c.requireLaptops(6, restarted.node.ID())
+	require.Equal(t, res.GetId(), restarted.idempotency.All()["key"].LaptopID)
+}
+
+func TestSnapshot(t *testing.T) {
+	t.Parallel()
+
+	c := newCluster(t, 3, raft.WithSnapshotThreshold(5))
+	leader := c.leader()
+	lagging := c.peers(leader.node.ID())[0]
+	c.nodes[lagging].stop()
+
+	for i := 0; i < 20; i++ {
+		laptop := sample.NewLaptop()
+		require.NoError(t, leader.laptopStore().Save(laptop))
+		_, err := leader.ratingStore().Add(laptop.GetId(), 5)
+		require.NoError(t, err)
+	}
+
+	// the log is compacted, the lagging node catches up from a snapshot
+	c.start(lagging, c.nodes[lagging].storage)
+	c.requireLaptops(20)
+	require.Eventually(t, func() bool {
+		return len(c.nodes[lagging].ratings.All()) == 20
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, leader.ratings.All(), c.nodes[lagging].ratings.All())
+
+	// a node restarts from its file storage
+	folder := t.TempDir()
+	storage, err := raft.NewFileStorage(folder)
+	require.NoError(t, err)
+	state, entries, snapshot, err := c.nodes[lagging].storage.Load()
+	require.NoError(t, err)
+	require.NotNil(t, snapshot)
+	require.NoError(t, storage.SaveSnapshot(snapshot))
+	require.NoError(t, storage.SaveState(state))
+	require.NoError(t, storage.AppendEntries(entries))
+
+	storage, err = raft.NewFileStorage(folder)
+	require.NoError(t, err)
+	c.nodes[lagging].stop()
+	c.start(lagging, storage)
+	c.requireLaptops(20, lagging)
+	c.leader()
+}
+
+func TestFileStorage(t *testing.T) {
+	t.Parallel()
+
+	folder := t.TempDir()
+	storage, err := raft.NewFileStorage(folder)
+	require.NoError(t, err)
+
+	requireLoaded := func(indexes ...uint64) {
+		storage, err = raft.NewFileStorage(folder)
+		require.NoError(t, err)
+		state, entries, _, err := storage.Load()
+		require.NoError(t, err)
+		require.Equal(t, uint64(3), state.GetTerm())
+		require.Equal(t, "node-1", state.GetVotedFor())
+
+		var loaded []uint64
+		for _, entry := range entries {
+			loaded = append(loaded, entry.GetIndex())
+			require.Equal(t, fmt.Sprint(entry.GetIndex(), "-", entry.GetTerm()), string(entry.GetCommand()))
+		}
+		require.Equal(t, indexes, loaded)
+	}
+	entry := func(index, term uint64) *pb.RaftEntry {
+		return &pb.RaftEntry{Index: index, Term: term, Command: []byte(fmt.Sprint(index, "-", term))}
+	}
+
+	require.NoError(t, storage.SaveState(&pb.RaftState{Term: 3, VotedFor: "node-1"}))
+	require.NoError(t, storage.AppendEntries([]*pb.RaftEntry{entry(1, 1), entry(2, 1), entry(3, 1)}))
+	require.NoError(t, storage.AppendEntries([]*pb.RaftEntry{entry(4, 1), entry(5, 1)}))
+	require.Error(t, storage.AppendEntries([]*pb.RaftEntry{entry(7, 1)}))
+	requireLoaded(1, 2, 3, 4, 5)
+
+	// the conflicting entries are replaced
+	require.NoError(t, storage.TruncateEntries(4))
+	require.NoError(t, storage.AppendEntries([]*pb.RaftEntry{entry(4, 2)}))
+	requireLoaded(1, 2, 3, 4)
+	_, entries, _, err := storage.Load()
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), entries[3].GetTerm())
+
+	// the entries of the snapshot are discarded
+	require.NoError(t, storage.SaveSnapshot(&pb.RaftSnapshot{Index: 2, Term: 1}))
+	requireLoaded(3, 4)
+
+	// the record partially written by a crash is removed
+	file, err := os.OpenFile(filepath.Join(folder, "log.bin"), os.O_WRONLY|os.O_APPEND, 0644)
+	require.NoError(t, err)
+	_, err = file.Write([]byte{0, 0, 0, 42, 1, 2})
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+	requireLoaded(3, 4)
+	require.NoError(t, storage.AppendEntries([]*pb.RaftEntry{entry(5, 2)}))
+	requireLoaded(3, 4, 5)
+
+	// the snapshot discards all the entries
+	require.NoError(t, storage.SaveSnapshot(&pb.RaftSnapshot{Index: 6, Term: 2}))
+	requireLoaded()
+	require.NoError(t, storage.AppendEntries([]*pb.RaftEntry{entry(7, 2)}))
+	requireLoaded(7)
+}
+
+// newLargeLaptop returns a laptop larger than the messages of the GRPCTransport
+func newLargeLaptop() *pb.Laptop {
+	laptop := sample.NewLaptop()
+	laptop.Name = strings.Repeat("x", raft.GRPCMessageSize+1)
+	return laptop
+}
+
+func TestLargeEntryAndSnapshot(t *testing.T) {
+	t.Parallel()
+
+	c := newCluster(t, 3, raft.WithSnapshotThreshold(2))
+	leader := c.leader()
+	lagging := c.peers(leader.node.ID())[0]
+	c.nodes[lagging].stop()
+
+	// the entry of the laptop is split to fit in the messages
+	laptop := newLargeLaptop()
+	require.NoError(t, leader.laptopStore().Save(laptop))
+	c.requireLaptops(1, c.peers(lagging)...)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, leader.laptopStore().Save(sample.NewLaptop()))
+	}
+
+	// the log is compacted, the lagging node catches up from the snapshot sent in chunks
+	_, _, snapshot, err := leader.storage.Load()
+	require.NoError(t, err)
+	require.Greater(t, len(snapshot.GetData()), raft.GRPCMessageSize)
+	c.start(lagging, c.nodes[lagging].storage)
+	c.requireLaptops(4)
+
+	found, err := c.nodes[lagging].laptops.Find(laptop.GetId())
+	require.NoError(t, err)
+	require.Equal(t, laptop.GetName(), found.GetName())
+}
+
+func TestGRPCTransport(t *testing.T) {
+	t.Parallel()
+
+	listeners := make([]net.Listener, 3)
+	ids := make([]string, 3)
+	for i := range listeners {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		listeners[i] = listener
+		ids[i] = listener.Addr().String()
+	}
+
+	nodes := make([]*raft.Node, 3)
+	catalogs := make([]*raft.Catalog, 3)
+	laptopStores := make([]*service.InMemoryLaptopStore, 3)
+	for i, id := range ids {
+		var peers []string
+		for _, peer := range ids {
+			if peer != id {
+				peers = append(peers, peer)
+			}
+		}
+
+		laptopStores[i] = service.NewInMemoryLaptopStore()
+		catalogs[i] = raft.NewCatalog(laptopStores[i], service.NewInMemoryRatingStore(), nil)
+		transport := raft.NewGRPCTransport(grpc.WithInsecure())
+		t.Cleanup(func() { transport.Close() })
+		node, err := raft.NewNode(
+			id,
+			peers,
+			catalogs[i],
+			raft.NewMemoryStorage(),
+			transport,
+			// the chunks of the large laptop take longer than the messages of the other tests
+			raft.WithElectionTimeout(time.Second),
+			raft.WithHeartbeatInterval(50*time.Millisecond),
+			raft.WithProposeTimeout(5*time.Second),
+		)
+		require.NoError(t, err)
+		nodes[i] = node
+
+		grpcServer := grpc.NewServer(grpc.MaxRecvMsgSize(raft.GRPCMessageSize))
+		pb.RegisterRaftServiceServer(grpcServer, node)
+		go grpcServer.Serve(listeners[i])
+		t.Cleanup(grpcServer.Stop)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+		go node.Run(ctx)
+	}
+
+	leader := -1
+	require.Eventually(t, func() bool {
+		for i, node := range nodes {
+			if node.IsLeader() {
+				leader = i
+				return true
+			}
+		}
+		return false
+	}, 5*time.Second, 10*time.Millisecond)
+
+	laptopStore := catalogs[leader].LaptopStore(nodes[leader])
+	require.NoError(t, laptopStore.Save(sample.NewLaptop()))
+	require.NoError(t, laptopStore.Save(newLargeLaptop()))
+	require.Eventually(t, func() bool {
+		for _, laptopStore := range laptopStores {
+			if laptopStore.Count() != 2 {
+				return false
+			}
+		}
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,205 @@
+package raft
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hjcian/grpc-notes/pb"
+	"go.uber.org/zap"
+)
+
+// RequestVote grants the vote of the node to a candidate whose log is at least as up-to-date as its log
+func (n *Node) RequestVote(ctx context.Context, req *pb.RequestVoteRequest) (*pb.RequestVoteResponse, error) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if n.stopped {
+		return nil, ErrStopped
+	}
+	if req.GetTerm() > n.term {
+		err := n.becomeFollower(req.GetTerm())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	res := &pb.RequestVoteResponse{Term: n.term}
+	if req.GetTerm() < n.term {
+		return res, nil
+	}
+
+	upToDate := req.GetLastLogTerm() > n.lastTerm() ||
+		(req.GetLastLogTerm() == n.lastTerm() && req.GetLastLogIndex() >= n.lastIndex())
+	if !upToDate || (len(n.votedFor) > 0 && n.votedFor != req.GetCandidateId()) {
+		return res, nil
+	}
+
+	n.votedFor = req.GetCandidateId()
+	err := n.persist()
+	if err != nil {
+		return nil, err
+	}
+	n.resetElectionDeadline()
+	res.VoteGranted = true
+	return res, nil
+}
+
+// AppendEntries stores the entries of the leader after the previous entry, if it matches the one of the node
+func (n *Node) AppendEntries(ctx context.Context, req *pb.AppendEntriesRequest) (*pb.AppendEntriesResponse, error) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if n.stopped {
+		return nil, ErrStopped
+	}
+	res, ok, err := n.followLeader(req.GetTerm(), req.GetLeaderId())
+	if !ok || err != nil {
+		return &pb.AppendEntriesResponse{Term: res}, err
+	}
+
+	// the entries of the snapshot are committed, so they match the ones of the leader
+	prevIndex, prevTerm, entries := req.GetPrevLogIndex(), req.GetPrevLogTerm(), req.GetEntries()
+	if prevIndex < n.snapshotIndex() {
+		skipped := n.snapshotIndex() - prevIndex
+		if skipped >= uint64(len(entries)) {
+			entries = nil
+		} else {
+			entries = entries[skipped:]
+		}
+		prevIndex, prevTerm = n.snapshotIndex(), n.log[0].GetTerm()
+	}
+
+	if prevIndex > n.lastIndex() {
+		return &pb.AppendEntriesResponse{Term: n.term, ConflictIndex: n.lastIndex() + 1}, nil
+	}
+	if conflictTerm := n.termAt(prevIndex); conflictTerm != prevTerm {
+		// skip the whole conflicting term rather than one entry per round trip
+		conflictIndex := prevIndex
+		for conflictIndex > n.snapshotIndex()+1 && n.termAt(conflictIndex-1) == conflictTerm {
+			conflictIndex--
+		}
+		return &pb.AppendEntriesResponse{Term: n.term, ConflictIndex: conflictIndex}, nil
+	}
+
+	for i, entry := range entries {
+		if entry.GetIndex() <= n.lastIndex() {
+			if n.termAt(entry.GetIndex()) == entry.GetTerm() {
+				continue
+			}
+			err := n.truncate(entry.GetIndex())
+			if err != nil {
+				return nil, err
+			}
+		}
+		err := n.append(entries[i:])
+		if err != nil {
+			return nil, err
+		}
+		break
+	}
+
+	lastNewIndex := prevIndex + uint64(len(entries))
+	commitIndex := req.GetLeaderCommit()
+	if commitIndex > lastNewIndex {
+		commitIndex = lastNewIndex
+	}
+	if commitIndex > n.commitIndex {
+		n.commitIndex = commitIndex
+		n.applyCond.Broadcast()
+	}
+	return &pb.AppendEntriesResponse{Term: n.term, Success: true}, nil
+}
+
+// InstallSnapshot receives a chunk of the snapshot of the leader, when the node is too far behind.
+// The last chunk replaces the log of the node by the snapshot.
+func (n *Node) InstallSnapshot(
+	ctx context.Context,
+	req *pb.InstallSnapshotRequest,
+) (*pb.InstallSnapshotResponse, error) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	if n.stopped {
+		return nil, ErrStopped
+	}
+	term, ok, err := n.followLeader(req.GetTerm(), req.GetLeaderId())
+	res := &pb.InstallSnapshotResponse{Term: term}
+	if !ok || err != nil {
+		return res, err
+	}
+
+	snapshot, err := n.receiveSnapshot(req)
+	if err != nil || snapshot == nil {
+		return res, err
+	}
+	if snapshot.GetIndex() <= n.commitIndex {
+		return res, nil
+	}
+
+	err = n.storage.SaveSnapshot(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	if snapshot.GetIndex() < n.lastIndex() && n.termAt(snapshot.GetIndex()) == snapshot.GetTerm() {
+		// keep the entries after the snapshot
+		n.log = append(
+			[]*pb.RaftEntry{{Index: snapshot.GetIndex(), Term: snapshot.GetTerm()}},
+			n.log[snapshot.GetIndex()-n.snapshotIndex()+1:]...,
+		)
+	} else {
+		err = n.truncate(n.snapshotIndex() + 1)
+		if err != nil {
+			return nil, err
+		}
+		n.log = []*pb.RaftEntry{{Index: snapshot.GetIndex(), Term: snapshot.GetTerm()}}
+	}
+
+	n.snapshot = snapshot
+	n.commitIndex = snapshot.GetIndex()
+	n.pendingSnapshot = snapshot
+	n.applyCond.Broadcast()
+	n.logger().Info("installed snapshot", zap.Uint64("index", snapshot.GetIndex()))
+	return res, nil
+}
+
+// receiveSnapshot appends the chunk to the snapshot received so far, it returns the snapshot
+// once the last chunk is received
+func (n *Node) receiveSnapshot(req *pb.InstallSnapshotRequest) (*pb.RaftSnapshot, error) {
+	chunk := req.GetSnapshot()
+	if req.GetOffset() == 0 {
+		n.receivedSnapshot = &pb.RaftSnapshot{Index: chunk.GetIndex(), Term: chunk.GetTerm()}
+	}
+
+	received := n.receivedSnapshot
+	if received == nil ||
+		received.GetIndex() != chunk.GetIndex() ||
+		received.GetTerm() != chunk.GetTerm() ||
+		uint64(len(received.GetData())) != req.GetOffset() {
+		n.receivedSnapshot = nil
+		return nil, fmt.Errorf("unexpected chunk at offset %d of snapshot %d", req.GetOffset(), chunk.GetIndex())
+	}
+
+	received.Data = append(received.Data, chunk.GetData()...)
+	if !req.GetDone() {
+		return nil, nil
+	}
+	n.receivedSnapshot = nil
+	return received, nil
+}
+
+// followLeader follows the leader sending an RPC of the term, it returns the term of the node
+// and false if the leader is stale
+func (n *Node) followLeader(term uint64, leaderID string) (uint64, bool, error) {
+	if term < n.term {
+		return n.term, false, nil
+	}
+	if term > n.term || n.role != follower {
+		err := n.becomeFollower(term)
+		if err != nil {
+			return n.term, false, err
+		}
+	}
+	n.leaderID = leaderID
+	n.resetElectionDeadline()
+	return n.term, true, nil
+}
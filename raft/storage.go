@@ -0,0 +1,458 @@
+package raft
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hjcian/grpc-notes/pb"
+)
+
+// Storage persists the state, the entries and the snapshot of a node, so that a restarted node
+// keeps its votes and the entries it has acknowledged
+type Storage interface {
+	// Load returns the saved state, entries and snapshot, nil if they were never saved
+	Load() (*pb.RaftState, []*pb.RaftEntry, *pb.RaftSnapshot, error)
+	// SaveState saves the term and the vote
+	SaveState(state *pb.RaftState) error
+	// AppendEntries saves the entries after the last saved one
+	AppendEntries(entries []*pb.RaftEntry) error
+	// TruncateEntries discards the saved entries from the index, they conflict with the entries of the leader
+	TruncateEntries(index uint64) error
+	// SaveSnapshot saves the snapshot and discards the saved entries up to its index
+	SaveSnapshot(snapshot *pb.RaftSnapshot) error
+}
+
+// MemoryStorage keeps the state in memory, it survives the restart of a Node but not of the process
+type MemoryStorage struct {
+	mutex    sync.Mutex
+	state    *pb.RaftState
+	entries  []*pb.RaftEntry
+	snapshot *pb.RaftSnapshot
+}
+
+// NewMemoryStorage returns a new MemoryStorage
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{}
+}
+
+// Load returns copies of the saved state, entries and snapshot
+func (s *MemoryStorage) Load() (*pb.RaftState, []*pb.RaftEntry, *pb.RaftSnapshot, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var state *pb.RaftState
+	if s.state != nil {
+		state = proto.Clone(s.state).(*pb.RaftState)
+	}
+	var entries []*pb.RaftEntry
+	for _, entry := range s.entries {
+		entries = append(entries, proto.Clone(entry).(*pb.RaftEntry))
+	}
+	var snapshot *pb.RaftSnapshot
+	if s.snapshot != nil {
+		snapshot = proto.Clone(s.snapshot).(*pb.RaftSnapshot)
+	}
+	return state, entries, snapshot, nil
+}
+
+// SaveState saves a copy of the state
+func (s *MemoryStorage) SaveState(state *pb.RaftState) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.state = proto.Clone(state).(*pb.RaftState)
+	return nil
+}
+
+// AppendEntries saves copies of the entries
+func (s *MemoryStorage) AppendEntries(entries []*pb.RaftEntry) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if len(entries) == 0 {
+		return nil
+	}
+	if len(s.entries) > 0 && entries[0].GetIndex() != s.entries[len(s.entries)-1].GetIndex()+1 {
+		return fmt.Errorf("entry %d doesn't follow the saved entries", entries[0].GetIndex())
+	}
+	for _, entry := range entries {
+		s.entries = append(s.entries, proto.Clone(entry).(*pb.RaftEntry))
+	}
+	return nil
+}
+
+// TruncateEntries discards the saved entries from the index
+func (s *MemoryStorage) TruncateEntries(index uint64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for i, entry := range s.entries {
+		if entry.GetIndex() >= index {
+			s.entries = s.entries[:i]
+			break
+		}
+	}
+	return nil
+}
+
+// SaveSnapshot saves a copy of the snapshot
+func (s *MemoryStorage) SaveSnapshot(snapshot *pb.RaftSnapshot) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.snapshot = proto.Clone(snapshot).(*pb.RaftSnapshot)
+	for len(s.entries) > 0 && s.entries[0].GetIndex() <= snapshot.GetIndex() {
+		s.entries = s.entries[1:]
+	}
+	return nil
+}
+
+// FileStorage saves the state, the entries and the snapshot to files of a folder.
+// The entries are appended to the log file, which is only rewritten without the entries of a new snapshot.
+type FileStorage struct {
+	mutex  sync.Mutex
+	folder string
+	// first is the index of the first entry of the log file, offsets are the offsets of its records
+	first   uint64
+	offsets []int64
+}
+
+const (
+	stateFile    = "state.bin"
+	logFile      = "log.bin"
+	snapshotFile = "snapshot.bin"
+
+	// a record of the log file is the size and the checksum of the entry followed by the entry
+	recordHeaderSize = 8
+)
+
+// NewFileStorage returns a new FileStorage in the folder, which is created if needed.
+// The record of an entry partially written by a crash is removed from the log file.
+func NewFileStorage(folder string) (*FileStorage, error) {
+	err := os.MkdirAll(folder, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create raft folder: %w", err)
+	}
+
+	s := &FileStorage{folder: folder}
+	entries, offsets, size, err := s.readLog()
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) > 0 {
+		s.first = entries[0].GetIndex()
+	}
+	s.offsets = offsets
+
+	err = os.Truncate(filepath.Join(folder, logFile), size)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("cannot truncate %s: %w", logFile, err)
+	}
+	return s, nil
+}
+
+// Load reads the state, the log and the snapshot files
+func (s *FileStorage) Load() (*pb.RaftState, []*pb.RaftEntry, *pb.RaftSnapshot, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	state := &pb.RaftState{}
+	found, err := s.read(stateFile, state)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if !found {
+		state = nil
+	}
+
+	entries, _, _, err := s.readLog()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	snapshot := &pb.RaftSnapshot{}
+	found, err = s.read(snapshotFile, snapshot)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if !found {
+		return state, entries, nil, nil
+	}
+
+	// complete the save of the snapshot interrupted by a crash
+	err = s.discardEntries(snapshot.GetIndex())
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	for len(entries) > 0 && entries[0].GetIndex() <= snapshot.GetIndex() {
+		entries = entries[1:]
+	}
+	return state, entries, snapshot, nil
+}
+
+// SaveState writes the state file
+func (s *FileStorage) SaveState(state *pb.RaftState) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.write(stateFile, state)
+}
+
+// AppendEntries appends the entries to the log file and syncs it
+func (s *FileStorage) AppendEntries(entries []*pb.RaftEntry) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if len(entries) == 0 {
+		return nil
+	}
+	if len(s.offsets) == 0 {
+		s.first = entries[0].GetIndex()
+	} else if entries[0].GetIndex() != s.first+uint64(len(s.offsets)) {
+		return fmt.Errorf("entry %d doesn't follow the saved entries", entries[0].GetIndex())
+	}
+
+	file, err := os.OpenFile(filepath.Join(s.folder, logFile), os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("cannot open %s: %w", logFile, err)
+	}
+	defer file.Close()
+
+	size, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return fmt.Errorf("cannot seek %s: %w", logFile, err)
+	}
+
+	var buffer bytes.Buffer
+	var offsets []int64
+	for _, entry := range entries {
+		offsets = append(offsets, size+int64(buffer.Len()))
+		err = writeRecord(&buffer, entry)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = file.Write(buffer.Bytes())
+	if err == nil {
+		err = file.Sync()
+	}
+	if err != nil {
+		// the partial records are overwritten by the next entries
+		file.Truncate(size)
+		return fmt.Errorf("cannot write %s: %w", logFile, err)
+	}
+	s.offsets = append(s.offsets, offsets...)
+	return nil
+}
+
+// TruncateEntries truncates the log file before the record of the index
+func (s *FileStorage) TruncateEntries(index uint64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if len(s.offsets) == 0 || index >= s.first+uint64(len(s.offsets)) {
+		return nil
+	}
+	kept := 0
+	if index > s.first {
+		kept = int(index - s.first)
+	}
+
+	err := s.truncateLog(s.offsets[kept])
+	if err != nil {
+		return err
+	}
+	s.offsets = s.offsets[:kept]
+	return nil
+}
+
+// SaveSnapshot writes the snapshot file, then rewrites the log file with the entries after the snapshot.
+// A crash in between keeps the entries of the snapshot in the log file, Load discards them.
+func (s *FileStorage) SaveSnapshot(snapshot *pb.RaftSnapshot) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	err := s.write(snapshotFile, snapshot)
+	if err != nil {
+		return err
+	}
+	return s.discardEntries(snapshot.GetIndex())
+}
+
+// discardEntries rewrites the log file with the entries after the index
+func (s *FileStorage) discardEntries(index uint64) error {
+	if len(s.offsets) == 0 || index < s.first {
+		return nil
+	}
+	discarded := int(index - s.first + 1)
+	if discarded >= len(s.offsets) {
+		err := s.truncateLog(0)
+		if err != nil {
+			return err
+		}
+		s.offsets = nil
+		return nil
+	}
+
+	path := filepath.Join(s.folder, logFile)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("cannot read %s: %w", logFile, err)
+	}
+	start := s.offsets[discarded]
+	err = s.writeFile(logFile, data[start:])
+	if err != nil {
+		return err
+	}
+
+	offsets := make([]int64, 0, len(s.offsets)-discarded)
+	for _, offset := range s.offsets[discarded:] {
+		offsets = append(offsets, offset-start)
+	}
+	s.first += uint64(discarded)
+	s.offsets = offsets
+	return nil
+}
+
+// readLog returns the entries of the log file, the offsets of their records and the size of the complete records
+func (s *FileStorage) readLog() ([]*pb.RaftEntry, []int64, int64, error) {
+	data, err := ioutil.ReadFile(filepath.Join(s.folder, logFile))
+	if os.IsNotExist(err) {
+		return nil, nil, 0, nil
+	}
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("cannot read %s: %w", logFile, err)
+	}
+
+	var entries []*pb.RaftEntry
+	var offsets []int64
+	var offset int64
+	for {
+		entry, size, err := readRecord(data[offset:])
+		if errors.Is(err, errPartialRecord) {
+			// the last records were partially written by a crash
+			return entries, offsets, offset, nil
+		}
+		if err != nil {
+			return nil, nil, 0, fmt.Errorf("cannot read %s at offset %d: %w", logFile, offset, err)
+		}
+		entries = append(entries, entry)
+		offsets = append(offsets, offset)
+		offset += int64(size)
+	}
+}
+
+func (s *FileStorage) truncateLog(size int64) error {
+	file, err := os.OpenFile(filepath.Join(s.folder, logFile), os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("cannot open %s: %w", logFile, err)
+	}
+	defer file.Close()
+
+	err = file.Truncate(size)
+	if err == nil {
+		err = file.Sync()
+	}
+	if err != nil {
+		return fmt.Errorf("cannot truncate %s: %w", logFile, err)
+	}
+	return nil
+}
+
+var errPartialRecord = errors.New("partial record")
+
+func writeRecord(buffer *bytes.Buffer, entry *pb.RaftEntry) error {
+	data, err := proto.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("cannot marshal entry %d: %w", entry.GetIndex(), err)
+	}
+
+	var header [recordHeaderSize]byte
+	binary.BigEndian.PutUint32(header[:4], uint32(len(data)))
+	binary.BigEndian.PutUint32(header[4:], crc32.ChecksumIEEE(data))
+	buffer.Write(header[:])
+	buffer.Write(data)
+	return nil
+}
+
+// readRecord returns the entry of the record at the start of the data and the size of the record,
+// errPartialRecord if the data ends before the record or the record doesn't match its checksum
+func readRecord(data []byte) (*pb.RaftEntry, int, error) {
+	if len(data) < recordHeaderSize {
+		return nil, 0, errPartialRecord
+	}
+	size := recordHeaderSize + int(binary.BigEndian.Uint32(data[:4]))
+	if len(data) < size {
+		return nil, 0, errPartialRecord
+	}
+	record := data[recordHeaderSize:size]
+	if crc32.ChecksumIEEE(record) != binary.BigEndian.Uint32(data[4:recordHeaderSize]) {
+		return nil, 0, errPartialRecord
+	}
+
+	entry := &pb.RaftEntry{}
+	err := proto.Unmarshal(record, entry)
+	if err != nil {
+		return nil, 0, fmt.Errorf("cannot unmarshal entry: %w", err)
+	}
+	return entry, size, nil
+}
+
+func (s *FileStorage) read(name string, message proto.Message) (bool, error) {
+	data, err := ioutil.ReadFile(filepath.Join(s.folder, name))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("cannot read %s: %w", name, err)
+	}
+
+	err = proto.Unmarshal(data, message)
+	if err != nil {
+		return false, fmt.Errorf("cannot unmarshal %s: %w", name, err)
+	}
+	return true, nil
+}
+
+func (s *FileStorage) write(name string, message proto.Message) error {
+	data, err := proto.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("cannot marshal %s: %w", name, err)
+	}
+	return s.writeFile(name, data)
+}
+
+// writeFile syncs the data to a temporary file and renames it, so that a crash keeps the previous file
+func (s *FileStorage) writeFile(name string, data []byte) error {
+	path := filepath.Join(s.folder, name)
+	file, err := os.Create(path + ".tmp")
+	if err != nil {
+		return fmt.Errorf("cannot create %s: %w", name, err)
+	}
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("cannot write %s: %w", name, err)
+	}
+
+	err = os.Rename(path+".tmp", path)
+	if err != nil {
+		return fmt.Errorf("cannot rename %s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package raft
+
+import (
+	"context"
+	"sync"
+
+	"github.com/hjcian/grpc-notes/pb"
+	"google.golang.org/grpc"
+)
+
+// Transport sends the RPCs of a node to its peers, identified by their ID
+type Transport interface {
+	RequestVote(ctx context.Context, peer string, req *pb.RequestVoteRequest) (*pb.RequestVoteResponse, error)
+	AppendEntries(ctx context.Context, peer string, req *pb.AppendEntriesRequest) (*pb.AppendEntriesResponse, error)
+	InstallSnapshot(ctx context.Context, peer string, req *pb.InstallSnapshotRequest) (*pb.InstallSnapshotResponse, error)
+}
+
+// GRPCMessageSize is the max size of the raft messages sent and received over gRPC,
+// the servers of the RaftService must receive it with grpc.MaxRecvMsgSize
+const GRPCMessageSize = 4 << 20
+
+// GRPCTransport sends the RPCs to the RaftService of the peers, their IDs are their addresses
+type GRPCTransport struct {
+	mutex   sync.Mutex
+	opts    []grpc.DialOption
+	conns   map[string]*grpc.ClientConn
+	clients map[string]pb.RaftServiceClient
+}
+
+// NewGRPCTransport returns a new GRPCTransport dialing the peers with the options,
+// the calls send and receive messages up to GRPCMessageSize
+func NewGRPCTransport(opts ...grpc.DialOption) *GRPCTransport {
+	opts = append([]grpc.DialOption{
+		grpc.WithDefaultCallOptions(
+			grpc.MaxCallSendMsgSize(GRPCMessageSize),
+			grpc.MaxCallRecvMsgSize(GRPCMessageSize),
+		),
+	}, opts...)
+	return &GRPCTransport{
+		opts:    opts,
+		conns:   make(map[string]*grpc.ClientConn),
+		clients: make(map[string]pb.RaftServiceClient),
+	}
+}
+
+// client returns the client of the peer, the connection is dialed on first use
+func (t *GRPCTransport) client(peer string) (pb.RaftServiceClient, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if client, ok := t.clients[peer]; ok {
+		return client, nil
+	}
+
+	conn, err := grpc.Dial(peer, t.opts...)
+	if err != nil {
+		return nil, err
+	}
+	t.conns[peer] = conn
+	t.clients[peer] = pb.NewRaftServiceClient(conn)
+	return t.clients[peer], nil
+}
+
+// RequestVote sends the RequestVote RPC to the peer
+func (t *GRPCTransport) RequestVote(
+	ctx context.Context,
+	peer string,
+	req *pb.RequestVoteRequest,
+) (*pb.RequestVoteResponse, error) {
+	client, err := t.client(peer)
+	if err != nil {
+		return nil, err
+	}
+	return client.RequestVote(ctx, req)
+}
+
+// AppendEntries sends the AppendEntries RPC to the peer
+func (t *GRPCTransport) AppendEntries(
+	ctx context.Context,
+	peer string,
+	req *pb.AppendEntriesRequest,
+) (*pb.AppendEntriesResponse, error) {
+	client, err := t.client(peer)
+	if err != nil {
+		return nil, err
+	}
+	return client.AppendEntries(ctx, req)
+}
+
+// InstallSnapshot sends the InstallSnapshot RPC to the peer
+func (t *GRPCTransport) InstallSnapshot(
+	ctx context.Context,
+	peer string,
+	req *pb.InstallSnapshotRequest,
+) (*pb.InstallSnapshotResponse, error) {
+	client, err := t.client(peer)
+	if err != nil {
+		return nil, err
+	}
+	return client.InstallSnapshot(ctx, req)
+}
+
+// Close closes the connections to the peers
+func (t *GRPCTransport) Close() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	var err error
+	for peer, conn := range t.conns {
+		if closeErr := conn.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+		delete(t.conns, peer)
+		delete(t.clients, peer)
+	}
+	return err
+}
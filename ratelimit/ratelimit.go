@@ -0,0 +1,361 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hjcian/grpc-notes/rpcerror"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// StreamRetryDelay is the delay suggested to the clients which have too many concurrent streams
+const StreamRetryDelay = time.Second
+
+// ClientAddressHeader is the metadata key of the client address forwarded by a proxy on the same host
+// as the server, e.g. the REST gateway
+const ClientAddressHeader = "x-forwarded-for"
+
+// sweepInterval is how often the buckets which are full again are dropped
+const sweepInterval = time.Minute
+
+// Limit is a token bucket refilled at Rate tokens per second up to Burst tokens,
+// each request takes a token. A zero Rate means no limit.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// String formats the limit as "rate:burst"
+func (l Limit) String() string {
+	return fmt.Sprintf("%g:%d", l.Rate, l.Burst)
+}
+
+// ParseLimit parses a limit formatted as "rate:burst", or "rate" which means a burst of 1
+func ParseLimit(value string) (Limit, error) {
+	parts := strings.SplitN(value, ":", 2)
+
+	rate, err := strconv.ParseFloat(parts[0], 64)
+	if err != nil || rate < 0 {
+		return Limit{}, fmt.Errorf("invalid rate in limit %q", value)
+	}
+
+	burst := 1
+	if len(parts) == 2 {
+		burst, err = strconv.Atoi(parts[1])
+		if err != nil || burst < 1 {
+			return Limit{}, fmt.Errorf("invalid burst in limit %q", value)
+		}
+	}
+
+	return Limit{Rate: rate, Burst: burst}, nil
+}
+
+// ParseMethodLimits parses comma separated limits of the methods, e.g. "SearchLaptop=1:5,RateLaptop=10:20",
+// the methods are named either by their short or their full names
+func ParseMethodLimits(value string) (map[string]Limit, error) {
+	limits := make(map[string]Limit)
+	if len(value) == 0 {
+		return limits, nil
+	}
+
+	for _, item := range strings.Split(value, ",") {
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 || len(parts[0]) == 0 {
+			return nil, fmt.Errorf("invalid method limit %q", item)
+		}
+
+		limit, err := ParseLimit(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		limits[parts[0]] = limit
+	}
+	return limits, nil
+}
+
+// PeerIdentity identifies the clients by the host of their address,
+// so that the connections of the same host share their limits.
+// The clients of a proxy on the loopback address, such as the gateway, are identified
+// by the address it forwards in the ClientAddressHeader. It's not trusted from the other peers.
+func PeerIdentity(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return "unknown"
+	}
+
+	host := p.Addr.String()
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		md, _ := metadata.FromIncomingContext(ctx)
+		if values := md.Get(ClientAddressHeader); len(values) > 0 && len(values[0]) > 0 {
+			return values[0]
+		}
+	}
+	return host
+}
+
+// Limiter limits the request rate of each client per method with token buckets,
+// and the number of concurrent streams of each client
+type Limiter struct {
+	mutex        sync.Mutex
+	defaultLimit Limit
+	methodLimits map[string]Limit
+	maxStreams   int
+	services     map[string]bool
+	identify     func(ctx context.Context) string
+	now          func() time.Time
+
+	buckets   map[bucketKey]*bucket
+	streams   map[string]int
+	lastSweep time.Time
+}
+
+type bucketKey struct {
+	client string
+	method string
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Option configures a Limiter
+type Option func(l *Limiter)
+
+// WithDefaultLimit sets the limit of the methods without their own limit
+func WithDefaultLimit(limit Limit) Option {
+	return func(l *Limiter) {
+		l.defaultLimit = limit
+	}
+}
+
+// WithMethodLimits sets the limits of the methods named by their short or full names
+func WithMethodLimits(limits map[string]Limit) Option {
+	return func(l *Limiter) {
+		for method, limit := range limits {
+			l.methodLimits[method] = limit
+		}
+	}
+}
+
+// WithMaxStreams sets the max number of concurrent streams of each client, 0 means no limit
+func WithMaxStreams(maxStreams int) Option {
+	return func(l *Limiter) {
+		l.maxStreams = maxStreams
+	}
+}
+
+// WithServices limits only the methods of the services, e.g. techschool.pcbook.LaptopService,
+// rather than all the methods of the server
+func WithServices(services ...string) Option {
+	return func(l *Limiter) {
+		l.services = make(map[string]bool, len(services))
+		for _, service := range services {
+			l.services[service] = true
+		}
+	}
+}
+
+// WithIdentity sets how the clients are identified, e.g. by their authenticated subject,
+// the default is PeerIdentity
+func WithIdentity(identify func(ctx context.Context) string) Option {
+	return func(l *Limiter) {
+		l.identify = identify
+	}
+}
+
+// WithClock sets the clock of the limiter, it's meant to be used in tests
+func WithClock(now func() time.Time) Option {
+	return func(l *Limiter) {
+		l.now = now
+	}
+}
+
+// NewLimiter returns a new Limiter, without any option nothing is limited
+func NewLimiter(opts ...Option) *Limiter {
+	l := &Limiter{
+		methodLimits: make(map[string]Limit),
+		identify:     PeerIdentity,
+		now:          time.Now,
+		buckets:      make(map[bucketKey]*bucket),
+		streams:      make(map[string]int),
+	}
+
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	return l
+}
+
+// limits tells if the method is limited, a full method is /package.Service/Method
+func (l *Limiter) limits(fullMethod string) bool {
+	if l.services == nil {
+		return true
+	}
+
+	service := strings.TrimPrefix(fullMethod, "/")
+	if i := strings.LastIndex(service, "/"); i >= 0 {
+		service = service[:i]
+	}
+	return l.services[service]
+}
+
+func (l *Limiter) limitOf(fullMethod string) Limit {
+	if limit, ok := l.methodLimits[fullMethod]; ok {
+		return limit
+	}
+	if limit, ok := l.methodLimits[fullMethod[strings.LastIndex(fullMethod, "/")+1:]]; ok {
+		return limit
+	}
+	return l.defaultLimit
+}
+
+// Allow takes a token of the client for the method,
+// it returns a ResourceExhausted error with the delay to retry if there is none left
+func (l *Limiter) Allow(client, fullMethod string) error {
+	limit := l.limitOf(fullMethod)
+	if limit.Rate <= 0 {
+		return nil
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	key := bucketKey{client: client, method: fullMethod}
+	b := l.buckets[key]
+	if b == nil {
+		b = &bucket{tokens: float64(limit.Burst), last: now}
+		l.buckets[key] = b
+	}
+	b.refill(now, limit)
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return nil
+	}
+
+	delay := time.Duration(math.Ceil((1 - b.tokens) / limit.Rate * float64(time.Second)))
+	return rpcerror.New(
+		codes.ResourceExhausted,
+		fmt.Sprintf("rate limit of %s is exceeded, retry after %s", fullMethod, delay),
+		rpcerror.QuotaFailure("client:"+client, fmt.Sprintf("rate limit of %s is %s", fullMethod, limit)),
+		rpcerror.RetryInfo(delay),
+	)
+}
+
+func (b *bucket) refill(now time.Time, limit Limit) {
+	elapsed := now.Sub(b.last).Seconds()
+	if elapsed > 0 {
+		b.tokens = math.Min(float64(limit.Burst), b.tokens+elapsed*limit.Rate)
+		b.last = now
+	}
+}
+
+// sweep drops the buckets which are full again, at most once per sweepInterval
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+
+	for key, b := range l.buckets {
+		limit := l.limitOf(key.method)
+		b.refill(now, limit)
+		if b.tokens >= float64(limit.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// acquireStream counts a new stream of the client, the returned release must be called when it ends
+func (l *Limiter) acquireStream(client string) (func(), error) {
+	if l.maxStreams <= 0 {
+		return func() {}, nil
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.streams[client] >= l.maxStreams {
+		return nil, rpcerror.New(
+			codes.ResourceExhausted,
+			fmt.Sprintf("too many concurrent streams, limit is %d", l.maxStreams),
+			rpcerror.QuotaFailure("client:"+client, fmt.Sprintf("concurrent stream limit is %d", l.maxStreams)),
+			rpcerror.RetryInfo(StreamRetryDelay),
+		)
+	}
+	l.streams[client]++
+
+	return func() {
+		l.mutex.Lock()
+		defer l.mutex.Unlock()
+
+		l.streams[client]--
+		if l.streams[client] == 0 {
+			delete(l.streams, client)
+		}
+	}, nil
+}
+
+// UnaryServerInterceptor rejects the unary RPCs exceeding the rate limits
+func (l *Limiter) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		if !l.limits(info.FullMethod) {
+			return handler(ctx, req)
+		}
+		if err := l.Allow(l.identify(ctx), info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor rejects the streaming RPCs exceeding the rate limits
+// or the concurrent stream limit
+func (l *Limiter) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		stream grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		if !l.limits(info.FullMethod) {
+			return handler(srv, stream)
+		}
+
+		client := l.identify(stream.Context())
+		if err := l.Allow(client, info.FullMethod); err != nil {
+			return err
+		}
+
+		release, err := l.acquireStream(client)
+		if err != nil {
+			return err
+		}
+		defer release()
+
+		return handler(srv, stream)
+	}
+}
@@ -0,0 +1,223 @@
+package ratelimit
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hjcian/grpc-notes/rpcerror"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const rateLaptop = "/techschool.pcbook.LaptopService/RateLaptop"
+const searchLaptop = "/techschool.pcbook.LaptopService/SearchLaptop"
+
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	return c.now
+}
+
+func (c *testClock) Add(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func TestParseLimit(t *testing.T) {
+	t.Parallel()
+
+	limit, err := ParseLimit("2.5:10")
+	require.NoError(t, err)
+	require.Equal(t, Limit{Rate: 2.5, Burst: 10}, limit)
+
+	limit, err = ParseLimit("3")
+	require.NoError(t, err)
+	require.Equal(t, Limit{Rate: 3, Burst: 1}, limit)
+
+	for _, invalid := range []string{"", "fast", "-1", "1:0", "1:many"} {
+		_, err := ParseLimit(invalid)
+		require.Error(t, err, invalid)
+	}
+
+	limits, err := ParseMethodLimits("SearchLaptop=1:5,RateLaptop=10")
+	require.NoError(t, err)
+	require.Equal(t, map[string]Limit{
+		"SearchLaptop": {Rate: 1, Burst: 5},
+		"RateLaptop":   {Rate: 10, Burst: 1},
+	}, limits)
+
+	_, err = ParseMethodLimits("SearchLaptop")
+	require.Error(t, err)
+}
+
+func TestAllow(t *testing.T) {
+	t.Parallel()
+
+	clock := &testClock{now: time.Now()}
+	limiter := NewLimiter(
+		WithMethodLimits(map[string]Limit{"RateLaptop": {Rate: 2, Burst: 3}}),
+		WithClock(clock.Now),
+	)
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, limiter.Allow("alice", rateLaptop))
+	}
+
+	err := limiter.Allow("alice", rateLaptop)
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+	delay, ok := rpcerror.RetryDelay(err)
+	require.True(t, ok)
+	require.Equal(t, 500*time.Millisecond, delay)
+	require.NotNil(t, rpcerror.FromError(err).QuotaFailure)
+
+	// the other clients and methods are not affected
+	require.NoError(t, limiter.Allow("bob", rateLaptop))
+	require.NoError(t, limiter.Allow("alice", searchLaptop))
+
+	clock.Add(delay)
+	require.NoError(t, limiter.Allow("alice", rateLaptop))
+	require.Error(t, limiter.Allow("alice", rateLaptop))
+
+	// the buckets are full again after a while, and dropped
+	clock.Add(time.Hour)
+	require.NoError(t, limiter.Allow("bob", rateLaptop))
+	require.Len(t, limiter.buckets, 1)
+}
+
+// testStream is a server stream of a client
+type testStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *testStream) Context() context.Context {
+	return s.ctx
+}
+
+func newTestStream(ip string) *testStream {
+	addr := &net.TCPAddr{IP: net.ParseIP(ip), Port: 50000}
+	return &testStream{ctx: peer.NewContext(context.Background(), &peer.Peer{Addr: addr})}
+}
+
+func TestStreamServerInterceptor(t *testing.T) {
+	t.Parallel()
+
+	limiter := NewLimiter(WithMaxStreams(2))
+	interceptor := limiter.StreamServerInterceptor()
+	info := &grpc.StreamServerInfo{FullMethod: searchLaptop}
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	handler := func(srv interface{}, stream grpc.ServerStream) error {
+		started <- struct{}{}
+		<-release
+		return nil
+	}
+
+	done := make(chan error)
+	for i := 0; i < 2; i++ {
+		go func() {
+			done <- interceptor(nil, newTestStream("10.0.0.1"), info, handler)
+		}()
+		<-started
+	}
+
+	err := interceptor(nil, newTestStream("10.0.0.1"), info, handler)
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+	delay, ok := rpcerror.RetryDelay(err)
+	require.True(t, ok)
+	require.Equal(t, StreamRetryDelay, delay)
+
+	// another client can still open streams
+	go func() {
+		done <- interceptor(nil, newTestStream("10.0.0.2"), info, handler)
+	}()
+	<-started
+
+	for i := 0; i < 3; i++ {
+		release <- struct{}{}
+		require.NoError(t, <-done)
+	}
+	require.Empty(t, limiter.streams)
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	t.Parallel()
+
+	limiter := NewLimiter(
+		WithDefaultLimit(Limit{Rate: 1, Burst: 1}),
+		WithIdentity(func(ctx context.Context) string { return "subject" }),
+	)
+	interceptor := limiter.UnaryServerInterceptor()
+	info := &grpc.UnaryServerInfo{FullMethod: "/techschool.pcbook.LaptopService/CreateLaptop"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "ok", nil
+	}
+
+	res, err := interceptor(context.Background(), nil, info, handler)
+	require.NoError(t, err)
+	require.Equal(t, "ok", res)
+
+	_, err = interceptor(context.Background(), nil, info, handler)
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
+
+func TestPeerIdentity(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(t, "10.0.0.1", PeerIdentity(newTestStream("10.0.0.1").Context()))
+	require.Equal(t, "unknown", PeerIdentity(context.Background()))
+
+	// the client address is forwarded by a proxy on the loopback address, e.g. the gateway
+	forwarded := metadata.Pairs(ClientAddressHeader, "203.0.113.7")
+	ctx := metadata.NewIncomingContext(newTestStream("127.0.0.1").Context(), forwarded)
+	require.Equal(t, "203.0.113.7", PeerIdentity(ctx))
+	ctx = metadata.NewIncomingContext(newTestStream("::1").Context(), forwarded)
+	require.Equal(t, "203.0.113.7", PeerIdentity(ctx))
+
+	// but not trusted from the other peers
+	ctx = metadata.NewIncomingContext(newTestStream("10.0.0.1").Context(), forwarded)
+	require.Equal(t, "10.0.0.1", PeerIdentity(ctx))
+	require.Equal(t, "127.0.0.1", PeerIdentity(newTestStream("127.0.0.1").Context()))
+}
+
+func TestWithServices(t *testing.T) {
+	t.Parallel()
+
+	limiter := NewLimiter(
+		WithServices("techschool.pcbook.LaptopService"),
+		WithDefaultLimit(Limit{Rate: 1, Burst: 1}),
+		WithMaxStreams(1),
+	)
+	unary := limiter.UnaryServerInterceptor()
+	stream := limiter.StreamServerInterceptor()
+	unaryHandler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, nil
+	}
+	streamHandler := func(srv interface{}, stream grpc.ServerStream) error {
+		return nil
+	}
+	ctx := newTestStream("10.0.0.1").Context()
+
+	// the methods of the other services aren't limited
+	check := &grpc.UnaryServerInfo{FullMethod: "/grpc.health.v1.Health/Check"}
+	watch := &grpc.StreamServerInfo{FullMethod: "/grpc.health.v1.Health/Watch"}
+	for i := 0; i < 3; i++ {
+		_, err := unary(ctx, nil, check, unaryHandler)
+		require.NoError(t, err)
+		require.NoError(t, stream(nil, newTestStream("10.0.0.1"), watch, streamHandler))
+	}
+
+	create := &grpc.UnaryServerInfo{FullMethod: "/techschool.pcbook.LaptopService/CreateLaptop"}
+	_, err := unary(ctx, nil, create, unaryHandler)
+	require.NoError(t, err)
+	_, err = unary(ctx, nil, create, unaryHandler)
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+}
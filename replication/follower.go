@@ -0,0 +1,270 @@
+package replication
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/hjcian/grpc-notes/pb"
+	"github.com/hjcian/grpc-notes/service"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+)
+
+// ErrDiverged is returned by Follower.Run when the leader has restarted with a new catalog,
+// the follower must restart with empty stores to follow it
+var ErrDiverged = errors.New("leader has a different catalog")
+
+// ErrNotSynced is returned by Follower.CheckHealth when the follower isn't following the leader
+var ErrNotSynced = errors.New("follower is not synced with the leader")
+
+// FollowerHealthName is the name of the follower reported by the health server
+const FollowerHealthName = "replication"
+
+// Follower applies the changes of the catalog received from the leader to its stores,
+// the stores must only be written by the follower
+type Follower struct {
+	mutex     sync.Mutex
+	client    pb.ReplicationServiceClient
+	stores    Stores
+	logID     string
+	applied   uint64
+	connected bool
+
+	retryBackoff time.Duration
+	logger       *zap.Logger
+}
+
+// NewFollower returns a new Follower of the leader on the connection
+func NewFollower(conn grpc.ClientConnInterface, stores Stores, opts ...Option) *Follower {
+	config := newConfig(opts)
+	return &Follower{
+		client:       pb.NewReplicationServiceClient(conn),
+		stores:       stores,
+		retryBackoff: config.retryBackoff,
+		logger:       config.logger,
+	}
+}
+
+// AppliedIndex returns the index of the last change applied by the follower
+func (f *Follower) AppliedIndex() uint64 {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return f.applied
+}
+
+// CheckHealth fails if the follower isn't connected to the leader, or has never synced with it
+func (f *Follower) CheckHealth() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if !f.connected || len(f.logID) == 0 {
+		return ErrNotSynced
+	}
+	return nil
+}
+
+// Run follows the leader until the context is done, it reconnects when the stream fails.
+// It returns ErrDiverged if the leader has restarted with a new catalog.
+func (f *Follower) Run(ctx context.Context) error {
+	for {
+		err := f.follow(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if errors.Is(err, ErrDiverged) {
+			return err
+		}
+
+		f.logger.Warn("replication stream failed, reconnect to the leader",
+			zap.Uint64("applied_index", f.AppliedIndex()),
+			zap.Duration("backoff", f.retryBackoff),
+			zap.Error(err))
+
+		timer := time.NewTimer(f.retryBackoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+	}
+}
+
+// follow receives and applies the changes until the stream fails
+func (f *Follower) follow(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	f.mutex.Lock()
+	req := &pb.FollowRequest{
+		LogId:        f.logID,
+		AppliedIndex: f.applied,
+	}
+	f.mutex.Unlock()
+
+	stream, err := f.client.Follow(ctx, req)
+	if err != nil {
+		return err
+	}
+	_, err = stream.Header()
+	if err != nil {
+		return err
+	}
+
+	f.setConnected(true)
+	defer f.setConnected(false)
+	f.logger.Info("following the leader", zap.Uint64("applied_index", req.GetAppliedIndex()))
+
+	// the chunks of the snapshot received so far, applied once all are received
+	var snapshot *pb.Snapshot
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			return errors.New("leader has ended the stream")
+		}
+		if err != nil {
+			return err
+		}
+
+		switch data := res.GetData().(type) {
+		case *pb.FollowResponse_Snapshot:
+			snapshot, err = mergeSnapshotChunk(snapshot, data.Snapshot)
+		case *pb.FollowResponse_SnapshotEnd:
+			end := data.SnapshotEnd
+			if snapshot == nil {
+				snapshot = &pb.Snapshot{LogId: end.GetLogId(), Index: end.GetIndex()}
+			}
+			if snapshot.GetLogId() != end.GetLogId() || snapshot.GetIndex() != end.GetIndex() {
+				err = fmt.Errorf("received end of snapshot %s/%d after chunks of %s/%d",
+					end.GetLogId(), end.GetIndex(), snapshot.GetLogId(), snapshot.GetIndex())
+				break
+			}
+			err = f.applySnapshot(snapshot)
+			snapshot = nil
+		case *pb.FollowResponse_Change:
+			if snapshot != nil {
+				err = fmt.Errorf("received change %d before the end of snapshot", data.Change.GetIndex())
+				break
+			}
+			err = f.apply(data.Change)
+		default:
+			err = fmt.Errorf("unexpected response %T", data)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// mergeSnapshotChunk appends the chunk to the chunks of the same snapshot received before
+func mergeSnapshotChunk(snapshot, chunk *pb.Snapshot) (*pb.Snapshot, error) {
+	if snapshot == nil {
+		return chunk, nil
+	}
+	if chunk.GetLogId() != snapshot.GetLogId() || chunk.GetIndex() != snapshot.GetIndex() {
+		return nil, fmt.Errorf("received chunk of snapshot %s/%d after chunks of %s/%d",
+			chunk.GetLogId(), chunk.GetIndex(), snapshot.GetLogId(), snapshot.GetIndex())
+	}
+	snapshot.Laptops = append(snapshot.Laptops, chunk.GetLaptops()...)
+	snapshot.Ratings = append(snapshot.Ratings, chunk.GetRatings()...)
+	snapshot.Images = append(snapshot.Images, chunk.GetImages()...)
+	snapshot.PrimaryImages = append(snapshot.PrimaryImages, chunk.GetPrimaryImages()...)
+	return snapshot, nil
+}
+
+func (f *Follower) setConnected(connected bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.connected = connected
+}
+
+// applySnapshot merges the snapshot into the stores. The catalog only grows, so the snapshot
+// of the same log contains everything the follower has applied.
+func (f *Follower) applySnapshot(snapshot *pb.Snapshot) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.applied > 0 && snapshot.GetLogId() != f.logID {
+		return fmt.Errorf("%w: log %s instead of %s", ErrDiverged, snapshot.GetLogId(), f.logID)
+	}
+
+	for _, laptop := range snapshot.GetLaptops() {
+		err := f.stores.Laptops.Save(laptop)
+		if err != nil && !errors.Is(err, service.ErrAlreadyExists) {
+			return fmt.Errorf("cannot save laptop %s: %w", laptop.GetId(), err)
+		}
+	}
+	for _, rating := range snapshot.GetRatings() {
+		f.stores.Ratings.Set(rating.GetLaptopId(), service.Rating{
+			Count: rating.GetCount(),
+			Sum:   rating.GetSum(),
+		})
+	}
+	for _, image := range snapshot.GetImages() {
+		f.addImage(image)
+	}
+	for _, primary := range snapshot.GetPrimaryImages() {
+		err := f.stores.Images.SetPrimary(primary.GetLaptopId(), primary.GetImageId())
+		if err != nil {
+			return fmt.Errorf("cannot set primary image of laptop %s: %w", primary.GetLaptopId(), err)
+		}
+	}
+
+	f.logID = snapshot.GetLogId()
+	f.applied = snapshot.GetIndex()
+	f.logger.Info("applied snapshot",
+		zap.String("log_id", f.logID),
+		zap.Uint64("index", f.applied),
+		zap.Int("laptops", len(snapshot.GetLaptops())))
+	return nil
+}
+
+// apply applies the next change to the stores
+func (f *Follower) apply(change *pb.Change) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if change.GetIndex() != f.applied+1 {
+		return fmt.Errorf("received change %d after %d", change.GetIndex(), f.applied)
+	}
+
+	switch data := change.GetData().(type) {
+	case *pb.Change_LaptopsCreated:
+		err := f.stores.Laptops.SaveAll(data.LaptopsCreated.GetLaptops())
+		if err != nil {
+			return fmt.Errorf("cannot save laptops of change %d: %w", change.GetIndex(), err)
+		}
+	case *pb.Change_RatingAdded:
+		_, err := f.stores.Ratings.Add(data.RatingAdded.GetLaptopId(), data.RatingAdded.GetScore())
+		if err != nil {
+			return fmt.Errorf("cannot add rating of change %d: %w", change.GetIndex(), err)
+		}
+	case *pb.Change_ImageSaved:
+		f.addImage(data.ImageSaved)
+	case *pb.Change_PrimaryImageSet:
+		primary := data.PrimaryImageSet
+		err := f.stores.Images.SetPrimary(primary.GetLaptopId(), primary.GetImageId())
+		if err != nil {
+			return fmt.Errorf("cannot set primary image of change %d: %w", change.GetIndex(), err)
+		}
+	default:
+		return fmt.Errorf("unexpected change %d of type %T", change.GetIndex(), data)
+	}
+
+	f.applied = change.GetIndex()
+	return nil
+}
+
+func (f *Follower) addImage(image *pb.ImageSaved) {
+	f.stores.Images.AddInfo(image.GetImageId(), &service.ImageInfo{
+		LaptopID: image.GetLaptopId(),
+		Type:     image.GetImageType(),
+		Size:     int(image.GetSize()),
+	})
+}
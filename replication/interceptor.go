@@ -0,0 +1,63 @@
+package replication
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hjcian/grpc-notes/rpcerror"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// NotLeaderReason is the reason of the ErrorInfo of the writes rejected by a follower,
+// its metadata has the address of the leader under the "leader" key
+const NotLeaderReason = "NOT_LEADER"
+
+// WriteMethods are the methods of LaptopService changing the catalog, only the leader serves them
+var WriteMethods = map[string]bool{
+	"/techschool.pcbook.LaptopService/CreateLaptop":    true,
+	"/techschool.pcbook.LaptopService/CreateLaptops":   true,
+	"/techschool.pcbook.LaptopService/UploadImage":     true,
+	"/techschool.pcbook.LaptopService/RateLaptop":      true,
+	"/techschool.pcbook.LaptopService/SetPrimaryImage": true,
+}
+
+// notLeaderError is UNAVAILABLE, so that the clients balancing across the nodes
+// retry the idempotent writes on another node, until they reach the leader
+func notLeaderError(leader string) error {
+	return rpcerror.New(
+		codes.Unavailable,
+		fmt.Sprintf("this node is a follower, the writes are served by the leader %s", leader),
+		rpcerror.ErrorInfo(NotLeaderReason, map[string]string{"leader": leader}),
+	)
+}
+
+// UnaryServerInterceptor rejects the unary writes sent to a follower of the leader
+func UnaryServerInterceptor(leader string) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		if WriteMethods[info.FullMethod] {
+			return nil, notLeaderError(leader)
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor rejects the streaming writes sent to a follower of the leader
+func StreamServerInterceptor(leader string) grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		stream grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		if WriteMethods[info.FullMethod] {
+			return notLeaderError(leader)
+		}
+		return handler(srv, stream)
+	}
+}
@@ -0,0 +1,348 @@
+package replication
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/hjcian/grpc-notes/logging"
+	"github.com/hjcian/grpc-notes/pb"
+	"github.com/hjcian/grpc-notes/service"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Stores are the in-memory stores of the catalog of a node
+type Stores struct {
+	Laptops *service.InMemoryLaptopStore
+	Images  *service.DiskImageStore
+	Ratings *service.InMemoryRatingStore
+}
+
+// Leader accepts the writes of the catalog and records them in its log,
+// the followers receive the changes from the Follow RPC
+type Leader struct {
+	// mutex orders the writes as their changes in the log,
+	// so that a snapshot is the state of the stores at its index
+	mutex  sync.Mutex
+	stores Stores
+	log    *Log
+
+	snapshotChunkSize int
+	logger            *zap.Logger
+}
+
+// NewLeader returns a new Leader of the catalog in the stores, which must be empty
+func NewLeader(stores Stores, opts ...Option) *Leader {
+	config := newConfig(opts)
+	return &Leader{
+		stores:            stores,
+		log:               NewLog(config.logCapacity),
+		snapshotChunkSize: config.snapshotChunkSize,
+		logger:            config.logger,
+	}
+}
+
+// LastIndex returns the index of the last change of the catalog
+func (l *Leader) LastIndex() uint64 {
+	return l.log.LastIndex()
+}
+
+// LaptopStore returns the laptop store recording the saved laptops in the log
+func (l *Leader) LaptopStore() service.LaptopStore {
+	return &leaderLaptopStore{InMemoryLaptopStore: l.stores.Laptops, leader: l}
+}
+
+// ImageStore returns the image store recording the metadata of the saved images in the log
+func (l *Leader) ImageStore() service.ImageStore {
+	return &leaderImageStore{DiskImageStore: l.stores.Images, leader: l}
+}
+
+// RatingStore returns the rating store recording the ratings in the log
+func (l *Leader) RatingStore() service.RatingStore {
+	return &leaderRatingStore{InMemoryRatingStore: l.stores.Ratings, leader: l}
+}
+
+// Follow streams the changes of the catalog to a follower
+func (l *Leader) Follow(req *pb.FollowRequest, stream pb.ReplicationService_FollowServer) error {
+	ctx := stream.Context()
+	logger := logging.FromContext(ctx, l.logger)
+
+	// the header tells the follower it's connected, even if there is no change to send
+	err := stream.SendHeader(metadata.MD{})
+	if err != nil {
+		return err
+	}
+
+	applied := req.GetAppliedIndex()
+	if req.GetLogId() != l.log.ID() || applied == 0 {
+		applied, err = l.sendSnapshot(stream)
+		if err != nil {
+			return err
+		}
+	}
+
+	for {
+		changes, ok, appended := l.log.Since(applied)
+		if !ok {
+			logger.Info("follower is behind the log, send a snapshot", zap.Uint64("applied_index", applied))
+			applied, err = l.sendSnapshot(stream)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		for _, change := range changes {
+			err := stream.Send(&pb.FollowResponse{
+				Data: &pb.FollowResponse_Change{Change: change},
+			})
+			if err != nil {
+				return err
+			}
+			applied = change.GetIndex()
+		}
+
+		if len(changes) > 0 {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case <-appended:
+		}
+	}
+}
+
+// sendSnapshot sends the snapshot of the catalog in chunks followed by its end, and returns its index
+func (l *Leader) sendSnapshot(stream pb.ReplicationService_FollowServer) (uint64, error) {
+	snapshot, err := l.snapshot()
+	if err != nil {
+		return 0, status.Errorf(codes.Internal, "cannot take snapshot: %v", err)
+	}
+
+	for _, chunk := range snapshotChunks(snapshot, l.snapshotChunkSize) {
+		err = stream.Send(&pb.FollowResponse{
+			Data: &pb.FollowResponse_Snapshot{Snapshot: chunk},
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err = stream.Send(&pb.FollowResponse{
+		Data: &pb.FollowResponse_SnapshotEnd{SnapshotEnd: &pb.SnapshotEnd{
+			LogId: snapshot.GetLogId(),
+			Index: snapshot.GetIndex(),
+		}},
+	})
+	if err != nil {
+		return 0, err
+	}
+	return snapshot.GetIndex(), nil
+}
+
+// snapshotChunks splits the snapshot into chunks of at most maxSize bytes of items,
+// in the order the follower applies them. An item larger than maxSize is sent alone.
+func snapshotChunks(snapshot *pb.Snapshot, maxSize int) []*pb.Snapshot {
+	var chunks []*pb.Snapshot
+	var chunk *pb.Snapshot
+	size := 0
+
+	add := func(item proto.Message, appendTo func(chunk *pb.Snapshot)) {
+		// the tag and the length of the item in the chunk take a few more bytes
+		itemSize := proto.Size(item) + 8
+		if chunk == nil || (size > 0 && size+itemSize > maxSize) {
+			chunk = &pb.Snapshot{
+				LogId: snapshot.GetLogId(),
+				Index: snapshot.GetIndex(),
+			}
+			chunks = append(chunks, chunk)
+			size = 0
+		}
+		appendTo(chunk)
+		size += itemSize
+	}
+
+	for _, laptop := range snapshot.GetLaptops() {
+		laptop := laptop
+		add(laptop, func(chunk *pb.Snapshot) { chunk.Laptops = append(chunk.Laptops, laptop) })
+	}
+	for _, rating := range snapshot.GetRatings() {
+		rating := rating
+		add(rating, func(chunk *pb.Snapshot) { chunk.Ratings = append(chunk.Ratings, rating) })
+	}
+	for _, image := range snapshot.GetImages() {
+		image := image
+		add(image, func(chunk *pb.Snapshot) { chunk.Images = append(chunk.Images, image) })
+	}
+	for _, primary := range snapshot.GetPrimaryImages() {
+		primary := primary
+		add(primary, func(chunk *pb.Snapshot) { chunk.PrimaryImages = append(chunk.PrimaryImages, primary) })
+	}
+	return chunks
+}
+
+// snapshot returns the catalog at the last index of the log
+func (l *Leader) snapshot() (*pb.Snapshot, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	snapshot := &pb.Snapshot{
+		LogId: l.log.ID(),
+		Index: l.log.LastIndex(),
+	}
+
+	err := l.stores.Laptops.All(func(laptop *pb.Laptop) error {
+		snapshot.Laptops = append(snapshot.Laptops, laptop)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for laptopID, rating := range l.stores.Ratings.All() {
+		snapshot.Ratings = append(snapshot.Ratings, &pb.LaptopRating{
+			LaptopId: laptopID,
+			Count:    rating.Count,
+			Sum:      rating.Sum,
+		})
+	}
+
+	images, primary := l.stores.Images.All()
+	for imageID, image := range images {
+		snapshot.Images = append(snapshot.Images, imageSaved(imageID, &image))
+	}
+	for laptopID, imageID := range primary {
+		snapshot.PrimaryImages = append(snapshot.PrimaryImages, &pb.PrimaryImageSet{
+			LaptopId: laptopID,
+			ImageId:  imageID,
+		})
+	}
+
+	return snapshot, nil
+}
+
+// write applies the write to the stores and appends its change to the log if it succeeds
+func (l *Leader) write(apply func() (*pb.Change, error)) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	change, err := apply()
+	if err != nil {
+		return err
+	}
+	l.log.Append(change)
+	return nil
+}
+
+func imageSaved(imageID string, image *service.ImageInfo) *pb.ImageSaved {
+	return &pb.ImageSaved{
+		ImageId:   imageID,
+		LaptopId:  image.LaptopID,
+		ImageType: image.Type,
+		Size:      uint32(image.Size),
+	}
+}
+
+type leaderLaptopStore struct {
+	*service.InMemoryLaptopStore
+	leader *Leader
+}
+
+func (s *leaderLaptopStore) Save(laptop *pb.Laptop) error {
+	return s.SaveAll([]*pb.Laptop{laptop})
+}
+
+func (s *leaderLaptopStore) SaveAll(laptops []*pb.Laptop) error {
+	return s.leader.write(func() (*pb.Change, error) {
+		var err error
+		if len(laptops) == 1 {
+			// keep the errors of Save, which aren't wrapped with the laptop ID
+			err = s.InMemoryLaptopStore.Save(laptops[0])
+		} else {
+			err = s.InMemoryLaptopStore.SaveAll(laptops)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		created := &pb.LaptopsCreated{}
+		for _, laptop := range laptops {
+			created.Laptops = append(created.Laptops, proto.Clone(laptop).(*pb.Laptop))
+		}
+		return &pb.Change{Data: &pb.Change_LaptopsCreated{LaptopsCreated: created}}, nil
+	})
+}
+
+type leaderImageStore struct {
+	*service.DiskImageStore
+	leader *Leader
+}
+
+// Save writes the image file without holding the leader lock. Its change may be appended after
+// the one of another write, but nothing depends on the image until its ID is returned.
+func (s *leaderImageStore) Save(
+	laptopID, imageType string,
+	imageData bytes.Buffer,
+	quota service.ImageQuota,
+) (string, error) {
+	imageID, err := s.DiskImageStore.Save(laptopID, imageType, imageData, quota)
+	if err != nil {
+		return "", err
+	}
+
+	err = s.leader.write(func() (*pb.Change, error) {
+		image, err := s.DiskImageStore.Find(imageID)
+		if err != nil {
+			return nil, err
+		}
+		if image == nil {
+			return nil, fmt.Errorf("saved image %s: %w", imageID, service.ErrNotFound)
+		}
+		return &pb.Change{Data: &pb.Change_ImageSaved{ImageSaved: imageSaved(imageID, image)}}, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return imageID, nil
+}
+
+func (s *leaderImageStore) SetPrimary(laptopID, imageID string) error {
+	return s.leader.write(func() (*pb.Change, error) {
+		err := s.DiskImageStore.SetPrimary(laptopID, imageID)
+		if err != nil {
+			return nil, err
+		}
+		return &pb.Change{Data: &pb.Change_PrimaryImageSet{
+			PrimaryImageSet: &pb.PrimaryImageSet{LaptopId: laptopID, ImageId: imageID},
+		}}, nil
+	})
+}
+
+type leaderRatingStore struct {
+	*service.InMemoryRatingStore
+	leader *Leader
+}
+
+func (s *leaderRatingStore) Add(laptopID string, score float64) (*service.Rating, error) {
+	var rating *service.Rating
+	err := s.leader.write(func() (*pb.Change, error) {
+		var err error
+		rating, err = s.InMemoryRatingStore.Add(laptopID, score)
+		if err != nil {
+			return nil, err
+		}
+		return &pb.Change{Data: &pb.Change_RatingAdded{
+			RatingAdded: &pb.RatingAdded{LaptopId: laptopID, Score: score},
+		}}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rating, nil
+}
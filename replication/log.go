@@ -0,0 +1,77 @@
+package replication
+
+import (
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/hjcian/grpc-notes/pb"
+)
+
+// Log keeps the latest changes of the catalog in order. It keeps at least capacity changes,
+// the older ones are dropped and the followers missing them catch up from a snapshot.
+type Log struct {
+	mutex    sync.Mutex
+	id       string
+	changes  []*pb.Change
+	last     uint64
+	capacity int
+	// appended is closed and replaced each time a change is appended
+	appended chan struct{}
+}
+
+// NewLog returns a new empty Log with a new random ID
+func NewLog(capacity int) *Log {
+	return &Log{
+		id:       uuid.New().String(),
+		capacity: capacity,
+		appended: make(chan struct{}),
+	}
+}
+
+// ID returns the ID of the log, the indexes of the changes of different logs are unrelated
+func (l *Log) ID() string {
+	return l.id
+}
+
+// Append gives the change the next index and appends it
+func (l *Log) Append(change *pb.Change) uint64 {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.last++
+	change.Index = l.last
+	l.changes = append(l.changes, change)
+
+	// drop the oldest changes by batch, so that they aren't copied on each append
+	if len(l.changes) >= 2*l.capacity {
+		l.changes = append([]*pb.Change(nil), l.changes[len(l.changes)-l.capacity:]...)
+	}
+
+	close(l.appended)
+	l.appended = make(chan struct{})
+	return l.last
+}
+
+// LastIndex returns the index of the last change, 0 if there is none
+func (l *Log) LastIndex() uint64 {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.last
+}
+
+// Since returns the changes after the index. ok is false if some of them are already dropped,
+// or the index is after the last change. The appended channel is closed once a new change
+// is appended after the returned ones.
+func (l *Log) Since(index uint64) (changes []*pb.Change, ok bool, appended <-chan struct{}) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	first := l.last + 1 - uint64(len(l.changes))
+	if index+1 < first || index > l.last {
+		return nil, false, l.appended
+	}
+
+	changes = append(changes, l.changes[index+1-first:]...)
+	return changes, true, l.appended
+}
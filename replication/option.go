@@ -0,0 +1,67 @@
+package replication
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Defaults of the options of Leader and Follower
+const (
+	DefaultLogCapacity       = 10000
+	DefaultRetryBackoff      = time.Second
+	DefaultSnapshotChunkSize = 1 << 20
+)
+
+type config struct {
+	logCapacity       int
+	retryBackoff      time.Duration
+	snapshotChunkSize int
+	logger            *zap.Logger
+}
+
+// Option configures a Leader or a Follower
+type Option func(c *config)
+
+func newConfig(opts []Option) *config {
+	c := &config{
+		logCapacity:       DefaultLogCapacity,
+		retryBackoff:      DefaultRetryBackoff,
+		snapshotChunkSize: DefaultSnapshotChunkSize,
+		logger:            zap.NewNop(),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// WithLogCapacity sets how many changes the leader keeps at least,
+// the followers further behind catch up from a snapshot
+func WithLogCapacity(capacity int) Option {
+	return func(c *config) {
+		c.logCapacity = capacity
+	}
+}
+
+// WithRetryBackoff sets how long the follower waits before reconnecting to the leader
+func WithRetryBackoff(backoff time.Duration) Option {
+	return func(c *config) {
+		c.retryBackoff = backoff
+	}
+}
+
+// WithSnapshotChunkSize sets how many bytes of the catalog the leader sends at most
+// in each chunk of a snapshot, besides a larger laptop sent alone
+func WithSnapshotChunkSize(size int) Option {
+	return func(c *config) {
+		c.snapshotChunkSize = size
+	}
+}
+
+// WithLogger sets the logger of the leader or the follower
+func WithLogger(logger *zap.Logger) Option {
+	return func(c *config) {
+		c.logger = logger
+	}
+}
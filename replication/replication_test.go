@@ -0,0 +1,240 @@
+package replication_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hjcian/grpc-notes/client"
+	"github.com/hjcian/grpc-notes/pb"
+	"github.com/hjcian/grpc-notes/replication"
+	"github.com/hjcian/grpc-notes/rpcerror"
+	"github.com/hjcian/grpc-notes/sample"
+	"github.com/hjcian/grpc-notes/service"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+)
+
+type node struct {
+	address string
+	stores  replication.Stores
+	client  *client.LaptopClient
+}
+
+func newStores(t *testing.T) replication.Stores {
+	return replication.Stores{
+		Laptops: service.NewInMemoryLaptopStore(),
+		Images:  service.NewDiskImageStore(t.TempDir()),
+		Ratings: service.NewInMemoryRatingStore(),
+	}
+}
+
+// serve serves the laptop service of the node on a new port
+func serve(t *testing.T, grpcServer *grpc.Server, stores replication.Stores) *node {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithInsecure())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return &node{
+		address: listener.Addr().String(),
+		stores:  stores,
+		client:  client.NewLaptopClient(conn, client.WithRetries(0, 0)),
+	}
+}
+
+func startLeader(t *testing.T, opts ...replication.Option) (*replication.Leader, *node) {
+	stores := newStores(t)
+	leader := replication.NewLeader(stores, opts...)
+
+	grpcServer := grpc.NewServer()
+	laptopServer := service.NewLaptopServer(leader.LaptopStore(), leader.ImageStore(), leader.RatingStore())
+	pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
+	pb.RegisterReplicationServiceServer(grpcServer, leader)
+
+	return leader, serve(t, grpcServer, stores)
+}
+
+// startFollower starts following the leader, until the returned function is called
+func startFollower(t *testing.T, leaderAddress string) (*replication.Follower, *node, func()) {
+	stores := newStores(t)
+
+	conn, err := grpc.Dial(leaderAddress, grpc.WithInsecure())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	follower := replication.NewFollower(conn, stores, replication.WithRetryBackoff(10*time.Millisecond))
+
+	grpcServer := grpc.NewServer(
+		grpc.UnaryInterceptor(replication.UnaryServerInterceptor(leaderAddress)),
+		grpc.StreamInterceptor(replication.StreamServerInterceptor(leaderAddress)),
+	)
+	laptopServer := service.NewLaptopServer(stores.Laptops, stores.Images, stores.Ratings)
+	pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
+
+	return follower, serve(t, grpcServer, stores), runFollower(t, follower)
+}
+
+// runFollower runs the follower until the returned function is called
+func runFollower(t *testing.T, follower *replication.Follower) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- follower.Run(ctx)
+	}()
+
+	var stopped bool
+	stop := func() {
+		if !stopped {
+			stopped = true
+			cancel()
+			require.NoError(t, <-done)
+		}
+	}
+	t.Cleanup(stop)
+	return stop
+}
+
+func requireSynced(t *testing.T, leader *replication.Leader, followers ...*replication.Follower) {
+	require.Eventually(t, func() bool {
+		for _, follower := range followers {
+			if follower.AppliedIndex() != leader.LastIndex() {
+				return false
+			}
+		}
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestReplication(t *testing.T) {
+	t.Parallel()
+
+	leader, leaderNode := startLeader(t)
+	follower1, followerNode1, _ := startFollower(t, leaderNode.address)
+	follower2, followerNode2, _ := startFollower(t, leaderNode.address)
+	ctx := context.Background()
+
+	require.Eventually(t, func() bool {
+		return follower1.CheckHealth() == nil && follower2.CheckHealth() == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	laptopID, err := leaderNode.client.Create(ctx, sample.NewLaptop())
+	require.NoError(t, err)
+	_, err = leaderNode.client.CreateAll(ctx, []*pb.Laptop{sample.NewLaptop(), sample.NewLaptop()}, true)
+	require.NoError(t, err)
+
+	image, err := leaderNode.client.UploadImageFromReader(ctx, laptopID, ".jpg", bytes.NewReader([]byte("image")), nil)
+	require.NoError(t, err)
+	require.NoError(t, leaderNode.client.SetPrimaryImage(ctx, laptopID, image.GetId()))
+
+	session, err := leaderNode.client.NewRateSession(ctx)
+	require.NoError(t, err)
+	_, err = session.Rate(laptopID, 8)
+	require.NoError(t, err)
+	_, err = session.Rate(laptopID, 10)
+	require.NoError(t, err)
+	require.NoError(t, session.Close())
+
+	requireSynced(t, leader, follower1, follower2)
+	require.Equal(t, uint64(6), leader.LastIndex())
+
+	for _, followerNode := range []*node{followerNode1, followerNode2} {
+		require.Equal(t, 3, followerNode.stores.Laptops.Count())
+		require.Equal(t, leaderNode.stores.Ratings.All(), followerNode.stores.Ratings.All())
+
+		res, err := followerNode.client.Get(ctx, laptopID)
+		require.NoError(t, err)
+		require.Equal(t, laptopID, res.GetLaptop().GetId())
+		require.Equal(t, image.GetId(), res.GetPrimaryImageId())
+
+		info, err := followerNode.stores.Images.Find(image.GetId())
+		require.NoError(t, err)
+		require.Equal(t, laptopID, info.LaptopID)
+		require.Equal(t, int(image.GetSize()), info.Size)
+	}
+}
+
+func TestFollowerRejectsWrites(t *testing.T) {
+	t.Parallel()
+
+	_, leaderNode := startLeader(t)
+	_, followerNode, _ := startFollower(t, leaderNode.address)
+	ctx := context.Background()
+
+	_, err := followerNode.client.Create(ctx, sample.NewLaptop())
+	require.True(t, errors.Is(err, client.ErrUnavailable))
+	errorInfo := rpcerror.FromError(err).ErrorInfo
+	require.NotNil(t, errorInfo)
+	require.Equal(t, replication.NotLeaderReason, errorInfo.GetReason())
+	require.Equal(t, leaderNode.address, errorInfo.GetMetadata()["leader"])
+
+	_, err = followerNode.client.CreateAll(ctx, []*pb.Laptop{sample.NewLaptop()}, false)
+	require.True(t, errors.Is(err, client.ErrUnavailable))
+	require.Zero(t, followerNode.stores.Laptops.Count())
+
+	// the reads are served
+	_, err = followerNode.client.Get(ctx, "unknown")
+	require.True(t, errors.Is(err, client.ErrNotFound))
+}
+
+func TestCatchUpFromSnapshot(t *testing.T) {
+	t.Parallel()
+
+	leader, leaderNode := startLeader(t, replication.WithLogCapacity(2))
+	ctx := context.Background()
+
+	createLaptops := func(n int) {
+		for i := 0; i < n; i++ {
+			laptopID, err := leaderNode.client.Create(ctx, sample.NewLaptop())
+			require.NoError(t, err)
+			_, err = leader.RatingStore().Add(laptopID, 5)
+			require.NoError(t, err)
+		}
+	}
+
+	// a new follower gets the catalog from a snapshot, then the next changes
+	createLaptops(5)
+	follower, followerNode, stop := startFollower(t, leaderNode.address)
+	requireSynced(t, leader, follower)
+	require.Equal(t, 5, followerNode.stores.Laptops.Count())
+
+	createLaptops(1)
+	requireSynced(t, leader, follower)
+	require.Equal(t, 6, followerNode.stores.Laptops.Count())
+	require.Equal(t, uint64(12), follower.AppliedIndex())
+
+	// a follower behind the log catches up from a new snapshot
+	stop()
+	require.Equal(t, replication.ErrNotSynced, follower.CheckHealth())
+	createLaptops(5)
+	runFollower(t, follower)
+	requireSynced(t, leader, follower)
+	require.NoError(t, follower.CheckHealth())
+	require.Equal(t, 11, followerNode.stores.Laptops.Count())
+	require.Equal(t, leaderNode.stores.Ratings.All(), followerNode.stores.Ratings.All())
+}
+
+func TestCatchUpFromLargeSnapshot(t *testing.T) {
+	t.Parallel()
+
+	leader, leaderNode := startLeader(t, replication.WithLogCapacity(1))
+
+	// the catalog is larger than the max message size of gRPC
+	for i := 0; i < 50; i++ {
+		laptop := sample.NewLaptop()
+		laptop.Name = strings.Repeat("x", 100*1024)
+		require.NoError(t, leader.LaptopStore().Save(laptop))
+	}
+
+	follower, followerNode, _ := startFollower(t, leaderNode.address)
+	requireSynced(t, leader, follower)
+	require.NoError(t, follower.CheckHealth())
+	require.Equal(t, 50, followerNode.stores.Laptops.Count())
+}
@@ -0,0 +1,176 @@
+package rpcerror
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Resource types used in the ResourceInfo details
+const (
+	LaptopResourceType = "techschool.pcbook.Laptop"
+	ImageResourceType  = "techschool.pcbook.Image"
+)
+
+// New returns a gRPC status error with the details attached
+func New(code codes.Code, message string, details ...proto.Message) error {
+	st := status.New(code, message)
+	if len(details) == 0 {
+		return st.Err()
+	}
+
+	detailed, err := st.WithDetails(details...)
+	if err != nil {
+		// the details are only a help for the client, still return the error without them
+		return st.Err()
+	}
+	return detailed.Err()
+}
+
+// ResourceInfo describes the resource which is missing or already exists
+func ResourceInfo(resourceType, resourceName, description string) *errdetails.ResourceInfo {
+	return &errdetails.ResourceInfo{
+		ResourceType: resourceType,
+		ResourceName: resourceName,
+		Description:  description,
+	}
+}
+
+// BadRequest describes one invalid field of the request
+func BadRequest(field, description string) *errdetails.BadRequest {
+	return &errdetails.BadRequest{
+		FieldViolations: []*errdetails.BadRequest_FieldViolation{
+			{
+				Field:       field,
+				Description: description,
+			},
+		},
+	}
+}
+
+// QuotaFailure describes which limit is exceeded
+func QuotaFailure(subject, description string) *errdetails.QuotaFailure {
+	return &errdetails.QuotaFailure{
+		Violations: []*errdetails.QuotaFailure_Violation{
+			{
+				Subject:     subject,
+				Description: description,
+			},
+		},
+	}
+}
+
+// RetryInfo tells the client how long to wait before retrying a transient error
+func RetryInfo(delay time.Duration) *errdetails.RetryInfo {
+	return &errdetails.RetryInfo{
+		RetryDelay: ptypes.DurationProto(delay),
+	}
+}
+
+// ErrorDomain is the domain of the ErrorInfo details
+const ErrorDomain = "pcbook.techschool"
+
+// ErrorInfo tells why the request failed in a machine readable reason,
+// with metadata helping the client to recover, e.g. which server to call instead
+func ErrorInfo(reason string, metadata map[string]string) *errdetails.ErrorInfo {
+	return &errdetails.ErrorInfo{
+		Reason:   reason,
+		Domain:   ErrorDomain,
+		Metadata: metadata,
+	}
+}
+
+// Details are the error details the server attaches to the status errors
+type Details struct {
+	ResourceInfo *errdetails.ResourceInfo
+	BadRequest   *errdetails.BadRequest
+	QuotaFailure *errdetails.QuotaFailure
+	RetryInfo    *errdetails.RetryInfo
+	ErrorInfo    *errdetails.ErrorInfo
+}
+
+// FromError extracts the details attached to a gRPC status error,
+// the fields are nil if the error doesn't carry the corresponding detail
+func FromError(err error) *Details {
+	result := &Details{}
+
+	st, ok := status.FromError(err)
+	if !ok {
+		return result
+	}
+
+	for _, detail := range st.Details() {
+		switch detail := detail.(type) {
+		case *errdetails.ResourceInfo:
+			result.ResourceInfo = detail
+		case *errdetails.BadRequest:
+			result.BadRequest = detail
+		case *errdetails.QuotaFailure:
+			result.QuotaFailure = detail
+		case *errdetails.RetryInfo:
+			result.RetryInfo = detail
+		case *errdetails.ErrorInfo:
+			result.ErrorInfo = detail
+		}
+	}
+
+	return result
+}
+
+// RetryDelay returns the delay suggested by the server for retrying the error,
+// ok is false if the error is not retryable
+func RetryDelay(err error) (delay time.Duration, ok bool) {
+	retryInfo := FromError(err).RetryInfo
+	if retryInfo == nil {
+		return 0, false
+	}
+
+	delay, err = ptypes.Duration(retryInfo.GetRetryDelay())
+	if err != nil {
+		return 0, false
+	}
+	return delay, true
+}
+
+// String formats the details into one line, e.g. for logs
+func (d *Details) String() string {
+	s := ""
+	if d.ResourceInfo != nil {
+		s += fmt.Sprintf(" resource=%s/%s", d.ResourceInfo.GetResourceType(), d.ResourceInfo.GetResourceName())
+	}
+	if d.BadRequest != nil {
+		for _, violation := range d.BadRequest.GetFieldViolations() {
+			s += fmt.Sprintf(" field=%s(%s)", violation.GetField(), violation.GetDescription())
+		}
+	}
+	if d.QuotaFailure != nil {
+		for _, violation := range d.QuotaFailure.GetViolations() {
+			s += fmt.Sprintf(" quota=%s(%s)", violation.GetSubject(), violation.GetDescription())
+		}
+	}
+	if d.RetryInfo != nil {
+		delay, _ := ptypes.Duration(d.RetryInfo.GetRetryDelay())
+		s += fmt.Sprintf(" retry_delay=%s", delay)
+	}
+	if d.ErrorInfo != nil {
+		s += fmt.Sprintf(" reason=%s", d.ErrorInfo.GetReason())
+		keys := make([]string, 0, len(d.ErrorInfo.GetMetadata()))
+		for key := range d.ErrorInfo.GetMetadata() {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			s += fmt.Sprintf(" %s=%s", key, d.ErrorInfo.GetMetadata()[key])
+		}
+	}
+	if len(s) == 0 {
+		return s
+	}
+	return s[1:]
+}
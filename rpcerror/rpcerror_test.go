@@ -0,0 +1,83 @@
+package rpcerror
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestFromError(t *testing.T) {
+	t.Parallel()
+
+	err := New(
+		codes.ResourceExhausted,
+		"too many images",
+		QuotaFailure("laptop:1", "image count limit is 2"),
+		RetryInfo(2*time.Second),
+	)
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+	require.Equal(t, "too many images", status.Convert(err).Message())
+
+	details := FromError(err)
+	require.Nil(t, details.ResourceInfo)
+	require.Nil(t, details.BadRequest)
+	require.NotNil(t, details.QuotaFailure)
+	require.Equal(t, "laptop:1", details.QuotaFailure.GetViolations()[0].GetSubject())
+	require.Equal(t, "quota=laptop:1(image count limit is 2) retry_delay=2s", details.String())
+
+	delay, ok := RetryDelay(err)
+	require.True(t, ok)
+	require.Equal(t, 2*time.Second, delay)
+}
+
+func TestFromErrorWithoutDetails(t *testing.T) {
+	t.Parallel()
+
+	for _, err := range []error{
+		nil,
+		errors.New("not a status error"),
+		status.Error(codes.Internal, "no details"),
+	} {
+		details := FromError(err)
+		require.Equal(t, &Details{}, details)
+		require.Empty(t, details.String())
+
+		_, ok := RetryDelay(err)
+		require.False(t, ok)
+	}
+}
+
+func TestResourceInfo(t *testing.T) {
+	t.Parallel()
+
+	err := New(
+		codes.NotFound,
+		"laptop id 1 doesn't exist",
+		ResourceInfo(LaptopResourceType, "1", "laptop doesn't exist"),
+	)
+
+	details := FromError(err)
+	require.NotNil(t, details.ResourceInfo)
+	require.Equal(t, LaptopResourceType, details.ResourceInfo.GetResourceType())
+	require.Equal(t, "1", details.ResourceInfo.GetResourceName())
+}
+
+func TestErrorInfo(t *testing.T) {
+	t.Parallel()
+
+	err := New(
+		codes.Unavailable,
+		"not the leader",
+		ErrorInfo("NOT_LEADER", map[string]string{"leader": "node1:8080", "node": "node2:8080"}),
+	)
+
+	details := FromError(err)
+	require.NotNil(t, details.ErrorInfo)
+	require.Equal(t, ErrorDomain, details.ErrorInfo.GetDomain())
+	require.Equal(t, "node1:8080", details.ErrorInfo.GetMetadata()["leader"])
+	require.Equal(t, "reason=NOT_LEADER leader=node1:8080 node=node2:8080", details.String())
+}
@@ -0,0 +1,522 @@
+package serializer
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hjcian/grpc-notes/money"
+	"github.com/hjcian/grpc-notes/pb"
+	"github.com/hjcian/grpc-notes/units"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// The CSV of the laptops has one column per field, the nested messages are flattened:
+//
+//	ram         16 GIGABYTE
+//	gpus        NVIDIA|RTX 2070|1.2|1.8|4 GIGABYTE; AMD|RX 590|1.3|1.7|8 GIGABYTE
+//	storages    SSD 512 GIGABYTE; HDD 2 TERABYTE; SSD, the size of the last one is unknown
+//	resolution  1920x1080
+//	weight      1.5 kg or 3.3 lb
+//	price       1999.90 EUR
+//	updated_at  2020-01-02T15:04:05.999999999Z
+//
+// The empty cells are unset fields.
+const (
+	csvListSeparator  = "; "
+	csvFieldSeparator = "|"
+)
+
+// csvColumn is a column of the CSV of the laptops, get returns an empty string for an unset field
+type csvColumn struct {
+	name string
+	get  func(laptop *pb.Laptop) (string, error)
+	set  func(laptop *pb.Laptop, value string) error
+}
+
+// CSVColumns are the names of the columns of the CSV of the laptops, in the order they are written
+var CSVColumns []string
+
+var csvColumnsByName = make(map[string]*csvColumn)
+
+var csvColumns = []*csvColumn{
+	stringColumn("id", (*pb.Laptop).GetId, func(laptop *pb.Laptop, id string) { laptop.Id = id }),
+	stringColumn("brand", (*pb.Laptop).GetBrand, func(laptop *pb.Laptop, brand string) { laptop.Brand = brand }),
+	stringColumn("name", (*pb.Laptop).GetName, func(laptop *pb.Laptop, name string) { laptop.Name = name }),
+	cpuColumn("cpu_brand",
+		func(cpu *pb.CPU) string { return cpu.GetBrand() },
+		func(cpu *pb.CPU, value string) error { cpu.Brand = value; return nil }),
+	cpuColumn("cpu_name",
+		func(cpu *pb.CPU) string { return cpu.GetName() },
+		func(cpu *pb.CPU, value string) error { cpu.Name = value; return nil }),
+	cpuColumn("cpu_cores",
+		func(cpu *pb.CPU) string { return formatUint(cpu.GetNumberCores()) },
+		func(cpu *pb.CPU, value string) (err error) { cpu.NumberCores, err = parseUint(value); return err }),
+	cpuColumn("cpu_threads",
+		func(cpu *pb.CPU) string { return formatUint(cpu.GetNumberThreads()) },
+		func(cpu *pb.CPU, value string) (err error) { cpu.NumberThreads, err = parseUint(value); return err }),
+	cpuColumn("cpu_min_ghz",
+		func(cpu *pb.CPU) string { return formatFloat(cpu.GetMinGhz()) },
+		func(cpu *pb.CPU, value string) (err error) { cpu.MinGhz, err = parseFloat(value); return err }),
+	cpuColumn("cpu_max_ghz",
+		func(cpu *pb.CPU) string { return formatFloat(cpu.GetMaxGhz()) },
+		func(cpu *pb.CPU, value string) (err error) { cpu.MaxGhz, err = parseFloat(value); return err }),
+	{
+		name: "ram",
+		get: func(laptop *pb.Laptop) (string, error) {
+			return formatMemory(laptop.GetRam()), nil
+		},
+		set: func(laptop *pb.Laptop, value string) (err error) {
+			laptop.Ram, err = parseMemory(value)
+			return err
+		},
+	},
+	{
+		name: "gpus",
+		get:  formatGPUs,
+		set:  parseGPUs,
+	},
+	{
+		name: "storages",
+		get: func(laptop *pb.Laptop) (string, error) {
+			var storages []string
+			for _, storage := range laptop.GetStorages() {
+				item := storage.GetDriver().String()
+				if storage.GetMemory() != nil {
+					item += " " + formatMemory(storage.GetMemory())
+				}
+				storages = append(storages, item)
+			}
+			return strings.Join(storages, csvListSeparator), nil
+		},
+		set: func(laptop *pb.Laptop, value string) error {
+			for _, item := range strings.Split(value, strings.TrimSpace(csvListSeparator)) {
+				parts := strings.SplitN(strings.TrimSpace(item), " ", 2)
+				driver, ok := pb.Storage_Driver_value[parts[0]]
+				if !ok {
+					return fmt.Errorf("invalid storage %q, expect <driver> [<value> <unit>]", item)
+				}
+				storage := &pb.Storage{Driver: pb.Storage_Driver(driver)}
+				if len(parts) == 2 {
+					var err error
+					if storage.Memory, err = parseMemory(parts[1]); err != nil {
+						return err
+					}
+				}
+				laptop.Storages = append(laptop.Storages, storage)
+			}
+			return nil
+		},
+	},
+	screenColumn("screen_size_inch",
+		func(screen *pb.Screen) string {
+			return strconv.FormatFloat(float64(screen.GetSizeInch()), 'g', -1, 32)
+		},
+		func(screen *pb.Screen, value string) error {
+			size, err := strconv.ParseFloat(value, 32)
+			screen.SizeInch = float32(size)
+			return err
+		}),
+	screenColumn("screen_resolution",
+		func(screen *pb.Screen) string {
+			resolution := screen.GetResolution()
+			if resolution == nil {
+				return ""
+			}
+			return fmt.Sprintf("%dx%d", resolution.GetWidth(), resolution.GetHeight())
+		},
+		func(screen *pb.Screen, value string) error {
+			parts := strings.Split(value, "x")
+			if len(parts) != 2 {
+				return fmt.Errorf("invalid resolution %q, expect <width>x<height>", value)
+			}
+			screen.Resolution = &pb.Screen_Resolution{}
+			var err error
+			if screen.Resolution.Width, err = parseUint(parts[0]); err != nil {
+				return err
+			}
+			screen.Resolution.Height, err = parseUint(parts[1])
+			return err
+		}),
+	screenColumn("screen_panel",
+		func(screen *pb.Screen) string { return screen.GetPanel().String() },
+		func(screen *pb.Screen, value string) error {
+			panel, ok := pb.Screen_Panel_value[value]
+			if !ok {
+				return fmt.Errorf("unknown screen panel %q", value)
+			}
+			screen.Panel = pb.Screen_Panel(panel)
+			return nil
+		}),
+	screenColumn("screen_multitouch",
+		func(screen *pb.Screen) string { return strconv.FormatBool(screen.GetMultitouch()) },
+		func(screen *pb.Screen, value string) (err error) {
+			screen.Multitouch, err = strconv.ParseBool(value)
+			return err
+		}),
+	keyboardColumn("keyboard_layout",
+		func(keyboard *pb.Keyboard) string { return keyboard.GetLayout().String() },
+		func(keyboard *pb.Keyboard, value string) error {
+			layout, ok := pb.Keyboard_Layout_value[value]
+			if !ok {
+				return fmt.Errorf("unknown keyboard layout %q", value)
+			}
+			keyboard.Layout = pb.Keyboard_Layout(layout)
+			return nil
+		}),
+	keyboardColumn("keyboard_backlit",
+		func(keyboard *pb.Keyboard) string { return strconv.FormatBool(keyboard.GetBacklit()) },
+		func(keyboard *pb.Keyboard, value string) (err error) {
+			keyboard.Backlit, err = strconv.ParseBool(value)
+			return err
+		}),
+	{
+		name: "weight",
+		get: func(laptop *pb.Laptop) (string, error) {
+			if weight, ok := units.LaptopWeight(laptop); ok {
+				return weight.String(), nil
+			}
+			return "", nil
+		},
+		set: func(laptop *pb.Laptop, value string) error {
+			weight, err := units.ParseWeight(value)
+			if err != nil {
+				return err
+			}
+			weight.SetTo(laptop)
+			return nil
+		},
+	},
+	{
+		name: "price_usd",
+		get: func(laptop *pb.Laptop) (string, error) {
+			return formatFloat(laptop.GetPriceUsd()), nil
+		},
+		set: func(laptop *pb.Laptop, value string) (err error) {
+			laptop.PriceUsd, err = parseFloat(value)
+			return err
+		},
+	},
+	{
+		name: "price",
+		get: func(laptop *pb.Laptop) (string, error) {
+			if laptop.GetPrice() == nil {
+				return "", nil
+			}
+			return money.Format(laptop.GetPrice()), nil
+		},
+		set: func(laptop *pb.Laptop, value string) (err error) {
+			laptop.Price, err = money.Parse(value)
+			return err
+		},
+	},
+	{
+		name: "release_year",
+		get: func(laptop *pb.Laptop) (string, error) {
+			return formatUint(laptop.GetReleaseYear()), nil
+		},
+		set: func(laptop *pb.Laptop, value string) (err error) {
+			laptop.ReleaseYear, err = parseUint(value)
+			return err
+		},
+	},
+	{
+		name: "updated_at",
+		get: func(laptop *pb.Laptop) (string, error) {
+			if laptop.GetUpdatedAt() == nil {
+				return "", nil
+			}
+			return laptop.GetUpdatedAt().AsTime().Format(time.RFC3339Nano), nil
+		},
+		set: func(laptop *pb.Laptop, value string) error {
+			updatedAt, err := time.Parse(time.RFC3339Nano, value)
+			if err != nil {
+				return err
+			}
+			laptop.UpdatedAt = timestamppb.New(updatedAt)
+			return nil
+		},
+	},
+}
+
+func init() {
+	for _, column := range csvColumns {
+		CSVColumns = append(CSVColumns, column.name)
+		csvColumnsByName[column.name] = column
+	}
+}
+
+func stringColumn(name string, get func(*pb.Laptop) string, set func(*pb.Laptop, string)) *csvColumn {
+	return &csvColumn{
+		name: name,
+		get: func(laptop *pb.Laptop) (string, error) {
+			return get(laptop), nil
+		},
+		set: func(laptop *pb.Laptop, value string) error {
+			set(laptop, value)
+			return nil
+		},
+	}
+}
+
+func cpuColumn(name string, get func(*pb.CPU) string, set func(*pb.CPU, string) error) *csvColumn {
+	return &csvColumn{
+		name: name,
+		get: func(laptop *pb.Laptop) (string, error) {
+			if laptop.GetCpu() == nil {
+				return "", nil
+			}
+			return get(laptop.GetCpu()), nil
+		},
+		set: func(laptop *pb.Laptop, value string) error {
+			if laptop.Cpu == nil {
+				laptop.Cpu = &pb.CPU{}
+			}
+			return set(laptop.Cpu, value)
+		},
+	}
+}
+
+func screenColumn(name string, get func(*pb.Screen) string, set func(*pb.Screen, string) error) *csvColumn {
+	return &csvColumn{
+		name: name,
+		get: func(laptop *pb.Laptop) (string, error) {
+			if laptop.GetScreen() == nil {
+				return "", nil
+			}
+			return get(laptop.GetScreen()), nil
+		},
+		set: func(laptop *pb.Laptop, value string) error {
+			if laptop.Screen == nil {
+				laptop.Screen = &pb.Screen{}
+			}
+			return set(laptop.Screen, value)
+		},
+	}
+}
+
+func keyboardColumn(name string, get func(*pb.Keyboard) string, set func(*pb.Keyboard, string) error) *csvColumn {
+	return &csvColumn{
+		name: name,
+		get: func(laptop *pb.Laptop) (string, error) {
+			if laptop.GetKeyboard() == nil {
+				return "", nil
+			}
+			return get(laptop.GetKeyboard()), nil
+		},
+		set: func(laptop *pb.Laptop, value string) error {
+			if laptop.Keyboard == nil {
+				laptop.Keyboard = &pb.Keyboard{}
+			}
+			return set(laptop.Keyboard, value)
+		},
+	}
+}
+
+func formatUint(value uint32) string {
+	return strconv.FormatUint(uint64(value), 10)
+}
+
+func parseUint(value string) (uint32, error) {
+	n, err := strconv.ParseUint(value, 10, 32)
+	return uint32(n), err
+}
+
+// formatFloat formats the float with the fewest digits which read back to the same value
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func parseFloat(value string) (float64, error) {
+	return strconv.ParseFloat(value, 64)
+}
+
+func formatMemory(memory *pb.Memory) string {
+	if memory == nil {
+		return ""
+	}
+	return fmt.Sprintf("%d %s", memory.GetValue(), memory.GetUnit())
+}
+
+func parseMemory(value string) (*pb.Memory, error) {
+	parts := strings.Fields(value)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid memory %q, expect <value> <unit>", value)
+	}
+	n, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid memory %q: %w", value, err)
+	}
+	unit, ok := pb.Memory_Unit_value[parts[1]]
+	if !ok {
+		return nil, fmt.Errorf("unknown memory unit %q", parts[1])
+	}
+	return &pb.Memory{Value: n, Unit: pb.Memory_Unit(unit)}, nil
+}
+
+func formatGPUs(laptop *pb.Laptop) (string, error) {
+	var gpus []string
+	for _, gpu := range laptop.GetGpus() {
+		for _, value := range []string{gpu.GetBrand(), gpu.GetName()} {
+			if strings.ContainsAny(value, csvFieldSeparator+strings.TrimSpace(csvListSeparator)) {
+				return "", fmt.Errorf("GPU %q contains a separator", value)
+			}
+		}
+		gpus = append(gpus, strings.Join([]string{
+			gpu.GetBrand(),
+			gpu.GetName(),
+			formatFloat(gpu.GetMinGhz()),
+			formatFloat(gpu.GetMaxGhz()),
+			formatMemory(gpu.GetMemory()),
+		}, csvFieldSeparator))
+	}
+	return strings.Join(gpus, csvListSeparator), nil
+}
+
+func parseGPUs(laptop *pb.Laptop, value string) error {
+	for _, item := range strings.Split(value, strings.TrimSpace(csvListSeparator)) {
+		fields := strings.Split(strings.TrimSpace(item), csvFieldSeparator)
+		if len(fields) != 5 {
+			return fmt.Errorf("invalid GPU %q, expect <brand>|<name>|<min ghz>|<max ghz>|<memory>", item)
+		}
+
+		gpu := &pb.GPU{Brand: fields[0], Name: fields[1]}
+		var err error
+		if gpu.MinGhz, err = parseFloat(fields[2]); err != nil {
+			return err
+		}
+		if gpu.MaxGhz, err = parseFloat(fields[3]); err != nil {
+			return err
+		}
+		if len(fields[4]) > 0 {
+			if gpu.Memory, err = parseMemory(fields[4]); err != nil {
+				return err
+			}
+		}
+		laptop.Gpus = append(laptop.Gpus, gpu)
+	}
+	return nil
+}
+
+// CSVLaptopWriter writes the laptops as CSV rows, after a header of CSVColumns
+type CSVLaptopWriter struct {
+	writer *csv.Writer
+	header bool
+}
+
+// NewCSVLaptopWriter returns a new CSVLaptopWriter to the writer
+func NewCSVLaptopWriter(writer io.Writer) *CSVLaptopWriter {
+	return &CSVLaptopWriter{writer: csv.NewWriter(writer)}
+}
+
+func (w *CSVLaptopWriter) writeHeader() error {
+	if w.header {
+		return nil
+	}
+	w.header = true
+	return w.writer.Write(CSVColumns)
+}
+
+// Write writes the laptop as a row
+func (w *CSVLaptopWriter) Write(laptop *pb.Laptop) error {
+	row := make([]string, len(csvColumns))
+	for i, column := range csvColumns {
+		value, err := column.get(laptop)
+		if err != nil {
+			return fmt.Errorf("laptop %s: column %s: %w", laptop.GetId(), column.name, err)
+		}
+		row[i] = value
+	}
+
+	if err := w.writeHeader(); err != nil {
+		return fmt.Errorf("cannot write CSV header: %w", err)
+	}
+	if err := w.writer.Write(row); err != nil {
+		return fmt.Errorf("cannot write CSV row: %w", err)
+	}
+	return nil
+}
+
+// Close writes the header if no laptop is written, and flushes the rows
+func (w *CSVLaptopWriter) Close() error {
+	if err := w.writeHeader(); err != nil {
+		return fmt.Errorf("cannot write CSV header: %w", err)
+	}
+	w.writer.Flush()
+	return w.writer.Error()
+}
+
+// CSVLaptopReader reads the laptops of the CSV rows. The first row is the header naming the columns,
+// they can be any of CSVColumns in any order, the unknown columns are errors.
+type CSVLaptopReader struct {
+	reader  *csv.Reader
+	columns []*csvColumn
+	record  int // the number of the records read, the header is the first one
+}
+
+// NewCSVLaptopReader returns a new CSVLaptopReader from the reader
+func NewCSVLaptopReader(reader io.Reader) *CSVLaptopReader {
+	return &CSVLaptopReader{reader: csv.NewReader(reader)}
+}
+
+// Read reads the next laptop
+func (r *CSVLaptopReader) Read() (*pb.Laptop, error) {
+	if r.columns == nil {
+		err := r.readHeader()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	row, err := r.reader.Read()
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read CSV row: %w", err)
+	}
+	r.record++
+
+	laptop := &pb.Laptop{}
+	for i, column := range r.columns {
+		value := strings.TrimSpace(row[i])
+		if len(value) == 0 {
+			continue
+		}
+		err := column.set(laptop, value)
+		if err != nil {
+			return nil, fmt.Errorf("record %d: column %s: %w", r.record, column.name, err)
+		}
+	}
+	return laptop, nil
+}
+
+func (r *CSVLaptopReader) readHeader() error {
+	header, err := r.reader.Read()
+	if err == io.EOF {
+		return io.EOF
+	}
+	if err != nil {
+		return fmt.Errorf("cannot read CSV header: %w", err)
+	}
+	r.record++
+
+	r.columns = make([]*csvColumn, len(header))
+	seen := make(map[string]bool, len(header))
+	for i, name := range header {
+		name = strings.TrimSpace(name)
+		column := csvColumnsByName[name]
+		if column == nil {
+			return fmt.Errorf("unknown CSV column %q", name)
+		}
+		if seen[name] {
+			return fmt.Errorf("duplicate CSV column %q", name)
+		}
+		seen[name] = true
+		r.columns[i] = column
+	}
+	return nil
+}
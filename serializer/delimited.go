@@ -0,0 +1,321 @@
+package serializer
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/klauspost/compress/zstd"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// MaxDelimitedMessageSize is the largest message written by a DelimitedWriter and read by a DelimitedReader
+const MaxDelimitedMessageSize = 64 << 20
+
+// maxTypeNameSize is the longest message type name of a stream header
+const maxTypeNameSize = 1024
+
+// streamMagic starts the header of a stream, it's followed by the version of the format,
+// the compression, and the full name of the type of the messages prefixed by its size
+var streamMagic = []byte("PBST")
+
+const streamVersion = 1
+
+// streamEnd is written in place of the size of a message after the last message of a stream,
+// so that a truncated stream is detected even when it's cut between two messages
+const streamEnd = 1<<32 - 1
+
+// Compression is the compression of the messages of a stream
+type Compression byte
+
+// Compressions of the streams
+const (
+	NoCompression Compression = iota
+	Gzip
+	Zstd
+)
+
+var compressionNames = map[Compression]string{
+	NoCompression: "none",
+	Gzip:          "gzip",
+	Zstd:          "zstd",
+}
+
+func (c Compression) String() string {
+	if name, ok := compressionNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("Compression(%d)", c)
+}
+
+// ParseCompression returns the compression of the name: none, gzip or zstd
+func ParseCompression(name string) (Compression, error) {
+	for compression, other := range compressionNames {
+		if name == other {
+			return compression, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown compression %q, expect none, gzip or zstd", name)
+}
+
+// DelimitedWriter writes a stream of protocol buffer messages, each one preceded by its size as a varint
+type DelimitedWriter struct {
+	writer      *bufio.Writer
+	compressor  compressor
+	messageType protoreflect.FullName
+	buffer      []byte
+}
+
+// compressor is the writer of a compression, it's the gzip.Writer or the zstd.Encoder
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// NewDelimitedWriter returns a new DelimitedWriter to the writer of a stream without header,
+// Flush or Close must be called after the last message
+func NewDelimitedWriter(writer io.Writer) *DelimitedWriter {
+	return &DelimitedWriter{writer: bufio.NewWriter(writer)}
+}
+
+// NewStreamWriter writes the header of a stream of messages of the type of message, and returns
+// the DelimitedWriter of the messages, compressed with the compression. Close must be called after
+// the last message, it doesn't close the writer.
+func NewStreamWriter(writer io.Writer, message proto.Message, compression Compression) (*DelimitedWriter, error) {
+	messageType := message.ProtoReflect().Descriptor().FullName()
+
+	header := append([]byte{}, streamMagic...)
+	header = append(header, streamVersion, byte(compression))
+	header = appendUvarint(header, uint64(len(messageType)))
+	header = append(header, messageType...)
+	_, err := writer.Write(header)
+	if err != nil {
+		return nil, fmt.Errorf("cannot write stream header: %w", err)
+	}
+
+	w := &DelimitedWriter{messageType: messageType}
+	switch compression {
+	case NoCompression:
+		w.writer = bufio.NewWriter(writer)
+		return w, nil
+	case Gzip:
+		w.compressor = gzip.NewWriter(writer)
+	case Zstd:
+		w.compressor, err = zstd.NewWriter(writer, zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return nil, fmt.Errorf("cannot create zstd encoder: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown compression %s", compression)
+	}
+	w.writer = bufio.NewWriter(w.compressor)
+	return w, nil
+}
+
+func appendUvarint(data []byte, x uint64) []byte {
+	var buffer [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buffer[:], x)
+	return append(data, buffer[:n]...)
+}
+
+// Write writes the message, it must be of the type of the stream if it has a header.
+// A message larger than MaxDelimitedMessageSize is rejected, since it couldn't be read.
+func (w *DelimitedWriter) Write(message proto.Message) error {
+	if len(w.messageType) > 0 {
+		if messageType := message.ProtoReflect().Descriptor().FullName(); messageType != w.messageType {
+			return fmt.Errorf("cannot write message of type %s to a stream of %s", messageType, w.messageType)
+		}
+	}
+	if size := proto.Size(message); size > MaxDelimitedMessageSize {
+		return fmt.Errorf("message size %d exceeds the limit of %d bytes", size, MaxDelimitedMessageSize)
+	}
+
+	data, err := proto.MarshalOptions{}.MarshalAppend(w.buffer[:0], message)
+	if err != nil {
+		return fmt.Errorf("cannot marshal proto message to binary: %w", err)
+	}
+	w.buffer = data
+
+	var size [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(size[:], uint64(len(data)))
+	if _, err := w.writer.Write(size[:n]); err != nil {
+		return fmt.Errorf("cannot write message size: %w", err)
+	}
+	if _, err := w.writer.Write(data); err != nil {
+		return fmt.Errorf("cannot write message: %w", err)
+	}
+	return nil
+}
+
+// Flush writes the buffered messages to the underlying writer
+func (w *DelimitedWriter) Flush() error {
+	err := w.writer.Flush()
+	if err != nil || w.compressor == nil {
+		return err
+	}
+	return w.compressor.Flush()
+}
+
+// Close ends the stream, flushes the messages and ends the compression.
+// It doesn't close the underlying writer.
+func (w *DelimitedWriter) Close() error {
+	if len(w.messageType) > 0 {
+		var end [binary.MaxVarintLen64]byte
+		n := binary.PutUvarint(end[:], streamEnd)
+		if _, err := w.writer.Write(end[:n]); err != nil {
+			return fmt.Errorf("cannot write stream end: %w", err)
+		}
+	}
+
+	err := w.writer.Flush()
+	if w.compressor == nil {
+		return err
+	}
+	if closeErr := w.compressor.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// DelimitedReader reads a stream of messages written by a DelimitedWriter
+type DelimitedReader struct {
+	reader       *bufio.Reader
+	decompressor io.Closer
+	messageType  protoreflect.FullName
+	ended        bool
+	buffer       bytes.Buffer
+}
+
+// NewDelimitedReader returns a new DelimitedReader from the reader of a stream without header
+func NewDelimitedReader(reader io.Reader) *DelimitedReader {
+	return &DelimitedReader{reader: bufio.NewReader(reader)}
+}
+
+// NewStreamReader reads the header of a stream written by NewStreamWriter, and returns the
+// DelimitedReader of its messages. It fails if the messages aren't of the type of message.
+// Close must be called once the messages are read, it doesn't close the reader.
+func NewStreamReader(reader io.Reader, message proto.Message) (*DelimitedReader, error) {
+	buffered := bufio.NewReader(reader)
+
+	header := make([]byte, len(streamMagic)+2)
+	_, err := io.ReadFull(buffered, header)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read stream header: %w", err)
+	}
+	if !bytes.Equal(header[:len(streamMagic)], streamMagic) {
+		return nil, fmt.Errorf("not a stream of messages")
+	}
+	if version := header[len(streamMagic)]; version != streamVersion {
+		return nil, fmt.Errorf("unsupported stream version %d", version)
+	}
+	compression := Compression(header[len(streamMagic)+1])
+
+	size, err := binary.ReadUvarint(buffered)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read stream message type: %w", err)
+	}
+	if size > maxTypeNameSize {
+		return nil, fmt.Errorf("stream message type is too long: %d bytes", size)
+	}
+	name := make([]byte, size)
+	_, err = io.ReadFull(buffered, name)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read stream message type: %w", err)
+	}
+	messageType := message.ProtoReflect().Descriptor().FullName()
+	if protoreflect.FullName(name) != messageType {
+		return nil, fmt.Errorf("stream of %s, expect %s", name, messageType)
+	}
+
+	r := &DelimitedReader{messageType: messageType}
+	switch compression {
+	case NoCompression:
+		r.reader = buffered
+		return r, nil
+	case Gzip:
+		decompressor, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read gzip header: %w", err)
+		}
+		r.decompressor = decompressor
+		r.reader = bufio.NewReader(decompressor)
+	case Zstd:
+		decompressor, err := zstd.NewReader(buffered, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, fmt.Errorf("cannot create zstd decoder: %w", err)
+		}
+		r.decompressor = decompressor.IOReadCloser()
+		r.reader = bufio.NewReader(decompressor)
+	default:
+		return nil, fmt.Errorf("unknown compression %s", compression)
+	}
+	return r, nil
+}
+
+// Read reads the next message into the message. It returns io.EOF at the end of the stream,
+// and io.ErrUnexpectedEOF if the stream ends inside a message, or a stream with a header
+// ends before its end is written.
+func (r *DelimitedReader) Read(message proto.Message) error {
+	if r.ended {
+		return io.EOF
+	}
+	stream := len(r.messageType) > 0
+	if stream {
+		if messageType := message.ProtoReflect().Descriptor().FullName(); messageType != r.messageType {
+			return fmt.Errorf("cannot read message of type %s from a stream of %s", messageType, r.messageType)
+		}
+	}
+
+	size, err := binary.ReadUvarint(r.reader)
+	if err == io.EOF && stream {
+		return io.ErrUnexpectedEOF
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("cannot read message size: %w", err)
+	}
+	if size == streamEnd && stream {
+		// read the compression to its end, so that its checksum is verified
+		if r.decompressor != nil {
+			if _, err := io.Copy(ioutil.Discard, r.reader); err != nil {
+				return fmt.Errorf("cannot read stream end: %w", err)
+			}
+		}
+		r.ended = true
+		return io.EOF
+	}
+	if size > MaxDelimitedMessageSize {
+		return fmt.Errorf("message size %d exceeds the limit of %d bytes", size, MaxDelimitedMessageSize)
+	}
+
+	// the buffer grows as the data is read, so that a truncated stream doesn't allocate the whole size
+	r.buffer.Reset()
+	n, err := io.CopyN(&r.buffer, r.reader, int64(size))
+	if uint64(n) < size && (err == io.EOF || err == io.ErrUnexpectedEOF) {
+		return io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return fmt.Errorf("cannot read message: %w", err)
+	}
+
+	err = proto.Unmarshal(r.buffer.Bytes(), message)
+	if err != nil {
+		return fmt.Errorf("cannot unmarshal binary to proto message: %w", err)
+	}
+	return nil
+}
+
+// Close releases the decompression, it doesn't close the underlying reader
+func (r *DelimitedReader) Close() error {
+	if r.decompressor == nil {
+		return nil
+	}
+	return r.decompressor.Close()
+}
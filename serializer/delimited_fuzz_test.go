@@ -0,0 +1,76 @@
+//go:build go1.18
+// +build go1.18
+
+package serializer
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/hjcian/grpc-notes/pb"
+	"github.com/hjcian/grpc-notes/sample"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+// FuzzStreamReader checks that any input is read without panic, until an error or the end of the stream
+func FuzzStreamReader(f *testing.F) {
+	laptops := []*pb.Laptop{sample.NewLaptop(), sample.NewLaptop(), sample.NewLaptop()}
+	for _, compression := range []Compression{NoCompression, Gzip, Zstd} {
+		data := writeStream(f, laptops, compression)
+		f.Add(data)
+		f.Add(data[:len(data)/2])
+	}
+	f.Add([]byte{})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		reader, err := NewStreamReader(bytes.NewReader(data), &pb.Laptop{})
+		if err != nil {
+			return
+		}
+		defer reader.Close()
+
+		for i := 0; i <= len(data); i++ {
+			if reader.Read(&pb.Laptop{}) != nil {
+				return
+			}
+		}
+		t.Fatalf("read more messages than the %d bytes of the input", len(data))
+	})
+}
+
+// FuzzTruncatedStream checks that a truncated stream gives the messages before the cut, then fails
+func FuzzTruncatedStream(f *testing.F) {
+	f.Add(uint8(3), uint16(200), uint8(NoCompression))
+	f.Add(uint8(5), uint16(100), uint8(Gzip))
+	f.Add(uint8(2), uint16(40), uint8(Zstd))
+
+	f.Fuzz(func(t *testing.T, count uint8, cut uint16, compression uint8) {
+		laptops := make([]*pb.Laptop, count%10)
+		for i := range laptops {
+			laptops[i] = sample.NewLaptop()
+		}
+		data := writeStream(t, laptops, Compression(compression%3))
+		if int(cut) >= len(data) {
+			return
+		}
+
+		reader, err := NewStreamReader(bytes.NewReader(data[:cut]), &pb.Laptop{})
+		if err != nil {
+			return
+		}
+		defer reader.Close()
+
+		for i := 0; ; i++ {
+			laptop := &pb.Laptop{}
+			err := reader.Read(laptop)
+			if err != nil {
+				require.NotEqual(t, io.EOF, err, "stream of %s cut at %d", Compression(compression%3), cut)
+				return
+			}
+			require.Less(t, i, len(laptops))
+			require.True(t, proto.Equal(laptops[i], laptop))
+		}
+	})
+}
@@ -0,0 +1,125 @@
+package serializer
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/hjcian/grpc-notes/pb"
+	"github.com/hjcian/grpc-notes/sample"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestDelimited(t *testing.T) {
+	t.Parallel()
+
+	var laptops []*pb.Laptop
+	var buffer bytes.Buffer
+	writer := NewDelimitedWriter(&buffer)
+	for i := 0; i < 10; i++ {
+		laptop := sample.NewLaptop()
+		require.NoError(t, writer.Write(laptop))
+		laptops = append(laptops, laptop)
+	}
+	// an empty message is written as its size only
+	require.NoError(t, writer.Write(&pb.Laptop{}))
+	require.NoError(t, writer.Flush())
+	data := buffer.Bytes()
+
+	reader := NewDelimitedReader(bytes.NewReader(data))
+	for _, laptop := range laptops {
+		other := &pb.Laptop{}
+		require.NoError(t, reader.Read(other))
+		require.True(t, proto.Equal(laptop, other))
+	}
+	other := &pb.Laptop{}
+	require.NoError(t, reader.Read(other))
+	require.True(t, proto.Equal(&pb.Laptop{}, other))
+	require.Equal(t, io.EOF, reader.Read(other))
+
+	// a truncated stream ends inside a message
+	reader = NewDelimitedReader(bytes.NewReader(data[:len(data)-2]))
+	var err error
+	for err == nil {
+		err = reader.Read(&pb.Laptop{})
+	}
+	require.Equal(t, io.ErrUnexpectedEOF, err)
+
+	// a size over the limit is rejected before reading the message
+	reader = NewDelimitedReader(bytes.NewReader([]byte{0xff, 0xff, 0xff, 0xff, 0x0f}))
+	require.Error(t, reader.Read(&pb.Laptop{}))
+
+	// and a message over the limit isn't written
+	buffer.Reset()
+	writer = NewDelimitedWriter(&buffer)
+	err = writer.Write(&pb.ImageChunk{Data: make([]byte, MaxDelimitedMessageSize)})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "exceeds the limit")
+	require.NoError(t, writer.Flush())
+	require.Zero(t, buffer.Len())
+}
+
+// writeStream writes the laptops to a stream with the compression
+func writeStream(t testing.TB, laptops []*pb.Laptop, compression Compression) []byte {
+	var buffer bytes.Buffer
+	writer, err := NewStreamWriter(&buffer, &pb.Laptop{}, compression)
+	require.NoError(t, err)
+	for _, laptop := range laptops {
+		require.NoError(t, writer.Write(laptop))
+	}
+	require.NoError(t, writer.Close())
+	return buffer.Bytes()
+}
+
+func TestStream(t *testing.T) {
+	t.Parallel()
+
+	var laptops []*pb.Laptop
+	for i := 0; i < 1000; i++ {
+		laptops = append(laptops, sample.NewLaptop())
+	}
+
+	sizes := make(map[Compression]int)
+	for _, compression := range []Compression{NoCompression, Gzip, Zstd} {
+		data := writeStream(t, laptops, compression)
+		sizes[compression] = len(data)
+
+		reader, err := NewStreamReader(bytes.NewReader(data), &pb.Laptop{})
+		require.NoError(t, err, compression)
+		for _, laptop := range laptops {
+			other := &pb.Laptop{}
+			require.NoError(t, reader.Read(other), compression)
+			require.True(t, proto.Equal(laptop, other), compression)
+		}
+		require.Equal(t, io.EOF, reader.Read(&pb.Laptop{}), compression)
+		require.NoError(t, reader.Close())
+
+		// the messages are of the type of the stream
+		_, err = NewStreamReader(bytes.NewReader(data), &pb.CPU{})
+		require.Error(t, err)
+
+		reader, err = NewStreamReader(bytes.NewReader(writeStream(t, nil, compression)), &pb.Laptop{})
+		require.NoError(t, err, compression)
+		require.Equal(t, io.EOF, reader.Read(&pb.Laptop{}), compression)
+		require.NoError(t, reader.Close())
+	}
+	require.Less(t, sizes[Gzip], sizes[NoCompression])
+	require.Less(t, sizes[Zstd], sizes[NoCompression])
+
+	var buffer bytes.Buffer
+	writer, err := NewStreamWriter(&buffer, &pb.Laptop{}, Gzip)
+	require.NoError(t, err)
+	require.Error(t, writer.Write(&pb.CPU{}))
+
+	_, err = NewStreamReader(bytes.NewReader([]byte("not a stream")), &pb.Laptop{})
+	require.Error(t, err)
+
+	for _, name := range []string{"none", "gzip", "zstd"} {
+		compression, err := ParseCompression(name)
+		require.NoError(t, err)
+		require.Equal(t, name, compression.String())
+	}
+	_, err = ParseCompression("lz4")
+	require.Error(t, err)
+}
@@ -50,18 +50,33 @@ func ReadProtobufFromBinaryFile(filename string, message proto.Message) error {
 // 	return marshaler.MarshalToString(message), nil
 // }
 
+// JSONMarshalOptions are the options used to convert protocol buffer messages to JSON,
+// the fields are named as in the proto files and the unpopulated fields are emitted
+var JSONMarshalOptions = protojson.MarshalOptions{
+	UseProtoNames:   true,
+	EmitUnpopulated: true,
+}
+
 // ProtobufToJSON converts protocol buffer message to JSON string
 // version mismatch solved by: https://github.com/golang/protobuf/issues/1133
 func ProtobufToJSON(message proto.Message) (string, error) {
-	marshaler := protojson.MarshalOptions{
-		Indent:          "  ",
-		UseProtoNames:   true,
-		EmitUnpopulated: true,
-	}
+	marshaler := JSONMarshalOptions
+	marshaler.Indent = "  "
 	b, err := marshaler.Marshal(message)
 	return string(b), err
 }
 
+// MarshalJSON converts protocol buffer message to compact JSON
+func MarshalJSON(message proto.Message) ([]byte, error) {
+	return JSONMarshalOptions.Marshal(message)
+}
+
+// UnmarshalJSON converts JSON to protocol buffer message, both the proto names
+// and the JSON names of the fields are accepted
+func UnmarshalJSON(data []byte, message proto.Message) error {
+	return protojson.Unmarshal(data, message)
+}
+
 // WriteProtobufToJSONFile writes protocol buffer message to JSON file
 func WriteProtobufToJSONFile(message proto.Message, filename string) error {
 	data, err := ProtobufToJSON(message)
@@ -76,3 +91,18 @@ func WriteProtobufToJSONFile(message proto.Message, filename string) error {
 
 	return nil
 }
+
+// ReadProtobufFromJSONFile reads protocol buffer message from JSON file
+func ReadProtobufFromJSONFile(filename string, message proto.Message) error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("cannot read JSON data from file: %w", err)
+	}
+
+	err = UnmarshalJSON(data, message)
+	if err != nil {
+		return fmt.Errorf("cannot unmarshal JSON to proto message: %w", err)
+	}
+
+	return nil
+}
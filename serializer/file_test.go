@@ -40,4 +40,9 @@ func TestFileSerializer(t *testing.T) {
 	require.NoError(t, err)
 
 	require.True(t, proto.Equal(laptop1, laptop2))
+
+	laptop3 := &pb.Laptop{}
+	err = ReadProtobufFromJSONFile(jsonFile, laptop3)
+	require.NoError(t, err)
+	require.True(t, proto.Equal(laptop1, laptop3))
 }
@@ -0,0 +1,143 @@
+package serializer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/hjcian/grpc-notes/pb"
+)
+
+// JSONLaptopWriter writes the laptops as an indented JSON array
+type JSONLaptopWriter struct {
+	writer io.Writer
+	count  int
+	buffer bytes.Buffer
+}
+
+// NewJSONLaptopWriter returns a new JSONLaptopWriter to the writer
+func NewJSONLaptopWriter(writer io.Writer) *JSONLaptopWriter {
+	return &JSONLaptopWriter{writer: writer}
+}
+
+// Write writes the laptop as an element of the array
+func (w *JSONLaptopWriter) Write(laptop *pb.Laptop) error {
+	data, err := MarshalJSON(laptop)
+	if err != nil {
+		return fmt.Errorf("cannot marshal laptop to JSON: %w", err)
+	}
+
+	w.buffer.Reset()
+	if w.count == 0 {
+		w.buffer.WriteString("[\n  ")
+	} else {
+		w.buffer.WriteString(",\n  ")
+	}
+	err = json.Indent(&w.buffer, data, "  ", "  ")
+	if err != nil {
+		return fmt.Errorf("cannot indent laptop JSON: %w", err)
+	}
+
+	_, err = w.writer.Write(w.buffer.Bytes())
+	if err != nil {
+		return fmt.Errorf("cannot write laptop JSON: %w", err)
+	}
+	w.count++
+	return nil
+}
+
+// Close ends the array
+func (w *JSONLaptopWriter) Close() error {
+	end := "\n]\n"
+	if w.count == 0 {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(w.writer, end)
+	return err
+}
+
+// JSONLaptopReader reads the laptops of a JSON array, or a sequence of JSON objects
+// such as a single laptop or one laptop per line. Both the proto names and the JSON names
+// of the fields are accepted.
+type JSONLaptopReader struct {
+	reader  *bufio.Reader
+	decoder *json.Decoder
+	array   bool
+	count   int
+}
+
+// NewJSONLaptopReader returns a new JSONLaptopReader from the reader
+func NewJSONLaptopReader(reader io.Reader) *JSONLaptopReader {
+	return &JSONLaptopReader{reader: bufio.NewReader(reader)}
+}
+
+// Read reads the next laptop
+func (r *JSONLaptopReader) Read() (*pb.Laptop, error) {
+	if r.decoder == nil {
+		err := r.start()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if !r.decoder.More() {
+		return nil, r.end()
+	}
+
+	var data json.RawMessage
+	err := r.decoder.Decode(&data)
+	if err != nil {
+		return nil, fmt.Errorf("laptop %d: cannot decode JSON: %w", r.count, err)
+	}
+
+	laptop := &pb.Laptop{}
+	err = UnmarshalJSON(data, laptop)
+	if err != nil {
+		return nil, fmt.Errorf("laptop %d: %w", r.count, err)
+	}
+	r.count++
+	return laptop, nil
+}
+
+// start reads the beginning of the array, if the laptops are in one
+func (r *JSONLaptopReader) start() error {
+	r.decoder = json.NewDecoder(r.reader)
+
+	for {
+		c, err := r.reader.ReadByte()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("cannot read JSON: %w", err)
+		}
+		if c == ' ' || c == '\t' || c == '\r' || c == '\n' {
+			continue
+		}
+
+		r.reader.UnreadByte()
+		if c != '[' {
+			return nil
+		}
+		r.array = true
+		_, err = r.decoder.Token()
+		return err
+	}
+}
+
+// end reads the end of the array, nothing may follow it
+func (r *JSONLaptopReader) end() error {
+	if r.array {
+		_, err := r.decoder.Token()
+		if err != nil {
+			return fmt.Errorf("cannot decode JSON: %w", err)
+		}
+		if _, err = r.decoder.Token(); err != io.EOF {
+			return fmt.Errorf("unexpected JSON after the array of laptops")
+		}
+		r.array = false
+	}
+	return io.EOF
+}
@@ -0,0 +1,133 @@
+package serializer
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hjcian/grpc-notes/pb"
+)
+
+// LaptopWriter writes laptops in a text format, Close must be called after the last laptop
+type LaptopWriter interface {
+	Write(laptop *pb.Laptop) error
+	Close() error
+}
+
+// LaptopReader reads laptops in a text format, Read returns io.EOF after the last laptop.
+// The fields which aren't fields of the laptops are errors.
+type LaptopReader interface {
+	Read() (*pb.Laptop, error)
+}
+
+// Format is a text format of the laptops
+type Format int
+
+// Formats of the laptops
+const (
+	JSON Format = iota
+	YAML
+	CSV
+)
+
+// FormatOf returns the format of the file by its extension: .json, .yaml, .yml or .csv
+func FormatOf(filename string) (Format, error) {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".json":
+		return JSON, nil
+	case ".yaml", ".yml":
+		return YAML, nil
+	case ".csv":
+		return CSV, nil
+	default:
+		return 0, fmt.Errorf("unknown format of file %s, expect .json, .yaml, .yml or .csv", filename)
+	}
+}
+
+// NewLaptopWriter returns a new LaptopWriter of the format to the writer
+func NewLaptopWriter(format Format, writer io.Writer) LaptopWriter {
+	switch format {
+	case YAML:
+		return NewYAMLLaptopWriter(writer)
+	case CSV:
+		return NewCSVLaptopWriter(writer)
+	default:
+		return NewJSONLaptopWriter(writer)
+	}
+}
+
+// NewLaptopReader returns a new LaptopReader of the format from the reader
+func NewLaptopReader(format Format, reader io.Reader) LaptopReader {
+	switch format {
+	case YAML:
+		return NewYAMLLaptopReader(reader)
+	case CSV:
+		return NewCSVLaptopReader(reader)
+	default:
+		return NewJSONLaptopReader(reader)
+	}
+}
+
+// ReadLaptops reads all the laptops of the reader
+func ReadLaptops(reader LaptopReader) ([]*pb.Laptop, error) {
+	var laptops []*pb.Laptop
+	for {
+		laptop, err := reader.Read()
+		if err == io.EOF {
+			return laptops, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		laptops = append(laptops, laptop)
+	}
+}
+
+// ReadLaptopsFromFile reads the laptops of the file in the format of its extension
+func ReadLaptopsFromFile(filename string) ([]*pb.Laptop, error) {
+	format, err := FormatOf(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open laptops file: %w", err)
+	}
+	defer file.Close()
+
+	laptops, err := ReadLaptops(NewLaptopReader(format, file))
+	if err != nil {
+		return nil, fmt.Errorf("cannot read laptops from %s: %w", filename, err)
+	}
+	return laptops, nil
+}
+
+// WriteLaptopsToFile writes the laptops to the file in the format of its extension
+func WriteLaptopsToFile(laptops []*pb.Laptop, filename string) error {
+	format, err := FormatOf(filename)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("cannot create laptops file: %w", err)
+	}
+	defer file.Close()
+
+	writer := NewLaptopWriter(format, file)
+	for _, laptop := range laptops {
+		err := writer.Write(laptop)
+		if err != nil {
+			return err
+		}
+	}
+	err = writer.Close()
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
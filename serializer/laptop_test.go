@@ -0,0 +1,208 @@
+package serializer
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hjcian/grpc-notes/pb"
+	"github.com/hjcian/grpc-notes/sample"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+)
+
+func sampleLaptops() []*pb.Laptop {
+	laptops := make([]*pb.Laptop, 20)
+	for i := range laptops {
+		laptops[i] = sample.NewLaptop()
+	}
+	laptops[1].Weight = &pb.Laptop_WeightLb{WeightLb: 3.3}
+	laptops[1].Price = &pb.Money{CurrencyCode: "EUR", Units: 1999, Nanos: 900000000}
+	laptops[2].Gpus = nil
+	laptops[2].Screen = nil
+	laptops[3].Storages[0].Memory = nil
+	return laptops
+}
+
+func requireLaptops(t *testing.T, expected, actual []*pb.Laptop) {
+	require.Len(t, actual, len(expected))
+	for i := range expected {
+		require.True(t, proto.Equal(expected[i], actual[i]), "laptop %d:\n%v\n%v", i, expected[i], actual[i])
+	}
+}
+
+func TestLaptopFormats(t *testing.T) {
+	t.Parallel()
+
+	laptops := sampleLaptops()
+	for _, format := range []Format{JSON, YAML, CSV} {
+		var buffer bytes.Buffer
+		writer := NewLaptopWriter(format, &buffer)
+		for _, laptop := range laptops {
+			require.NoError(t, writer.Write(laptop))
+		}
+		require.NoError(t, writer.Close())
+
+		other, err := ReadLaptops(NewLaptopReader(format, &buffer))
+		require.NoError(t, err, "format %d", format)
+		requireLaptops(t, laptops, other)
+
+		// no laptop
+		buffer.Reset()
+		writer = NewLaptopWriter(format, &buffer)
+		require.NoError(t, writer.Close())
+		other, err = ReadLaptops(NewLaptopReader(format, &buffer))
+		require.NoError(t, err, "format %d", format)
+		require.Empty(t, other)
+	}
+}
+
+func TestLaptopFiles(t *testing.T) {
+	t.Parallel()
+
+	laptops := sampleLaptops()
+	for _, name := range []string{"laptops.json", "laptops.yaml", "laptops.yml", "laptops.csv"} {
+		filename := filepath.Join(t.TempDir(), name)
+		require.NoError(t, WriteLaptopsToFile(laptops, filename))
+
+		other, err := ReadLaptopsFromFile(filename)
+		require.NoError(t, err)
+		requireLaptops(t, laptops, other)
+	}
+
+	_, err := FormatOf("laptops.xml")
+	require.Error(t, err)
+}
+
+func TestJSONLaptopReader(t *testing.T) {
+	t.Parallel()
+
+	laptops := sampleLaptops()[:3]
+	var lines []string
+	for _, laptop := range laptops {
+		data, err := MarshalJSON(laptop)
+		require.NoError(t, err)
+		lines = append(lines, string(data))
+	}
+
+	// a single laptop, as written by WriteProtobufToJSONFile
+	data, err := ProtobufToJSON(laptops[0])
+	require.NoError(t, err)
+	other, err := ReadLaptops(NewJSONLaptopReader(strings.NewReader(data)))
+	require.NoError(t, err)
+	requireLaptops(t, laptops[:1], other)
+
+	// one laptop per line
+	other, err = ReadLaptops(NewJSONLaptopReader(strings.NewReader(strings.Join(lines, "\n"))))
+	require.NoError(t, err)
+	requireLaptops(t, laptops, other)
+
+	// the JSON names of the fields are accepted too
+	other, err = ReadLaptops(NewJSONLaptopReader(strings.NewReader(`[{"priceUsd": 1000, "releaseYear": 2020}]`)))
+	require.NoError(t, err)
+	requireLaptops(t, []*pb.Laptop{{PriceUsd: 1000, ReleaseYear: 2020}}, other)
+
+	for _, data := range []string{
+		`[{"id": "1", "color": "red"}]`,
+		`[{"id": "1", "cpu": {"speed": 3}}]`,
+		`[{"id": "1"}] {"id": "2"}`,
+		`[{"id": "1"}`,
+		`{"id": 1}`,
+	} {
+		_, err := ReadLaptops(NewJSONLaptopReader(strings.NewReader(data)))
+		require.Error(t, err, data)
+	}
+}
+
+func TestYAMLLaptopReader(t *testing.T) {
+	t.Parallel()
+
+	data := `
+- id: "1"
+  brand: Lenovo
+  ram: {value: 16, unit: GIGABYTE}
+  weight_kg: 1.5
+  updated_at: 2020-01-02T15:04:05Z
+- id: "2"
+  price_usd: 999.99
+---
+id: "3"
+`
+	laptops, err := ReadLaptops(NewYAMLLaptopReader(strings.NewReader(data)))
+	require.NoError(t, err)
+	require.Len(t, laptops, 3)
+	require.Equal(t, "Lenovo", laptops[0].GetBrand())
+	require.EqualValues(t, 16, laptops[0].GetRam().GetValue())
+	require.Equal(t, pb.Memory_GIGABYTE, laptops[0].GetRam().GetUnit())
+	require.Equal(t, 1.5, laptops[0].GetWeightKg())
+	require.EqualValues(t, 1577977445, laptops[0].GetUpdatedAt().GetSeconds())
+	require.Equal(t, 999.99, laptops[1].GetPriceUsd())
+	require.Equal(t, "3", laptops[2].GetId())
+
+	for _, data := range []string{
+		"id: \"1\"\ncolor: red\n",
+		"id: \"1\"\ncpu: {speed: 3}\n",
+		"- id: \"1\"\n  id: \"2\"\n",
+		"id: [\n",
+	} {
+		_, err := ReadLaptops(NewYAMLLaptopReader(strings.NewReader(data)))
+		require.Error(t, err, data)
+	}
+}
+
+func TestCSVLaptopReader(t *testing.T) {
+	t.Parallel()
+
+	data := `name,id,ram,gpus,storages,weight,screen_resolution
+XPS 13,1,16 GIGABYTE,NVIDIA|RTX 2070|1.2|1.8|8 GIGABYTE; AMD|RX 590|1.3|1.7|,SSD 512 GIGABYTE; HDD 2 TERABYTE; SSD,3.3 lb,1920x1080
+XPS 15,2,,,,,
+`
+	laptops, err := ReadLaptops(NewCSVLaptopReader(strings.NewReader(data)))
+	require.NoError(t, err)
+	requireLaptops(t, []*pb.Laptop{
+		{
+			Id:   "1",
+			Name: "XPS 13",
+			Ram:  &pb.Memory{Value: 16, Unit: pb.Memory_GIGABYTE},
+			Gpus: []*pb.GPU{
+				{Brand: "NVIDIA", Name: "RTX 2070", MinGhz: 1.2, MaxGhz: 1.8, Memory: &pb.Memory{Value: 8, Unit: pb.Memory_GIGABYTE}},
+				{Brand: "AMD", Name: "RX 590", MinGhz: 1.3, MaxGhz: 1.7},
+			},
+			Storages: []*pb.Storage{
+				{Driver: pb.Storage_SSD, Memory: &pb.Memory{Value: 512, Unit: pb.Memory_GIGABYTE}},
+				{Driver: pb.Storage_HDD, Memory: &pb.Memory{Value: 2, Unit: pb.Memory_TERABYTE}},
+				{Driver: pb.Storage_SSD},
+			},
+			Weight: &pb.Laptop_WeightLb{WeightLb: 3.3},
+			Screen: &pb.Screen{Resolution: &pb.Screen_Resolution{Width: 1920, Height: 1080}},
+		},
+		{Id: "2", Name: "XPS 15"},
+	}, laptops)
+
+	for _, data := range []string{
+		"id,color\n1,red\n",
+		"id,id\n1,2\n",
+		"id,ram\n1,16 GB\n",
+		"id,ram\n1,16\n",
+		"id,weight\n1,3 stone\n",
+		"id,price\n1,1999.90\n",
+		"id,gpus\n1,NVIDIA|RTX 2070\n",
+		"id,storages\n1,NVME 512 GIGABYTE\n",
+		"id,screen_panel\n1,TN\n",
+		"id,release_year\n1,soon\n",
+		"id,name\n1\n",
+	} {
+		_, err := ReadLaptops(NewCSVLaptopReader(strings.NewReader(data)))
+		require.Error(t, err, data)
+	}
+
+	// the errors point at the record, a quoted cell may span several lines
+	_, err = ReadLaptops(NewCSVLaptopReader(strings.NewReader("id,name,ram\n1,\"XPS\n13\",16 GB\n")))
+	require.EqualError(t, err, `record 2: column ram: unknown memory unit "GB"`)
+
+	// the GPUs can't contain the separators
+	laptop := sample.NewLaptop()
+	laptop.Gpus[0].Name = "RTX 2070|Super"
+	require.Error(t, NewCSVLaptopWriter(&bytes.Buffer{}).Write(laptop))
+}
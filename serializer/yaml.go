@@ -0,0 +1,115 @@
+package serializer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/hjcian/grpc-notes/pb"
+	"gopkg.in/yaml.v3"
+)
+
+// YAMLLaptopWriter writes the laptops as YAML documents, one laptop per document.
+// The fields are named and ordered as in the JSON of the laptops.
+type YAMLLaptopWriter struct {
+	encoder *yaml.Encoder
+	count   int
+}
+
+// NewYAMLLaptopWriter returns a new YAMLLaptopWriter to the writer
+func NewYAMLLaptopWriter(writer io.Writer) *YAMLLaptopWriter {
+	encoder := yaml.NewEncoder(writer)
+	encoder.SetIndent(2)
+	return &YAMLLaptopWriter{encoder: encoder}
+}
+
+// Write writes the laptop as a document
+func (w *YAMLLaptopWriter) Write(laptop *pb.Laptop) error {
+	data, err := MarshalJSON(laptop)
+	if err != nil {
+		return fmt.Errorf("cannot marshal laptop to JSON: %w", err)
+	}
+
+	// JSON is YAML in flow style, parsing it as nodes keeps the order of the fields
+	var node yaml.Node
+	err = yaml.Unmarshal(data, &node)
+	if err != nil {
+		return fmt.Errorf("cannot convert laptop JSON to YAML: %w", err)
+	}
+	setBlockStyle(&node)
+
+	err = w.encoder.Encode(&node)
+	if err != nil {
+		return fmt.Errorf("cannot write laptop YAML: %w", err)
+	}
+	w.count++
+	return nil
+}
+
+// setBlockStyle clears the flow style and the quotes of the nodes,
+// the strings are only quoted when they would be read as other values
+func setBlockStyle(node *yaml.Node) {
+	node.Style = 0
+	for _, child := range node.Content {
+		setBlockStyle(child)
+	}
+}
+
+// Close flushes the documents, nothing is written if there is no laptop
+func (w *YAMLLaptopWriter) Close() error {
+	if w.count == 0 {
+		return nil
+	}
+	return w.encoder.Close()
+}
+
+// YAMLLaptopReader reads the laptops of YAML documents, each one being a laptop or a sequence of laptops.
+// The fields are named as in the JSON of the laptops.
+type YAMLLaptopReader struct {
+	decoder *yaml.Decoder
+	pending []interface{}
+	count   int
+}
+
+// NewYAMLLaptopReader returns a new YAMLLaptopReader from the reader
+func NewYAMLLaptopReader(reader io.Reader) *YAMLLaptopReader {
+	return &YAMLLaptopReader{decoder: yaml.NewDecoder(reader)}
+}
+
+// Read reads the next laptop
+func (r *YAMLLaptopReader) Read() (*pb.Laptop, error) {
+	for len(r.pending) == 0 {
+		var document interface{}
+		err := r.decoder.Decode(&document)
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		if err != nil {
+			return nil, fmt.Errorf("laptop %d: cannot decode YAML: %w", r.count, err)
+		}
+
+		switch document := document.(type) {
+		case nil:
+		case []interface{}:
+			r.pending = document
+		default:
+			r.pending = []interface{}{document}
+		}
+	}
+
+	value := r.pending[0]
+	r.pending = r.pending[1:]
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("laptop %d: cannot convert YAML to JSON: %w", r.count, err)
+	}
+
+	laptop := &pb.Laptop{}
+	err = UnmarshalJSON(data, laptop)
+	if err != nil {
+		return nil, fmt.Errorf("laptop %d: %w", r.count, err)
+	}
+	r.count++
+	return laptop, nil
+}
@@ -0,0 +1,111 @@
+package service
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// LaptopServiceName is the full name of the laptop service reported by the health server
+const LaptopServiceName = "techschool.pcbook.LaptopService"
+
+// Names of the dependencies reported by the health server
+const (
+	LaptopStoreHealthName = "laptop_store"
+	ImageStoreHealthName  = "image_store"
+	RatingStoreHealthName = "rating_store"
+)
+
+// HealthChecker is implemented by the stores which can tell whether they are working
+type HealthChecker interface {
+	CheckHealth() error
+}
+
+// HealthMonitor checks the dependencies of the laptop service and reports their status
+// to the health server, the laptop service is NOT_SERVING if any dependency fails
+type HealthMonitor struct {
+	mutex    sync.Mutex
+	server   *health.Server
+	checkers map[string]HealthChecker
+	failed   map[string]bool
+	logger   *zap.Logger
+}
+
+// NewHealthMonitor returns a new HealthMonitor reporting to the health server
+func NewHealthMonitor(server *health.Server, logger *zap.Logger) *HealthMonitor {
+	return &HealthMonitor{
+		server:   server,
+		checkers: make(map[string]HealthChecker),
+		failed:   make(map[string]bool),
+		logger:   logger,
+	}
+}
+
+// Add registers a dependency to be checked under the given name
+func (m *HealthMonitor) Add(name string, checker HealthChecker) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.checkers[name] = checker
+}
+
+// Check checks all the dependencies once and updates the health server,
+// it returns true if all of them are working
+func (m *HealthMonitor) Check() bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	names := make([]string, 0, len(m.checkers))
+	for name := range m.checkers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	serving := true
+	for _, name := range names {
+		err := m.checkers[name].CheckHealth()
+		if err != nil {
+			serving = false
+			if !m.failed[name] {
+				m.logger.Warn("health check failed", zap.String("name", name), zap.Error(err))
+			}
+		} else if m.failed[name] {
+			m.logger.Info("health check recovered", zap.String("name", name))
+		}
+
+		m.failed[name] = err != nil
+		m.server.SetServingStatus(name, servingStatus(err == nil))
+	}
+
+	m.server.SetServingStatus("", servingStatus(serving))
+	m.server.SetServingStatus(LaptopServiceName, servingStatus(serving))
+	return serving
+}
+
+// Run checks the dependencies every interval until the context is done
+func (m *HealthMonitor) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		m.Check()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func servingStatus(serving bool) healthpb.HealthCheckResponse_ServingStatus {
+	if serving {
+		return healthpb.HealthCheckResponse_SERVING
+	}
+	return healthpb.HealthCheckResponse_NOT_SERVING
+}
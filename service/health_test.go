@@ -0,0 +1,80 @@
+package service_test
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hjcian/grpc-notes/pb"
+	"github.com/hjcian/grpc-notes/service"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestHealthMonitor(t *testing.T) {
+	t.Parallel()
+
+	imageFolder := filepath.Join(t.TempDir(), "img")
+	err := os.Mkdir(imageFolder, 0755)
+	require.NoError(t, err)
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	imageStore := service.NewDiskImageStore(imageFolder)
+	ratingStore := service.NewInMemoryRatingStore()
+
+	grpcServer := grpc.NewServer()
+	pb.RegisterLaptopServiceServer(grpcServer, service.NewLaptopServer(laptopStore, imageStore, ratingStore))
+
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
+
+	monitor := service.NewHealthMonitor(healthServer, zap.NewNop())
+	monitor.Add(service.LaptopStoreHealthName, laptopStore)
+	monitor.Add(service.ImageStoreHealthName, imageStore)
+	monitor.Add(service.RatingStoreHealthName, ratingStore)
+
+	listener, err := net.Listen("tcp", ":0")
+	require.NoError(t, err)
+	go grpcServer.Serve(listener)
+	defer grpcServer.Stop()
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+	client := healthpb.NewHealthClient(conn)
+
+	requireStatus := func(service string, expected healthpb.HealthCheckResponse_ServingStatus) {
+		res, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+		require.NoError(t, err)
+		require.Equal(t, expected, res.GetStatus(), service)
+	}
+
+	require.True(t, monitor.Check())
+	requireStatus("", healthpb.HealthCheckResponse_SERVING)
+	requireStatus(service.LaptopServiceName, healthpb.HealthCheckResponse_SERVING)
+	requireStatus(service.ImageStoreHealthName, healthpb.HealthCheckResponse_SERVING)
+
+	// the image folder is gone, so the images can't be written anymore
+	err = os.RemoveAll(imageFolder)
+	require.NoError(t, err)
+
+	require.False(t, monitor.Check())
+	requireStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	requireStatus(service.LaptopServiceName, healthpb.HealthCheckResponse_NOT_SERVING)
+	requireStatus(service.ImageStoreHealthName, healthpb.HealthCheckResponse_NOT_SERVING)
+	requireStatus(service.LaptopStoreHealthName, healthpb.HealthCheckResponse_SERVING)
+	requireStatus(service.RatingStoreHealthName, healthpb.HealthCheckResponse_SERVING)
+
+	// the image folder is back
+	err = os.Mkdir(imageFolder, 0755)
+	require.NoError(t, err)
+
+	require.True(t, monitor.Check())
+	requireStatus("", healthpb.HealthCheckResponse_SERVING)
+	requireStatus(service.ImageStoreHealthName, healthpb.HealthCheckResponse_SERVING)
+}
@@ -0,0 +1,124 @@
+package service
+
+import (
+	"sync"
+	"time"
+)
+
+// IdempotencyStore is an interface to remember which laptop ID was assigned to an idempotency key
+type IdempotencyStore interface {
+	// LoadOrStore returns the laptop ID remembered for the key if it's not expired yet,
+	// otherwise it remembers the given laptop ID. loaded is true if the ID was remembered before.
+	LoadOrStore(key, laptopID string) (id string, loaded bool, err error)
+	// Forget forgets the key if it's still remembered for the laptop ID,
+	// e.g. when the laptop couldn't be created
+	Forget(key, laptopID string) error
+}
+
+// IdempotencyRecord is the laptop ID remembered for an idempotency key until it expires
+type IdempotencyRecord struct {
+	LaptopID  string
+	ExpiresAt time.Time
+}
+
+// InMemoryIdempotencyStore remembers idempotency keys in memory for a time window
+type InMemoryIdempotencyStore struct {
+	mutex   sync.Mutex
+	window  time.Duration
+	records map[string]*IdempotencyRecord
+	now     func() time.Time
+
+	lastSweep time.Time
+}
+
+// NewInMemoryIdempotencyStore returns a new InMemoryIdempotencyStore
+// which remembers each key for the given window
+func NewInMemoryIdempotencyStore(window time.Duration, opts ...StoreOption) *InMemoryIdempotencyStore {
+	config := newStoreConfig(opts)
+	return &InMemoryIdempotencyStore{
+		window:  window,
+		records: make(map[string]*IdempotencyRecord),
+		now:     config.now,
+	}
+}
+
+// LoadOrStore returns the laptop ID remembered for the key, or remembers the given one
+func (s *InMemoryIdempotencyStore) LoadOrStore(key, laptopID string) (string, bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := s.now()
+	record := s.records[key]
+	if record != nil && now.Before(record.ExpiresAt) {
+		return record.LaptopID, true, nil
+	}
+
+	s.removeExpired(now)
+	s.records[key] = &IdempotencyRecord{
+		LaptopID:  laptopID,
+		ExpiresAt: now.Add(s.window),
+	}
+	return laptopID, false, nil
+}
+
+// Store remembers the laptop ID for the key during the window, replacing the one remembered before
+func (s *InMemoryIdempotencyStore) Store(key, laptopID string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := s.now()
+	s.removeExpired(now)
+	s.records[key] = &IdempotencyRecord{
+		LaptopID:  laptopID,
+		ExpiresAt: now.Add(s.window),
+	}
+}
+
+// Set remembers the record of the key, replacing the one remembered before
+func (s *InMemoryIdempotencyStore) Set(key string, record IdempotencyRecord) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.records[key] = &record
+}
+
+// All returns copies of the records which aren't expired
+func (s *InMemoryIdempotencyStore) All() map[string]IdempotencyRecord {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := s.now()
+	records := make(map[string]IdempotencyRecord)
+	for key, record := range s.records {
+		if now.Before(record.ExpiresAt) {
+			records[key] = *record
+		}
+	}
+	return records
+}
+
+// Forget forgets the key if it's still remembered for the laptop ID
+func (s *InMemoryIdempotencyStore) Forget(key, laptopID string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	record := s.records[key]
+	if record != nil && record.LaptopID == laptopID {
+		delete(s.records, key)
+	}
+	return nil
+}
+
+// removeExpired forgets the expired keys, at most once per window
+func (s *InMemoryIdempotencyStore) removeExpired(now time.Time) {
+	if now.Sub(s.lastSweep) < s.window {
+		return
+	}
+	s.lastSweep = now
+
+	for key, record := range s.records {
+		if !now.Before(record.ExpiresAt) {
+			delete(s.records, key)
+		}
+	}
+}
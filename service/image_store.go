@@ -2,37 +2,104 @@ package service
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"sync"
 
 	"github.com/google/uuid"
+	"go.uber.org/zap"
 )
 
 type ImageStore interface {
-	Save(laptopID, imageType string, imageData bytes.Buffer) (string, error)
+	// Save saves the image of the laptop, it returns an error wrapping ErrQuotaExceeded
+	// if the image would exceed the quota of the laptop
+	Save(laptopID, imageType string, imageData bytes.Buffer, quota ImageQuota) (string, error)
+	Find(imageID string) (*ImageInfo, error)
+	Usage(laptopID string) (*ImageUsage, error)
+	SetPrimary(laptopID, imageID string) error
+	Primary(laptopID string) (string, error)
 }
 
 type ImageInfo struct {
 	LaptopID string
 	Type     string
 	Path     string
+	Size     int
+}
+
+// ImageUsage is the number of images and total bytes stored for a laptop,
+// the images whose data is stored elsewhere aren't counted
+type ImageUsage struct {
+	Count int
+	Size  int
+}
+
+// ImageQuota limits the images stored for one laptop, zero means no limit
+type ImageQuota struct {
+	MaxCount int
+	MaxSize  int
+}
+
+// ErrQuotaExceeded is returned when an image would exceed the quota of its laptop
+var ErrQuotaExceeded = errors.New("image quota exceeded")
+
+// check returns an error wrapping ErrQuotaExceeded if an image of the size can't be added to the usage
+func (quota ImageQuota) check(usage ImageUsage, size int) error {
+	if quota.MaxCount > 0 && usage.Count >= quota.MaxCount {
+		return fmt.Errorf("%w: laptop already has %d images, limit is %d", ErrQuotaExceeded, usage.Count, quota.MaxCount)
+	}
+	if quota.MaxSize > 0 && usage.Size+size > quota.MaxSize {
+		return fmt.Errorf("%w: total image size of laptop is too large: %d > %d",
+			ErrQuotaExceeded, usage.Size+size, quota.MaxSize)
+	}
+	return nil
 }
 
 type DiskImageStore struct {
 	mutex       sync.RWMutex
 	imageFolder string
 	images      map[string]*ImageInfo
+	usage       map[string]*ImageUsage
+	primary     map[string]string
+
+	closed bool
+	saving sync.WaitGroup
+	logger *zap.Logger
 }
 
-func NewDiskImageStore(imageFolder string) *DiskImageStore {
+// partialImageSuffix is appended to the image files which are still being written
+const partialImageSuffix = ".partial"
+
+func NewDiskImageStore(imageFolder string, opts ...StoreOption) *DiskImageStore {
+	config := newStoreConfig(opts)
 	return &DiskImageStore{
 		imageFolder: imageFolder,
 		images:      make(map[string]*ImageInfo),
+		usage:       make(map[string]*ImageUsage),
+		primary:     make(map[string]string),
+		logger:      config.logger,
 	}
 }
 
-func (s *DiskImageStore) Save(laptopID, imageType string, imageData bytes.Buffer) (string, error) {
+// Save writes the image file, then records it if it doesn't exceed the quota. The quota is checked
+// again under the lock, so concurrent saves of the same laptop can't exceed it together.
+func (s *DiskImageStore) Save(laptopID, imageType string, imageData bytes.Buffer, quota ImageQuota) (string, error) {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return "", ErrClosed
+	}
+	if err := quota.check(s.usageOf(laptopID), imageData.Len()); err != nil {
+		s.mutex.Unlock()
+		return "", err
+	}
+	s.saving.Add(1)
+	s.mutex.Unlock()
+	defer s.saving.Done()
+
 	imageID, err := uuid.NewRandom()
 	if err != nil {
 		return "", fmt.Errorf("cannot generate image id: %w", err)
@@ -40,24 +107,261 @@ func (s *DiskImageStore) Save(laptopID, imageType string, imageData bytes.Buffer
 
 	imagePath := fmt.Sprintf("%s/%s%s", s.imageFolder, imageID, imageType)
 
-	file, err := os.Create(imagePath)
+	size, err := writeImageFile(imagePath, imageData)
+	if err != nil {
+		return "", err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if err := quota.check(s.usageOf(laptopID), int(size)); err != nil {
+		os.Remove(imagePath)
+		return "", err
+	}
+	s.addImage(imageID.String(), &ImageInfo{
+		LaptopID: laptopID,
+		Type:     imageType,
+		Path:     imagePath,
+		Size:     int(size),
+	})
+
+	s.logger.Debug("wrote image file", zap.String("path", imagePath), zap.Int64("size", size))
+
+	return imageID.String(), nil
+}
+
+// writeImageFile writes the image to a partial file first, then renames it,
+// so that an interrupted write never leaves a truncated image behind
+func writeImageFile(imagePath string, imageData bytes.Buffer) (int64, error) {
+	partialPath := imagePath + partialImageSuffix
+
+	file, err := os.Create(partialPath)
+	if err != nil {
+		return 0, fmt.Errorf("cannot create image file: %w", err)
+	}
+
+	size, err := imageData.WriteTo(file)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(partialPath)
+		return 0, fmt.Errorf("cannot write image to file: %w", err)
+	}
+
+	err = os.Rename(partialPath, imagePath)
 	if err != nil {
-		return "", fmt.Errorf("cannot create image file: %w", err)
+		os.Remove(partialPath)
+		return 0, fmt.Errorf("cannot rename image file: %w", err)
 	}
 
-	_, err = imageData.WriteTo(file)
+	return size, nil
+}
+
+// RemovePartialImages removes the image files left behind by interrupted writes
+func (s *DiskImageStore) RemovePartialImages() error {
+	paths, err := filepath.Glob(filepath.Join(s.imageFolder, "*"+partialImageSuffix))
 	if err != nil {
-		return "", fmt.Errorf("cannot write image to file: %w", err)
+		return fmt.Errorf("cannot list partial images: %w", err)
+	}
+
+	for _, path := range paths {
+		err := os.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("cannot remove partial image: %w", err)
+		}
+		s.logger.Info("removed partial image", zap.String("path", path))
+	}
+	return nil
+}
+
+// Close waits for the images being saved and removes the partial image files,
+// no image can be saved after the store is closed
+func (s *DiskImageStore) Close() error {
+	s.mutex.Lock()
+	s.closed = true
+	s.mutex.Unlock()
+
+	s.saving.Wait()
+	return s.RemovePartialImages()
+}
+
+// Find finds an image by ID, returns nil if it doesn't exist
+func (s *DiskImageStore) Find(imageID string) (*ImageInfo, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	image := s.images[imageID]
+	if image == nil {
+		return nil, nil
 	}
 
+	other := *image
+	return &other, nil
+}
+
+// Usage returns how many images and bytes are stored for the laptop
+func (s *DiskImageStore) Usage(laptopID string) (*ImageUsage, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	usage := s.usageOf(laptopID)
+	return &usage, nil
+}
+
+// SetPrimary marks the image as the primary image of the laptop
+func (s *DiskImageStore) SetPrimary(laptopID, imageID string) error {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
-	s.images[imageID.String()] = &ImageInfo{
-		LaptopID: laptopID,
-		Type:     imageType,
+	image := s.images[imageID]
+	if image == nil || image.LaptopID != laptopID {
+		return ErrNotFound
+	}
+
+	s.primary[laptopID] = imageID
+	return nil
+}
+
+// Count returns the number of images of all laptops
+func (s *DiskImageStore) Count() int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return len(s.images)
+}
+
+// Size returns the total bytes of the images of all laptops
+func (s *DiskImageStore) Size() int64 {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	var size int64
+	for _, usage := range s.usage {
+		size += int64(usage.Size)
+	}
+	return size
+}
+
+// CheckHealth checks that the image folder is writable
+func (s *DiskImageStore) CheckHealth() error {
+	file, err := ioutil.TempFile(s.imageFolder, ".health-")
+	if err != nil {
+		return fmt.Errorf("image folder is not writable: %w", err)
+	}
+
+	file.Close()
+	return os.Remove(file.Name())
+}
+
+// AddInfo records an image whose data is stored elsewhere, e.g. by the leader of a replicated
+// catalog. It isn't counted in the usage of its laptop. Adding an image which is already recorded does nothing.
+func (s *DiskImageStore) AddInfo(imageID string, info *ImageInfo) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.images[imageID] != nil {
+		return
+	}
+
+	other := *info
+	s.addImage(imageID, &other)
+}
+
+// Restore writes an image with its ID, e.g. when it's imported from an archive.
+// It returns ErrAlreadyExists if the image is already recorded.
+func (s *DiskImageStore) Restore(imageID string, info *ImageInfo, imageData bytes.Buffer) error {
+	if _, err := uuid.Parse(imageID); err != nil {
+		return fmt.Errorf("invalid image id %q: %w", imageID, err)
+	}
+	if filepath.Base(info.Type) != info.Type {
+		return fmt.Errorf("invalid image type %q", info.Type)
+	}
+
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return ErrClosed
+	}
+	if s.images[imageID] != nil {
+		s.mutex.Unlock()
+		return ErrAlreadyExists
+	}
+	s.saving.Add(1)
+	s.mutex.Unlock()
+	defer s.saving.Done()
+
+	imagePath := fmt.Sprintf("%s/%s%s", s.imageFolder, imageID, info.Type)
+
+	size, err := writeImageFile(imagePath, imageData)
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.images[imageID] != nil {
+		return ErrAlreadyExists
+	}
+	s.addImage(imageID, &ImageInfo{
+		LaptopID: info.LaptopID,
+		Type:     info.Type,
 		Path:     imagePath,
+		Size:     int(size),
+	})
+	return nil
+}
+
+// usageOf returns the usage of the laptop, the mutex must be locked
+func (s *DiskImageStore) usageOf(laptopID string) ImageUsage {
+	if usage := s.usage[laptopID]; usage != nil {
+		return *usage
 	}
+	return ImageUsage{}
+}
 
-	return imageID.String(), nil
+// addImage records the image and counts it in the usage of its laptop if its data is stored
+// by this store, the mutex must be locked
+func (s *DiskImageStore) addImage(imageID string, image *ImageInfo) {
+	s.images[imageID] = image
+	if len(image.Path) == 0 {
+		return
+	}
+
+	usage := s.usage[image.LaptopID]
+	if usage == nil {
+		usage = &ImageUsage{}
+		s.usage[image.LaptopID] = usage
+	}
+	usage.Count++
+	usage.Size += image.Size
+}
+
+// All returns a copy of the images by ID and of the primary image IDs by laptop ID
+func (s *DiskImageStore) All() (map[string]ImageInfo, map[string]string) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	images := make(map[string]ImageInfo, len(s.images))
+	for imageID, image := range s.images {
+		images[imageID] = *image
+	}
+	primary := make(map[string]string, len(s.primary))
+	for laptopID, imageID := range s.primary {
+		primary[laptopID] = imageID
+	}
+	return images, primary
+}
+
+// Primary returns the primary image ID of the laptop, or empty string if it's not set
+func (s *DiskImageStore) Primary(laptopID string) (string, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.primary[laptopID], nil
 }
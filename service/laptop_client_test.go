@@ -5,19 +5,25 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"math"
 	"net"
 	"os"
 	"path/filepath"
+	"sync"
 	"testing"
 
+	"github.com/hjcian/grpc-notes/money"
 	"github.com/hjcian/grpc-notes/sample"
 	"github.com/hjcian/grpc-notes/serializer"
 
 	"github.com/hjcian/grpc-notes/pb"
+	"github.com/hjcian/grpc-notes/rpcerror"
 	"github.com/stretchr/testify/require"
 
 	"github.com/hjcian/grpc-notes/service"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func startTestLaptopServer(
@@ -25,12 +31,14 @@ func startTestLaptopServer(
 	laptopstore service.LaptopStore,
 	imageStore service.ImageStore,
 	ratingStore service.RatingStore,
+	opts ...service.ServerOption,
 ) string {
 	grpcServer := grpc.NewServer()
 	laptopServer := service.NewLaptopServer(
 		laptopstore,
 		imageStore,
 		ratingStore,
+		opts...,
 	)
 
 	pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
@@ -101,7 +109,7 @@ func TestClientSearchLaptop(t *testing.T) {
 
 	expectedIDs := make(map[string]bool)
 
-	for i := 0; i < 6; i++ {
+	for i := 0; i < 7; i++ {
 		laptop := sample.NewLaptop()
 
 		switch i {
@@ -133,6 +141,14 @@ func TestClientSearchLaptop(t *testing.T) {
 			laptop.Cpu.MaxGhz = laptop.Cpu.MinGhz + 2.0
 			laptop.Ram = &pb.Memory{Value: 64, Unit: pb.Memory_GIGABYTE}
 			expectedIDs[laptop.Id] = true
+		case 6:
+			// Case 6: matched, its RAM doesn't fit in 64 bits.
+			laptop.PriceUsd = 1500
+			laptop.Cpu.NumberCores = 4
+			laptop.Cpu.MinGhz = 2.5
+			laptop.Cpu.MaxGhz = laptop.Cpu.MinGhz + 2.0
+			laptop.Ram = &pb.Memory{Value: 1 << 21, Unit: pb.Memory_TERABYTE}
+			expectedIDs[laptop.Id] = true
 		}
 
 		err := store.Save(laptop)
@@ -169,6 +185,161 @@ func TestClientSearchLaptop(t *testing.T) {
 	require.Equal(t, len(expectedIDs), found)
 }
 
+func TestClientSearchLaptopByPrice(t *testing.T) {
+	t.Parallel()
+	store := service.NewInMemoryLaptopStore()
+
+	rates, err := money.LoadStaticRates("../money/testdata/rates.json")
+	require.NoError(t, err)
+
+	prices := map[string]*pb.Money{
+		"usd":  nil, // the legacy price of 1000 USD, 920 EUR
+		"eur":  {CurrencyCode: "EUR", Units: 900},
+		"gbp":  {CurrencyCode: "GBP", Units: 800},   // 931.645569620 EUR
+		"twd":  {CurrencyCode: "TWD", Units: 34650}, // 1012 EUR
+		"jpy":  {CurrencyCode: "JPY", Units: 500},   // no rate
+		"usd2": nil,
+	}
+	ids := make(map[string]string)
+	for name, price := range prices {
+		laptop := sample.NewLaptop()
+		laptop.PriceUsd = 1000
+		if name == "usd2" {
+			laptop.PriceUsd = 1100
+		}
+		laptop.Price = price
+		require.NoError(t, store.Save(laptop))
+		ids[laptop.Id] = name
+	}
+
+	serverAddress := startTestLaptopServer(t, store, nil, nil, service.WithExchangeRates(rates))
+	client := newTestLaptopClient(t, serverAddress)
+
+	search := func(req *pb.SearchLaptopRequest) ([]string, []*pb.Money, error) {
+		stream, err := client.SearchLaptop(context.Background(), req)
+		require.NoError(t, err)
+
+		var names []string
+		var prices []*pb.Money
+		for {
+			res, err := stream.Recv()
+			if err == io.EOF {
+				return names, prices, nil
+			}
+			if err != nil {
+				return nil, nil, err
+			}
+			names = append(names, ids[res.GetLaptop().GetId()])
+			prices = append(prices, res.GetPrice())
+		}
+	}
+
+	maxPrice := &pb.Filter{MaxPrice: &pb.Money{CurrencyCode: "EUR", Units: 1000}}
+	names, found, err := search(&pb.SearchLaptopRequest{
+		Filter:       maxPrice,
+		CurrencyCode: "EUR",
+		Sort:         pb.SearchLaptopRequest_PRICE_ASCENDING,
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"eur", "usd", "gbp"}, names)
+	require.Equal(t, []string{"900.00 EUR", "920.00 EUR", "931.64556962 EUR"}, formatPrices(found))
+
+	// the currency of the sort is the currency of the max price
+	names, found, err = search(&pb.SearchLaptopRequest{
+		Filter: maxPrice,
+		Sort:   pb.SearchLaptopRequest_PRICE_DESCENDING,
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"gbp", "usd", "eur"}, names)
+	require.Equal(t, []string{"931.64556962 EUR", "920.00 EUR", "900.00 EUR"}, formatPrices(found))
+
+	names, found, err = search(&pb.SearchLaptopRequest{
+		Filter: &pb.Filter{MaxPrice: &pb.Money{CurrencyCode: "USD", Units: 1050}},
+		Sort:   pb.SearchLaptopRequest_PRICE_ASCENDING,
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"eur", "usd", "gbp"}, names)
+	require.Equal(t, []string{"978.260869565 USD", "1000.00 USD", "1012.658227848 USD"}, formatPrices(found))
+
+	// without currency nor sort, the prices aren't converted
+	names, found, err = search(&pb.SearchLaptopRequest{Filter: &pb.Filter{MaxPriceUsd: math.MaxFloat64}})
+	require.NoError(t, err)
+	require.Len(t, names, len(prices))
+	require.Equal(t, make([]*pb.Money, len(prices)), found)
+
+	// the legacy max price compares the prices in other currencies converted to USD, not their price_usd
+	names, _, err = search(&pb.SearchLaptopRequest{Filter: &pb.Filter{MaxPriceUsd: 1000}})
+	require.NoError(t, err)
+	require.ElementsMatch(t, []string{"usd", "eur"}, names)
+
+	// without a max price, a legacy max price of 0 only matches the free laptops
+	names, _, err = search(&pb.SearchLaptopRequest{})
+	require.NoError(t, err)
+	require.Empty(t, names)
+
+	for _, req := range []*pb.SearchLaptopRequest{
+		{CurrencyCode: "JPY"},
+		{CurrencyCode: "eur"},
+		{Filter: &pb.Filter{MaxPrice: &pb.Money{CurrencyCode: "JPY", Units: 1000}}},
+		{Filter: &pb.Filter{MaxPrice: &pb.Money{CurrencyCode: "EUR", Units: -1}}},
+		{Sort: 42},
+	} {
+		_, _, err := search(req)
+		require.Equal(t, codes.InvalidArgument, status.Code(err), req)
+	}
+}
+
+func TestClientSearchLaptopSortedLimit(t *testing.T) {
+	t.Parallel()
+	store := service.NewInMemoryLaptopStore()
+	for i := 0; i < 3; i++ {
+		laptop := sample.NewLaptop()
+		laptop.PriceUsd = 1000
+		require.NoError(t, store.Save(laptop))
+	}
+
+	serverAddress := startTestLaptopServer(t, store, nil, nil, service.WithMaxSortedResults(2))
+	client := newTestLaptopClient(t, serverAddress)
+
+	count := func(sort pb.SearchLaptopRequest_Sort) (int, error) {
+		stream, err := client.SearchLaptop(context.Background(), &pb.SearchLaptopRequest{
+			Filter: &pb.Filter{MaxPriceUsd: 2000},
+			Sort:   sort,
+		})
+		require.NoError(t, err)
+
+		found := 0
+		for {
+			_, err := stream.Recv()
+			if err == io.EOF {
+				return found, nil
+			}
+			if err != nil {
+				return found, err
+			}
+			found++
+		}
+	}
+
+	// the sorted laptops are kept in memory, the others are sent as soon as they are found
+	found, err := count(pb.SearchLaptopRequest_PRICE_ASCENDING)
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+	require.NotNil(t, rpcerror.FromError(err).QuotaFailure)
+	require.Zero(t, found)
+
+	found, err = count(pb.SearchLaptopRequest_NONE)
+	require.NoError(t, err)
+	require.Equal(t, 3, found)
+}
+
+func formatPrices(prices []*pb.Money) []string {
+	formatted := make([]string, len(prices))
+	for i, price := range prices {
+		formatted[i] = money.Format(price)
+	}
+	return formatted
+}
+
 func Test_Client_UploadImage(t *testing.T) {
 	t.Parallel()
 
@@ -286,3 +457,395 @@ func TestClientRateLaptop(t *testing.T) {
 		require.Equal(t, averages[idx], res.GetAverageScore())
 	}
 }
+
+func uploadTestImage(
+	t *testing.T,
+	client pb.LaptopServiceClient,
+	laptopID string,
+	imageData []byte,
+) (*pb.UploadImageResponse, error) {
+	stream, err := client.UploadImage(context.Background())
+	require.NoError(t, err)
+
+	req := &pb.UploadImageRequest{
+		Data: &pb.UploadImageRequest_Info{
+			Info: &pb.ImageInfo{
+				LaptopId:  laptopID,
+				ImageType: ".jpg",
+			},
+		},
+	}
+
+	err = stream.Send(req)
+	require.NoError(t, err)
+
+	req = &pb.UploadImageRequest{
+		Data: &pb.UploadImageRequest_ChunkData{
+			ChunkData: imageData,
+		},
+	}
+
+	// the server may close the stream early when a quota is exceeded,
+	// the error is then returned by CloseAndRecv
+	_ = stream.Send(req)
+
+	return stream.CloseAndRecv()
+}
+
+func TestClientUploadImageQuota(t *testing.T) {
+	t.Parallel()
+
+	imageStore := service.NewDiskImageStore(t.TempDir())
+	laptopStore := service.NewInMemoryLaptopStore()
+
+	laptop := sample.NewLaptop()
+	err := laptopStore.Save(laptop)
+	require.NoError(t, err)
+
+	grpcServer := grpc.NewServer()
+	laptopServer := service.NewLaptopServer(
+		laptopStore,
+		imageStore,
+		nil,
+		service.WithImageQuota(2, 300),
+	)
+	pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
+
+	listener, err := net.Listen("tcp", ":0")
+	require.NoError(t, err)
+	go grpcServer.Serve(listener)
+	defer grpcServer.Stop()
+
+	client := newTestLaptopClient(t, listener.Addr().String())
+
+	_, err = uploadTestImage(t, client, laptop.GetId(), make([]byte, 100))
+	require.NoError(t, err)
+
+	// exceeds the total size limit: 100 + 250 > 300
+	_, err = uploadTestImage(t, client, laptop.GetId(), make([]byte, 250))
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	_, err = uploadTestImage(t, client, laptop.GetId(), make([]byte, 150))
+	require.NoError(t, err)
+
+	// exceeds the image count limit
+	_, err = uploadTestImage(t, client, laptop.GetId(), make([]byte, 10))
+	require.Equal(t, codes.ResourceExhausted, status.Code(err))
+	require.NotNil(t, rpcerror.FromError(err).QuotaFailure)
+
+	// the laptop doesn't exist
+	_, err = uploadTestImage(t, client, sample.NewLaptop().GetId(), make([]byte, 10))
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	resourceInfo := rpcerror.FromError(err).ResourceInfo
+	require.NotNil(t, resourceInfo)
+	require.Equal(t, rpcerror.LaptopResourceType, resourceInfo.GetResourceType())
+
+	usage, err := imageStore.Usage(laptop.GetId())
+	require.NoError(t, err)
+	require.Equal(t, 2, usage.Count)
+	require.Equal(t, 250, usage.Size)
+}
+
+func TestClientUploadImageQuotaConcurrent(t *testing.T) {
+	t.Parallel()
+
+	imageFolder := t.TempDir()
+	imageStore := service.NewDiskImageStore(imageFolder)
+	laptopStore := service.NewInMemoryLaptopStore()
+
+	laptop := sample.NewLaptop()
+	err := laptopStore.Save(laptop)
+	require.NoError(t, err)
+
+	serverAddr := startTestLaptopServer(t, laptopStore, imageStore, nil, service.WithImageQuota(2, 1000))
+	client := newTestLaptopClient(t, serverAddr)
+
+	// the uploads may all pass the quota check before any of them is saved
+	const n = 10
+	codesCh := make(chan codes.Code, n)
+	wg := sync.WaitGroup{}
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := uploadTestImage(t, client, laptop.GetId(), make([]byte, 100))
+			codesCh <- status.Code(err)
+		}()
+	}
+	wg.Wait()
+	close(codesCh)
+
+	saved := 0
+	for code := range codesCh {
+		if code == codes.OK {
+			saved++
+			continue
+		}
+		require.Equal(t, codes.ResourceExhausted, code)
+	}
+	require.Equal(t, 2, saved)
+
+	usage, err := imageStore.Usage(laptop.GetId())
+	require.NoError(t, err)
+	require.Equal(t, 2, usage.Count)
+	require.Equal(t, 200, usage.Size)
+
+	// the files of the rejected images are removed
+	files, err := filepath.Glob(filepath.Join(imageFolder, "*"))
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+}
+
+func TestClientSetPrimaryImage(t *testing.T) {
+	t.Parallel()
+
+	imageStore := service.NewDiskImageStore(t.TempDir())
+	laptopStore := service.NewInMemoryLaptopStore()
+
+	laptop := sample.NewLaptop()
+	err := laptopStore.Save(laptop)
+	require.NoError(t, err)
+
+	other := sample.NewLaptop()
+	err = laptopStore.Save(other)
+	require.NoError(t, err)
+
+	serverAddr := startTestLaptopServer(t, laptopStore, imageStore, nil)
+	client := newTestLaptopClient(t, serverAddr)
+
+	image, err := uploadTestImage(t, client, laptop.GetId(), []byte("image"))
+	require.NoError(t, err)
+
+	otherImage, err := uploadTestImage(t, client, other.GetId(), []byte("other image"))
+	require.NoError(t, err)
+
+	_, err = client.SetPrimaryImage(context.Background(), &pb.SetPrimaryImageRequest{
+		LaptopId: laptop.GetId(),
+		ImageId:  otherImage.GetId(),
+	})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = client.SetPrimaryImage(context.Background(), &pb.SetPrimaryImageRequest{
+		LaptopId: laptop.GetId(),
+		ImageId:  "unknown-image-id",
+	})
+	require.Equal(t, codes.NotFound, status.Code(err))
+
+	res, err := client.SetPrimaryImage(context.Background(), &pb.SetPrimaryImageRequest{
+		LaptopId: laptop.GetId(),
+		ImageId:  image.GetId(),
+	})
+	require.NoError(t, err)
+	require.Equal(t, image.GetId(), res.GetImageId())
+
+	stream, err := client.SearchLaptop(context.Background(), &pb.SearchLaptopRequest{
+		Filter: &pb.Filter{MaxPriceUsd: 10000},
+	})
+	require.NoError(t, err)
+
+	found := 0
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+
+		found++
+		if res.GetLaptop().GetId() == laptop.GetId() {
+			require.Equal(t, image.GetId(), res.GetPrimaryImageId())
+		} else {
+			require.Empty(t, res.GetPrimaryImageId())
+		}
+	}
+	require.Equal(t, 2, found)
+}
+
+func createTestLaptops(
+	t *testing.T,
+	client pb.LaptopServiceClient,
+	laptops []*pb.Laptop,
+	allOrNothing bool,
+) *pb.CreateLaptopsResponse {
+	stream, err := client.CreateLaptops(context.Background())
+	require.NoError(t, err)
+
+	err = stream.Send(&pb.CreateLaptopsRequest{
+		Data: &pb.CreateLaptopsRequest_Options{
+			Options: &pb.CreateLaptopsOptions{AllOrNothing: allOrNothing},
+		},
+	})
+	require.NoError(t, err)
+
+	for _, laptop := range laptops {
+		err := stream.Send(&pb.CreateLaptopsRequest{
+			Data: &pb.CreateLaptopsRequest_Laptop{Laptop: laptop},
+		})
+		require.NoError(t, err)
+	}
+
+	res, err := stream.CloseAndRecv()
+	require.NoError(t, err)
+	require.Len(t, res.GetResults(), len(laptops))
+	return res
+}
+
+func TestClientCreateLaptops(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	existing := sample.NewLaptop()
+	err := laptopStore.Save(existing)
+	require.NoError(t, err)
+
+	serverAddr := startTestLaptopServer(t, laptopStore, nil, nil)
+	client := newTestLaptopClient(t, serverAddr)
+
+	laptopNoID := sample.NewLaptop()
+	laptopNoID.Id = ""
+	laptopInvalidID := sample.NewLaptop()
+	laptopInvalidID.Id = "invalid-uuid"
+	laptop := sample.NewLaptop()
+
+	t.Run("all_or_nothing_aborted", func(t *testing.T) {
+		other := sample.NewLaptop()
+		res := createTestLaptops(t, client, []*pb.Laptop{other, existing}, true)
+
+		require.EqualValues(t, 0, res.GetCreatedCount())
+		require.EqualValues(t, 1, res.GetFailedCount())
+		require.Equal(t, pb.CreateLaptopResult_ABORTED, res.GetResults()[0].GetStatus())
+		require.Equal(t, pb.CreateLaptopResult_ALREADY_EXISTS, res.GetResults()[1].GetStatus())
+
+		found, err := laptopStore.Find(other.GetId())
+		require.NoError(t, err)
+		require.Nil(t, found)
+	})
+
+	t.Run("best_effort", func(t *testing.T) {
+		res := createTestLaptops(t, client, []*pb.Laptop{laptop, laptopNoID, laptopInvalidID, existing}, false)
+
+		require.EqualValues(t, 2, res.GetCreatedCount())
+		require.EqualValues(t, 2, res.GetFailedCount())
+
+		results := res.GetResults()
+		require.Equal(t, pb.CreateLaptopResult_CREATED, results[0].GetStatus())
+		require.Equal(t, laptop.GetId(), results[0].GetId())
+		require.Equal(t, pb.CreateLaptopResult_CREATED, results[1].GetStatus())
+		require.NotEmpty(t, results[1].GetId())
+		require.Equal(t, pb.CreateLaptopResult_INVALID, results[2].GetStatus())
+		require.NotEmpty(t, results[2].GetReason())
+		require.Equal(t, pb.CreateLaptopResult_ALREADY_EXISTS, results[3].GetStatus())
+
+		for i, result := range results {
+			require.EqualValues(t, i, result.GetIndex())
+		}
+
+		found, err := laptopStore.Find(results[1].GetId())
+		require.NoError(t, err)
+		require.NotNil(t, found)
+	})
+
+	t.Run("all_or_nothing_created", func(t *testing.T) {
+		laptops := []*pb.Laptop{sample.NewLaptop(), sample.NewLaptop()}
+		res := createTestLaptops(t, client, laptops, true)
+
+		require.EqualValues(t, 2, res.GetCreatedCount())
+		require.EqualValues(t, 0, res.GetFailedCount())
+		for i, result := range res.GetResults() {
+			require.Equal(t, pb.CreateLaptopResult_CREATED, result.GetStatus())
+
+			found, err := laptopStore.Find(laptops[i].GetId())
+			require.NoError(t, err)
+			require.NotNil(t, found)
+		}
+	})
+}
+
+func TestClientRateLaptopInvalid(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	laptop := sample.NewLaptop()
+	err := laptopStore.Save(laptop)
+	require.NoError(t, err)
+
+	serverAddr := startTestLaptopServer(t, laptopStore, nil, service.NewInMemoryRatingStore())
+	client := newTestLaptopClient(t, serverAddr)
+
+	testCases := []struct {
+		name     string
+		laptopID string
+		score    float64
+		code     codes.Code
+		check    func(t *testing.T, details *rpcerror.Details)
+	}{
+		{
+			name:     "unknown_laptop",
+			laptopID: sample.NewLaptop().GetId(),
+			score:    5,
+			code:     codes.NotFound,
+			check: func(t *testing.T, details *rpcerror.Details) {
+				require.NotNil(t, details.ResourceInfo)
+			},
+		},
+		{
+			name:     "invalid_score",
+			laptopID: laptop.GetId(),
+			score:    11,
+			code:     codes.InvalidArgument,
+			check: func(t *testing.T, details *rpcerror.Details) {
+				require.NotNil(t, details.BadRequest)
+				require.Equal(t, "score", details.BadRequest.GetFieldViolations()[0].GetField())
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			stream, err := client.RateLaptop(context.Background())
+			require.NoError(t, err)
+
+			err = stream.Send(&pb.RateLaptopRequest{LaptopId: tc.laptopID, Score: tc.score})
+			require.NoError(t, err)
+
+			_, err = stream.Recv()
+			require.Equal(t, tc.code, status.Code(err))
+			tc.check(t, rpcerror.FromError(err))
+		})
+	}
+}
+
+func TestClientGetLaptop(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	imageStore := service.NewDiskImageStore(t.TempDir())
+
+	laptop := sample.NewLaptop()
+	err := laptopStore.Save(laptop)
+	require.NoError(t, err)
+
+	serverAddr := startTestLaptopServer(t, laptopStore, imageStore, nil)
+	client := newTestLaptopClient(t, serverAddr)
+
+	res, err := client.GetLaptop(context.Background(), &pb.GetLaptopRequest{Id: laptop.GetId()})
+	require.NoError(t, err)
+	requireSameLaptop(t, laptop, res.GetLaptop())
+	require.Empty(t, res.GetPrimaryImageId())
+
+	image, err := uploadTestImage(t, client, laptop.GetId(), []byte("image"))
+	require.NoError(t, err)
+	err = imageStore.SetPrimary(laptop.GetId(), image.GetId())
+	require.NoError(t, err)
+
+	res, err = client.GetLaptop(context.Background(), &pb.GetLaptopRequest{Id: laptop.GetId()})
+	require.NoError(t, err)
+	require.Equal(t, image.GetId(), res.GetPrimaryImageId())
+
+	_, err = client.GetLaptop(context.Background(), &pb.GetLaptopRequest{Id: "unknown"})
+	require.Equal(t, codes.NotFound, status.Code(err))
+	require.NotNil(t, rpcerror.FromError(err).ResourceInfo)
+}
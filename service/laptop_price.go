@@ -0,0 +1,186 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+
+	"github.com/hjcian/grpc-notes/money"
+	"github.com/hjcian/grpc-notes/pb"
+	"github.com/hjcian/grpc-notes/rpcerror"
+	"github.com/hjcian/grpc-notes/tracing"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/proto"
+)
+
+// errTooManySorted is returned when a sorted search finds more laptops than it can keep in memory
+var errTooManySorted = errors.New("too many laptops to sort")
+
+// priceSearch filters the laptops found by a search by their prices converted to the currencies
+// of the max prices, and sorts them by their prices in the currency of the search
+type priceSearch struct {
+	rates      money.RateProvider
+	maxPrices  []*pb.Money
+	currency   string
+	sort       pb.SearchLaptopRequest_Sort
+	maxResults int
+	logger     *zap.Logger
+
+	responses []*pb.SearchLaptopResponse
+	prices    []*big.Rat
+}
+
+// newPriceSearch returns the priceSearch of the request, its currencies must be known by the rates.
+// The currency of a sorted search is the currency of the max price, or USD, if none is requested.
+// A sorted search finds at most maxResults laptops, 0 means no limit.
+func newPriceSearch(
+	ctx context.Context,
+	rates money.RateProvider,
+	req *pb.SearchLaptopRequest,
+	maxResults int,
+	logger *zap.Logger,
+) (*priceSearch, error) {
+	search := &priceSearch{
+		rates:      rates,
+		currency:   req.GetCurrencyCode(),
+		sort:       req.GetSort(),
+		maxResults: maxResults,
+		logger:     logger,
+	}
+	maxPrice := req.GetFilter().GetMaxPrice()
+	if len(search.currency) == 0 && search.sort != pb.SearchLaptopRequest_NONE {
+		search.currency = money.USD
+		if maxPrice != nil {
+			search.currency = maxPrice.GetCurrencyCode()
+		}
+	}
+
+	// the legacy max price is compared with the prices of the laptops in any currency, converted to USD,
+	// it's ignored if it's 0 and a max price is set. A legacy max price above any amount of money,
+	// such as the max float of the clients without a limit, is no limit.
+	if maxPriceUsd := req.GetFilter().GetMaxPriceUsd(); maxPriceUsd != 0 || maxPrice == nil {
+		if legacy, err := money.FromFloat(money.USD, maxPriceUsd); err == nil {
+			search.maxPrices = append(search.maxPrices, legacy)
+		}
+	}
+	if maxPrice != nil {
+		search.maxPrices = append(search.maxPrices, maxPrice)
+	}
+
+	// an unknown currency is an error of the request, rather than laptops which can't be converted
+	currencies := map[string]string{"currency_code": req.GetCurrencyCode()}
+	if maxPrice != nil {
+		currencies["filter.max_price.currency_code"] = maxPrice.GetCurrencyCode()
+	}
+	for field, currency := range currencies {
+		if len(currency) == 0 {
+			continue
+		}
+		if _, err := rates.Rate(ctx, currency, currency); err != nil {
+			return nil, rpcerror.New(
+				codes.InvalidArgument,
+				fmt.Sprintf("invalid search: %v", err),
+				rpcerror.BadRequest(field, err.Error()),
+			)
+		}
+	}
+
+	return search, nil
+}
+
+// storeFilter returns the filter of the laptop store without the price limits, the max prices are compared
+// with the prices of the laptops in any currency, with the rates of the server rather than by
+// the remote shards of the store
+func storeFilter(filter *pb.Filter) *pb.Filter {
+	if filter.GetMaxPrice() == nil && filter.GetMaxPriceUsd() == math.MaxFloat64 {
+		return filter
+	}
+	if filter == nil {
+		filter = &pb.Filter{}
+	} else {
+		filter = proto.Clone(filter).(*pb.Filter)
+	}
+	filter.MaxPrice = nil
+	filter.MaxPriceUsd = math.MaxFloat64
+	return filter
+}
+
+// price returns the price of the laptop in the currency of the search, nil if the search has no currency,
+// and false if the price is above a max price. A laptop whose price can't be converted doesn't match.
+func (search *priceSearch) price(ctx context.Context, laptop *pb.Laptop) (*pb.Money, bool) {
+	if len(search.maxPrices) == 0 && len(search.currency) == 0 {
+		return nil, true
+	}
+
+	_, span := tracing.Start(ctx, "convertPrice", tracing.String("laptop.id", laptop.GetId()))
+	defer span.End()
+
+	price, err := money.LaptopPrice(laptop)
+	for _, maxPrice := range search.maxPrices {
+		if err != nil {
+			break
+		}
+		var converted *pb.Money
+		converted, err = money.Convert(ctx, search.rates, price, maxPrice.GetCurrencyCode())
+		if err == nil {
+			if cmp, _ := money.Compare(converted, maxPrice); cmp > 0 {
+				return nil, false
+			}
+		}
+	}
+	if err == nil && len(search.currency) > 0 {
+		price, err = money.Convert(ctx, search.rates, price, search.currency)
+	}
+	if err != nil {
+		span.RecordError(err)
+		search.logger.Warn("cannot convert laptop price", zap.String("laptop_id", laptop.GetId()), zap.Error(err))
+		return nil, false
+	}
+
+	if len(search.currency) == 0 {
+		return nil, true
+	}
+	return price, true
+}
+
+// sorted tells if the responses are sent once all of them are found, in the order of their prices
+func (search *priceSearch) sorted() bool {
+	return search.sort != pb.SearchLaptopRequest_NONE
+}
+
+// add adds the response of a sorted search, its price is in the currency of the search.
+// It returns errTooManySorted if the search already has its max number of results.
+func (search *priceSearch) add(res *pb.SearchLaptopResponse) error {
+	if search.maxResults > 0 && len(search.responses) >= search.maxResults {
+		return errTooManySorted
+	}
+	search.responses = append(search.responses, res)
+	search.prices = append(search.prices, money.Rat(res.GetPrice()))
+	return nil
+}
+
+// sortedResponses returns the responses added to the search, sorted by price.
+// The laptops of the same price are in the order of the store.
+func (search *priceSearch) sortedResponses() []*pb.SearchLaptopResponse {
+	order := make([]int, len(search.responses))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		cmp := search.prices[order[i]].Cmp(search.prices[order[j]])
+		if search.sort == pb.SearchLaptopRequest_PRICE_DESCENDING {
+			return cmp > 0
+		}
+		return cmp < 0
+	})
+
+	responses := make([]*pb.SearchLaptopResponse, len(order))
+	for i, index := range order {
+		responses[i] = search.responses[index]
+	}
+	return responses
+}
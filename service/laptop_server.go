@@ -4,12 +4,20 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"io"
-	"log"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/hjcian/grpc-notes/logging"
+	"github.com/hjcian/grpc-notes/money"
 	"github.com/hjcian/grpc-notes/pb"
+	"github.com/hjcian/grpc-notes/rpcerror"
+	"github.com/hjcian/grpc-notes/tracing"
+	"github.com/hjcian/grpc-notes/validator"
+	"go.uber.org/zap"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 )
 
@@ -18,6 +26,13 @@ type LaptopServer struct {
 	laptopStore LaptopStore
 	imageStore  ImageStore
 	ratingStore RatingStore
+
+	idempotencyStore IdempotencyStore
+	exchangeRates    money.RateProvider
+	logger           *zap.Logger
+
+	imageQuota       ImageQuota
+	maxSortedResults int
 }
 
 // NewLaptopServer returns a new LaptopServer
@@ -25,12 +40,22 @@ func NewLaptopServer(
 	laptopStore LaptopStore,
 	imageStore ImageStore,
 	ratingStore RatingStore,
+	opts ...ServerOption,
 ) *LaptopServer {
-	return &LaptopServer{
-		laptopStore,
-		imageStore,
-		ratingStore,
+	server := &LaptopServer{
+		laptopStore:      laptopStore,
+		imageStore:       imageStore,
+		ratingStore:      ratingStore,
+		exchangeRates:    money.USDOnly,
+		logger:           zap.NewNop(),
+		maxSortedResults: DefaultMaxSortedResults,
+	}
+
+	for _, opt := range opts {
+		opt(server)
 	}
+
+	return server
 }
 
 // CreateLaptop is a unary RPC to create a new laptop
@@ -38,44 +63,47 @@ func (s *LaptopServer) CreateLaptop(
 	ctx context.Context,
 	req *pb.CreateLaptopRequest,
 ) (*pb.CreateLaptopResponse, error) {
+	logger := s._logger(ctx)
 	laptop := req.GetLaptop()
-	log.Printf("receive a create-laptop request with id: %s", laptop.Id)
+	logger.Info("receive a create-laptop request", zap.String("laptop_id", laptop.GetId()))
 
-	if len(laptop.Id) > 0 {
-		// check if it's a valid UUID
-		_, err := uuid.Parse(laptop.Id)
-		if err != nil {
-			return nil, status.Errorf(codes.InvalidArgument, "laptop ID is not a valid UUID: %v", err)
-		}
-	} else {
-		id, err := uuid.NewRandom()
-		if err != nil {
-			return nil, status.Errorf(codes.Internal, "cannot generate a new laptop ID: %v", err)
-		}
-		laptop.Id = id.String()
+	hasID := len(laptop.GetId()) > 0
+	if err := prepareLaptop(laptop); err != nil {
+		return nil, err
 	}
 
-	if ctx.Err() == context.Canceled {
-		log.Print("request is canceled")
-		return nil, status.Error(codes.Canceled, "request is canceled")
+	retried, err := s._applyIdempotencyKey(ctx, req, hasID)
+	if err != nil {
+		return nil, err
 	}
 
-	if ctx.Err() == context.DeadlineExceeded {
-		log.Print("deadline is exceeded")
-		return nil, status.Error(codes.DeadlineExceeded, "deadline is exceeded")
+	if err := checkCtxErr(ctx); err != nil {
+		s._forgetIdempotencyKey(ctx, req, retried)
+		return nil, err
 	}
 
-	err := s.laptopStore.Save(laptop)
+	err = s._saveLaptop(ctx, laptop)
+	if errors.Is(err, ErrAlreadyExists) && retried {
+		logger.Info("laptop was already created by the same idempotency key", zap.String("laptop_id", laptop.Id))
+		return &pb.CreateLaptopResponse{Id: laptop.Id}, nil
+	}
 	if err != nil {
-		code := codes.Internal
+		// an unavailable store may have saved the laptop, so its key is kept for the retries
+		if !errors.Is(err, ErrUnavailable) {
+			s._forgetIdempotencyKey(ctx, req, retried)
+		}
 		if errors.Is(err, ErrAlreadyExists) {
-			code = codes.AlreadyExists
+			return nil, rpcerror.New(
+				codes.AlreadyExists,
+				fmt.Sprintf("cannot save laptop to the store: %v", err),
+				rpcerror.ResourceInfo(rpcerror.LaptopResourceType, laptop.Id, "laptop already exists"),
+			)
 		}
 
-		return nil, status.Errorf(code, "cannot save laptop to the store: %v", err)
+		return nil, storeError(err, "cannot save laptop to the store")
 	}
 
-	log.Printf("saved laptop with id: %s", laptop.Id)
+	logger.Info("saved laptop", zap.String("laptop_id", laptop.Id))
 
 	res := &pb.CreateLaptopResponse{
 		Id: laptop.Id,
@@ -83,103 +111,482 @@ func (s *LaptopServer) CreateLaptop(
 	return res, nil
 }
 
-// SearchLaptop is a server-streaming RPC to search for laptops
+func (s *LaptopServer) _saveLaptop(ctx context.Context, laptop *pb.Laptop) error {
+	_, span := tracing.Start(ctx, "LaptopStore.Save", tracing.String("laptop.id", laptop.Id))
+	defer span.End()
+
+	err := s.laptopStore.Save(laptop)
+	span.RecordError(err)
+	return err
+}
+
+// IdempotencyKeyHeader is the metadata key of the idempotency key of CreateLaptop
+const IdempotencyKeyHeader = "idempotency-key"
+
+func idempotencyKey(ctx context.Context, req *pb.CreateLaptopRequest) string {
+	if len(req.GetIdempotencyKey()) > 0 {
+		return req.GetIdempotencyKey()
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(IdempotencyKeyHeader); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// _applyIdempotencyKey replaces the laptop ID by the one remembered for the idempotency key,
+// it returns true if the key was used by a previous request
+func (s *LaptopServer) _applyIdempotencyKey(
+	ctx context.Context,
+	req *pb.CreateLaptopRequest,
+	hasID bool,
+) (bool, error) {
+	key := idempotencyKey(ctx, req)
+	if s.idempotencyStore == nil || len(key) == 0 {
+		return false, nil
+	}
+
+	logger := s._logger(ctx)
+
+	laptop := req.GetLaptop()
+	id, loaded, err := s.idempotencyStore.LoadOrStore(key, laptop.Id)
+	if err != nil {
+		return false, logError(logger, storeError(err, "cannot load idempotency key"))
+	}
+	if !loaded {
+		return false, nil
+	}
+	if hasID && id != laptop.Id {
+		return false, logError(logger, rpcerror.New(
+			codes.FailedPrecondition,
+			fmt.Sprintf("idempotency key %s was used for another laptop %s", key, id),
+			rpcerror.BadRequest("idempotency_key", "already used for another laptop"),
+		))
+	}
+
+	laptop.Id = id
+	return true, nil
+}
+
+// _forgetIdempotencyKey forgets the idempotency key of a request which failed to create its laptop,
+// so that the key isn't bound to a laptop which doesn't exist. The key of a retried request
+// belongs to the previous request.
+func (s *LaptopServer) _forgetIdempotencyKey(ctx context.Context, req *pb.CreateLaptopRequest, retried bool) {
+	key := idempotencyKey(ctx, req)
+	if s.idempotencyStore == nil || len(key) == 0 || retried {
+		return
+	}
+
+	err := s.idempotencyStore.Forget(key, req.GetLaptop().GetId())
+	if err != nil {
+		s._logger(ctx).Warn("cannot forget idempotency key", zap.String("key", key), zap.Error(err))
+	}
+}
+
+// prepareLaptop validates the laptop to be created and generates an ID for it if it has none
+func prepareLaptop(laptop *pb.Laptop) error {
+	violations := validator.ValidateLaptop("laptop", laptop)
+	if err := violations.Err("invalid laptop"); err != nil {
+		return err
+	}
+
+	if len(laptop.Id) == 0 {
+		id, err := uuid.NewRandom()
+		if err != nil {
+			return status.Errorf(codes.Internal, "cannot generate a new laptop ID: %v", err)
+		}
+		laptop.Id = id.String()
+	}
+
+	return nil
+}
+
+// CreateLaptops is a client-streaming RPC to create many laptops at once.
+// The stream may start with an options message, followed by the laptops to create.
+func (s *LaptopServer) CreateLaptops(stream pb.LaptopService_CreateLaptopsServer) error {
+	logger := s._logger(stream.Context())
+	allOrNothing := false
+	res := &pb.CreateLaptopsResponse{}
+
+	// laptops waiting to be saved together in all-or-nothing mode
+	var pending []*pb.Laptop
+	var pendingResults []*pb.CreateLaptopResult
+	pendingIDs := make(map[string]bool)
+
+	for index := uint32(0); ; {
+		if err := checkCtxErr(stream.Context()); err != nil {
+			return err
+		}
+
+		req, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return logError(logger, recvError(stream.Context(), err, "cannot receive stream request"))
+		}
+
+		if options := req.GetOptions(); options != nil {
+			if index > 0 {
+				return logError(logger, rpcerror.New(
+					codes.InvalidArgument,
+					"options must be sent before any laptop",
+					rpcerror.BadRequest("options", "must be sent before any laptop"),
+				))
+			}
+			allOrNothing = options.GetAllOrNothing()
+			continue
+		}
+
+		laptop := req.GetLaptop()
+		result := &pb.CreateLaptopResult{Index: index}
+		res.Results = append(res.Results, result)
+		index++
+
+		if err := prepareLaptop(laptop); err != nil {
+			if status.Code(err) != codes.InvalidArgument {
+				return logError(logger, err)
+			}
+			result.Status = pb.CreateLaptopResult_INVALID
+			result.Reason = status.Convert(err).Message()
+			res.FailedCount++
+			continue
+		}
+		result.Id = laptop.Id
+
+		if allOrNothing {
+			found, err := s._findLaptop(stream.Context(), laptop.Id)
+			if err != nil {
+				return logError(logger, storeError(err, "cannot find laptop"))
+			}
+			if found != nil || pendingIDs[laptop.Id] {
+				result.Status = pb.CreateLaptopResult_ALREADY_EXISTS
+				res.FailedCount++
+				continue
+			}
+
+			pending = append(pending, laptop)
+			pendingResults = append(pendingResults, result)
+			pendingIDs[laptop.Id] = true
+			continue
+		}
+
+		err = s._saveLaptop(stream.Context(), laptop)
+		if errors.Is(err, ErrAlreadyExists) {
+			result.Status = pb.CreateLaptopResult_ALREADY_EXISTS
+			res.FailedCount++
+			continue
+		}
+		if err != nil {
+			return logError(logger, storeError(err, "cannot save laptop to the store"))
+		}
+
+		result.Status = pb.CreateLaptopResult_CREATED
+		res.CreatedCount++
+	}
+
+	if len(pending) > 0 {
+		resultStatus := pb.CreateLaptopResult_ABORTED
+		if res.FailedCount == 0 {
+			err := s._saveAllLaptops(stream.Context(), pending)
+			if err != nil {
+				return err
+			}
+			resultStatus = pb.CreateLaptopResult_CREATED
+			res.CreatedCount = uint32(len(pending))
+		}
+
+		for _, result := range pendingResults {
+			result.Status = resultStatus
+		}
+	}
+
+	err := stream.SendAndClose(res)
+	if err != nil {
+		return logError(logger, status.Errorf(codes.Unknown, "cannot send response: %v", err))
+	}
+
+	logger.Info("created laptops",
+		zap.Uint32("created_count", res.CreatedCount),
+		zap.Uint32("failed_count", res.FailedCount))
+	return nil
+}
+
+func (s *LaptopServer) _saveAllLaptops(ctx context.Context, laptops []*pb.Laptop) error {
+	logger := s._logger(ctx)
+	_, span := tracing.Start(ctx, "LaptopStore.SaveAll", tracing.Int("laptop.count", len(laptops)))
+	err := s.laptopStore.SaveAll(laptops)
+	span.RecordError(err)
+	span.End()
+	if errors.Is(err, ErrAlreadyExists) {
+		// another request saved one of the laptops after we checked it
+		return logError(logger, rpcerror.New(
+			codes.Aborted,
+			fmt.Sprintf("cannot save laptops to the store: %v", err),
+			rpcerror.RetryInfo(RetryDelay),
+		))
+	}
+	if errors.Is(err, ErrNotAtomic) {
+		return logError(logger, rpcerror.New(
+			codes.FailedPrecondition,
+			fmt.Sprintf("cannot save laptops all or nothing: %v", err),
+			rpcerror.BadRequest("options.all_or_nothing", "not supported for these laptops by the store of this server"),
+		))
+	}
+	if err != nil {
+		return logError(logger, storeError(err, "cannot save laptops to the store"))
+	}
+	return nil
+}
+
+// DefaultMaxSortedResults is how many laptops a search sorted by price can find by default,
+// they are kept in memory until the search is done
+const DefaultMaxSortedResults = 10000
+
+// SearchLaptop is a server-streaming RPC to search for laptops. The laptops are sent as soon as
+// they are found, unless they are sorted by price.
 func (s *LaptopServer) SearchLaptop(
 	req *pb.SearchLaptopRequest,
 	stream pb.LaptopService_SearchLaptopServer) error {
 
+	logger := s._logger(stream.Context())
 	filter := req.GetFilter()
-	log.Printf("receive a search-laptop request with filter: %v", filter)
+	logger.Info("receive a search-laptop request",
+		zap.Stringer("filter", filter),
+		zap.String("currency_code", req.GetCurrencyCode()),
+		zap.Stringer("sort", req.GetSort()),
+	)
+
+	violations := validator.ValidateSearch(req)
+	if err := violations.Err("invalid search"); err != nil {
+		return logError(logger, err)
+	}
 
-	err := s.laptopStore.Search(
-		stream.Context(),
-		filter,
+	ctx := stream.Context()
+	search, err := newPriceSearch(ctx, s.exchangeRates, req, s.maxSortedResults, logger)
+	if err != nil {
+		return logError(logger, err)
+	}
+
+	send := func(ctx context.Context, res *pb.SearchLaptopResponse) error {
+		_, sendSpan := tracing.Start(ctx, "stream.Send", tracing.String("laptop.id", res.GetLaptop().GetId()))
+		err := stream.Send(res)
+		sendSpan.RecordError(err)
+		sendSpan.End()
+
+		if err != nil {
+			return err
+		}
+
+		logger.Debug("sent laptop", zap.String("laptop_id", res.GetLaptop().GetId()))
+		return nil
+	}
+
+	err = s.laptopStore.Search(
+		ctx,
+		storeFilter(filter),
 		func(laptop *pb.Laptop) error {
-			res := &pb.SearchLaptopResponse{
-				Laptop: laptop,
+			price, ok := search.price(ctx, laptop)
+			if !ok {
+				return nil
 			}
-			err := stream.Send(res)
 
+			primaryImageID, err := s._findPrimaryImage(laptop.GetId())
 			if err != nil {
 				return err
 			}
 
-			log.Printf("sent laptop with id: %s", laptop.GetId())
-			return nil
+			res := &pb.SearchLaptopResponse{
+				Laptop:         laptop,
+				PrimaryImageId: primaryImageID,
+				Price:          price,
+			}
+			if search.sorted() {
+				return search.add(res)
+			}
+			return send(ctx, res)
 		},
 	)
 
+	if errors.Is(err, errTooManySorted) {
+		return logError(logger, rpcerror.New(
+			codes.ResourceExhausted,
+			fmt.Sprintf("cannot search laptops: more than %d laptops to sort, narrow the filter", s.maxSortedResults),
+			rpcerror.QuotaFailure("search", fmt.Sprintf("sorted search limit is %d laptops", s.maxSortedResults)),
+		))
+	}
 	if err != nil {
-		return status.Errorf(codes.Internal, "unexpected error: %v", err)
+		return storeError(err, "cannot search laptops")
+	}
+	if !search.sorted() {
+		return nil
+	}
+
+	// the sorted laptops are sent once the store search is done
+	responses := search.sortedResponses()
+	sendCtx, span := tracing.Start(ctx, "sendSorted", tracing.Int("laptop.count", len(responses)))
+	defer span.End()
+	for _, res := range responses {
+		if err := send(sendCtx, res); err != nil {
+			span.RecordError(err)
+			return err
+		}
 	}
 
 	return nil
 }
 
-func logError(err error) error {
+func (s *LaptopServer) _findPrimaryImage(laptopID string) (string, error) {
+	if s.imageStore == nil {
+		return "", nil
+	}
+	return s.imageStore.Primary(laptopID)
+}
+
+// _logger returns the request logger set by the logging interceptor, or the server logger
+func (s *LaptopServer) _logger(ctx context.Context) *zap.Logger {
+	return logging.FromContext(ctx, s.logger)
+}
+
+// logError logs where an error is returned, the error itself is logged by the logging interceptor
+func logError(logger *zap.Logger, err error) error {
 	if err != nil {
-		log.Print(err)
+		logger.Debug("return error", zap.Error(err))
 	}
 	return err
 }
 
+// storeError converts an error of a store to a status. The errors which may not happen again
+// are Unavailable, so that the clients retry them, the others are Internal.
+func storeError(err error, message string) error {
+	code := codes.Internal
+	if errors.Is(err, ErrUnavailable) || errors.Is(err, ErrClosed) {
+		code = codes.Unavailable
+	}
+	return status.Errorf(code, "%s: %v", message, err)
+}
+
+// recvError converts an error of receiving from a stream to a status,
+// the stream is canceled or timed out if its context is done
+func recvError(ctx context.Context, err error, message string) error {
+	if ctxErr := checkCtxErr(ctx); ctxErr != nil {
+		return ctxErr
+	}
+	return status.Errorf(codes.Unknown, "%s: %v", message, err)
+}
+
 func checkCtxErr(ctx context.Context) error {
 	switch ctx.Err() {
 	case context.Canceled:
-		return logError(status.Error(codes.Canceled, "request is canceled"))
+		return status.Error(codes.Canceled, "request is canceled")
 	case context.DeadlineExceeded:
-		return logError(status.Error(codes.DeadlineExceeded, "deadline is exceeded"))
+		return status.Error(codes.DeadlineExceeded, "deadline is exceeded")
 	default:
 		return nil
 	}
 }
 
-func (s *LaptopServer) _checkLaptopID(laptopID string) error {
-	laptop, err := s.laptopStore.Find(laptopID)
+func (s *LaptopServer) _checkLaptopID(ctx context.Context, laptopID string) error {
+	logger := s._logger(ctx)
+	laptop, err := s._findLaptop(ctx, laptopID)
 	if err != nil {
-		return logError(status.Errorf(codes.Internal, "cannot find laptop: %v", err))
+		return logError(logger, storeError(err, "cannot find laptop"))
 	}
 	if laptop == nil {
-		return logError(status.Errorf(codes.InvalidArgument, "laptop id %s doesn't exist", laptopID))
+		return logError(logger, laptopNotFoundError(codes.InvalidArgument, laptopID))
 	}
 	return nil
 }
 
+func (s *LaptopServer) _findLaptop(ctx context.Context, laptopID string) (*pb.Laptop, error) {
+	_, span := tracing.Start(ctx, "LaptopStore.Find", tracing.String("laptop.id", laptopID))
+	defer span.End()
+
+	laptop, err := s.laptopStore.Find(laptopID)
+	span.RecordError(err)
+	return laptop, err
+}
+
+func laptopNotFoundError(code codes.Code, laptopID string) error {
+	return rpcerror.New(
+		code,
+		fmt.Sprintf("laptop id %s doesn't exist", laptopID),
+		rpcerror.ResourceInfo(rpcerror.LaptopResourceType, laptopID, "laptop doesn't exist"),
+	)
+}
+
+// RetryDelay is the delay suggested to the clients for retrying transient errors
+const RetryDelay = time.Second
+
 // MaxImageSize is limit the client upload too large image
 // (1 MB = 2^20 bytes = 1 << 20 bytes)
 const MaxImageSize = 1 << 20
 
+func (s *LaptopServer) _checkImageQuota(ctx context.Context, laptopID string) (*ImageUsage, error) {
+	logger := s._logger(ctx)
+	usage, err := s.imageStore.Usage(laptopID)
+	if err != nil {
+		return nil, logError(logger, storeError(err, "cannot get image usage"))
+	}
+	if s.imageQuota.MaxCount > 0 && usage.Count >= s.imageQuota.MaxCount {
+		return nil, logError(logger, rpcerror.New(
+			codes.ResourceExhausted,
+			fmt.Sprintf("laptop %s already has %d images, limit is %d", laptopID, usage.Count, s.imageQuota.MaxCount),
+			rpcerror.QuotaFailure("laptop:"+laptopID, fmt.Sprintf("image count limit is %d", s.imageQuota.MaxCount)),
+		))
+	}
+	return usage, nil
+}
+
 func (s *LaptopServer) _collectImageChunks(
 	imageData *bytes.Buffer,
 	imageSize *int,
+	usage *ImageUsage,
 	stream pb.LaptopService_UploadImageServer,
 ) error {
+	logger := s._logger(stream.Context())
+	chunkCount := 0
+	defer func() {
+		tracing.SpanFromContext(stream.Context()).SetAttributes(
+			tracing.Int("image.chunk_count", chunkCount),
+			tracing.Int("image.size", *imageSize),
+		)
+	}()
+
 	for {
 		err := checkCtxErr(stream.Context())
 		if err != nil {
 			return err
 		}
 
-		log.Print("waiting to receive more data")
-
 		req, err := stream.Recv()
 		if err == io.EOF {
-			log.Print("no more data")
 			break
 		}
 		if err != nil {
-			return logError(status.Errorf(codes.Unknown, "cannot receive chunk data: %v", err))
+			return logError(logger, recvError(stream.Context(), err, "cannot receive chunk data"))
 		}
 
 		chunk := req.GetChunkData()
 		size := len(chunk)
-		log.Printf("received a chunk with size: %d", size)
+		chunkCount++
+		logger.Debug("received a chunk", zap.Int("size", size))
 
 		*imageSize += size
 		if *imageSize > MaxImageSize {
-			return logError(status.Errorf(
-				codes.InvalidArgument, "image is too large: %d > %d",
-				imageSize, MaxImageSize))
+			return logError(logger, rpcerror.New(
+				codes.InvalidArgument,
+				fmt.Sprintf("image is too large: %d > %d", *imageSize, MaxImageSize),
+				rpcerror.QuotaFailure("image", fmt.Sprintf("image size limit is %d bytes", MaxImageSize)),
+			))
+		}
+		if s.imageQuota.MaxSize > 0 && usage.Size+*imageSize > s.imageQuota.MaxSize {
+			return logError(logger, rpcerror.New(
+				codes.ResourceExhausted,
+				fmt.Sprintf("total image size of laptop is too large: %d > %d", usage.Size+*imageSize, s.imageQuota.MaxSize),
+				rpcerror.QuotaFailure("laptop", fmt.Sprintf("total image size limit is %d bytes", s.imageQuota.MaxSize)),
+			))
 		}
 
 		// // assume write slowly
@@ -187,7 +594,7 @@ func (s *LaptopServer) _collectImageChunks(
 
 		_, err = imageData.Write(chunk)
 		if err != nil {
-			return logError(status.Errorf(codes.Internal, "cannot write chunk data: %v", err))
+			return logError(logger, storeError(err, "cannot write chunk data"))
 		}
 	}
 	return nil
@@ -196,31 +603,59 @@ func (s *LaptopServer) _collectImageChunks(
 func (s *LaptopServer) UploadImage(
 	stream pb.LaptopService_UploadImageServer,
 ) error {
+	logger := s._logger(stream.Context())
 
 	// First we call stream.Recv() to receive the first request,
 	// 	which contains the metadata information of the image
 	req, err := stream.Recv()
 	if err != nil {
-		return logError(status.Errorf(codes.Unknown, "cannot receive image info"))
+		return logError(logger, recvError(stream.Context(), err, "cannot receive image info"))
 	}
 
 	laptopID := req.GetInfo().GetLaptopId()
 	imageType := req.GetInfo().GetImageType()
-	log.Printf("receive an upload-image request for laptop %s with image type %s", laptopID, imageType)
+	logger.Info("receive an upload-image request",
+		zap.String("laptop_id", laptopID),
+		zap.String("image_type", imageType))
+	tracing.SpanFromContext(stream.Context()).SetAttributes(
+		tracing.String("laptop.id", laptopID),
+		tracing.String("image.type", imageType),
+	)
 
-	if err := s._checkLaptopID(laptopID); err != nil {
+	if err := s._checkLaptopID(stream.Context(), laptopID); err != nil {
+		return err
+	}
+
+	usage, err := s._checkImageQuota(stream.Context(), laptopID)
+	if err != nil {
 		return err
 	}
 
 	imageData := bytes.Buffer{}
 	imageSize := 0
-	if err := s._collectImageChunks(&imageData, &imageSize, stream); err != nil {
+	if err := s._collectImageChunks(&imageData, &imageSize, usage, stream); err != nil {
 		return err
 	}
 
-	imageID, err := s.imageStore.Save(laptopID, imageType, imageData)
+	_, span := tracing.Start(stream.Context(), "ImageStore.Save",
+		tracing.String("laptop.id", laptopID),
+		tracing.String("image.type", imageType),
+		tracing.Int("image.size", imageSize),
+	)
+	imageID, err := s.imageStore.Save(laptopID, imageType, imageData, s.imageQuota)
+	span.SetAttributes(tracing.String("image.id", imageID))
+	span.RecordError(err)
+	span.End()
+	if errors.Is(err, ErrQuotaExceeded) {
+		// another upload of the laptop was saved since the quota was checked
+		return logError(logger, rpcerror.New(
+			codes.ResourceExhausted,
+			err.Error(),
+			rpcerror.QuotaFailure("laptop:"+laptopID, err.Error()),
+		))
+	}
 	if err != nil {
-		return logError(status.Errorf(codes.Internal, "cannot save image to the store: %v", err))
+		return logError(logger, storeError(err, "cannot save image to the store"))
 	}
 
 	res := &pb.UploadImageResponse{
@@ -230,14 +665,97 @@ func (s *LaptopServer) UploadImage(
 
 	err = stream.SendAndClose(res)
 	if err != nil {
-		return logError(status.Errorf(codes.Unknown, "cannot send response: %v", err))
+		return logError(logger, status.Errorf(codes.Unknown, "cannot send response: %v", err))
 	}
 
-	log.Printf("saved image with id: %s, size: %d", imageID, imageSize)
+	logger.Info("saved image", zap.String("image_id", imageID), zap.Int("size", imageSize))
 	return nil
 }
 
+// SetPrimaryImage is a unary RPC to mark an uploaded image as the primary image of a laptop
+func (s *LaptopServer) SetPrimaryImage(
+	ctx context.Context,
+	req *pb.SetPrimaryImageRequest,
+) (*pb.SetPrimaryImageResponse, error) {
+	laptopID := req.GetLaptopId()
+	imageID := req.GetImageId()
+	logger := s._logger(ctx)
+	logger.Info("receive a set-primary-image request",
+		zap.String("laptop_id", laptopID),
+		zap.String("image_id", imageID))
+
+	if err := s._checkLaptopID(ctx, laptopID); err != nil {
+		return nil, err
+	}
+
+	image, err := s.imageStore.Find(imageID)
+	if err != nil {
+		return nil, logError(logger, storeError(err, "cannot find image"))
+	}
+	if image == nil {
+		return nil, logError(logger, rpcerror.New(
+			codes.NotFound,
+			fmt.Sprintf("image id %s doesn't exist", imageID),
+			rpcerror.ResourceInfo(rpcerror.ImageResourceType, imageID, "image doesn't exist"),
+		))
+	}
+	if image.LaptopID != laptopID {
+		return nil, logError(logger, rpcerror.New(
+			codes.InvalidArgument,
+			fmt.Sprintf("image %s doesn't belong to laptop %s", imageID, laptopID),
+			rpcerror.BadRequest("image_id", "image doesn't belong to the laptop"),
+		))
+	}
+
+	_, span := tracing.Start(ctx, "ImageStore.SetPrimary",
+		tracing.String("laptop.id", laptopID),
+		tracing.String("image.id", imageID),
+	)
+	err = s.imageStore.SetPrimary(laptopID, imageID)
+	span.RecordError(err)
+	span.End()
+	if err != nil {
+		return nil, logError(logger, storeError(err, "cannot set primary image"))
+	}
+
+	res := &pb.SetPrimaryImageResponse{
+		LaptopId: laptopID,
+		ImageId:  imageID,
+	}
+	return res, nil
+}
+
+// GetLaptop is a unary RPC to get a laptop by ID
+func (s *LaptopServer) GetLaptop(
+	ctx context.Context,
+	req *pb.GetLaptopRequest,
+) (*pb.GetLaptopResponse, error) {
+	laptopID := req.GetId()
+	logger := s._logger(ctx)
+	logger.Info("receive a get-laptop request", zap.String("laptop_id", laptopID))
+
+	laptop, err := s._findLaptop(ctx, laptopID)
+	if err != nil {
+		return nil, logError(logger, storeError(err, "cannot find laptop"))
+	}
+	if laptop == nil {
+		return nil, logError(logger, laptopNotFoundError(codes.NotFound, laptopID))
+	}
+
+	primaryImageID, err := s._findPrimaryImage(laptopID)
+	if err != nil {
+		return nil, logError(logger, storeError(err, "cannot find primary image"))
+	}
+
+	res := &pb.GetLaptopResponse{
+		Laptop:         laptop,
+		PrimaryImageId: primaryImageID,
+	}
+	return res, nil
+}
+
 func (s *LaptopServer) RateLaptop(stream pb.LaptopService_RateLaptopServer) error {
+	logger := s._logger(stream.Context())
 	for {
 		if err := checkCtxErr(stream.Context()); err != nil {
 			return err
@@ -245,29 +763,38 @@ func (s *LaptopServer) RateLaptop(stream pb.LaptopService_RateLaptopServer) erro
 
 		req, err := stream.Recv()
 		if err == io.EOF {
-			log.Print("no more data")
 			break
 		}
 		if err != nil {
-			return logError(status.Errorf(codes.Unknown, "cannot receive stream request: %v", err))
+			return logError(logger, recvError(stream.Context(), err, "cannot receive stream request"))
 		}
 
 		laptopID := req.GetLaptopId()
 		score := req.GetScore()
 
-		log.Printf("received a rate-laptop request: id = %s, score = %.2f", laptopID, score)
+		logger.Debug("received a rate-laptop request",
+			zap.String("laptop_id", laptopID),
+			zap.Float64("score", score))
+
+		violations := validator.ValidateScore("score", score)
+		if err := violations.Err("invalid score"); err != nil {
+			return logError(logger, err)
+		}
 
-		found, err := s.laptopStore.Find(laptopID)
+		found, err := s._findLaptop(stream.Context(), laptopID)
 		if err != nil {
-			return logError(status.Errorf(codes.Internal, "cannot find laptop: %v", err))
+			return logError(logger, storeError(err, "cannot find laptop"))
 		}
 		if found == nil {
-			return logError(status.Errorf(codes.NotFound, "laptopID %s is not found", laptopID))
+			return logError(logger, laptopNotFoundError(codes.NotFound, laptopID))
 		}
 
+		_, span := tracing.Start(stream.Context(), "RatingStore.Add", tracing.String("laptop.id", laptopID))
 		rating, err := s.ratingStore.Add(laptopID, score)
+		span.RecordError(err)
+		span.End()
 		if err != nil {
-			return logError(status.Errorf(codes.Internal, "cannot add rating to the store: %v", err))
+			return logError(logger, storeError(err, "cannot add rating to the store"))
 		}
 
 		res := &pb.RateLaptopResponse{
@@ -278,7 +805,7 @@ func (s *LaptopServer) RateLaptop(stream pb.LaptopService_RateLaptopServer) erro
 
 		err = stream.Send(res)
 		if err != nil {
-			return logError(status.Errorf(codes.Unknown, "cannot send stream response: %v", err))
+			return logError(logger, status.Errorf(codes.Unknown, "cannot send stream response: %v", err))
 		}
 	}
 
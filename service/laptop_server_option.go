@@ -0,0 +1,48 @@
+package service
+
+import (
+	"github.com/hjcian/grpc-notes/money"
+	"go.uber.org/zap"
+)
+
+// ServerOption configures optional behaviors of the LaptopServer
+type ServerOption func(s *LaptopServer)
+
+// WithImageQuota limits how many images and how many bytes in total
+// can be uploaded for one laptop, zero means no limit
+func WithImageQuota(maxCount, maxSize int) ServerOption {
+	return func(s *LaptopServer) {
+		s.imageQuota = ImageQuota{MaxCount: maxCount, MaxSize: maxSize}
+	}
+}
+
+// WithIdempotencyStore remembers the idempotency keys of CreateLaptop requests in the store,
+// so retried requests return the laptop ID of the first one
+func WithIdempotencyStore(store IdempotencyStore) ServerOption {
+	return func(s *LaptopServer) {
+		s.idempotencyStore = store
+	}
+}
+
+// WithLogger sets the logger used when the request has no logger from the logging interceptor
+func WithLogger(logger *zap.Logger) ServerOption {
+	return func(s *LaptopServer) {
+		s.logger = logger
+	}
+}
+
+// WithExchangeRates sets the exchange rates used to search for the laptops by price in any currency,
+// only USD is known by default
+func WithExchangeRates(rates money.RateProvider) ServerOption {
+	return func(s *LaptopServer) {
+		s.exchangeRates = rates
+	}
+}
+
+// WithMaxSortedResults limits how many laptops a search sorted by price can find, a larger
+// search fails with ResourceExhausted. 0 means no limit, DefaultMaxSortedResults is the default.
+func WithMaxSortedResults(max int) ServerOption {
+	return func(s *LaptopServer) {
+		s.maxSortedResults = max
+	}
+}
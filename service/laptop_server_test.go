@@ -3,6 +3,7 @@ package service_test
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/hjcian/grpc-notes/service"
 
@@ -10,6 +11,7 @@ import (
 	"github.com/hjcian/grpc-notes/sample"
 	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 )
 
@@ -22,6 +24,12 @@ func TestServerCreateLaptop(t *testing.T) {
 	laptopInvalidID := sample.NewLaptop()
 	laptopInvalidID.Id = "invalid-uuid"
 
+	laptopInvalidCPU := sample.NewLaptop()
+	laptopInvalidCPU.Cpu.NumberThreads = laptopInvalidCPU.Cpu.NumberCores - 1
+
+	laptopNegativePrice := sample.NewLaptop()
+	laptopNegativePrice.PriceUsd = -1
+
 	laptopDuplicateID := sample.NewLaptop()
 	storeDuplicateID := service.NewInMemoryLaptopStore()
 	err := storeDuplicateID.Save(laptopDuplicateID)
@@ -51,6 +59,24 @@ func TestServerCreateLaptop(t *testing.T) {
 			store:  service.NewInMemoryLaptopStore(),
 			code:   codes.InvalidArgument,
 		},
+		{
+			name:   "failure_invalid_cpu",
+			laptop: laptopInvalidCPU,
+			store:  service.NewInMemoryLaptopStore(),
+			code:   codes.InvalidArgument,
+		},
+		{
+			name:   "failure_negative_price",
+			laptop: laptopNegativePrice,
+			store:  service.NewInMemoryLaptopStore(),
+			code:   codes.InvalidArgument,
+		},
+		{
+			name:   "failure_missing_laptop",
+			laptop: nil,
+			store:  service.NewInMemoryLaptopStore(),
+			code:   codes.InvalidArgument,
+		},
 		{
 			name:   "failure_duplicate_id",
 			laptop: laptopDuplicateID,
@@ -60,6 +86,7 @@ func TestServerCreateLaptop(t *testing.T) {
 	}
 
 	for _, tc := range testCases {
+		tc := tc
 		t.Run(tc.name, func(t *testing.T) {
 			t.Parallel()
 
@@ -88,3 +115,65 @@ func TestServerCreateLaptop(t *testing.T) {
 		})
 	}
 }
+
+func TestServerCreateLaptopIdempotent(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	idempotencyStore := service.NewInMemoryIdempotencyStore(
+		time.Minute,
+		service.WithStoreClock(func() time.Time { return now }),
+	)
+	laptopStore := service.NewInMemoryLaptopStore()
+	server := service.NewLaptopServer(laptopStore, nil, nil, service.WithIdempotencyStore(idempotencyStore))
+
+	newRequest := func(key string) *pb.CreateLaptopRequest {
+		laptop := sample.NewLaptop()
+		laptop.Id = ""
+		return &pb.CreateLaptopRequest{Laptop: laptop, IdempotencyKey: key}
+	}
+
+	first, err := server.CreateLaptop(context.Background(), newRequest("key-1"))
+	require.NoError(t, err)
+
+	retried, err := server.CreateLaptop(context.Background(), newRequest("key-1"))
+	require.NoError(t, err)
+	require.Equal(t, first.GetId(), retried.GetId())
+
+	// the key can also be sent as metadata
+	ctx := metadata.NewIncomingContext(
+		context.Background(),
+		metadata.Pairs(service.IdempotencyKeyHeader, "key-1"),
+	)
+	retried, err = server.CreateLaptop(ctx, newRequest(""))
+	require.NoError(t, err)
+	require.Equal(t, first.GetId(), retried.GetId())
+
+	other, err := server.CreateLaptop(context.Background(), newRequest("key-2"))
+	require.NoError(t, err)
+	require.NotEqual(t, first.GetId(), other.GetId())
+
+	// the key can't be reused for a laptop with another ID
+	req := newRequest("key-1")
+	req.Laptop.Id = sample.NewLaptop().GetId()
+	_, err = server.CreateLaptop(context.Background(), req)
+	require.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	// the key of a request which failed to create its laptop is forgotten
+	req = newRequest("key-3")
+	req.Laptop.Id = first.GetId()
+	_, err = server.CreateLaptop(context.Background(), req)
+	require.Equal(t, codes.AlreadyExists, status.Code(err))
+
+	req = newRequest("key-3")
+	req.Laptop.Id = sample.NewLaptop().GetId()
+	created, err := server.CreateLaptop(context.Background(), req)
+	require.NoError(t, err)
+	require.Equal(t, req.GetLaptop().GetId(), created.GetId())
+
+	// the key is forgotten after the window
+	now = now.Add(time.Minute)
+	expired, err := server.CreateLaptop(context.Background(), newRequest("key-1"))
+	require.NoError(t, err)
+	require.NotEqual(t, first.GetId(), expired.GetId())
+}
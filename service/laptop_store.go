@@ -4,37 +4,57 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"log"
+	"sort"
 	"sync"
 
 	"github.com/jinzhu/copier"
+	"go.uber.org/zap"
 
+	"github.com/hjcian/grpc-notes/logging"
 	"github.com/hjcian/grpc-notes/pb"
+	"github.com/hjcian/grpc-notes/tracing"
+	"github.com/hjcian/grpc-notes/units"
 )
 
 // LaptopStore is an interface to store laptop
 type LaptopStore interface {
 	Save(laptop *pb.Laptop) error
+	SaveAll(laptops []*pb.Laptop) error
 	Find(id string) (*pb.Laptop, error)
 	Search(ctx context.Context, filter *pb.Filter, found func(laptop *pb.Laptop) error) error
 }
 
 // InMemoryLaptopStore is a InMemoryLaptopStore with RW lock
 type InMemoryLaptopStore struct {
-	mutex sync.RWMutex
-	data  map[string]*pb.Laptop
+	mutex  sync.RWMutex
+	data   map[string]*pb.Laptop
+	logger *zap.Logger
 }
 
 // NewInMemoryLaptopStore returns a new InMemoryLaptopStore
-func NewInMemoryLaptopStore() *InMemoryLaptopStore {
+func NewInMemoryLaptopStore(opts ...StoreOption) *InMemoryLaptopStore {
+	config := newStoreConfig(opts)
 	return &InMemoryLaptopStore{
-		data: make(map[string]*pb.Laptop),
+		data:   make(map[string]*pb.Laptop),
+		logger: config.logger,
 	}
 }
 
 // ErrAlreadyExists is returned when a record with the same ID
 var ErrAlreadyExists = errors.New("record already exists")
 
+// ErrNotFound is returned when a record with the ID doesn't exist
+var ErrNotFound = errors.New("record not found")
+
+// ErrClosed is returned when the store is already closed
+var ErrClosed = errors.New("store is closed")
+
+// ErrUnavailable is returned when the store can't serve the request for now, e.g. it's not ready yet
+var ErrUnavailable = errors.New("store is unavailable")
+
+// ErrNotAtomic is returned when the store can't save the laptops all or nothing
+var ErrNotAtomic = errors.New("laptops cannot be saved atomically")
+
 // Save saves the laptop to the store
 func (store *InMemoryLaptopStore) Save(laptop *pb.Laptop) error {
 	store.mutex.Lock()
@@ -53,6 +73,61 @@ func (store *InMemoryLaptopStore) Save(laptop *pb.Laptop) error {
 	return nil
 }
 
+// SaveAll saves all the laptops to the store, or none of them if any laptop already exists
+func (store *InMemoryLaptopStore) SaveAll(laptops []*pb.Laptop) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	others := make(map[string]*pb.Laptop, len(laptops))
+	for _, laptop := range laptops {
+		if store.data[laptop.Id] != nil || others[laptop.Id] != nil {
+			return fmt.Errorf("laptop id %s: %w", laptop.Id, ErrAlreadyExists)
+		}
+
+		other, err := deepCopy(laptop)
+		if err != nil {
+			return err
+		}
+		others[laptop.Id] = other
+	}
+
+	for id, other := range others {
+		store.data[id] = other
+	}
+	return nil
+}
+
+// Count returns the number of laptops in the store
+func (store *InMemoryLaptopStore) Count() int {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	return len(store.data)
+}
+
+// All returns all the laptops one by one via the found function, e.g. to take a snapshot
+func (store *InMemoryLaptopStore) All(found func(laptop *pb.Laptop) error) error {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+
+	for _, laptop := range store.data {
+		other, err := deepCopy(laptop)
+		if err != nil {
+			return err
+		}
+		err = found(other)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckHealth always succeeds since the data is kept in memory
+func (store *InMemoryLaptopStore) CheckHealth() error {
+	return nil
+}
+
 func deepCopy(laptopFrom *pb.Laptop) (*pb.Laptop, error) {
 	laptopTo := &pb.Laptop{}
 
@@ -77,67 +152,74 @@ func (store *InMemoryLaptopStore) Find(id string) (*pb.Laptop, error) {
 	return deepCopy(laptop)
 }
 
-// Search searches for laptops with filter, returns one by one via the found function
+// Search searches for laptops with filter, returns one by one via the found function in the order
+// of their IDs. The span of the search and the spans of the copies of the laptops are siblings.
 func (store *InMemoryLaptopStore) Search(
 	ctx context.Context,
 	filter *pb.Filter,
 	found func(laptop *pb.Laptop) error,
 ) error {
+	logger := logging.FromContext(ctx, store.logger)
+	_, span := tracing.Start(ctx, "LaptopStore.Search", tracing.Stringer("filter", filter))
+	defer span.End()
+
 	store.mutex.RLock()
 	defer store.mutex.RUnlock()
 
-	for _, laptop := range store.data {
+	ids := make([]string, 0, len(store.data))
+	for id := range store.data {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	matched := 0
+	for _, id := range ids {
+		laptop := store.data[id]
 		if ctx.Err() == context.Canceled ||
 			ctx.Err() == context.DeadlineExceeded {
 
-			log.Print("context is canceled")
+			logger.Debug("search is stopped by the context", zap.Error(ctx.Err()))
 			return nil
 		}
 
 		if isQualified(filter, laptop) {
+			_, span := tracing.Start(ctx, "deepCopy", tracing.String("laptop.id", laptop.Id))
 			ret, err := deepCopy(laptop)
+			span.RecordError(err)
+			span.End()
 			if err != nil {
 				return err
 			}
-			log.Print("Storage Found, call callback")
+			matched++
 			err = found(ret)
 			if err != nil {
 				return err
 			}
-			log.Print("No error, next laptop...")
 		}
 	}
 
+	logger.Debug("search is done", zap.Int("scanned", len(store.data)), zap.Int("matched", matched))
+	span.SetAttributes(
+		tracing.Int("search.scanned", len(store.data)),
+		tracing.Int("search.matched", matched),
+	)
 	return nil
 }
 
 func isQualified(filter *pb.Filter, laptop *pb.Laptop) bool {
 	if laptop.GetPriceUsd() > filter.GetMaxPriceUsd() ||
 		laptop.GetCpu().GetNumberCores() < filter.GetMinCpuCores() ||
-		laptop.GetCpu().GetMinGhz() < filter.GetMinCpuGhz() ||
-		toBit(laptop.GetRam()) < toBit(filter.GetMinRam()) {
+		laptop.GetCpu().GetMinGhz() < filter.GetMinCpuGhz() {
 		return false
 	}
 
-	return true
-}
-
-func toBit(memory *pb.Memory) uint64 {
-	value := memory.GetValue()
-	switch memory.GetUnit() {
-	case pb.Memory_BIT:
-		return value
-	case pb.Memory_BYTE:
-		return value << 3 // 8 = 2^3
-	case pb.Memory_KILOBYTE:
-		return value << 13 // 1024 * 8 = 2^10 * 2^3 = 2^13
-	case pb.Memory_MEGABYTE:
-		return value << 23
-	case pb.Memory_GIGABYTE:
-		return value << 33
-	case pb.Memory_TERABYTE:
-		return value << 43
-	default:
-		return 0
+	// a laptop whose RAM is of an unknown unit doesn't match a min RAM
+	if minRAM := filter.GetMinRam(); minRAM.GetValue() > 0 {
+		cmp, err := units.CompareMemory(laptop.GetRam(), minRAM)
+		if err != nil || cmp < 0 {
+			return false
+		}
 	}
+
+	return true
 }
@@ -1,6 +1,10 @@
 package service
 
-import "sync"
+import (
+	"sync"
+
+	"go.uber.org/zap"
+)
 
 type Rating struct {
 	Count uint32
@@ -14,12 +18,65 @@ type RatingStore interface {
 type InMemoryRatingStore struct {
 	mutex  sync.RWMutex
 	rating map[string]*Rating
+	logger *zap.Logger
 }
 
-func NewInMemoryRatingStore() *InMemoryRatingStore {
+func NewInMemoryRatingStore(opts ...StoreOption) *InMemoryRatingStore {
+	config := newStoreConfig(opts)
 	return &InMemoryRatingStore{
 		rating: make(map[string]*Rating),
+		logger: config.logger,
+	}
+}
+
+// Count returns the number of ratings of all laptops
+func (s *InMemoryRatingStore) Count() int {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	count := 0
+	for _, rating := range s.rating {
+		count += int(rating.Count)
+	}
+	return count
+}
+
+// All returns a copy of the ratings of all laptops by laptop ID
+func (s *InMemoryRatingStore) All() map[string]Rating {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	ratings := make(map[string]Rating, len(s.rating))
+	for laptopID, rating := range s.rating {
+		ratings[laptopID] = *rating
+	}
+	return ratings
+}
+
+// Set replaces the rating of the laptop, e.g. when it's restored from a snapshot
+func (s *InMemoryRatingStore) Set(laptopID string, rating Rating) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.rating[laptopID] = &rating
+}
+
+// Restore sets the rating of the laptop, e.g. when it's imported from an archive.
+// It returns ErrAlreadyExists if the laptop is already rated.
+func (s *InMemoryRatingStore) Restore(laptopID string, rating Rating) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.rating[laptopID] != nil {
+		return ErrAlreadyExists
 	}
+	s.rating[laptopID] = &rating
+	return nil
+}
+
+// CheckHealth always succeeds since the data is kept in memory
+func (s *InMemoryRatingStore) CheckHealth() error {
+	return nil
 }
 
 func (s *InMemoryRatingStore) Add(laptopID string, score float64) (*Rating, error) {
@@ -38,5 +95,8 @@ func (s *InMemoryRatingStore) Add(laptopID string, score float64) (*Rating, erro
 	}
 
 	s.rating[laptopID] = rating
+	s.logger.Debug("added rating",
+		zap.String("laptop_id", laptopID),
+		zap.Uint32("count", rating.Count))
 	return rating, nil
 }
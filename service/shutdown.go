@@ -0,0 +1,104 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// StopGracefully stops the server from accepting new connections and RPCs,
+// then waits for the pending RPCs to finish. The RPCs still running after the timeout
+// are canceled. It returns false if the server had to be stopped forcibly.
+func StopGracefully(server *grpc.Server, timeout time.Duration) bool {
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop()
+		close(stopped)
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-stopped:
+		return true
+	case <-timer.C:
+		server.Stop()
+		<-stopped
+		return false
+	}
+}
+
+// Drainer cancels the long-lived streams, such as the replication and the health watch streams,
+// when the server is drained. GracefulStop would wait for them until the shutdown timeout otherwise.
+type Drainer struct {
+	methods map[string]bool
+	ctx     context.Context
+	cancel  context.CancelFunc
+	once    sync.Once
+}
+
+// NewDrainer returns a Drainer of the streams of the full method names,
+// e.g. /grpc.health.v1.Health/Watch
+func NewDrainer(methods ...string) *Drainer {
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &Drainer{
+		methods: make(map[string]bool, len(methods)),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+	for _, method := range methods {
+		d.methods[method] = true
+	}
+	return d
+}
+
+// Drain cancels the drained streams and the ones started afterwards
+func (d *Drainer) Drain() {
+	d.once.Do(d.cancel)
+}
+
+// StreamServerInterceptor cancels the context of the drained streams when the server is drained,
+// they fail with UNAVAILABLE so that their clients reconnect to another server
+func (d *Drainer) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		stream grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		if !d.methods[info.FullMethod] {
+			return handler(srv, stream)
+		}
+
+		ctx, cancel := context.WithCancel(stream.Context())
+		defer cancel()
+		go func() {
+			select {
+			case <-d.ctx.Done():
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		err := handler(srv, &drainedStream{ServerStream: stream, ctx: ctx})
+		if err != nil && d.ctx.Err() != nil && stream.Context().Err() == nil {
+			return status.Error(codes.Unavailable, "server is shutting down")
+		}
+		return err
+	}
+}
+
+// drainedStream is a server stream whose context is canceled when the server is drained
+type drainedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *drainedStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,165 @@
+package service_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hjcian/grpc-notes/pb"
+	"github.com/hjcian/grpc-notes/sample"
+	"github.com/hjcian/grpc-notes/service"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+func startStoppableLaptopServer(
+	t *testing.T,
+	laptopStore service.LaptopStore,
+	imageStore service.ImageStore,
+) (*grpc.Server, pb.LaptopServiceClient) {
+	grpcServer := grpc.NewServer()
+	pb.RegisterLaptopServiceServer(grpcServer, service.NewLaptopServer(laptopStore, imageStore, nil))
+
+	listener, err := net.Listen("tcp", ":0")
+	require.NoError(t, err)
+	go grpcServer.Serve(listener)
+
+	return grpcServer, newTestLaptopClient(t, listener.Addr().String())
+}
+
+func startUploadImage(
+	t *testing.T,
+	client pb.LaptopServiceClient,
+	laptopID string,
+) pb.LaptopService_UploadImageClient {
+	stream, err := client.UploadImage(context.Background())
+	require.NoError(t, err)
+
+	err = stream.Send(&pb.UploadImageRequest{
+		Data: &pb.UploadImageRequest_Info{
+			Info: &pb.ImageInfo{LaptopId: laptopID, ImageType: ".jpg"},
+		},
+	})
+	require.NoError(t, err)
+
+	err = stream.Send(&pb.UploadImageRequest{
+		Data: &pb.UploadImageRequest_ChunkData{ChunkData: []byte("first chunk")},
+	})
+	require.NoError(t, err)
+
+	return stream
+}
+
+func TestStopGracefully(t *testing.T) {
+	t.Parallel()
+
+	imageFolder := t.TempDir()
+	laptopStore := service.NewInMemoryLaptopStore()
+	laptop := sample.NewLaptop()
+	err := laptopStore.Save(laptop)
+	require.NoError(t, err)
+
+	grpcServer, client := startStoppableLaptopServer(t, laptopStore, service.NewDiskImageStore(imageFolder))
+	stream := startUploadImage(t, client, laptop.GetId())
+
+	graceful := make(chan bool)
+	go func() {
+		graceful <- service.StopGracefully(grpcServer, 5*time.Second)
+	}()
+
+	// the pending upload can still finish while the server is draining
+	err = stream.Send(&pb.UploadImageRequest{
+		Data: &pb.UploadImageRequest_ChunkData{ChunkData: []byte(", last chunk")},
+	})
+	require.NoError(t, err)
+
+	res, err := stream.CloseAndRecv()
+	require.NoError(t, err)
+	require.EqualValues(t, len("first chunk, last chunk"), res.GetSize())
+	require.True(t, <-graceful)
+
+	// but no new RPC is accepted
+	_, err = client.CreateLaptop(context.Background(), &pb.CreateLaptopRequest{Laptop: sample.NewLaptop()})
+	require.Error(t, err)
+}
+
+func TestStopGracefullyTimeout(t *testing.T) {
+	t.Parallel()
+
+	laptopStore := service.NewInMemoryLaptopStore()
+	laptop := sample.NewLaptop()
+	err := laptopStore.Save(laptop)
+	require.NoError(t, err)
+
+	grpcServer, client := startStoppableLaptopServer(t, laptopStore, service.NewDiskImageStore(t.TempDir()))
+	stream := startUploadImage(t, client, laptop.GetId())
+
+	// the upload never finishes, so it's canceled after the timeout
+	require.False(t, service.StopGracefully(grpcServer, 100*time.Millisecond))
+
+	_, err = stream.CloseAndRecv()
+	require.Error(t, err)
+}
+
+func TestDiskImageStoreClose(t *testing.T) {
+	t.Parallel()
+
+	imageFolder := t.TempDir()
+	imageStore := service.NewDiskImageStore(imageFolder)
+
+	imageID, err := imageStore.Save("laptop-id", ".jpg", *bytes.NewBufferString("image"), service.ImageQuota{})
+	require.NoError(t, err)
+	require.FileExists(t, filepath.Join(imageFolder, imageID+".jpg"))
+
+	// left behind by an interrupted write
+	partialPath := filepath.Join(imageFolder, "interrupted.jpg.partial")
+	err = ioutil.WriteFile(partialPath, []byte("ima"), 0644)
+	require.NoError(t, err)
+
+	err = imageStore.Close()
+	require.NoError(t, err)
+	require.NoFileExists(t, partialPath)
+	require.FileExists(t, filepath.Join(imageFolder, imageID+".jpg"))
+
+	_, err = imageStore.Save("laptop-id", ".jpg", *bytes.NewBufferString("image"), service.ImageQuota{})
+	require.True(t, errors.Is(err, service.ErrClosed))
+}
+
+func TestDrainerCancelsLongLivedStreams(t *testing.T) {
+	t.Parallel()
+
+	drainer := service.NewDrainer("/grpc.health.v1.Health/Watch")
+	grpcServer := grpc.NewServer(grpc.StreamInterceptor(drainer.StreamServerInterceptor()))
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
+
+	listener, err := net.Listen("tcp", ":0")
+	require.NoError(t, err)
+	go grpcServer.Serve(listener)
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithInsecure())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	stream, err := healthpb.NewHealthClient(conn).Watch(context.Background(), &healthpb.HealthCheckRequest{})
+	require.NoError(t, err)
+	res, err := stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, healthpb.HealthCheckResponse_SERVING, res.GetStatus())
+
+	// the watch stream would never end by itself
+	drainer.Drain()
+	require.True(t, service.StopGracefully(grpcServer, 5*time.Second))
+
+	_, err = stream.Recv()
+	require.Equal(t, codes.Unavailable, status.Code(err))
+}
@@ -0,0 +1,42 @@
+package service
+
+import (
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// StoreOption configures optional behaviors of the stores
+type StoreOption func(c *storeConfig)
+
+type storeConfig struct {
+	logger *zap.Logger
+	now    func() time.Time
+}
+
+func newStoreConfig(opts []StoreOption) storeConfig {
+	config := storeConfig{
+		logger: zap.NewNop(),
+		now:    time.Now,
+	}
+
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	return config
+}
+
+// WithStoreLogger sets the logger of the store
+func WithStoreLogger(logger *zap.Logger) StoreOption {
+	return func(c *storeConfig) {
+		c.logger = logger
+	}
+}
+
+// WithStoreClock sets the clock of the store, it's meant to be used in tests
+func WithStoreClock(now func() time.Time) StoreOption {
+	return func(c *storeConfig) {
+		c.now = now
+	}
+}
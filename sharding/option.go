@@ -0,0 +1,43 @@
+package sharding
+
+import "time"
+
+// Defaults of the options of LaptopStore and RemoteLaptopStore
+const (
+	DefaultVirtualNodes = 100
+	DefaultTimeout      = 5 * time.Second
+)
+
+type config struct {
+	virtualNodes int
+	timeout      time.Duration
+}
+
+// Option configures a LaptopStore or a RemoteLaptopStore
+type Option func(c *config)
+
+func newConfig(opts []Option) *config {
+	c := &config{
+		virtualNodes: DefaultVirtualNodes,
+		timeout:      DefaultTimeout,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// WithVirtualNodes sets the number of points of each shard on the ring of the LaptopStore
+func WithVirtualNodes(virtualNodes int) Option {
+	return func(c *config) {
+		c.virtualNodes = virtualNodes
+	}
+}
+
+// WithTimeout sets the timeout of the RPCs of the RemoteLaptopStore, except SearchLaptop which is
+// bounded by the context of the search
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *config) {
+		c.timeout = timeout
+	}
+}
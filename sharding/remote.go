@@ -0,0 +1,144 @@
+package sharding
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/hjcian/grpc-notes/pb"
+	"github.com/hjcian/grpc-notes/service"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// RemoteLaptopStore is a LaptopStore of the laptops of a LaptopService node
+type RemoteLaptopStore struct {
+	client  pb.LaptopServiceClient
+	timeout time.Duration
+}
+
+// NewRemoteLaptopStore returns a new RemoteLaptopStore of the LaptopService on the connection
+func NewRemoteLaptopStore(conn grpc.ClientConnInterface, opts ...Option) *RemoteLaptopStore {
+	config := newConfig(opts)
+	return &RemoteLaptopStore{
+		client:  pb.NewLaptopServiceClient(conn),
+		timeout: config.timeout,
+	}
+}
+
+// storeError converts the status of an RPC to the errors of the stores
+func storeError(err error, message string) error {
+	switch status.Code(err) {
+	case codes.AlreadyExists, codes.Aborted:
+		return fmt.Errorf("%s: %v: %w", message, err, service.ErrAlreadyExists)
+	case codes.NotFound:
+		return fmt.Errorf("%s: %v: %w", message, err, service.ErrNotFound)
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted:
+		return fmt.Errorf("%s: %v: %w", message, err, service.ErrUnavailable)
+	default:
+		return fmt.Errorf("%s: %w", message, err)
+	}
+}
+
+// Save creates the laptop with its ID
+func (store *RemoteLaptopStore) Save(laptop *pb.Laptop) error {
+	ctx, cancel := context.WithTimeout(context.Background(), store.timeout)
+	defer cancel()
+
+	_, err := store.client.CreateLaptop(ctx, &pb.CreateLaptopRequest{Laptop: laptop})
+	if err != nil {
+		return storeError(err, "cannot create laptop")
+	}
+	return nil
+}
+
+// SaveAll creates the laptops in all-or-nothing mode
+func (store *RemoteLaptopStore) SaveAll(laptops []*pb.Laptop) error {
+	ctx, cancel := context.WithTimeout(context.Background(), store.timeout)
+	defer cancel()
+
+	stream, err := store.client.CreateLaptops(ctx)
+	if err != nil {
+		return storeError(err, "cannot create laptops")
+	}
+	err = stream.Send(&pb.CreateLaptopsRequest{Data: &pb.CreateLaptopsRequest_Options{
+		Options: &pb.CreateLaptopsOptions{AllOrNothing: true},
+	}})
+	for _, laptop := range laptops {
+		if err != nil {
+			break
+		}
+		err = stream.Send(&pb.CreateLaptopsRequest{Data: &pb.CreateLaptopsRequest_Laptop{Laptop: laptop}})
+	}
+	// the status of a failed stream is returned by CloseAndRecv
+	if err != nil && err != io.EOF {
+		return storeError(err, "cannot send laptop")
+	}
+
+	res, err := stream.CloseAndRecv()
+	if err != nil {
+		return storeError(err, "cannot create laptops")
+	}
+
+	for _, result := range res.GetResults() {
+		switch result.GetStatus() {
+		case pb.CreateLaptopResult_CREATED, pb.CreateLaptopResult_ABORTED:
+		case pb.CreateLaptopResult_ALREADY_EXISTS:
+			return fmt.Errorf("laptop id %s: %w", result.GetId(), service.ErrAlreadyExists)
+		default:
+			return fmt.Errorf("cannot create laptop %d: %s %s", result.GetIndex(), result.GetStatus(), result.GetReason())
+		}
+	}
+	return nil
+}
+
+// Find gets the laptop, it returns nil if the laptop doesn't exist
+func (store *RemoteLaptopStore) Find(id string) (*pb.Laptop, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), store.timeout)
+	defer cancel()
+
+	res, err := store.client.GetLaptop(ctx, &pb.GetLaptopRequest{Id: id})
+	if status.Code(err) == codes.NotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, storeError(err, "cannot get laptop")
+	}
+	return res.GetLaptop(), nil
+}
+
+// Search streams the laptops found by the node, until the context is done
+func (store *RemoteLaptopStore) Search(
+	ctx context.Context,
+	filter *pb.Filter,
+	found func(laptop *pb.Laptop) error,
+) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stream, err := store.client.SearchLaptop(ctx, &pb.SearchLaptopRequest{Filter: filter})
+	if err != nil {
+		return storeError(err, "cannot search laptop")
+	}
+
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if ctx.Err() != nil {
+			// stopped by the context, like the in-memory store
+			return nil
+		}
+		if err != nil {
+			return storeError(err, "cannot receive laptop")
+		}
+
+		err = found(res.GetLaptop())
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package sharding
+
+import (
+	"hash/crc32"
+	"sort"
+	"strconv"
+)
+
+// Ring maps the keys to the shards by consistent hashing. Each shard owns many points of the ring,
+// so that the keys are spread evenly and adding a shard only moves the keys it takes over.
+type Ring struct {
+	virtualNodes int
+	points       []uint32
+	owners       map[uint32]string
+}
+
+// NewRing returns a new Ring of the shards, each one owning virtualNodes points
+func NewRing(virtualNodes int, shards ...string) *Ring {
+	ring := &Ring{
+		virtualNodes: virtualNodes,
+		owners:       make(map[uint32]string),
+	}
+	for _, shard := range shards {
+		ring.Add(shard)
+	}
+	return ring
+}
+
+// Add adds the points of the shard to the ring
+func (r *Ring) Add(shard string) {
+	for i := 0; i < r.virtualNodes; i++ {
+		point := crc32.ChecksumIEEE([]byte(strconv.Itoa(i) + shard))
+		if _, taken := r.owners[point]; taken {
+			// the smallest name owns a point hashed twice, so that the ring doesn't depend on the order of the shards
+			if r.owners[point] < shard {
+				continue
+			}
+		} else {
+			r.points = append(r.points, point)
+		}
+		r.owners[point] = shard
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+}
+
+// Get returns the shard owning the key, empty if the ring has no shard
+func (r *Ring) Get(key string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+
+	hash := crc32.ChecksumIEEE([]byte(key))
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= hash })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[r.points[i]]
+}
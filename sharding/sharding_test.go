@@ -0,0 +1,350 @@
+package sharding_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync/atomic"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/hjcian/grpc-notes/pb"
+	"github.com/hjcian/grpc-notes/sample"
+	"github.com/hjcian/grpc-notes/service"
+	"github.com/hjcian/grpc-notes/sharding"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var anyFilter = &pb.Filter{MaxPriceUsd: 1e9}
+
+func TestRing(t *testing.T) {
+	t.Parallel()
+
+	ring := sharding.NewRing(sharding.DefaultVirtualNodes, "a", "b", "c")
+	require.Equal(t, ring.Get("key"), sharding.NewRing(sharding.DefaultVirtualNodes, "c", "a", "b").Get("key"))
+
+	const keys = 3000
+	owners := make(map[string]string, keys)
+	counts := make(map[string]int)
+	for i := 0; i < keys; i++ {
+		key := uuid.New().String()
+		owners[key] = ring.Get(key)
+		counts[owners[key]]++
+	}
+	for _, shard := range []string{"a", "b", "c"} {
+		require.InDelta(t, keys/3, counts[shard], keys/6, "shard %s", shard)
+	}
+
+	// a new shard only takes keys over from the others
+	ring.Add("d")
+	moved := 0
+	for key, owner := range owners {
+		if newOwner := ring.Get(key); newOwner != owner {
+			require.Equal(t, "d", newOwner)
+			moved++
+		}
+	}
+	require.InDelta(t, keys/4, moved, keys/8)
+
+	require.Empty(t, sharding.NewRing(10).Get("key"))
+}
+
+func newLocalShards(names ...string) (map[string]service.LaptopStore, map[string]*service.InMemoryLaptopStore) {
+	shards := make(map[string]service.LaptopStore)
+	stores := make(map[string]*service.InMemoryLaptopStore)
+	for _, name := range names {
+		stores[name] = service.NewInMemoryLaptopStore()
+		shards[name] = stores[name]
+	}
+	return shards, stores
+}
+
+// shardLaptops returns count sample laptops of the shard
+func shardLaptops(store *sharding.LaptopStore, shard string, count int) []*pb.Laptop {
+	var laptops []*pb.Laptop
+	for len(laptops) < count {
+		laptop := sample.NewLaptop()
+		if store.Shard(laptop.GetId()) == shard {
+			laptops = append(laptops, laptop)
+		}
+	}
+	return laptops
+}
+
+func TestLaptopStore(t *testing.T) {
+	t.Parallel()
+
+	shards, stores := newLocalShards("a", "b", "c")
+	store := sharding.NewLaptopStore(shards)
+
+	laptops := make(map[string]*pb.Laptop)
+	for i := 0; i < 30; i++ {
+		laptop := sample.NewLaptop()
+		require.NoError(t, store.Save(laptop))
+		laptops[laptop.GetId()] = laptop
+	}
+	for id := range laptops {
+		found, err := stores[store.Shard(id)].Find(id)
+		require.NoError(t, err)
+		require.NotNil(t, found)
+
+		found, err = store.Find(id)
+		require.NoError(t, err)
+		require.Equal(t, id, found.GetId())
+	}
+	for _, shard := range stores {
+		require.NotZero(t, shard.Count())
+	}
+
+	found, err := store.Find(uuid.New().String())
+	require.NoError(t, err)
+	require.Nil(t, found)
+
+	var existing *pb.Laptop
+	for _, laptop := range laptops {
+		existing = laptop
+		break
+	}
+	err = store.Save(existing)
+	require.True(t, errors.Is(err, service.ErrAlreadyExists))
+
+	// nothing is saved if any laptop already exists
+	batch := append(shardLaptops(store, store.Shard(existing.GetId()), 3), existing)
+	err = store.SaveAll(batch)
+	require.True(t, errors.Is(err, service.ErrAlreadyExists))
+	duplicate := sample.NewLaptop()
+	err = store.SaveAll([]*pb.Laptop{duplicate, duplicate})
+	require.True(t, errors.Is(err, service.ErrAlreadyExists))
+
+	// nor if the laptops are of several shards, which can't commit together
+	err = store.SaveAll(append(shardLaptops(store, "a", 1), shardLaptops(store, "b", 1)...))
+	require.True(t, errors.Is(err, service.ErrNotAtomic))
+
+	require.Equal(t, len(laptops), stores["a"].Count()+stores["b"].Count()+stores["c"].Count())
+
+	require.NoError(t, store.SaveAll(batch[:3]))
+	for _, laptop := range batch[:3] {
+		laptops[laptop.GetId()] = laptop
+	}
+
+	var searched []string
+	err = store.Search(context.Background(), anyFilter, func(laptop *pb.Laptop) error {
+		require.NotNil(t, laptops[laptop.GetId()])
+		searched = append(searched, laptop.GetId())
+		return nil
+	})
+	require.NoError(t, err)
+	require.Len(t, searched, len(laptops))
+	require.True(t, sort.StringsAreSorted(searched))
+}
+
+// orderedStore finds its laptops in order, running counts its searches in progress
+type orderedStore struct {
+	service.LaptopStore
+	name    string
+	count   int
+	fail    bool
+	running int32
+}
+
+func (store *orderedStore) Search(ctx context.Context, filter *pb.Filter, found func(laptop *pb.Laptop) error) error {
+	atomic.AddInt32(&store.running, 1)
+	defer atomic.AddInt32(&store.running, -1)
+
+	for i := 0; i < store.count; i++ {
+		if ctx.Err() != nil {
+			return nil
+		}
+		if store.fail && i == store.count/2 {
+			return errors.New("shard failure")
+		}
+		err := found(&pb.Laptop{Id: fmt.Sprintf("%03d-%s", i, store.name)})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestSearchOrderAndCancellation(t *testing.T) {
+	t.Parallel()
+
+	stores := []*orderedStore{{name: "a", count: 100}, {name: "b", count: 100}, {name: "c", count: 100}}
+	shards := make(map[string]service.LaptopStore)
+	for _, shard := range stores {
+		shards[shard.name] = shard
+	}
+	store := sharding.NewLaptopStore(shards)
+
+	// the laptops of the shards are merged in the order of their IDs
+	var ids []string
+	err := store.Search(context.Background(), anyFilter, func(laptop *pb.Laptop) error {
+		ids = append(ids, laptop.GetId())
+		return nil
+	})
+	require.NoError(t, err)
+	require.Len(t, ids, 300)
+	require.Equal(t, []string{"000-a", "000-b", "000-c", "001-a"}, ids[:4])
+	require.True(t, sort.StringsAreSorted(ids))
+
+	// the error of the found function stops all the shards
+	stop := errors.New("stop")
+	count := 0
+	err = store.Search(context.Background(), anyFilter, func(laptop *pb.Laptop) error {
+		count++
+		if count == 10 {
+			return stop
+		}
+		return nil
+	})
+	require.Equal(t, stop, err)
+	require.Equal(t, 10, count)
+	for _, shard := range stores {
+		require.Zero(t, atomic.LoadInt32(&shard.running))
+	}
+
+	// so does the context
+	ctx, cancel := context.WithCancel(context.Background())
+	count = 0
+	err = store.Search(ctx, anyFilter, func(laptop *pb.Laptop) error {
+		count++
+		if count == 10 {
+			cancel()
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.Less(t, count, 300)
+
+	// and the failure of a shard
+	stores[1].fail = true
+	err = store.Search(context.Background(), anyFilter, func(laptop *pb.Laptop) error {
+		return nil
+	})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "shard b")
+	for _, shard := range stores {
+		require.Zero(t, atomic.LoadInt32(&shard.running))
+	}
+}
+
+func startShardServer(t *testing.T) (*service.InMemoryLaptopStore, *sharding.RemoteLaptopStore) {
+	laptopStore := service.NewInMemoryLaptopStore()
+	return laptopStore, serveLaptopStore(t, laptopStore)
+}
+
+// serveLaptopStore starts a LaptopService of the store, and returns its RemoteLaptopStore
+func serveLaptopStore(t *testing.T, laptopStore service.LaptopStore) *sharding.RemoteLaptopStore {
+	laptopServer := service.NewLaptopServer(
+		laptopStore,
+		service.NewDiskImageStore(t.TempDir()),
+		service.NewInMemoryRatingStore(),
+	)
+	grpcServer := grpc.NewServer()
+	pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	conn, err := grpc.Dial(listener.Addr().String(), grpc.WithInsecure())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return sharding.NewRemoteLaptopStore(conn)
+}
+
+func TestRemoteShards(t *testing.T) {
+	t.Parallel()
+
+	shards, stores := newLocalShards("local")
+	for _, name := range []string{"remote-1", "remote-2"} {
+		stores[name], shards[name] = startShardServer(t)
+	}
+	store := sharding.NewLaptopStore(shards)
+
+	var ids []string
+	for i := 0; i < 20; i++ {
+		laptop := sample.NewLaptop()
+		require.NoError(t, store.Save(laptop))
+		ids = append(ids, laptop.GetId())
+	}
+	for _, name := range []string{"local", "remote-1"} {
+		batch := shardLaptops(store, name, 3)
+		require.NoError(t, store.SaveAll(batch))
+		for _, laptop := range batch {
+			ids = append(ids, laptop.GetId())
+		}
+	}
+
+	for _, id := range ids {
+		found, err := stores[store.Shard(id)].Find(id)
+		require.NoError(t, err)
+		require.NotNil(t, found, "shard %s", store.Shard(id))
+
+		found, err = store.Find(id)
+		require.NoError(t, err)
+		require.Equal(t, id, found.GetId())
+	}
+	for name, shard := range stores {
+		require.NotZero(t, shard.Count(), "shard %s", name)
+	}
+
+	// the errors of the remote shards are the errors of the stores
+	for _, id := range ids {
+		if store.Shard(id) == "local" {
+			continue
+		}
+		laptop, err := store.Find(id)
+		require.NoError(t, err)
+		err = store.Save(laptop)
+		require.True(t, errors.Is(err, service.ErrAlreadyExists))
+		err = shards[store.Shard(id)].SaveAll([]*pb.Laptop{sample.NewLaptop(), laptop})
+		require.True(t, errors.Is(err, service.ErrAlreadyExists))
+		break
+	}
+	found, err := shards["remote-1"].Find(uuid.New().String())
+	require.NoError(t, err)
+	require.Nil(t, found)
+
+	// the remote shards find their laptops in the order of their IDs too
+	var searched []string
+	err = store.Search(context.Background(), anyFilter, func(laptop *pb.Laptop) error {
+		searched = append(searched, laptop.GetId())
+		return nil
+	})
+	require.NoError(t, err)
+	sort.Strings(ids)
+	require.Equal(t, ids, searched)
+
+	stop := errors.New("stop")
+	count := 0
+	err = store.Search(context.Background(), anyFilter, func(laptop *pb.Laptop) error {
+		count++
+		return stop
+	})
+	require.Equal(t, stop, err)
+	require.Equal(t, 1, count)
+}
+
+func TestAllOrNothingAcrossShards(t *testing.T) {
+	t.Parallel()
+
+	shards, stores := newLocalShards("a", "b", "c")
+	store := sharding.NewLaptopStore(shards)
+	remote := serveLaptopStore(t, store)
+
+	// the laptops of several shards are rejected before any of them is created
+	laptops := append(shardLaptops(store, "a", 2), shardLaptops(store, "b", 2)...)
+	err := remote.SaveAll(laptops)
+	require.Equal(t, codes.FailedPrecondition, status.Code(errors.Unwrap(err)), err)
+	require.Equal(t, 0, stores["a"].Count()+stores["b"].Count()+stores["c"].Count())
+
+	require.NoError(t, remote.SaveAll(shardLaptops(store, "c", 3)))
+	require.Equal(t, 3, stores["c"].Count())
+}
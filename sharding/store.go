@@ -0,0 +1,143 @@
+package sharding
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/hjcian/grpc-notes/pb"
+	"github.com/hjcian/grpc-notes/service"
+)
+
+// LaptopStore spreads the laptops over the shards by the consistent hash of their ID
+type LaptopStore struct {
+	ring   *Ring
+	shards map[string]service.LaptopStore
+}
+
+// NewLaptopStore returns a new LaptopStore of the shards by name. The names place the shards on the ring,
+// they must be kept when the shards are restarted or moved.
+func NewLaptopStore(shards map[string]service.LaptopStore, opts ...Option) *LaptopStore {
+	config := newConfig(opts)
+	ring := NewRing(config.virtualNodes)
+	for name := range shards {
+		ring.Add(name)
+	}
+	return &LaptopStore{
+		ring:   ring,
+		shards: shards,
+	}
+}
+
+// Shard returns the name of the shard storing the laptop
+func (store *LaptopStore) Shard(laptopID string) string {
+	return store.ring.Get(laptopID)
+}
+
+func (store *LaptopStore) shard(laptopID string) service.LaptopStore {
+	return store.shards[store.ring.Get(laptopID)]
+}
+
+// Save saves the laptop to its shard
+func (store *LaptopStore) Save(laptop *pb.Laptop) error {
+	return store.shard(laptop.GetId()).Save(laptop)
+}
+
+// SaveAll saves the laptops to their shard, or none of them if any laptop already exists.
+// The shards can't commit together, so the laptops must be of the same shard, the laptops
+// of several shards are rejected with service.ErrNotAtomic before any of them is saved.
+func (store *LaptopStore) SaveAll(laptops []*pb.Laptop) error {
+	if len(laptops) == 0 {
+		return nil
+	}
+
+	name := store.Shard(laptops[0].GetId())
+	for _, laptop := range laptops[1:] {
+		if other := store.Shard(laptop.GetId()); other != name {
+			return fmt.Errorf("laptop %s of shard %s and laptop %s of shard %s: %w",
+				laptops[0].GetId(), name, laptop.GetId(), other, service.ErrNotAtomic)
+		}
+	}
+
+	err := store.shards[name].SaveAll(laptops)
+	if err != nil {
+		return fmt.Errorf("shard %s: %w", name, err)
+	}
+	return nil
+}
+
+// Find finds a laptop by ID in its shard
+func (store *LaptopStore) Find(id string) (*pb.Laptop, error) {
+	return store.shard(id).Find(id)
+}
+
+// Search searches all the shards concurrently, and merges the laptops of the shards in the order
+// of their IDs, the shards must find their laptops in that order as the InMemoryLaptopStore does.
+// The found function is called by the calling goroutine, one laptop at a time. The search of every
+// shard is stopped when the context is done, the found function fails, or any shard fails.
+func (store *LaptopStore) Search(
+	ctx context.Context,
+	filter *pb.Filter,
+	found func(laptop *pb.Laptop) error,
+) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// each shard sends its laptops to its own channel, which is closed when its search is done
+	streams := make([]chan *pb.Laptop, 0, len(store.shards))
+	errs := make(chan error, len(store.shards))
+	var wg sync.WaitGroup
+	for name, shard := range store.shards {
+		laptops := make(chan *pb.Laptop)
+		streams = append(streams, laptops)
+
+		wg.Add(1)
+		go func(name string, shard service.LaptopStore) {
+			defer wg.Done()
+			defer close(laptops)
+			err := shard.Search(ctx, filter, func(laptop *pb.Laptop) error {
+				select {
+				case laptops <- laptop:
+					return nil
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			})
+			if err != nil && ctx.Err() == nil {
+				errs <- fmt.Errorf("shard %s: %w", name, err)
+				cancel()
+			}
+		}(name, shard)
+	}
+	defer wg.Wait()
+
+	// the next laptop of each shard, nil once the shard is done
+	heads := make([]*pb.Laptop, len(streams))
+	for i, laptops := range streams {
+		heads[i] = <-laptops
+	}
+	for {
+		next := -1
+		for i, head := range heads {
+			if head != nil && (next < 0 || head.GetId() < heads[next].GetId()) {
+				next = i
+			}
+		}
+		if next < 0 {
+			break
+		}
+
+		if err := found(heads[next]); err != nil {
+			cancel()
+			return err
+		}
+		heads[next] = <-streams[next]
+	}
+
+	select {
+	case err := <-errs:
+		return err
+	default:
+		return nil
+	}
+}
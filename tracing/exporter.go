@@ -0,0 +1,94 @@
+package tracing
+
+import (
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// InMemoryExporter keeps the ended spans in memory, it's meant to be used in tests
+type InMemoryExporter struct {
+	mutex sync.Mutex
+	spans []*SpanData
+}
+
+// NewInMemoryExporter returns a new InMemoryExporter
+func NewInMemoryExporter() *InMemoryExporter {
+	return &InMemoryExporter{}
+}
+
+// ExportSpan keeps the span
+func (e *InMemoryExporter) ExportSpan(span *SpanData) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.spans = append(e.spans, span)
+}
+
+// Spans returns the ended spans in the order they ended
+func (e *InMemoryExporter) Spans() []*SpanData {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	return append([]*SpanData(nil), e.spans...)
+}
+
+// Find returns the ended spans with the given name
+func (e *InMemoryExporter) Find(name string) []*SpanData {
+	var spans []*SpanData
+	for _, span := range e.Spans() {
+		if span.Name == name {
+			spans = append(spans, span)
+		}
+	}
+	return spans
+}
+
+// Children returns the ended spans whose parent is the given span
+func (e *InMemoryExporter) Children(parent *SpanData) []*SpanData {
+	var spans []*SpanData
+	for _, span := range e.Spans() {
+		if span.SpanContext.TraceID == parent.SpanContext.TraceID &&
+			span.ParentSpanID == parent.SpanContext.SpanID {
+			spans = append(spans, span)
+		}
+	}
+	return spans
+}
+
+// Reset drops the spans kept so far
+func (e *InMemoryExporter) Reset() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.spans = nil
+}
+
+// LogExporter writes the ended spans to a logger at debug level
+type LogExporter struct {
+	logger *zap.Logger
+}
+
+// NewLogExporter returns a new LogExporter
+func NewLogExporter(logger *zap.Logger) *LogExporter {
+	return &LogExporter{logger: logger}
+}
+
+// ExportSpan logs the span
+func (e *LogExporter) ExportSpan(span *SpanData) {
+	fields := []zap.Field{
+		zap.String("name", span.Name),
+		zap.Stringer("trace_id", span.SpanContext.TraceID),
+		zap.Stringer("span_id", span.SpanContext.SpanID),
+		zap.Duration("duration", span.Duration()),
+		zap.Any("attributes", span.Attributes),
+	}
+	if span.ParentSpanID.IsValid() {
+		fields = append(fields, zap.Stringer("parent_span_id", span.ParentSpanID))
+	}
+	if span.Err != nil {
+		fields = append(fields, zap.Error(span.Err))
+	}
+
+	e.logger.Debug("span", fields...)
+}
@@ -0,0 +1,151 @@
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// TraceParentHeader is the metadata key propagating the span context,
+// its value follows the W3C trace context format: 00-<trace id>-<span id>-01
+const TraceParentHeader = "traceparent"
+
+// FormatTraceParent formats the span context as a traceparent value
+func FormatTraceParent(sc SpanContext) string {
+	return fmt.Sprintf("00-%s-%s-01", sc.TraceID, sc.SpanID)
+}
+
+// ParseTraceParent parses a traceparent value
+func ParseTraceParent(value string) (SpanContext, error) {
+	parts := strings.Split(value, "-")
+	if len(parts) != 4 || parts[0] != "00" {
+		return SpanContext{}, fmt.Errorf("invalid traceparent %q", value)
+	}
+
+	var sc SpanContext
+	if err := decodeHex(sc.TraceID[:], parts[1]); err != nil {
+		return SpanContext{}, fmt.Errorf("invalid trace id in traceparent: %w", err)
+	}
+	if err := decodeHex(sc.SpanID[:], parts[2]); err != nil {
+		return SpanContext{}, fmt.Errorf("invalid span id in traceparent: %w", err)
+	}
+	if !sc.IsValid() {
+		return SpanContext{}, fmt.Errorf("invalid traceparent %q", value)
+	}
+	return sc, nil
+}
+
+func decodeHex(dst []byte, src string) error {
+	if hex.DecodedLen(len(src)) != len(dst) {
+		return fmt.Errorf("expect %d hex digits, got %d", 2*len(dst), len(src))
+	}
+	_, err := hex.Decode(dst, []byte(src))
+	return err
+}
+
+// extract returns the context carrying the tracer and the span context sent by the client, if any
+func extract(ctx context.Context, tracer *Tracer) context.Context {
+	ctx = NewContext(ctx, tracer)
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(TraceParentHeader); len(values) > 0 {
+		if sc, err := ParseTraceParent(values[0]); err == nil {
+			ctx = ContextWithRemoteSpanContext(ctx, sc)
+		}
+	}
+	return ctx
+}
+
+// inject adds the span context of the context to the outgoing metadata
+func inject(ctx context.Context) context.Context {
+	sc := SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, TraceParentHeader, FormatTraceParent(sc))
+}
+
+func endRPCSpan(span *Span, err error) {
+	span.SetAttributes(String("rpc.grpc.status_code", status.Code(err).String()))
+	span.RecordError(err)
+	span.End()
+}
+
+// UnaryServerInterceptor starts a span for each unary RPC,
+// as a child of the span propagated by the client
+func UnaryServerInterceptor(tracer *Tracer) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		ctx, span := Start(extract(ctx, tracer), info.FullMethod)
+
+		res, err := handler(ctx, req)
+
+		endRPCSpan(span, err)
+		return res, err
+	}
+}
+
+// StreamServerInterceptor starts a span for each streaming RPC,
+// as a child of the span propagated by the client
+func StreamServerInterceptor(tracer *Tracer) grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		stream grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		ctx, span := Start(extract(stream.Context(), tracer), info.FullMethod)
+
+		err := handler(srv, &contextStream{ServerStream: stream, ctx: ctx})
+
+		endRPCSpan(span, err)
+		return err
+	}
+}
+
+// UnaryClientInterceptor propagates the current span of the context to the server
+func UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(
+		ctx context.Context,
+		method string,
+		req, reply interface{},
+		cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker,
+		opts ...grpc.CallOption,
+	) error {
+		return invoker(inject(ctx), method, req, reply, cc, opts...)
+	}
+}
+
+// StreamClientInterceptor propagates the current span of the context to the server
+func StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(
+		ctx context.Context,
+		desc *grpc.StreamDesc,
+		cc *grpc.ClientConn,
+		method string,
+		streamer grpc.Streamer,
+		opts ...grpc.CallOption,
+	) (grpc.ClientStream, error) {
+		return streamer(inject(ctx), desc, cc, method, opts...)
+	}
+}
+
+// contextStream is a server stream with a replaced context
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
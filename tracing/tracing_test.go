@@ -0,0 +1,228 @@
+package tracing_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/hjcian/grpc-notes/pb"
+	"github.com/hjcian/grpc-notes/sample"
+	"github.com/hjcian/grpc-notes/service"
+	"github.com/hjcian/grpc-notes/tracing"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+)
+
+func TestStart(t *testing.T) {
+	t.Parallel()
+
+	// no span is recorded without a tracer
+	ctx, span := tracing.Start(context.Background(), "noop")
+	require.Nil(t, span)
+	require.Nil(t, tracing.SpanFromContext(ctx))
+	span.SetAttributes(tracing.String("key", "value"))
+	span.End()
+
+	exporter := tracing.NewInMemoryExporter()
+	ctx = tracing.NewContext(context.Background(), tracing.NewTracer(exporter))
+
+	ctx, parent := tracing.Start(ctx, "parent", tracing.String("key", "value"))
+	_, child := tracing.Start(ctx, "child")
+	child.RecordError(errors.New("failed"))
+	child.End()
+	child.End()
+	parent.End()
+
+	spans := exporter.Spans()
+	require.Len(t, spans, 2)
+	require.Equal(t, "child", spans[0].Name)
+	require.Equal(t, "parent", spans[1].Name)
+	require.Equal(t, spans[1].SpanContext.TraceID, spans[0].SpanContext.TraceID)
+	require.Equal(t, spans[1].SpanContext.SpanID, spans[0].ParentSpanID)
+	require.False(t, spans[1].ParentSpanID.IsValid())
+	require.Equal(t, "value", spans[1].Attributes["key"])
+	require.EqualError(t, spans[0].Err, "failed")
+	require.Equal(t, spans[:1], exporter.Children(spans[1]))
+}
+
+func TestTraceParent(t *testing.T) {
+	t.Parallel()
+
+	value := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, err := tracing.ParseTraceParent(value)
+	require.NoError(t, err)
+	require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID.String())
+	require.Equal(t, "00f067aa0ba902b7", sc.SpanID.String())
+	require.Equal(t, value, tracing.FormatTraceParent(sc))
+
+	for _, invalid := range []string{
+		"",
+		"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e47-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-zzf067aa0ba902b7-01",
+	} {
+		_, err := tracing.ParseTraceParent(invalid)
+		require.Error(t, err, invalid)
+	}
+}
+
+func startTestServer(t *testing.T, tracer *tracing.Tracer) pb.LaptopServiceClient {
+	grpcServer := grpc.NewServer(
+		grpc.UnaryInterceptor(tracing.UnaryServerInterceptor(tracer)),
+		grpc.StreamInterceptor(tracing.StreamServerInterceptor(tracer)),
+	)
+	laptopServer := service.NewLaptopServer(
+		service.NewInMemoryLaptopStore(),
+		service.NewDiskImageStore(t.TempDir()),
+		service.NewInMemoryRatingStore(),
+	)
+	pb.RegisterLaptopServiceServer(grpcServer, laptopServer)
+
+	listener, err := net.Listen("tcp", ":0")
+	require.NoError(t, err)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	conn, err := grpc.Dial(
+		listener.Addr().String(),
+		grpc.WithInsecure(),
+		grpc.WithUnaryInterceptor(tracing.UnaryClientInterceptor()),
+		grpc.WithStreamInterceptor(tracing.StreamClientInterceptor()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return pb.NewLaptopServiceClient(conn)
+}
+
+func TestSearchLaptopSpans(t *testing.T) {
+	t.Parallel()
+
+	serverExporter := tracing.NewInMemoryExporter()
+	client := startTestServer(t, tracing.NewTracer(serverExporter))
+
+	for i := 0; i < 3; i++ {
+		_, err := client.CreateLaptop(context.Background(), &pb.CreateLaptopRequest{Laptop: sample.NewLaptop()})
+		require.NoError(t, err)
+	}
+	serverExporter.Reset()
+
+	// the client span is propagated to the server
+	clientExporter := tracing.NewInMemoryExporter()
+	ctx := tracing.NewContext(context.Background(), tracing.NewTracer(clientExporter))
+	ctx, clientSpan := tracing.Start(ctx, "client")
+
+	stream, err := client.SearchLaptop(ctx, &pb.SearchLaptopRequest{
+		Filter: &pb.Filter{MaxPriceUsd: 10000},
+	})
+	require.NoError(t, err)
+	for {
+		_, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+	}
+	clientSpan.End()
+
+	rpcSpans := serverExporter.Find("/techschool.pcbook.LaptopService/SearchLaptop")
+	require.Len(t, rpcSpans, 1)
+	rpcSpan := rpcSpans[0]
+	require.Equal(t, clientSpan.SpanContext().TraceID, rpcSpan.SpanContext.TraceID)
+	require.Equal(t, clientSpan.SpanContext().SpanID, rpcSpan.ParentSpanID)
+	require.Equal(t, "OK", rpcSpan.Attributes["rpc.grpc.status_code"])
+
+	searchSpans := serverExporter.Find("LaptopStore.Search")
+	require.Len(t, searchSpans, 1)
+	searchSpan := searchSpans[0]
+	require.Equal(t, int64(3), searchSpan.Attributes["search.matched"])
+	require.NotEmpty(t, searchSpan.Attributes["filter"])
+	require.Empty(t, serverExporter.Children(searchSpan))
+
+	// the time in the store, in the copies and in the sends are siblings
+	names := map[string]int{}
+	for _, span := range serverExporter.Children(rpcSpan) {
+		if span != searchSpan {
+			require.NotEmpty(t, span.Attributes["laptop.id"])
+		}
+		names[span.Name]++
+	}
+	require.Equal(t, map[string]int{"LaptopStore.Search": 1, "convertPrice": 3, "deepCopy": 3, "stream.Send": 3}, names)
+
+	// the sorted laptops are sent after the store search
+	serverExporter.Reset()
+	stream, err = client.SearchLaptop(context.Background(), &pb.SearchLaptopRequest{
+		Filter: &pb.Filter{MaxPriceUsd: 10000},
+		Sort:   pb.SearchLaptopRequest_PRICE_ASCENDING,
+	})
+	require.NoError(t, err)
+	for {
+		_, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+	}
+
+	rpcSpans = serverExporter.Find("/techschool.pcbook.LaptopService/SearchLaptop")
+	require.Len(t, rpcSpans, 1)
+	names = map[string]int{}
+	for _, span := range serverExporter.Children(rpcSpans[0]) {
+		names[span.Name]++
+	}
+	require.Equal(t, map[string]int{"LaptopStore.Search": 1, "convertPrice": 3, "deepCopy": 3, "sendSorted": 1}, names)
+
+	sendSpans := serverExporter.Find("sendSorted")
+	require.Equal(t, int64(3), sendSpans[0].Attributes["laptop.count"])
+	sends := serverExporter.Children(sendSpans[0])
+	require.Len(t, sends, 3)
+	for _, span := range sends {
+		require.Equal(t, "stream.Send", span.Name)
+	}
+}
+
+func TestUploadImageSpans(t *testing.T) {
+	t.Parallel()
+
+	exporter := tracing.NewInMemoryExporter()
+	client := startTestServer(t, tracing.NewTracer(exporter))
+
+	laptop := sample.NewLaptop()
+	_, err := client.CreateLaptop(context.Background(), &pb.CreateLaptopRequest{Laptop: laptop})
+	require.NoError(t, err)
+
+	stream, err := client.UploadImage(context.Background())
+	require.NoError(t, err)
+	err = stream.Send(&pb.UploadImageRequest{
+		Data: &pb.UploadImageRequest_Info{
+			Info: &pb.ImageInfo{LaptopId: laptop.Id, ImageType: ".jpg"},
+		},
+	})
+	require.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		err = stream.Send(&pb.UploadImageRequest{
+			Data: &pb.UploadImageRequest_ChunkData{ChunkData: bytes.Repeat([]byte{1}, 100)},
+		})
+		require.NoError(t, err)
+	}
+	res, err := stream.CloseAndRecv()
+	require.NoError(t, err)
+
+	rpcSpans := exporter.Find("/techschool.pcbook.LaptopService/UploadImage")
+	require.Len(t, rpcSpans, 1)
+	rpcSpan := rpcSpans[0]
+	require.Equal(t, laptop.Id, rpcSpan.Attributes["laptop.id"])
+	require.Equal(t, int64(2), rpcSpan.Attributes["image.chunk_count"])
+	require.Equal(t, int64(200), rpcSpan.Attributes["image.size"])
+
+	children := exporter.Children(rpcSpan)
+	require.Len(t, children, 2)
+	require.Equal(t, "LaptopStore.Find", children[0].Name)
+	require.Equal(t, "ImageStore.Save", children[1].Name)
+	require.Equal(t, res.Id, children[1].Attributes["image.id"])
+	require.Equal(t, int64(200), children[1].Attributes["image.size"])
+}
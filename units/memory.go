@@ -0,0 +1,219 @@
+package units
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+
+	"github.com/hjcian/grpc-notes/pb"
+)
+
+// ErrUnknownUnit is returned for a memory of the UNKNOWN unit
+var ErrUnknownUnit = errors.New("unknown memory unit")
+
+// ErrOverflow is returned when a size doesn't fit in 64 bits
+var ErrOverflow = errors.New("size overflows 64 bits")
+
+// memoryShifts are the sizes of the memory units in bits, as powers of 2.
+// The units are powers of 1024, so a KILOBYTE is 1024 bytes.
+var memoryShifts = map[pb.Memory_Unit]uint{
+	pb.Memory_BIT:      0,
+	pb.Memory_BYTE:     3,
+	pb.Memory_KILOBYTE: 13,
+	pb.Memory_MEGABYTE: 23,
+	pb.Memory_GIGABYTE: 33,
+	pb.Memory_TERABYTE: 43,
+}
+
+// memoryUnits are the known units, from the largest to the smallest
+var memoryUnits = []pb.Memory_Unit{
+	pb.Memory_TERABYTE,
+	pb.Memory_GIGABYTE,
+	pb.Memory_MEGABYTE,
+	pb.Memory_KILOBYTE,
+	pb.Memory_BYTE,
+	pb.Memory_BIT,
+}
+
+var memorySymbols = map[pb.Memory_Unit]string{
+	pb.Memory_BIT:      "bit",
+	pb.Memory_BYTE:     "B",
+	pb.Memory_KILOBYTE: "KiB",
+	pb.Memory_MEGABYTE: "MiB",
+	pb.Memory_GIGABYTE: "GiB",
+	pb.Memory_TERABYTE: "TiB",
+}
+
+// memoryNames are the lower case names of the units read by ParseMemory
+var memoryNames = map[string]pb.Memory_Unit{
+	"bit":       pb.Memory_BIT,
+	"bits":      pb.Memory_BIT,
+	"b":         pb.Memory_BYTE,
+	"byte":      pb.Memory_BYTE,
+	"bytes":     pb.Memory_BYTE,
+	"k":         pb.Memory_KILOBYTE,
+	"kb":        pb.Memory_KILOBYTE,
+	"kib":       pb.Memory_KILOBYTE,
+	"kilobyte":  pb.Memory_KILOBYTE,
+	"kilobytes": pb.Memory_KILOBYTE,
+	"m":         pb.Memory_MEGABYTE,
+	"mb":        pb.Memory_MEGABYTE,
+	"mib":       pb.Memory_MEGABYTE,
+	"megabyte":  pb.Memory_MEGABYTE,
+	"megabytes": pb.Memory_MEGABYTE,
+	"g":         pb.Memory_GIGABYTE,
+	"gb":        pb.Memory_GIGABYTE,
+	"gib":       pb.Memory_GIGABYTE,
+	"gigabyte":  pb.Memory_GIGABYTE,
+	"gigabytes": pb.Memory_GIGABYTE,
+	"t":         pb.Memory_TERABYTE,
+	"tb":        pb.Memory_TERABYTE,
+	"tib":       pb.Memory_TERABYTE,
+	"terabyte":  pb.Memory_TERABYTE,
+	"terabytes": pb.Memory_TERABYTE,
+}
+
+// memoryBits returns the size of the memory in bits, it's never out of range
+func memoryBits(memory *pb.Memory) (*big.Int, error) {
+	shift, ok := memoryShifts[memory.GetUnit()]
+	if !ok {
+		return nil, fmt.Errorf("%w %s", ErrUnknownUnit, memory.GetUnit())
+	}
+	bits := new(big.Int).SetUint64(memory.GetValue())
+	return bits.Lsh(bits, shift), nil
+}
+
+// MemoryBits returns the size of the memory in bits, or ErrOverflow
+// if the size doesn't fit in an uint64, e.g. more than 2 million TERABYTE
+func MemoryBits(memory *pb.Memory) (uint64, error) {
+	bits, err := memoryBits(memory)
+	if err != nil {
+		return 0, err
+	}
+	if !bits.IsUint64() {
+		return 0, fmt.Errorf("%d %s: %w", memory.GetValue(), memory.GetUnit(), ErrOverflow)
+	}
+	return bits.Uint64(), nil
+}
+
+// MaxMemoryValue returns the largest value of the unit whose size in bits fits in an uint64
+func MaxMemoryValue(unit pb.Memory_Unit) uint64 {
+	return math.MaxUint64 >> memoryShifts[unit]
+}
+
+// CompareMemory compares the sizes of the memories, it returns -1 if a is smaller than b,
+// 0 if they are equal and +1 if a is larger. The sizes may be out of the range of MemoryBits.
+func CompareMemory(a, b *pb.Memory) (int, error) {
+	aBits, err := memoryBits(a)
+	if err != nil {
+		return 0, err
+	}
+	bBits, err := memoryBits(b)
+	if err != nil {
+		return 0, err
+	}
+	return aBits.Cmp(bBits), nil
+}
+
+// NormalizeMemory returns the memory in the largest unit of which its size is a whole number,
+// e.g. 2048 MEGABYTE is 2 GIGABYTE
+func NormalizeMemory(memory *pb.Memory) (*pb.Memory, error) {
+	bits, err := memoryBits(memory)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, unit := range memoryUnits {
+		value, rest := new(big.Int).QuoRem(bits, unitBits(unit), new(big.Int))
+		if rest.Sign() == 0 && value.IsUint64() {
+			return &pb.Memory{Value: value.Uint64(), Unit: unit}, nil
+		}
+	}
+	// the memory itself is a whole number of its unit, so it's never reached
+	return memory, nil
+}
+
+func unitBits(unit pb.Memory_Unit) *big.Int {
+	return new(big.Int).Lsh(big.NewInt(1), memoryShifts[unit])
+}
+
+// FormatMemory formats the memory in the largest unit in which it has at most 2 decimals,
+// e.g. "16 GiB" or "1.5 TiB". The units are written as binary prefixes, since they are
+// powers of 1024. A memory of an unknown unit is written as its value and its unit.
+func FormatMemory(memory *pb.Memory) string {
+	bits, err := memoryBits(memory)
+	if err != nil {
+		return fmt.Sprintf("%d %s", memory.GetValue(), memory.GetUnit())
+	}
+
+	hundred := big.NewRat(100, 1)
+	for _, unit := range memoryUnits {
+		if unit == memory.GetUnit() {
+			break
+		}
+		amount := new(big.Rat).SetFrac(bits, unitBits(unit))
+		if amount.Cmp(big.NewRat(1, 1)) >= 0 && new(big.Rat).Mul(amount, hundred).IsInt() {
+			value := strings.TrimRight(amount.FloatString(2), "0")
+			return strings.TrimSuffix(value, ".") + " " + memorySymbols[unit]
+		}
+	}
+	return fmt.Sprintf("%d %s", memory.GetValue(), memorySymbols[memory.GetUnit()])
+}
+
+// ParseMemory parses a size such as "16 GiB", "16GB" or "1.5 TB". Both the decimal and the binary
+// prefixes are powers of 1024, as the units of the memory. The names of the units are also accepted,
+// in any case, e.g. "8 gigabyte". A decimal size is converted to the largest unit of which it's
+// a whole number, so that "1.5 TB" is 1536 GIGABYTE.
+func ParseMemory(value string) (*pb.Memory, error) {
+	text := strings.TrimSpace(value)
+	end := strings.IndexFunc(text, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if end <= 0 {
+		return nil, fmt.Errorf("invalid memory %q, expect <number> <unit> such as 16 GiB", value)
+	}
+	number, symbol := text[:end], strings.TrimSpace(text[end:])
+
+	unit, ok := memoryNames[strings.ToLower(symbol)]
+	if !ok {
+		return nil, fmt.Errorf("invalid memory %q: unknown unit %q", value, symbol)
+	}
+	// "b" is a byte, but "bit" is spelled out so that it's not mistaken for one
+	if symbol == "b" {
+		return nil, fmt.Errorf("invalid memory %q: ambiguous unit %q, expect B or bit", value, symbol)
+	}
+
+	if strings.Count(number, ".") > 1 || strings.HasSuffix(number, ".") || strings.HasPrefix(number, ".") {
+		return nil, fmt.Errorf("invalid memory %q: invalid number %q", value, number)
+	}
+	amount, ok := new(big.Rat).SetString(number)
+	if !ok {
+		return nil, fmt.Errorf("invalid memory %q: invalid number %q", value, number)
+	}
+
+	for i := indexOfUnit(unit); i < len(memoryUnits); i++ {
+		unit := memoryUnits[i]
+		if amount.IsInt() {
+			if !amount.Num().IsUint64() {
+				return nil, fmt.Errorf("invalid memory %q: %w", value, ErrOverflow)
+			}
+			return &pb.Memory{Value: amount.Num().Uint64(), Unit: unit}, nil
+		}
+		if i+1 < len(memoryUnits) {
+			ratio := memoryShifts[unit] - memoryShifts[memoryUnits[i+1]]
+			amount.Mul(amount, new(big.Rat).SetInt(new(big.Int).Lsh(big.NewInt(1), ratio)))
+		}
+	}
+	return nil, fmt.Errorf("invalid memory %q: not a whole number of bits", value)
+}
+
+func indexOfUnit(unit pb.Memory_Unit) int {
+	for i, other := range memoryUnits {
+		if other == unit {
+			return i
+		}
+	}
+	return len(memoryUnits)
+}
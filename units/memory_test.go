@@ -0,0 +1,151 @@
+package units
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/hjcian/grpc-notes/pb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryBits(t *testing.T) {
+	t.Parallel()
+
+	bits, err := MemoryBits(&pb.Memory{Value: 16, Unit: pb.Memory_GIGABYTE})
+	require.NoError(t, err)
+	require.Equal(t, uint64(16)<<33, bits)
+
+	bits, err = MemoryBits(&pb.Memory{Value: MaxMemoryValue(pb.Memory_TERABYTE), Unit: pb.Memory_TERABYTE})
+	require.NoError(t, err)
+	require.Equal(t, uint64(math.MaxUint64)>>43<<43, bits)
+
+	_, err = MemoryBits(&pb.Memory{Value: MaxMemoryValue(pb.Memory_TERABYTE) + 1, Unit: pb.Memory_TERABYTE})
+	require.True(t, errors.Is(err, ErrOverflow), err)
+
+	_, err = MemoryBits(&pb.Memory{Value: 16})
+	require.True(t, errors.Is(err, ErrUnknownUnit), err)
+	_, err = MemoryBits(nil)
+	require.True(t, errors.Is(err, ErrUnknownUnit), err)
+}
+
+func TestCompareMemory(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		a, b *pb.Memory
+		cmp  int
+	}{
+		{&pb.Memory{Value: 4096, Unit: pb.Memory_MEGABYTE}, &pb.Memory{Value: 4, Unit: pb.Memory_GIGABYTE}, 0},
+		{&pb.Memory{Value: 4095, Unit: pb.Memory_MEGABYTE}, &pb.Memory{Value: 4, Unit: pb.Memory_GIGABYTE}, -1},
+		{&pb.Memory{Value: 9, Unit: pb.Memory_BIT}, &pb.Memory{Value: 1, Unit: pb.Memory_BYTE}, 1},
+		// beyond 64 bits
+		{&pb.Memory{Value: 1 << 21, Unit: pb.Memory_TERABYTE}, &pb.Memory{Value: 8, Unit: pb.Memory_GIGABYTE}, 1},
+		{&pb.Memory{Value: math.MaxUint64, Unit: pb.Memory_TERABYTE}, &pb.Memory{Value: math.MaxUint64, Unit: pb.Memory_GIGABYTE}, 1},
+	}
+
+	for _, tc := range testCases {
+		cmp, err := CompareMemory(tc.a, tc.b)
+		require.NoError(t, err)
+		require.Equal(t, tc.cmp, cmp, "%v %v", tc.a, tc.b)
+
+		cmp, err = CompareMemory(tc.b, tc.a)
+		require.NoError(t, err)
+		require.Equal(t, -tc.cmp, cmp, "%v %v", tc.b, tc.a)
+	}
+
+	_, err := CompareMemory(&pb.Memory{Value: 8, Unit: pb.Memory_GIGABYTE}, &pb.Memory{Value: 8})
+	require.True(t, errors.Is(err, ErrUnknownUnit), err)
+}
+
+func TestNormalizeMemory(t *testing.T) {
+	t.Parallel()
+
+	memory, err := NormalizeMemory(&pb.Memory{Value: 2048, Unit: pb.Memory_MEGABYTE})
+	require.NoError(t, err)
+	require.Equal(t, &pb.Memory{Value: 2, Unit: pb.Memory_GIGABYTE}, memory)
+
+	memory, err = NormalizeMemory(&pb.Memory{Value: 12, Unit: pb.Memory_BIT})
+	require.NoError(t, err)
+	require.Equal(t, &pb.Memory{Value: 12, Unit: pb.Memory_BIT}, memory)
+
+	memory, err = NormalizeMemory(&pb.Memory{Value: math.MaxUint64, Unit: pb.Memory_TERABYTE})
+	require.NoError(t, err)
+	require.Equal(t, &pb.Memory{Value: math.MaxUint64, Unit: pb.Memory_TERABYTE}, memory)
+
+	_, err = NormalizeMemory(&pb.Memory{Value: 1})
+	require.True(t, errors.Is(err, ErrUnknownUnit), err)
+}
+
+func TestFormatMemory(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		memory *pb.Memory
+		text   string
+	}{
+		{&pb.Memory{Value: 16, Unit: pb.Memory_GIGABYTE}, "16 GiB"},
+		{&pb.Memory{Value: 1536, Unit: pb.Memory_GIGABYTE}, "1.5 TiB"},
+		{&pb.Memory{Value: 1280, Unit: pb.Memory_MEGABYTE}, "1.25 GiB"},
+		{&pb.Memory{Value: 1025, Unit: pb.Memory_MEGABYTE}, "1025 MiB"},
+		{&pb.Memory{Value: 512, Unit: pb.Memory_MEGABYTE}, "512 MiB"},
+		{&pb.Memory{Value: 16, Unit: pb.Memory_BIT}, "2 B"},
+		{&pb.Memory{Value: 12, Unit: pb.Memory_BIT}, "1.5 B"},
+		{&pb.Memory{Value: 5, Unit: pb.Memory_BIT}, "5 bit"},
+		{&pb.Memory{Value: 0, Unit: pb.Memory_GIGABYTE}, "0 GiB"},
+		{&pb.Memory{Value: math.MaxUint64, Unit: pb.Memory_TERABYTE}, "18446744073709551615 TiB"},
+		{&pb.Memory{Value: 16}, "16 UNKNOWN"},
+	}
+
+	for _, tc := range testCases {
+		require.Equal(t, tc.text, FormatMemory(tc.memory))
+	}
+}
+
+func TestParseMemory(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		text   string
+		memory *pb.Memory
+	}{
+		{"16 GiB", &pb.Memory{Value: 16, Unit: pb.Memory_GIGABYTE}},
+		{"16GB", &pb.Memory{Value: 16, Unit: pb.Memory_GIGABYTE}},
+		{" 8 gigabyte ", &pb.Memory{Value: 8, Unit: pb.Memory_GIGABYTE}},
+		{"512 MEGABYTE", &pb.Memory{Value: 512, Unit: pb.Memory_MEGABYTE}},
+		{"1.5 TB", &pb.Memory{Value: 1536, Unit: pb.Memory_GIGABYTE}},
+		{"0.5 KiB", &pb.Memory{Value: 512, Unit: pb.Memory_BYTE}},
+		{"1.5 B", &pb.Memory{Value: 12, Unit: pb.Memory_BIT}},
+		{"3 bits", &pb.Memory{Value: 3, Unit: pb.Memory_BIT}},
+		{"18446744073709551615 TiB", &pb.Memory{Value: math.MaxUint64, Unit: pb.Memory_TERABYTE}},
+	}
+
+	for _, tc := range testCases {
+		memory, err := ParseMemory(tc.text)
+		require.NoError(t, err, tc.text)
+		require.Equal(t, tc.memory, memory, tc.text)
+	}
+
+	for _, text := range []string{"", "GiB", "16", "16 PiB", "-16 GiB", "1.2.3 GB", ".5 GB", "1e3 GB", "1/2 GB", "16 b", "0.1 bit", "18446744073709551616 GiB"} {
+		_, err := ParseMemory(text)
+		require.Error(t, err, text)
+	}
+	_, err := ParseMemory("18446744073709551616 GiB")
+	require.True(t, errors.Is(err, ErrOverflow), err)
+}
+
+func TestFormatParseMemory(t *testing.T) {
+	t.Parallel()
+
+	for _, unit := range memoryUnits {
+		for _, value := range []uint64{1, 3, 1000, 1023, 1024, 1536, 1 << 20, math.MaxUint64} {
+			memory := &pb.Memory{Value: value, Unit: unit}
+			parsed, err := ParseMemory(FormatMemory(memory))
+			require.NoError(t, err, memory)
+
+			cmp, err := CompareMemory(memory, parsed)
+			require.NoError(t, err)
+			require.Equal(t, 0, cmp, "%v %v", memory, parsed)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package units
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/hjcian/grpc-notes/pb"
+)
+
+// PoundInKg is the international avoirdupois pound in kilograms
+const PoundInKg = 0.45359237
+
+// WeightUnit is the unit of a weight, as in the weight of the laptops
+type WeightUnit int
+
+// Units of the weights
+const (
+	Kilogram WeightUnit = iota
+	Pound
+)
+
+func (u WeightUnit) String() string {
+	switch u {
+	case Kilogram:
+		return "kg"
+	case Pound:
+		return "lb"
+	default:
+		return fmt.Sprintf("WeightUnit(%d)", int(u))
+	}
+}
+
+// weightNames are the lower case names of the units read by ParseWeight
+var weightNames = map[string]WeightUnit{
+	"kg":        Kilogram,
+	"kgs":       Kilogram,
+	"kilogram":  Kilogram,
+	"kilograms": Kilogram,
+	"lb":        Pound,
+	"lbs":       Pound,
+	"pound":     Pound,
+	"pounds":    Pound,
+}
+
+// Weight is the weight of a laptop in its unit
+type Weight struct {
+	Value float64
+	Unit  WeightUnit
+}
+
+// LaptopWeight returns the weight of the laptop, false if it has no weight
+func LaptopWeight(laptop *pb.Laptop) (Weight, bool) {
+	switch weight := laptop.GetWeight().(type) {
+	case *pb.Laptop_WeightKg:
+		return Weight{Value: weight.WeightKg, Unit: Kilogram}, true
+	case *pb.Laptop_WeightLb:
+		return Weight{Value: weight.WeightLb, Unit: Pound}, true
+	default:
+		return Weight{}, false
+	}
+}
+
+// SetTo sets the weight of the laptop, in the unit of the weight
+func (w Weight) SetTo(laptop *pb.Laptop) {
+	switch w.Unit {
+	case Pound:
+		laptop.Weight = &pb.Laptop_WeightLb{WeightLb: w.Value}
+	default:
+		laptop.Weight = &pb.Laptop_WeightKg{WeightKg: w.Value}
+	}
+}
+
+// Convert returns the weight in the unit, or ErrOverflow if the weight or
+// its conversion isn't a finite number
+func (w Weight) Convert(unit WeightUnit) (Weight, error) {
+	value := w.Value
+	switch {
+	case w.Unit == unit:
+	case w.Unit == Pound && unit == Kilogram:
+		value *= PoundInKg
+	case w.Unit == Kilogram && unit == Pound:
+		value /= PoundInKg
+	default:
+		return Weight{}, fmt.Errorf("cannot convert weight from %s to %s", w.Unit, unit)
+	}
+
+	if math.IsInf(value, 0) || math.IsNaN(value) {
+		return Weight{}, fmt.Errorf("weight %s in %s: %w", w, unit, ErrOverflow)
+	}
+	return Weight{Value: value, Unit: unit}, nil
+}
+
+// String formats the weight with the fewest digits which read back to the same value, e.g. "3.3 lb"
+func (w Weight) String() string {
+	return strconv.FormatFloat(w.Value, 'g', -1, 64) + " " + w.Unit.String()
+}
+
+// ParseWeight parses a weight such as "1.5 kg", "3.3lb" or "2 pounds", the value must be finite
+func ParseWeight(value string) (Weight, error) {
+	text := strings.TrimSpace(value)
+	// the unit is the letters which end the weight
+	start := strings.LastIndexFunc(text, func(r rune) bool {
+		return (r < 'a' || r > 'z') && (r < 'A' || r > 'Z')
+	})
+	if start < 0 || start == len(text)-1 {
+		return Weight{}, fmt.Errorf("invalid weight %q, expect <number> kg or <number> lb", value)
+	}
+	number, symbol := strings.TrimSpace(text[:start+1]), text[start+1:]
+
+	unit, ok := weightNames[strings.ToLower(symbol)]
+	if !ok {
+		return Weight{}, fmt.Errorf("invalid weight %q: unknown unit %q, expect kg or lb", value, symbol)
+	}
+	weight, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return Weight{}, fmt.Errorf("invalid weight %q: %w", value, err)
+	}
+	if math.IsInf(weight, 0) || math.IsNaN(weight) {
+		return Weight{}, fmt.Errorf("invalid weight %q: not a finite number", value)
+	}
+	return Weight{Value: weight, Unit: unit}, nil
+}
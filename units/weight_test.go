@@ -0,0 +1,83 @@
+package units
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/hjcian/grpc-notes/pb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLaptopWeight(t *testing.T) {
+	t.Parallel()
+
+	laptop := &pb.Laptop{}
+	_, ok := LaptopWeight(laptop)
+	require.False(t, ok)
+
+	Weight{Value: 3.3, Unit: Pound}.SetTo(laptop)
+	require.Equal(t, 3.3, laptop.GetWeightLb())
+	weight, ok := LaptopWeight(laptop)
+	require.True(t, ok)
+	require.Equal(t, Weight{Value: 3.3, Unit: Pound}, weight)
+
+	Weight{Value: 1.5, Unit: Kilogram}.SetTo(laptop)
+	require.Equal(t, 1.5, laptop.GetWeightKg())
+	weight, ok = LaptopWeight(laptop)
+	require.True(t, ok)
+	require.Equal(t, Weight{Value: 1.5, Unit: Kilogram}, weight)
+}
+
+func TestConvertWeight(t *testing.T) {
+	t.Parallel()
+
+	weight, err := Weight{Value: 1, Unit: Pound}.Convert(Kilogram)
+	require.NoError(t, err)
+	require.Equal(t, Weight{Value: PoundInKg, Unit: Kilogram}, weight)
+
+	weight, err = Weight{Value: 2 * PoundInKg, Unit: Kilogram}.Convert(Pound)
+	require.NoError(t, err)
+	require.Equal(t, Weight{Value: 2, Unit: Pound}, weight)
+
+	weight, err = Weight{Value: 1.5, Unit: Kilogram}.Convert(Kilogram)
+	require.NoError(t, err)
+	require.Equal(t, Weight{Value: 1.5, Unit: Kilogram}, weight)
+
+	_, err = Weight{Value: math.MaxFloat64, Unit: Kilogram}.Convert(Pound)
+	require.True(t, errors.Is(err, ErrOverflow), err)
+	_, err = Weight{Value: math.NaN(), Unit: Kilogram}.Convert(Kilogram)
+	require.True(t, errors.Is(err, ErrOverflow), err)
+}
+
+func TestFormatParseWeight(t *testing.T) {
+	t.Parallel()
+
+	require.Equal(t, "1.5 kg", Weight{Value: 1.5, Unit: Kilogram}.String())
+	require.Equal(t, "3.3 lb", Weight{Value: 3.3, Unit: Pound}.String())
+
+	testCases := []struct {
+		text   string
+		weight Weight
+	}{
+		{"1.5 kg", Weight{Value: 1.5, Unit: Kilogram}},
+		{"3.3lb", Weight{Value: 3.3, Unit: Pound}},
+		{" 2 Pounds ", Weight{Value: 2, Unit: Pound}},
+		{"1e0 KG", Weight{Value: 1, Unit: Kilogram}},
+		{"-1 kg", Weight{Value: -1, Unit: Kilogram}},
+	}
+	for _, tc := range testCases {
+		weight, err := ParseWeight(tc.text)
+		require.NoError(t, err, tc.text)
+		require.Equal(t, tc.weight, weight, tc.text)
+
+		parsed, err := ParseWeight(weight.String())
+		require.NoError(t, err)
+		require.Equal(t, weight, parsed)
+	}
+
+	for _, text := range []string{"", "kg", "1.5", "3 stone", "heavy kg", "Inf kg", "NaN lb"} {
+		_, err := ParseWeight(text)
+		require.Error(t, err, text)
+	}
+}
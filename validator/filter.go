@@ -0,0 +1,43 @@
+package validator
+
+import (
+	"github.com/hjcian/grpc-notes/money"
+	"github.com/hjcian/grpc-notes/pb"
+)
+
+// ValidateFilter checks the search filter, an empty filter is valid
+func ValidateFilter(field string, filter *pb.Filter) Violations {
+	var v Violations
+	if filter == nil {
+		return v
+	}
+
+	if filter.GetMaxPriceUsd() < 0 {
+		v.add(field+".max_price_usd", "must not be negative")
+	}
+	if filter.GetMinCpuGhz() < 0 {
+		v.add(field+".min_cpu_ghz", "must not be negative")
+	}
+	if minRAM := filter.GetMinRam(); minRAM.GetValue() > 0 {
+		v = append(v, validateMemorySize(field+".min_ram", minRAM)...)
+	}
+	if filter.GetMaxPrice() != nil {
+		v = append(v, ValidatePrice(field+".max_price", filter.GetMaxPrice())...)
+	}
+
+	return v
+}
+
+// ValidateSearch checks the filter, the currency and the sort of the search request
+func ValidateSearch(req *pb.SearchLaptopRequest) Violations {
+	v := ValidateFilter("filter", req.GetFilter())
+
+	if currency := req.GetCurrencyCode(); len(currency) > 0 && !money.ValidCurrencyCode(currency) {
+		v.add("currency_code", "must be 3 upper case letters such as USD")
+	}
+	if _, ok := pb.SearchLaptopRequest_Sort_name[int32(req.GetSort())]; !ok {
+		v.add("sort", "must be one of NONE, PRICE_ASCENDING or PRICE_DESCENDING")
+	}
+
+	return v
+}
@@ -0,0 +1,282 @@
+package validator
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/hjcian/grpc-notes/money"
+	"github.com/hjcian/grpc-notes/pb"
+	"github.com/hjcian/grpc-notes/rpcerror"
+	"github.com/hjcian/grpc-notes/units"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+)
+
+// Violations collects the field violations found while validating a message
+type Violations []*errdetails.BadRequest_FieldViolation
+
+func (v *Violations) add(field string, format string, args ...interface{}) {
+	*v = append(*v, &errdetails.BadRequest_FieldViolation{
+		Field:       field,
+		Description: fmt.Sprintf(format, args...),
+	})
+}
+
+// String joins all the violations into one line, e.g. for logs
+func (v Violations) String() string {
+	descriptions := make([]string, len(v))
+	for i, violation := range v {
+		descriptions[i] = fmt.Sprintf("%s: %s", violation.GetField(), violation.GetDescription())
+	}
+	return strings.Join(descriptions, "; ")
+}
+
+// Err returns an InvalidArgument status error with the violations attached
+// as BadRequest details, or nil if there is no violation
+func (v Violations) Err(message string) error {
+	if len(v) == 0 {
+		return nil
+	}
+
+	return rpcerror.New(
+		codes.InvalidArgument,
+		fmt.Sprintf("%s: %s", message, v),
+		&errdetails.BadRequest{FieldViolations: v},
+	)
+}
+
+// ValidateLaptop checks the laptop and its sub-messages,
+// field paths of the violations are prefixed with field
+func ValidateLaptop(field string, laptop *pb.Laptop) Violations {
+	var v Violations
+	if laptop == nil {
+		v.add(field, "must be set")
+		return v
+	}
+
+	if len(laptop.GetId()) > 0 {
+		if _, err := uuid.Parse(laptop.GetId()); err != nil {
+			v.add(field+".id", "must be a valid UUID: %v", err)
+		}
+	}
+	if len(laptop.GetBrand()) == 0 {
+		v.add(field+".brand", "must not be empty")
+	}
+	if len(laptop.GetName()) == 0 {
+		v.add(field+".name", "must not be empty")
+	}
+
+	v = append(v, ValidateCPU(field+".cpu", laptop.GetCpu())...)
+	v = append(v, ValidateMemory(field+".ram", laptop.GetRam())...)
+	for i, gpu := range laptop.GetGpus() {
+		v = append(v, ValidateGPU(fmt.Sprintf("%s.gpus[%d]", field, i), gpu)...)
+	}
+	for i, storage := range laptop.GetStorages() {
+		v = append(v, ValidateStorage(fmt.Sprintf("%s.storages[%d]", field, i), storage)...)
+	}
+	v = append(v, ValidateScreen(field+".screen", laptop.GetScreen())...)
+	if laptop.GetKeyboard() != nil {
+		v = append(v, ValidateKeyboard(field+".keyboard", laptop.GetKeyboard())...)
+	}
+
+	if weight, ok := units.LaptopWeight(laptop); ok {
+		v = append(v, validateWeight(field, weight)...)
+	}
+
+	if laptop.GetPriceUsd() < 0 {
+		v.add(field+".price_usd", "must not be negative")
+	}
+	if price := laptop.GetPrice(); price != nil {
+		v = append(v, ValidatePrice(field+".price", price)...)
+		if price.GetCurrencyCode() == money.USD && laptop.GetPriceUsd() != 0 && laptop.GetPriceUsd() != money.Float(price) {
+			v.add(field+".price_usd", "must be equal to price (%s)", money.Format(price))
+		}
+	}
+
+	return v
+}
+
+// validateWeight checks that the weight is greater than 0, and that it's finite in both units
+func validateWeight(field string, weight units.Weight) Violations {
+	var v Violations
+	field += ".weight_" + weight.Unit.String()
+
+	if !(weight.Value > 0) {
+		v.add(field, "must be greater than 0")
+		return v
+	}
+	for _, unit := range []units.WeightUnit{units.Kilogram, units.Pound} {
+		if _, err := weight.Convert(unit); err != nil {
+			v.add(field, "must be a finite number of %s", unit)
+			break
+		}
+	}
+
+	return v
+}
+
+// ValidatePrice checks that the price is a valid money, which isn't negative
+func ValidatePrice(field string, price *pb.Money) Violations {
+	var v Violations
+	if price == nil {
+		v.add(field, "must be set")
+		return v
+	}
+
+	if err := money.Validate(price); err != nil {
+		v.add(field, "must be a valid money: %v", err)
+	} else if price.GetUnits() < 0 || price.GetNanos() < 0 {
+		v.add(field, "must not be negative")
+	}
+
+	return v
+}
+
+// ValidateCPU checks the number of cores/threads and the frequencies of the CPU
+func ValidateCPU(field string, cpu *pb.CPU) Violations {
+	var v Violations
+	if cpu == nil {
+		v.add(field, "must be set")
+		return v
+	}
+
+	if len(cpu.GetBrand()) == 0 {
+		v.add(field+".brand", "must not be empty")
+	}
+	if len(cpu.GetName()) == 0 {
+		v.add(field+".name", "must not be empty")
+	}
+	if cpu.GetNumberCores() == 0 {
+		v.add(field+".number_cores", "must be greater than 0")
+	}
+	if cpu.GetNumberThreads() < cpu.GetNumberCores() {
+		v.add(field+".number_threads", "must not be less than number_cores (%d)", cpu.GetNumberCores())
+	}
+	v = append(v, validateFrequency(field, cpu.GetMinGhz(), cpu.GetMaxGhz())...)
+
+	return v
+}
+
+// ValidateGPU checks the frequencies and the memory of the GPU
+func ValidateGPU(field string, gpu *pb.GPU) Violations {
+	var v Violations
+	if gpu == nil {
+		v.add(field, "must be set")
+		return v
+	}
+
+	if len(gpu.GetBrand()) == 0 {
+		v.add(field+".brand", "must not be empty")
+	}
+	if len(gpu.GetName()) == 0 {
+		v.add(field+".name", "must not be empty")
+	}
+	v = append(v, validateFrequency(field, gpu.GetMinGhz(), gpu.GetMaxGhz())...)
+	v = append(v, ValidateMemory(field+".memory", gpu.GetMemory())...)
+
+	return v
+}
+
+func validateFrequency(field string, minGhz, maxGhz float64) Violations {
+	var v Violations
+	if minGhz <= 0 {
+		v.add(field+".min_ghz", "must be greater than 0")
+	}
+	if maxGhz < minGhz {
+		v.add(field+".max_ghz", "must not be less than min_ghz (%g)", minGhz)
+	}
+	return v
+}
+
+// ValidateMemory checks that the memory has a positive value and a known unit,
+// and that its size in bits fits in 64 bits
+func ValidateMemory(field string, memory *pb.Memory) Violations {
+	var v Violations
+	if memory == nil {
+		v.add(field, "must be set")
+		return v
+	}
+
+	if memory.GetValue() == 0 {
+		v.add(field+".value", "must be greater than 0")
+	}
+	v = append(v, validateMemorySize(field, memory)...)
+
+	return v
+}
+
+func validateMemorySize(field string, memory *pb.Memory) Violations {
+	var v Violations
+	_, err := units.MemoryBits(memory)
+	switch {
+	case errors.Is(err, units.ErrUnknownUnit):
+		v.add(field+".unit", "must be specified")
+	case errors.Is(err, units.ErrOverflow):
+		v.add(field+".value", "must not exceed %d %s", units.MaxMemoryValue(memory.GetUnit()), memory.GetUnit())
+	}
+	return v
+}
+
+// ValidateStorage checks the driver and the memory of the storage
+func ValidateStorage(field string, storage *pb.Storage) Violations {
+	var v Violations
+	if storage == nil {
+		v.add(field, "must be set")
+		return v
+	}
+
+	if storage.GetDriver() == pb.Storage_UNKNOWN {
+		v.add(field+".driver", "must be specified")
+	}
+	v = append(v, ValidateMemory(field+".memory", storage.GetMemory())...)
+
+	return v
+}
+
+// ValidateScreen checks the size, the resolution and the panel of the screen
+func ValidateScreen(field string, screen *pb.Screen) Violations {
+	var v Violations
+	if screen == nil {
+		v.add(field, "must be set")
+		return v
+	}
+
+	if screen.GetSizeInch() <= 0 {
+		v.add(field+".size_inch", "must be greater than 0")
+	}
+
+	resolution := screen.GetResolution()
+	if resolution == nil {
+		v.add(field+".resolution", "must be set")
+	} else {
+		if resolution.GetWidth() == 0 {
+			v.add(field+".resolution.width", "must be greater than 0")
+		}
+		if resolution.GetHeight() == 0 {
+			v.add(field+".resolution.height", "must be greater than 0")
+		}
+	}
+
+	if screen.GetPanel() == pb.Screen_UNKNOWN {
+		v.add(field+".panel", "must be specified")
+	}
+
+	return v
+}
+
+// ValidateKeyboard checks the layout of the keyboard
+func ValidateKeyboard(field string, keyboard *pb.Keyboard) Violations {
+	var v Violations
+	if keyboard == nil {
+		v.add(field, "must be set")
+		return v
+	}
+
+	if keyboard.GetLayout() == pb.Keyboard_UNKNOWN {
+		v.add(field+".layout", "must be specified")
+	}
+
+	return v
+}
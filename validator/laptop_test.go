@@ -0,0 +1,182 @@
+package validator
+
+import (
+	"math"
+	"testing"
+
+	"github.com/hjcian/grpc-notes/pb"
+	"github.com/hjcian/grpc-notes/sample"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func fields(v Violations) []string {
+	result := make([]string, len(v))
+	for i, violation := range v {
+		result[i] = violation.GetField()
+	}
+	return result
+}
+
+func TestValidateLaptop(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name   string
+		modify func(laptop *pb.Laptop)
+		fields []string
+	}{
+		{
+			name:   "valid",
+			modify: func(laptop *pb.Laptop) {},
+		},
+		{
+			name:   "valid_no_id",
+			modify: func(laptop *pb.Laptop) { laptop.Id = "" },
+		},
+		{
+			name:   "invalid_id",
+			modify: func(laptop *pb.Laptop) { laptop.Id = "invalid-uuid" },
+			fields: []string{"laptop.id"},
+		},
+		{
+			name: "invalid_cpu",
+			modify: func(laptop *pb.Laptop) {
+				laptop.Cpu.NumberCores = 4
+				laptop.Cpu.NumberThreads = 2
+				laptop.Cpu.MinGhz = 3.0
+				laptop.Cpu.MaxGhz = 2.0
+			},
+			fields: []string{"laptop.cpu.number_threads", "laptop.cpu.max_ghz"},
+		},
+		{
+			name:   "zero_cores",
+			modify: func(laptop *pb.Laptop) { laptop.Cpu.NumberCores = 0 },
+			fields: []string{"laptop.cpu.number_cores"},
+		},
+		{
+			name:   "unknown_memory_unit",
+			modify: func(laptop *pb.Laptop) { laptop.Ram.Unit = pb.Memory_UNKNOWN },
+			fields: []string{"laptop.ram.unit"},
+		},
+		{
+			name:   "memory_overflow",
+			modify: func(laptop *pb.Laptop) { laptop.Ram = &pb.Memory{Value: 1 << 21, Unit: pb.Memory_TERABYTE} },
+			fields: []string{"laptop.ram.value"},
+		},
+		{
+			name: "invalid_gpu_and_storage",
+			modify: func(laptop *pb.Laptop) {
+				laptop.Gpus[0].Memory = nil
+				laptop.Storages[1].Driver = pb.Storage_UNKNOWN
+			},
+			fields: []string{"laptop.gpus[0].memory", "laptop.storages[1].driver"},
+		},
+		{
+			name: "empty_screen",
+			modify: func(laptop *pb.Laptop) {
+				laptop.Screen.Resolution = &pb.Screen_Resolution{}
+			},
+			fields: []string{"laptop.screen.resolution.width", "laptop.screen.resolution.height"},
+		},
+		{
+			name:   "unknown_keyboard_layout",
+			modify: func(laptop *pb.Laptop) { laptop.Keyboard.Layout = pb.Keyboard_UNKNOWN },
+			fields: []string{"laptop.keyboard.layout"},
+		},
+		{
+			name: "negative_price_and_weight",
+			modify: func(laptop *pb.Laptop) {
+				laptop.PriceUsd = -1
+				laptop.Weight = &pb.Laptop_WeightLb{WeightLb: -2}
+			},
+			fields: []string{"laptop.weight_lb", "laptop.price_usd"},
+		},
+		{
+			name:   "weight_not_a_number",
+			modify: func(laptop *pb.Laptop) { laptop.Weight = &pb.Laptop_WeightKg{WeightKg: math.NaN()} },
+			fields: []string{"laptop.weight_kg"},
+		},
+		{
+			name:   "weight_overflow_in_lb",
+			modify: func(laptop *pb.Laptop) { laptop.Weight = &pb.Laptop_WeightKg{WeightKg: math.MaxFloat64} },
+			fields: []string{"laptop.weight_kg"},
+		},
+		{
+			name:   "price_in_another_currency",
+			modify: func(laptop *pb.Laptop) { laptop.Price = &pb.Money{CurrencyCode: "EUR", Units: 1999} },
+		},
+		{
+			name: "invalid_price_currency",
+			modify: func(laptop *pb.Laptop) {
+				laptop.Price = &pb.Money{CurrencyCode: "eur", Units: 1999}
+			},
+			fields: []string{"laptop.price"},
+		},
+		{
+			name: "negative_price",
+			modify: func(laptop *pb.Laptop) {
+				laptop.Price = &pb.Money{CurrencyCode: "EUR", Nanos: -1}
+			},
+			fields: []string{"laptop.price"},
+		},
+		{
+			name: "price_usd_not_equal_to_price",
+			modify: func(laptop *pb.Laptop) {
+				laptop.PriceUsd = 999.99
+				laptop.Price = &pb.Money{CurrencyCode: "USD", Units: 1000}
+			},
+			fields: []string{"laptop.price_usd"},
+		},
+		{
+			name: "missing_sub_messages",
+			modify: func(laptop *pb.Laptop) {
+				laptop.Cpu = nil
+				laptop.Ram = nil
+				laptop.Screen = nil
+			},
+			fields: []string{"laptop.cpu", "laptop.ram", "laptop.screen"},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			laptop := sample.NewLaptop()
+			tc.modify(laptop)
+
+			v := ValidateLaptop("laptop", laptop)
+			if len(tc.fields) == 0 {
+				require.Empty(t, v)
+				require.NoError(t, v.Err("invalid laptop"))
+				return
+			}
+
+			require.Equal(t, tc.fields, fields(v))
+		})
+	}
+}
+
+func TestViolationsErr(t *testing.T) {
+	t.Parallel()
+
+	laptop := sample.NewLaptop()
+	laptop.Cpu.NumberCores = 0
+	laptop.PriceUsd = -1
+
+	err := ValidateLaptop("laptop", laptop).Err("invalid laptop")
+	require.Error(t, err)
+
+	st, ok := status.FromError(err)
+	require.True(t, ok)
+	require.Equal(t, codes.InvalidArgument, st.Code())
+	require.Len(t, st.Details(), 1)
+
+	badRequest, ok := st.Details()[0].(*errdetails.BadRequest)
+	require.True(t, ok)
+	require.Equal(t, []string{"laptop.cpu.number_cores", "laptop.price_usd"}, fields(badRequest.GetFieldViolations()))
+}
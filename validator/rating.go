@@ -0,0 +1,16 @@
+package validator
+
+// The range of a laptop rating score
+const (
+	MinScore = 1.0
+	MaxScore = 10.0
+)
+
+// ValidateScore checks that the rating score is between MinScore and MaxScore
+func ValidateScore(field string, score float64) Violations {
+	var v Violations
+	if !(score >= MinScore && score <= MaxScore) {
+		v.add(field, "must be between %g and %g", MinScore, MaxScore)
+	}
+	return v
+}